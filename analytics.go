@@ -0,0 +1,591 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Analytics metrics stored in the daily rollup.
+const (
+	metricView         = "view"          // product detail opened
+	metricProductClick = "product_click" // outbound click to a product's Shopee link
+	metricSocialClick  = "social_click"  // outbound click to a social link
+)
+
+// analyticsTZ is the shop's local time; daily stats follow Vietnamese days.
+var analyticsTZ = time.FixedZone("ICT", 7*60*60)
+
+const (
+	analyticsDayLayout   = "2006-01-02"
+	analyticsMaxRangeDay = 366
+)
+
+// analyticsRow is one rollup row: the number of events of a metric for one target,
+// referrer host and user agent class on one day.
+type analyticsRow struct {
+	Day      string
+	Metric   string
+	TargetID int64
+	Referrer string // referrer host, "" for direct traffic
+	UAClass  string
+	Count    int
+}
+
+// analyticsEvent is a raw view or click event as seen by the rollup.
+type analyticsEvent struct {
+	Metric   string
+	TargetID int64
+	Referrer string
+	UAClass  string
+	At       time.Time
+}
+
+// referrerHost reduces a referrer URL to its host without "www.".
+func referrerHost(ref string) string {
+	u, err := url.Parse(strings.TrimSpace(ref))
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+// aggregateEvents counts events into rollup rows.
+func aggregateEvents(events []analyticsEvent) []analyticsRow {
+	type key struct {
+		day, metric, referrer, ua string
+		target                    int64
+	}
+	counts := make(map[key]int)
+	for _, e := range events {
+		counts[key{e.At.In(analyticsTZ).Format(analyticsDayLayout), e.Metric, referrerHost(e.Referrer), e.UAClass, e.TargetID}]++
+	}
+	rows := make([]analyticsRow, 0, len(counts))
+	for k, n := range counts {
+		rows = append(rows, analyticsRow{Day: k.day, Metric: k.metric, TargetID: k.target, Referrer: k.referrer, UAClass: k.ua, Count: n})
+	}
+	return rows
+}
+
+// recordView stores a product detail view.
+func recordView(db *sql.DB, e ViewEvent) error {
+	if db == nil {
+		DevAddViewEvent(e)
+		return nil
+	}
+	_, err := db.Exec("INSERT INTO view_events (shop_id, product_id, referrer, ua_class, ip, created_at) VALUES (@shop_id, ?, ?, ?, ?, ?)",
+		e.ProductID, sqlNullString(e.Referrer), e.UAClass, sqlNullString(e.IP), e.CreatedAt)
+	return err
+}
+
+// loadEventsSince returns the view and click events at or after since.
+func loadEventsSince(db *sql.DB, since time.Time) ([]analyticsEvent, error) {
+	var out []analyticsEvent
+	clickMetric := func(kind string) string {
+		if kind == clickSocial {
+			return metricSocialClick
+		}
+		return metricProductClick
+	}
+	if db == nil {
+		for _, e := range DevGetViewEvents() {
+			if !e.CreatedAt.Before(since) {
+				out = append(out, analyticsEvent{metricView, e.ProductID, e.Referrer, e.UAClass, e.CreatedAt})
+			}
+		}
+		for _, e := range DevGetClickEvents() {
+			if !e.CreatedAt.Before(since) {
+				out = append(out, analyticsEvent{clickMetric(e.Kind), e.TargetID, e.Referrer, e.UAClass, e.CreatedAt})
+			}
+		}
+		return out, nil
+	}
+	queries := []string{
+		"SELECT 'view', product_id, IFNULL(referrer,''), ua_class, created_at FROM view_events WHERE shop_id = @shop_id AND created_at >= ?",
+		"SELECT kind, target_id, IFNULL(referrer,''), ua_class, created_at FROM click_events WHERE shop_id = @shop_id AND created_at >= ?",
+	}
+	for _, q := range queries {
+		rows, err := db.Query(q, since)
+		if err != nil {
+			return nil, fmt.Errorf("query events: %w", err)
+		}
+		for rows.Next() {
+			var e analyticsEvent
+			var kind string
+			var at interface{}
+			if err := rows.Scan(&kind, &e.TargetID, &e.Referrer, &e.UAClass, &at); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("scan event: %w", err)
+			}
+			e.Metric = metricView
+			if kind != metricView {
+				e.Metric = clickMetric(kind)
+			}
+			if t := parseDBTime(at); t != nil {
+				e.At = *t
+			}
+			out = append(out, e)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// rollupAnalytics recomputes the daily rollup for every day from fromDay on.
+func rollupAnalytics(db *sql.DB, fromDay string) error {
+	start, err := time.ParseInLocation(analyticsDayLayout, fromDay, analyticsTZ)
+	if err != nil {
+		return err
+	}
+	events, err := loadEventsSince(db, start)
+	if err != nil {
+		return err
+	}
+	rows := aggregateEvents(events)
+	if db == nil {
+		DevReplaceAnalyticsRows(fromDay, rows)
+		return nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM analytics_daily WHERE shop_id = @shop_id AND day >= ?", fromDay); err != nil {
+		return err
+	}
+	for _, r := range rows {
+		if _, err := tx.Exec("INSERT INTO analytics_daily (shop_id, day, metric, target_id, referrer_host, ua_class, count) VALUES (@shop_id, ?, ?, ?, ?, ?, ?)",
+			r.Day, r.Metric, r.TargetID, r.Referrer, r.UAClass, r.Count); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// lastRollupDay returns the newest day in the rollup, or "" when it is empty.
+func lastRollupDay(db *sql.DB) (string, error) {
+	if db == nil {
+		day := ""
+		for _, r := range DevGetAnalyticsRows() {
+			if r.Day > day {
+				day = r.Day
+			}
+		}
+		return day, nil
+	}
+	var day sql.NullString
+	if err := db.QueryRow("SELECT MAX(day) FROM analytics_daily WHERE shop_id = @shop_id").Scan(&day); err != nil {
+		return "", err
+	}
+	return day.String, nil
+}
+
+// analyticsRollup tracks when each shop was last rolled up so reports can say how
+// fresh they are. Shops are keyed by their pool; every shop has its own.
+var analyticsRollup = struct {
+	sync.Mutex
+	ranAt map[*sql.DB]time.Time
+}{ranAt: map[*sql.DB]time.Time{}}
+
+// rollupShop rolls up one shop from yesterday (to catch events around midnight),
+// or from its last rolled up day when that is older, e.g. after downtime.
+func rollupShop(db *sql.DB) error {
+	analyticsRollup.Lock()
+	defer analyticsRollup.Unlock()
+	from := time.Now().In(analyticsTZ).AddDate(0, 0, -1).Format(analyticsDayLayout)
+	last, err := lastRollupDay(db)
+	if err != nil {
+		return err
+	}
+	if _, ok := analyticsRollup.ranAt[db]; !ok {
+		// first run since start: everything after the last stored day is new
+		if last == "" {
+			last = "2000-01-01"
+		}
+		if last < from {
+			from = last
+		}
+	}
+	if err := rollupAnalytics(db, from); err != nil {
+		return err
+	}
+	analyticsRollup.ranAt[db] = time.Now()
+	return nil
+}
+
+// runAnalyticsRollup rolls up every shop in dbs (by username). A failing shop is
+// logged and does not stop the others.
+func runAnalyticsRollup(dbs map[string]*sql.DB) {
+	for username, db := range dbs {
+		if err := rollupShop(db); err != nil {
+			log.Printf("analytics rollup error (shop %s): %v", username, err)
+		}
+	}
+}
+
+// startAnalyticsRollup runs the rollup of every shop returned by shops now and
+// then every interval until ctx is done.
+func startAnalyticsRollup(ctx context.Context, shops func() map[string]*sql.DB, interval time.Duration) {
+	go func() {
+		runAnalyticsRollup(shops())
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		log.Printf("analytics rollup enabled, every %s", interval)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				runAnalyticsRollup(shops())
+			}
+		}
+	}()
+}
+
+// fetchAnalyticsRows returns the rollup rows for days in [from, to].
+func fetchAnalyticsRows(db *sql.DB, from, to string) ([]analyticsRow, error) {
+	if db == nil {
+		var out []analyticsRow
+		for _, r := range DevGetAnalyticsRows() {
+			if r.Day >= from && r.Day <= to {
+				out = append(out, r)
+			}
+		}
+		return out, nil
+	}
+	rows, err := db.Query("SELECT day, metric, target_id, referrer_host, ua_class, count FROM analytics_daily WHERE shop_id = @shop_id AND day >= ? AND day <= ?", from, to)
+	if err != nil {
+		return nil, fmt.Errorf("query analytics: %w", err)
+	}
+	defer rows.Close()
+	var out []analyticsRow
+	for rows.Next() {
+		var r analyticsRow
+		if err := rows.Scan(&r.Day, &r.Metric, &r.TargetID, &r.Referrer, &r.UAClass, &r.Count); err != nil {
+			return nil, fmt.Errorf("scan analytics: %w", err)
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// AnalyticsCounts holds the three metrics for one day, product, social or referrer.
+type AnalyticsCounts struct {
+	Views         int `json:"views"`
+	ProductClicks int `json:"product_clicks"`
+	SocialClicks  int `json:"social_clicks"`
+}
+
+func (c *AnalyticsCounts) add(metric string, n int) {
+	switch metric {
+	case metricView:
+		c.Views += n
+	case metricProductClick:
+		c.ProductClicks += n
+	case metricSocialClick:
+		c.SocialClicks += n
+	}
+}
+
+// AnalyticsReport is the admin analytics response for a date range.
+type AnalyticsReport struct {
+	From        string                     `json:"from"`
+	To          string                     `json:"to"`
+	IncludeBots bool                       `json:"include_bots"`
+	RolledUpAt  string                     `json:"rolled_up_at"`
+	Totals      AnalyticsCounts            `json:"totals"`
+	Daily       []AnalyticsDay             `json:"daily"`
+	TopProducts []AnalyticsProduct         `json:"top_products"`
+	Socials     []AnalyticsSocial          `json:"socials"`
+	Referrers   []AnalyticsReferrer        `json:"referrers"`
+	UAClasses   map[string]AnalyticsCounts `json:"ua_classes"`
+}
+
+// AnalyticsDay is one day of the time series.
+type AnalyticsDay struct {
+	Day string `json:"day"`
+	AnalyticsCounts
+}
+
+// AnalyticsProduct is a product's views and outbound clicks; CTR is clicks/views.
+type AnalyticsProduct struct {
+	ProductID int64   `json:"product_id"`
+	Title     string  `json:"title"`
+	Views     int     `json:"views"`
+	Clicks    int     `json:"clicks"`
+	CTR       float64 `json:"ctr"`
+}
+
+// AnalyticsSocial is a social link's clicks.
+type AnalyticsSocial struct {
+	SocialID int64  `json:"social_id"`
+	Name     string `json:"name"`
+	Clicks   int    `json:"clicks"`
+}
+
+// AnalyticsReferrer is the traffic from one referrer host ("" = direct).
+type AnalyticsReferrer struct {
+	Host string `json:"host"`
+	AnalyticsCounts
+}
+
+// buildAnalyticsReport aggregates rollup rows for [from, to]. Every day in the
+// range is present in Daily, with zeros when nothing happened.
+func buildAnalyticsReport(rows []analyticsRow, from, to string, includeBots bool, limit int, products []Product, socials []Social) AnalyticsReport {
+	rep := AnalyticsReport{From: from, To: to, IncludeBots: includeBots, UAClasses: map[string]AnalyticsCounts{}}
+	daily := make(map[string]*AnalyticsCounts)
+	byProduct := make(map[int64]*AnalyticsProduct)
+	bySocial := make(map[int64]int)
+	byReferrer := make(map[string]*AnalyticsCounts)
+	for _, r := range rows {
+		ua := rep.UAClasses[r.UAClass]
+		ua.add(r.Metric, r.Count)
+		rep.UAClasses[r.UAClass] = ua
+		if r.UAClass == "bot" && !includeBots {
+			continue
+		}
+		rep.Totals.add(r.Metric, r.Count)
+		if daily[r.Day] == nil {
+			daily[r.Day] = &AnalyticsCounts{}
+		}
+		daily[r.Day].add(r.Metric, r.Count)
+		if byReferrer[r.Referrer] == nil {
+			byReferrer[r.Referrer] = &AnalyticsCounts{}
+		}
+		byReferrer[r.Referrer].add(r.Metric, r.Count)
+		switch r.Metric {
+		case metricView, metricProductClick:
+			p := byProduct[r.TargetID]
+			if p == nil {
+				p = &AnalyticsProduct{ProductID: r.TargetID}
+				byProduct[r.TargetID] = p
+			}
+			if r.Metric == metricView {
+				p.Views += r.Count
+			} else {
+				p.Clicks += r.Count
+			}
+		case metricSocialClick:
+			bySocial[r.TargetID] += r.Count
+		}
+	}
+
+	start, _ := time.ParseInLocation(analyticsDayLayout, from, analyticsTZ)
+	end, _ := time.ParseInLocation(analyticsDayLayout, to, analyticsTZ)
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		day := AnalyticsDay{Day: d.Format(analyticsDayLayout)}
+		if c := daily[day.Day]; c != nil {
+			day.AnalyticsCounts = *c
+		}
+		rep.Daily = append(rep.Daily, day)
+	}
+
+	titles := make(map[int64]string, len(products))
+	for _, p := range products {
+		titles[p.ID] = p.Title
+	}
+	rep.TopProducts = []AnalyticsProduct{}
+	for _, p := range byProduct {
+		p.Title = titles[p.ProductID]
+		if p.Views > 0 {
+			p.CTR = float64(p.Clicks) / float64(p.Views)
+		}
+		rep.TopProducts = append(rep.TopProducts, *p)
+	}
+	sort.Slice(rep.TopProducts, func(i, j int) bool {
+		a, b := rep.TopProducts[i], rep.TopProducts[j]
+		if a.Views+a.Clicks != b.Views+b.Clicks {
+			return a.Views+a.Clicks > b.Views+b.Clicks
+		}
+		return a.ProductID < b.ProductID
+	})
+	if len(rep.TopProducts) > limit {
+		rep.TopProducts = rep.TopProducts[:limit]
+	}
+
+	names := make(map[int64]string, len(socials))
+	for _, s := range socials {
+		names[s.ID] = s.Name
+	}
+	rep.Socials = []AnalyticsSocial{}
+	for id, n := range bySocial {
+		rep.Socials = append(rep.Socials, AnalyticsSocial{SocialID: id, Name: names[id], Clicks: n})
+	}
+	sort.Slice(rep.Socials, func(i, j int) bool {
+		if rep.Socials[i].Clicks != rep.Socials[j].Clicks {
+			return rep.Socials[i].Clicks > rep.Socials[j].Clicks
+		}
+		return rep.Socials[i].SocialID < rep.Socials[j].SocialID
+	})
+
+	rep.Referrers = []AnalyticsReferrer{}
+	for host, c := range byReferrer {
+		rep.Referrers = append(rep.Referrers, AnalyticsReferrer{Host: host, AnalyticsCounts: *c})
+	}
+	sort.Slice(rep.Referrers, func(i, j int) bool {
+		a, b := rep.Referrers[i], rep.Referrers[j]
+		ta, tb := a.Views+a.ProductClicks+a.SocialClicks, b.Views+b.ProductClicks+b.SocialClicks
+		if ta != tb {
+			return ta > tb
+		}
+		return a.Host < b.Host
+	})
+	if len(rep.Referrers) > limit {
+		rep.Referrers = rep.Referrers[:limit]
+	}
+	return rep
+}
+
+// analyticsRange reads ?from= and ?to= (YYYY-MM-DD, shop time), defaulting to the
+// last 30 days.
+func analyticsRange(r *http.Request) (string, string, error) {
+	today := time.Now().In(analyticsTZ)
+	to := today.Format(analyticsDayLayout)
+	from := today.AddDate(0, 0, -29).Format(analyticsDayLayout)
+	if v := r.URL.Query().Get("to"); v != "" {
+		to = v
+	}
+	if v := r.URL.Query().Get("from"); v != "" {
+		from = v
+	}
+	start, err := time.ParseInLocation(analyticsDayLayout, from, analyticsTZ)
+	if err != nil {
+		return "", "", errors.New("invalid from (want YYYY-MM-DD)")
+	}
+	end, err := time.ParseInLocation(analyticsDayLayout, to, analyticsTZ)
+	if err != nil {
+		return "", "", errors.New("invalid to (want YYYY-MM-DD)")
+	}
+	if end.Before(start) {
+		return "", "", errors.New("from must not be after to")
+	}
+	if end.Sub(start) > analyticsMaxRangeDay*24*time.Hour {
+		return "", "", fmt.Errorf("range must be at most %d days", analyticsMaxRangeDay)
+	}
+	return from, to, nil
+}
+
+// adminAnalytics serves GET /api/admin/analytics?from=&to=&bots=1&limit=&refresh=1
+// from the daily rollup. refresh=1 runs the rollup first instead of waiting for
+// the background job.
+func adminAnalytics(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		from, to, err := analyticsRange(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		limit := 10
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > 100 {
+				http.Error(w, "limit must be between 1 and 100", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		if r.URL.Query().Get("refresh") == "1" {
+			if err := rollupShop(db); err != nil {
+				log.Println("analytics rollup error:", err)
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
+		}
+		rows, err := fetchAnalyticsRows(db, from, to)
+		if err != nil {
+			log.Println("fetchAnalyticsRows error:", err)
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		products, err := fetchProducts(db)
+		if err != nil {
+			log.Println("fetchProducts error:", err)
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		profile, err := fetchProfile(db)
+		if err != nil {
+			log.Println("fetchProfile error:", err)
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		rep := buildAnalyticsReport(rows, from, to, r.URL.Query().Get("bots") == "1", limit, products, profile.Socials)
+		analyticsRollup.Lock()
+		if at, ok := analyticsRollup.ranAt[db]; ok {
+			rep.RolledUpAt = at.Format(time.RFC3339)
+		}
+		analyticsRollup.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(rep)
+	}
+}
+
+// viewEventHandler serves POST /api/events/view {"product_id", "referrer"}, sent
+// by the storefront when a product is opened. referrer is the page's own
+// document.referrer, i.e. where the visitor came from.
+func viewEventHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var payload struct {
+			ProductID int64  `json:"product_id"`
+			Referrer  string `json:"referrer"`
+		}
+		// navigator.sendBeacon posts JSON as text/plain, so the content type is not checked
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<10)).Decode(&payload); err != nil {
+			http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		p, found, err := fetchProduct(db, payload.ProductID)
+		if err != nil {
+			log.Println("fetchProduct error:", err)
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		if !found || p.Status == statusDraft {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if len(payload.Referrer) > 512 {
+			payload.Referrer = payload.Referrer[:512]
+		}
+		e := ViewEvent{
+			ProductID: p.ID,
+			Referrer:  payload.Referrer,
+			UAClass:   uaClass(r.UserAgent()),
+			IP:        anonymizeIP(clientIP(r)),
+			CreatedAt: time.Now(),
+		}
+		if err := recordView(db, e); err != nil {
+			log.Println("recordView error:", err)
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
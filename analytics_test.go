@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestReferrerHost(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"https://www.Facebook.com/some/page?x=1", "facebook.com"},
+		{"http://l.instagram.com:8080/", "l.instagram.com"},
+		{"android-app://com.zing.zalo/", "com.zing.zalo"},
+		{"", ""},
+		{"not a url", ""},
+		{"/relative/path", ""},
+	}
+	for _, tt := range tests {
+		if got := referrerHost(tt.in); got != tt.want {
+			t.Errorf("referrerHost(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestAggregateEvents(t *testing.T) {
+	// 17:30 UTC is already the next day in Vietnam
+	late := time.Date(2025, 6, 1, 17, 30, 0, 0, time.UTC)
+	early := time.Date(2025, 6, 1, 16, 30, 0, 0, time.UTC)
+	rows := aggregateEvents([]analyticsEvent{
+		{Metric: metricView, TargetID: 1, Referrer: "https://www.facebook.com/x", UAClass: "mobile", At: early},
+		{Metric: metricView, TargetID: 1, Referrer: "https://facebook.com/y", UAClass: "mobile", At: early},
+		{Metric: metricView, TargetID: 1, Referrer: "https://facebook.com/y", UAClass: "mobile", At: late},
+		{Metric: metricProductClick, TargetID: 1, UAClass: "desktop", At: late},
+	})
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Day != rows[j].Day {
+			return rows[i].Day < rows[j].Day
+		}
+		return rows[i].Metric < rows[j].Metric
+	})
+	want := []analyticsRow{
+		{Day: "2025-06-01", Metric: metricView, TargetID: 1, Referrer: "facebook.com", UAClass: "mobile", Count: 2},
+		{Day: "2025-06-02", Metric: metricProductClick, TargetID: 1, UAClass: "desktop", Count: 1},
+		{Day: "2025-06-02", Metric: metricView, TargetID: 1, Referrer: "facebook.com", UAClass: "mobile", Count: 1},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("aggregateEvents = %+v, want %+v", rows, want)
+	}
+}
+
+func TestBuildAnalyticsReport(t *testing.T) {
+	rows := []analyticsRow{
+		{Day: "2025-06-01", Metric: metricView, TargetID: 1, Referrer: "facebook.com", UAClass: "mobile", Count: 10},
+		{Day: "2025-06-01", Metric: metricProductClick, TargetID: 1, Referrer: "facebook.com", UAClass: "mobile", Count: 2},
+		{Day: "2025-06-03", Metric: metricView, TargetID: 2, UAClass: "desktop", Count: 4},
+		{Day: "2025-06-03", Metric: metricView, TargetID: 3, UAClass: "desktop", Count: 1},
+		{Day: "2025-06-03", Metric: metricSocialClick, TargetID: 9, UAClass: "desktop", Count: 3},
+		{Day: "2025-06-02", Metric: metricView, TargetID: 2, UAClass: "bot", Count: 50},
+	}
+	products := []Product{{ID: 1, Title: "Áo"}, {ID: 2, Title: "Quần"}}
+	socials := []Social{{ID: 9, Name: "Instagram"}}
+
+	rep := buildAnalyticsReport(rows, "2025-06-01", "2025-06-03", false, 2, products, socials)
+	if want := (AnalyticsCounts{Views: 15, ProductClicks: 2, SocialClicks: 3}); rep.Totals != want {
+		t.Errorf("totals = %+v, want %+v", rep.Totals, want)
+	}
+	var days []string
+	for _, d := range rep.Daily {
+		days = append(days, d.Day)
+	}
+	if want := []string{"2025-06-01", "2025-06-02", "2025-06-03"}; !reflect.DeepEqual(days, want) {
+		t.Errorf("daily = %v, want every day of the range", days)
+	}
+	if rep.Daily[1].Views != 0 || rep.Daily[2].Views != 5 {
+		t.Errorf("daily views = %+v", rep.Daily)
+	}
+	if len(rep.TopProducts) != 2 || rep.TopProducts[0].ProductID != 1 || rep.TopProducts[0].Title != "Áo" || rep.TopProducts[0].CTR != 0.2 || rep.TopProducts[1].ProductID != 2 {
+		t.Errorf("top products = %+v, want products 1 (ctr 0.2) and 2, limited to 2", rep.TopProducts)
+	}
+	if len(rep.Socials) != 1 || rep.Socials[0].Name != "Instagram" || rep.Socials[0].Clicks != 3 {
+		t.Errorf("socials = %+v", rep.Socials)
+	}
+	if len(rep.Referrers) != 2 || rep.Referrers[0].Host != "facebook.com" || rep.Referrers[1].Host != "" {
+		t.Errorf("referrers = %+v, want facebook.com then direct", rep.Referrers)
+	}
+	// bots are always broken out by class, but only counted when asked for
+	if rep.UAClasses["bot"].Views != 50 {
+		t.Errorf("bot class = %+v, want 50 views", rep.UAClasses["bot"])
+	}
+	withBots := buildAnalyticsReport(rows, "2025-06-01", "2025-06-03", true, 10, products, socials)
+	if withBots.Totals.Views != 65 || withBots.TopProducts[0].ProductID != 2 {
+		t.Errorf("with bots: totals = %+v, top product = %+v", withBots.Totals, withBots.TopProducts[0])
+	}
+}
+
+func TestAnalyticsRange(t *testing.T) {
+	tests := []struct {
+		query, from, to string
+		wantErr         bool
+	}{
+		{"?from=2025-01-01&to=2025-01-31", "2025-01-01", "2025-01-31", false},
+		{"?from=2025-01-01&to=2025-01-01", "2025-01-01", "2025-01-01", false},
+		{"?from=2025-02-01&to=2025-01-01", "", "", true},
+		{"?from=2024-01-01&to=2025-06-01", "", "", true},
+		{"?from=01/02/2025&to=2025-06-01", "", "", true},
+		{"?from=2025-01-01&to=tomorrow", "", "", true},
+	}
+	for _, tt := range tests {
+		from, to, err := analyticsRange(httptest.NewRequest(http.MethodGet, "/api/admin/analytics"+tt.query, nil))
+		if from != tt.from || to != tt.to || (err != nil) != tt.wantErr {
+			t.Errorf("analyticsRange(%s) = %q, %q, %v; want %q, %q, wantErr %v", tt.query, from, to, err, tt.from, tt.to, tt.wantErr)
+		}
+	}
+	from, to, err := analyticsRange(httptest.NewRequest(http.MethodGet, "/api/admin/analytics", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start, _ := time.Parse(analyticsDayLayout, from)
+	end, _ := time.Parse(analyticsDayLayout, to)
+	if days := int(end.Sub(start).Hours()/24) + 1; days != 30 {
+		t.Errorf("default range %s..%s is %d days, want 30", from, to, days)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// arrivalsLimit is how many of the newest products the arrivals feeds list.
+const arrivalsLimit = 30
+
+// newArrivals returns the newest published products, optionally limited to the
+// categories in cats (nil = all), newest first.
+func newArrivals(products []Product, cats map[int64]bool) []Product {
+	var out []Product
+	for _, p := range visibleProducts(products) {
+		if p.Status == statusPublished && (cats == nil || cats[p.CategoryID]) {
+			out = append(out, p)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return parseProductTime(out[i].CreatedAt).After(parseProductTime(out[j].CreatedAt))
+	})
+	if len(out) > arrivalsLimit {
+		out = out[:arrivalsLimit]
+	}
+	return out
+}
+
+// imageMIME guesses an enclosure type from the image URL's extension.
+func imageMIME(u string) string {
+	u, _, _ = strings.Cut(u, "?")
+	switch strings.ToLower(path.Ext(u)) {
+	case ".png":
+		return "image/png"
+	case ".webp":
+		return "image/webp"
+	case ".gif":
+		return "image/gif"
+	}
+	return "image/jpeg"
+}
+
+// arrivalHTML is the entry body: image, price and description.
+func arrivalHTML(p Product) string {
+	var b strings.Builder
+	if p.ImageURL != "" {
+		fmt.Fprintf(&b, `<p><img src="%s" alt="%s"></p>`, html.EscapeString(p.ImageURL), html.EscapeString(p.Title))
+	}
+	price := "Liên hệ"
+	if p.Price > 0 {
+		price = p.EffectivePriceFormatted
+	}
+	fmt.Fprintf(&b, "<p><strong>%s</strong></p>", html.EscapeString(price))
+	if p.Description != "" {
+		fmt.Fprintf(&b, "<p>%s</p>", strings.ReplaceAll(html.EscapeString(p.Description), "\n", "<br>"))
+	}
+	return b.String()
+}
+
+type atomLink struct {
+	Rel    string `xml:"rel,attr,omitempty"`
+	Type   string `xml:"type,attr,omitempty"`
+	Href   string `xml:"href,attr"`
+	Length string `xml:"length,attr,omitempty"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr,omitempty"`
+	Body string `xml:",chardata"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomEntry struct {
+	ID        string        `xml:"id"`
+	Title     string        `xml:"title"`
+	Updated   string        `xml:"updated"`
+	Published string        `xml:"published,omitempty"`
+	Links     []atomLink    `xml:"link"`
+	Category  *atomCategory `xml:"category,omitempty"`
+	Content   atomText      `xml:"content"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name   `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string     `xml:"id"`
+	Title   string     `xml:"title"`
+	Updated string     `xml:"updated"`
+	Links   []atomLink `xml:"link"`
+	Author  struct {
+		Name string `xml:"name"`
+	} `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Type   string `xml:"type,attr"`
+	Length string `xml:"length,attr"`
+}
+
+type rssItem struct {
+	Title string `xml:"title"`
+	Link  string `xml:"link"`
+	GUID  struct {
+		IsPermaLink string `xml:"isPermaLink,attr"`
+		Value       string `xml:",chardata"`
+	} `xml:"guid"`
+	PubDate     string        `xml:"pubDate,omitempty"`
+	Category    string        `xml:"category,omitempty"`
+	Description string        `xml:"description"`
+	Enclosure   *rssEnclosure `xml:"enclosure,omitempty"`
+}
+
+type rssChannelFeed struct {
+	XMLName xml.Name `xml:"rss"`
+	Version string   `xml:"version,attr"`
+	NSAtom  string   `xml:"xmlns:atom,attr"`
+	Channel struct {
+		Title       string    `xml:"title"`
+		Link        string    `xml:"link"`
+		Description string    `xml:"description"`
+		Language    string    `xml:"language"`
+		SelfLink    atomLink  `xml:"atom:link"`
+		LastBuild   string    `xml:"lastBuildDate,omitempty"`
+		Items       []rssItem `xml:"item"`
+	} `xml:"channel"`
+}
+
+// renderArrivalsAtom builds the Atom document; self is the feed's own URL.
+func renderArrivalsAtom(items []Product, shop, base, self string) ([]byte, error) {
+	var feed atomFeed
+	feed.ID, feed.Title, feed.Author.Name = base+"/", shop+" – hàng mới về", shop
+	feed.Links = []atomLink{{Rel: "self", Type: "application/atom+xml", Href: self}, {Rel: "alternate", Type: "text/html", Href: base + "/"}}
+	var newest time.Time
+	for _, p := range items {
+		mod := productLastModified(p)
+		if mod.After(newest) {
+			newest = mod
+		}
+		e := atomEntry{
+			ID:        base + p.PageURL,
+			Title:     p.Title,
+			Updated:   sitemapLastMod(mod),
+			Published: sitemapLastMod(parseProductTime(p.CreatedAt)),
+			Links:     []atomLink{{Rel: "alternate", Type: "text/html", Href: base + p.PageURL}},
+			Content:   atomText{Type: "html", Body: arrivalHTML(p)},
+		}
+		if p.ImageURL != "" {
+			e.Links = append(e.Links, atomLink{Rel: "enclosure", Type: imageMIME(p.ImageURL), Href: p.ImageURL})
+		}
+		if p.Category != "" {
+			e.Category = &atomCategory{Term: p.Category}
+		}
+		feed.Entries = append(feed.Entries, e)
+	}
+	if newest.IsZero() {
+		newest = time.Now()
+	}
+	feed.Updated = sitemapLastMod(newest)
+	return encodeXMLDoc(feed)
+}
+
+// renderArrivalsRSS builds the RSS 2.0 document; self is the feed's own URL.
+func renderArrivalsRSS(items []Product, shop, base, self string) ([]byte, error) {
+	feed := rssChannelFeed{Version: "2.0", NSAtom: "http://www.w3.org/2005/Atom"}
+	ch := &feed.Channel
+	ch.Title, ch.Link, ch.Description, ch.Language = shop+" – hàng mới về", base+"/", "Sản phẩm mới của "+shop, "vi"
+	ch.SelfLink = atomLink{Rel: "self", Type: "application/rss+xml", Href: self}
+	for i, p := range items {
+		it := rssItem{Title: p.Title, Link: base + p.PageURL, Category: p.Category, Description: arrivalHTML(p)}
+		it.GUID.IsPermaLink, it.GUID.Value = "true", base+p.PageURL
+		if t := parseProductTime(p.CreatedAt); !t.IsZero() {
+			it.PubDate = t.Format(time.RFC1123Z)
+			if i == 0 {
+				ch.LastBuild = it.PubDate
+			}
+		}
+		if p.ImageURL != "" {
+			// the image size is unknown without fetching it; RSS readers accept 0
+			it.Enclosure = &rssEnclosure{URL: p.ImageURL, Type: imageMIME(p.ImageURL), Length: "0"}
+		}
+		ch.Items = append(ch.Items, it)
+	}
+	return encodeXMLDoc(feed)
+}
+
+// arrivalsFeedHandler serves the new-arrivals feeds: /feed.xml (Atom) and
+// /feed.rss (RSS 2.0), optionally filtered by ?category=<id or slug>.
+func arrivalsFeedHandler(db *sql.DB, atom bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var catIDs map[int64]bool
+		ref := r.URL.Query().Get("category")
+		if ref != "" {
+			cats, err := fetchCategories(db)
+			if err != nil {
+				log.Println("arrivals categories error:", err)
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
+			c, ok := findCategory(cats, ref)
+			if !ok {
+				http.Error(w, "category not found", http.StatusNotFound)
+				return
+			}
+			catIDs = categoryDescendants(cats, c.ID)
+		}
+		base := siteURL(r)
+		self := base + r.URL.Path
+		if ref != "" {
+			self += "?category=" + url.QueryEscape(ref)
+		}
+		body, err := renderedCatalog.cached(r, "arrivals "+r.URL.Path+" "+ref, func() ([]byte, error) {
+			products, err := fetchProducts(db)
+			if err != nil {
+				return nil, err
+			}
+			profile, err := fetchProfile(db)
+			if err != nil {
+				return nil, err
+			}
+			items := newArrivals(products, catIDs)
+			if atom {
+				return renderArrivalsAtom(items, profile.DisplayName, base, self)
+			}
+			return renderArrivalsRSS(items, profile.DisplayName, base, self)
+		})
+		if err != nil {
+			log.Println("arrivals feed error:", err)
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		if atom {
+			w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		} else {
+			w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+		}
+		w.Header().Set("Cache-Control", "public, max-age=900")
+		_, _ = w.Write(body)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewArrivals(t *testing.T) {
+	day := func(d int) string { return time.Date(2025, 1, d, 0, 0, 0, 0, time.UTC).Format(time.RFC3339) }
+	products := []Product{
+		{ID: 1, CreatedAt: day(1), Status: statusPublished, CategoryID: 1},
+		{ID: 2, CreatedAt: day(5), Status: statusDraft, CategoryID: 1},
+		{ID: 3, CreatedAt: day(3), Status: statusSoldOut, CategoryID: 1},
+		{ID: 4, CreatedAt: day(4), Status: statusPublished, CategoryID: 2},
+		{ID: 5, CreatedAt: day(2), Status: statusPublished, Pinned: true},
+	}
+	if got := productIDs(newArrivals(products, nil)); !reflect.DeepEqual(got, []int64{4, 5, 1}) {
+		t.Errorf("newArrivals = %v, want published products newest first (pinning ignored)", got)
+	}
+	if got := productIDs(newArrivals(products, map[int64]bool{1: true})); !reflect.DeepEqual(got, []int64{1}) {
+		t.Errorf("newArrivals in category 1 = %v, want [1]", got)
+	}
+
+	var many []Product
+	for i := 1; i <= arrivalsLimit+5; i++ {
+		many = append(many, Product{ID: int64(i), CreatedAt: time.Date(2025, 1, 1, i, 0, 0, 0, time.UTC).Format(time.RFC3339), Status: statusPublished})
+	}
+	got := newArrivals(many, nil)
+	if len(got) != arrivalsLimit || got[0].ID != int64(arrivalsLimit+5) {
+		t.Errorf("newArrivals of %d products = %d items starting at %d, want %d starting at the newest", len(many), len(got), got[0].ID, arrivalsLimit)
+	}
+}
+
+func TestImageMIME(t *testing.T) {
+	tests := map[string]string{
+		"https://img.test/a.PNG":            "image/png",
+		"https://img.test/a.webp?w=300":     "image/webp",
+		"https://img.test/a.gif":            "image/gif",
+		"https://img.test/a.jpg":            "image/jpeg",
+		"https://img.test/image?format=png": "image/jpeg",
+	}
+	for in, want := range tests {
+		if got := imageMIME(in); got != want {
+			t.Errorf("imageMIME(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestArrivalHTML(t *testing.T) {
+	p := Product{Title: `Áo "đẹp" <b>`, ImageURL: "https://img.test/a.jpg?x=1&y=2", Price: 100000, EffectivePriceFormatted: "90.000 ₫", Description: "Dòng 1\n<script>"}
+	want := `<p><img src="https://img.test/a.jpg?x=1&amp;y=2" alt="Áo &#34;đẹp&#34; &lt;b&gt;"></p><p><strong>90.000 ₫</strong></p><p>Dòng 1<br>&lt;script&gt;</p>`
+	if got := arrivalHTML(p); got != want {
+		t.Errorf("arrivalHTML =\n%s\nwant\n%s", got, want)
+	}
+	if got := arrivalHTML(Product{Title: "Liên hệ giá"}); got != "<p><strong>Liên hệ</strong></p>" {
+		t.Errorf("arrivalHTML without price or image = %q", got)
+	}
+}
+
+func arrivalsRequest(atom bool, query string) *httptest.ResponseRecorder {
+	path := "/feed.rss"
+	if atom {
+		path = "/feed.xml"
+	}
+	w := httptest.NewRecorder()
+	arrivalsFeedHandler(nil, atom)(w, httptest.NewRequest(http.MethodGet, path+query, nil))
+	return w
+}
+
+func TestArrivalsAtomFeed(t *testing.T) {
+	useDevFeedCatalog(t)
+	w := arrivalsRequest(true, "")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/atom+xml") {
+		t.Fatalf("status = %d, content type = %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	var feed atomFeed
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, e := range feed.Entries {
+		ids = append(ids, e.ID)
+	}
+	// published products only, newest first; sold-out and draft items are left out
+	want := []string{"https://tram.test/p/6-khong-gia", "https://tram.test/p/5-khong-anh", "https://tram.test/p/3-ao-len", "https://tram.test/p/1-ao-khoac-jean"}
+	if !reflect.DeepEqual(ids, want) {
+		t.Errorf("entries = %v, want %v", ids, want)
+	}
+	if feed.Title != "Tram Shop – hàng mới về" || feed.Author.Name != "Tram Shop" {
+		t.Errorf("feed title = %q, author = %q", feed.Title, feed.Author.Name)
+	}
+	if len(feed.Links) == 0 || feed.Links[0].Rel != "self" || feed.Links[0].Href != "https://tram.test/feed.xml" {
+		t.Errorf("feed links = %+v, want a self link first", feed.Links)
+	}
+	last := feed.Entries[3]
+	if last.Category == nil || last.Category.Term != "Áo khoác" || last.Published != "2025-01-01T00:00:00Z" {
+		t.Errorf("entry = %+v", last)
+	}
+	if n := len(last.Links); n != 2 || last.Links[1].Rel != "enclosure" || last.Links[1].Type != "image/jpeg" {
+		t.Errorf("entry links = %+v, want alternate and image enclosure", last.Links)
+	}
+	if len(feed.Entries[1].Links) != 1 {
+		t.Errorf("entry without image has links %+v, want no enclosure", feed.Entries[1].Links)
+	}
+}
+
+func TestArrivalsRSSFeed(t *testing.T) {
+	useDevFeedCatalog(t)
+	w := arrivalsRequest(false, "?category=quan-ao")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/rss+xml") {
+		t.Fatalf("status = %d, content type = %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		`<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom">`,
+		`<atom:link rel="self" type="application/rss+xml" href="https://tram.test/feed.rss?category=quan-ao"></atom:link>`,
+		`<guid isPermaLink="true">https://tram.test/p/3-ao-len</guid>`,
+		`<enclosure url="https://img.test/1.jpg" type="image/jpeg" length="0"></enclosure>`,
+		fmt.Sprintf("<lastBuildDate>%s</lastBuildDate>", time.Date(2025, 1, 3, 0, 0, 0, 0, time.UTC).Format(time.RFC1123Z)),
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("feed.rss is missing %s", want)
+		}
+	}
+	if n := strings.Count(body, "<item>"); n != 2 {
+		t.Errorf("feed.rss?category=quan-ao has %d items, want 2", n)
+	}
+
+	if w := arrivalsRequest(false, "?category=tui-xach"); w.Code != http.StatusNotFound {
+		t.Errorf("unknown category = %d, want 404", w.Code)
+	}
+}
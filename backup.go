@@ -0,0 +1,785 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/cloudinary/cloudinary-go/v2"
+	"github.com/cloudinary/cloudinary-go/v2/api/uploader"
+)
+
+// Backup archives are zip files holding manifest.json, data.json (a ShopSnapshot)
+// and optionally images/ with the product and avatar images. Bump backupVersion
+// whenever ShopSnapshot changes incompatibly; restore refuses newer archives.
+// Version 2 stores amounts as integer minor units with the currency on the profile.
+// Version 3 adds orders, version 4 coupons, version 5 shipping zones and version 6
+// profile blocks. Sections an older archive lacks are left as they are on
+// restore (their slices stay nil), so older archives still restore.
+const (
+	backupFormat  = "tram-backup"
+	backupVersion = 6
+	maxImageBytes = 20 << 20
+	// maxBackupBytes caps an uploaded archive, images included.
+	maxBackupBytes = 512 << 20
+	// Limits on reading an archive: data.json and manifest.json each, the number
+	// of entries and everything decompressed together.
+	maxBackupDataBytes  = 64 << 20
+	maxBackupEntries    = 5000
+	maxBackupTotalBytes = 1 << 30
+)
+
+// ShopSnapshot is the complete shop content. Product.Collections carries the
+// product/collection links; the ids only tie rows of one snapshot together.
+// Sections added after version 1 are nil when missing from the source, which
+// restore treats as "keep the current rows".
+type ShopSnapshot struct {
+	Profile       Profile        `json:"profile"`
+	Socials       []Social       `json:"socials"`
+	Categories    []Category     `json:"categories"`
+	Collections   []Collection   `json:"collections"`
+	Products      []Product      `json:"products"`
+	Orders        []Order        `json:"orders"`
+	Coupons       []Coupon       `json:"coupons"`
+	ShippingZones []ShippingZone `json:"shipping_zones"`
+	ProfileBlocks []ProfileBlock `json:"profile_blocks"`
+}
+
+// backupManifest describes an archive. Images maps an original image URL to its
+// file inside the archive.
+type backupManifest struct {
+	Format    string            `json:"format"`
+	Version   int               `json:"version"`
+	CreatedAt string            `json:"created_at"`
+	Counts    map[string]int    `json:"counts"`
+	Images    map[string]string `json:"images,omitempty"`
+}
+
+// loadSnapshot reads the whole shop from MySQL (or the dev store when db==nil).
+func loadSnapshot(db *sql.DB) (ShopSnapshot, error) {
+	var snap ShopSnapshot
+	var err error
+	if snap.Profile, err = fetchProfile(db); err != nil {
+		return snap, err
+	}
+	snap.Socials, snap.Profile.Socials = snap.Profile.Socials, nil
+	if snap.Categories, err = fetchCategories(db); err != nil {
+		return snap, err
+	}
+	if snap.Collections, err = fetchCollections(db); err != nil {
+		return snap, err
+	}
+	for i := range snap.Collections {
+		snap.Collections[i].ProductCount = 0
+	}
+	if snap.Products, err = fetchProducts(db); err != nil {
+		return snap, err
+	}
+	if snap.Orders, err = fetchAllOrders(db); err != nil {
+		return snap, err
+	}
+	if snap.Orders == nil {
+		snap.Orders = []Order{}
+	}
+	if snap.Coupons, err = fetchCoupons(db); err != nil {
+		return snap, err
+	}
+	if snap.Coupons == nil {
+		snap.Coupons = []Coupon{}
+	}
+	if snap.ShippingZones, err = fetchShippingZones(db); err != nil {
+		return snap, err
+	}
+	if snap.ShippingZones == nil {
+		snap.ShippingZones = []ShippingZone{}
+	}
+	if snap.ProfileBlocks, err = fetchProfileBlocks(db); err != nil {
+		return snap, err
+	}
+	if snap.ProfileBlocks == nil {
+		snap.ProfileBlocks = []ProfileBlock{}
+	}
+	return snap, nil
+}
+
+// counts summarises a snapshot for manifests and API responses.
+func (s ShopSnapshot) counts() map[string]int {
+	return map[string]int{
+		"products":       len(s.Products),
+		"categories":     len(s.Categories),
+		"collections":    len(s.Collections),
+		"socials":        len(s.Socials),
+		"orders":         len(s.Orders),
+		"coupons":        len(s.Coupons),
+		"shipping_zones": len(s.ShippingZones),
+		"profile_blocks": len(s.ProfileBlocks),
+	}
+}
+
+// imageURLs lists the distinct http(s) image URLs referenced by the snapshot.
+func (s ShopSnapshot) imageURLs() []string {
+	seen := make(map[string]bool)
+	var out []string
+	add := func(u string) {
+		if (strings.HasPrefix(u, "http://") || strings.HasPrefix(u, "https://")) && !seen[u] {
+			seen[u] = true
+			out = append(out, u)
+		}
+	}
+	add(s.Profile.AvatarURL)
+	for _, p := range s.Products {
+		add(p.ImageURL)
+	}
+	return out
+}
+
+// downloadImage fetches an image for the archive and returns its bytes and file extension.
+func downloadImage(client *http.Client, url string) ([]byte, string, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("status %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxImageBytes+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) > maxImageBytes {
+		return nil, "", errors.New("image too large")
+	}
+	ext := strings.ToLower(path.Ext(strings.SplitN(url, "?", 2)[0]))
+	if ext == "" || len(ext) > 5 {
+		ext = ".jpg"
+		if exts, _ := mime.ExtensionsByType(resp.Header.Get("Content-Type")); len(exts) > 0 {
+			ext = exts[0]
+		}
+	}
+	return data, ext, nil
+}
+
+// writeBackup writes a backup archive of snap to w. With images set, every referenced
+// image is downloaded into the archive; images that cannot be fetched are logged
+// and left as URLs only.
+func writeBackup(w io.Writer, snap ShopSnapshot, images bool) error {
+	zw := zip.NewWriter(w)
+	manifest := backupManifest{
+		Format:    backupFormat,
+		Version:   backupVersion,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		Counts:    snap.counts(),
+	}
+	if images {
+		manifest.Images = make(map[string]string)
+		client := &http.Client{Timeout: 30 * time.Second}
+		for i, url := range snap.imageURLs() {
+			data, ext, err := downloadImage(client, url)
+			if err != nil {
+				log.Printf("backup: skip image %s: %v", url, err)
+				continue
+			}
+			name := fmt.Sprintf("images/%04d%s", i+1, ext)
+			f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: time.Now()})
+			if err != nil {
+				return err
+			}
+			if _, err := f.Write(data); err != nil {
+				return err
+			}
+			manifest.Images[url] = name
+		}
+	}
+	for _, entry := range []struct {
+		name string
+		v    interface{}
+	}{{"data.json", snap}, {"manifest.json", manifest}} {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: entry.name, Method: zip.Deflate, Modified: time.Now()})
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(entry.v); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// readBackup parses an archive and returns its snapshot, manifest and image files by archive path.
+func readBackup(data []byte) (ShopSnapshot, backupManifest, map[string][]byte, error) {
+	var snap ShopSnapshot
+	var manifest backupManifest
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return snap, manifest, nil, fmt.Errorf("open archive: %w", err)
+	}
+	if len(zr.File) > maxBackupEntries {
+		return snap, manifest, nil, fmt.Errorf("archive has more than %d files", maxBackupEntries)
+	}
+	files := make(map[string][]byte)
+	var total int64
+	for _, f := range zr.File {
+		limit := int64(maxImageBytes)
+		switch {
+		case f.Name == "data.json" || f.Name == "manifest.json":
+			limit = maxBackupDataBytes
+		case !strings.HasPrefix(f.Name, "images/"):
+			continue // not ours
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return snap, manifest, nil, err
+		}
+		b, err := io.ReadAll(io.LimitReader(rc, limit+1))
+		rc.Close()
+		if err != nil {
+			return snap, manifest, nil, fmt.Errorf("read %s: %w", f.Name, err)
+		}
+		if int64(len(b)) > limit {
+			return snap, manifest, nil, fmt.Errorf("%s is larger than %d MB", f.Name, limit>>20)
+		}
+		if total += int64(len(b)); total > maxBackupTotalBytes {
+			return snap, manifest, nil, fmt.Errorf("archive unpacks to more than %d MB", maxBackupTotalBytes>>20)
+		}
+		files[f.Name] = b
+	}
+	if err := json.Unmarshal(files["manifest.json"], &manifest); err != nil {
+		return snap, manifest, nil, fmt.Errorf("manifest.json: %w", err)
+	}
+	if manifest.Format != backupFormat {
+		return snap, manifest, nil, errors.New("not a shop backup archive")
+	}
+	if manifest.Version < 1 || manifest.Version > backupVersion {
+		return snap, manifest, nil, fmt.Errorf("unsupported backup version %d (this server reads up to %d)", manifest.Version, backupVersion)
+	}
+	if err := json.Unmarshal(files["data.json"], &snap); err != nil {
+		return snap, manifest, nil, fmt.Errorf("data.json: %w", err)
+	}
+	if manifest.Version == 1 {
+		// version 1 predates currencies; every shop sold in đồng, whose amounts
+		// are the same in major and minor units
+		snap.Profile.Currency = defaultCurrency
+	}
+	if manifest.Version < 3 {
+		snap.Orders = nil
+	}
+	if manifest.Version < 4 {
+		snap.Coupons = nil
+	}
+	if manifest.Version < 5 {
+		snap.ShippingZones = nil
+	}
+	if manifest.Version < 6 {
+		snap.ProfileBlocks = nil
+	}
+	images := make(map[string][]byte)
+	for url, name := range manifest.Images {
+		if b, ok := files[name]; ok {
+			images[url] = b
+		}
+	}
+	return snap, manifest, images, nil
+}
+
+// reuploadImages uploads archived images to Cloudinary and points the snapshot at the
+// new URLs. Without Cloudinary the original URLs are kept.
+func reuploadImages(cloudURL string, snap *ShopSnapshot, images map[string][]byte) error {
+	if cloudURL == "" || len(images) == 0 {
+		return nil
+	}
+	cld, err := cloudinary.NewFromURL(cloudURL)
+	if err != nil {
+		return fmt.Errorf("cloudinary init: %w", err)
+	}
+	type uploaded struct{ url, publicID string }
+	done := make(map[string]uploaded)
+	upload := func(url string) (uploaded, bool) {
+		if u, ok := done[url]; ok {
+			return u, true
+		}
+		data, ok := images[url]
+		if !ok {
+			return uploaded{}, false
+		}
+		res, err := cld.Upload.Upload(context.Background(), bytes.NewReader(data), uploader.UploadParams{})
+		if err != nil {
+			log.Printf("restore: upload %s: %v", url, err)
+			return uploaded{}, false
+		}
+		done[url] = uploaded{res.SecureURL, res.PublicID}
+		return done[url], true
+	}
+	if u, ok := upload(snap.Profile.AvatarURL); ok {
+		snap.Profile.AvatarURL = u.url
+	}
+	for i := range snap.Products {
+		if u, ok := upload(snap.Products[i].ImageURL); ok {
+			snap.Products[i].ImageURL, snap.Products[i].ImagePublicID = u.url, u.publicID
+		}
+	}
+	return nil
+}
+
+// restoreSnapshot replaces all content of the current shop with snap. In MySQL
+// everything runs in one transaction and rows get new ids, since ids are shared
+// by all shops; references between restored rows (category parents, product
+// categories and collections, order lines, featured products) are remapped, so a snapshot can be
+// restored into any shop or database. Price history, sync state and events are
+// kept for products restored into the shop they came from (see
+// remapProductRefsTx). The dev store keeps the archived ids.
+func restoreSnapshot(db *sql.DB, snap ShopSnapshot) error {
+	if db == nil {
+		DevRestoreSnapshot(snap)
+		return nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	oldTitles, err := shopProductTitles(tx)
+	if err != nil {
+		return err
+	}
+	for _, table := range []string{"product_collections", "products", "collections", "categories", "socials"} {
+		if _, err := tx.Exec("DELETE FROM " + table + " WHERE shop_id = @shop_id"); err != nil {
+			return fmt.Errorf("clear %s: %w", table, err)
+		}
+	}
+	p := snap.Profile
+	if _, err := tx.Exec(`UPDATE profile SET display_name=?, username=?, bio=?, highlight=?, avatar_url=?, currency=?, shopee_affiliate_params=? WHERE shop_id = @shop_id`,
+		p.DisplayName, p.Username, p.Bio, p.Highlight, p.AvatarURL, currencyOrDefault(p.Currency).Code, sqlNullString(p.ShopeeAffiliateParams)); err != nil {
+		return fmt.Errorf("restore profile: %w", err)
+	}
+	for _, s := range snap.Socials {
+		if _, err := tx.Exec("INSERT INTO socials (shop_id, name, url, icon, ord) VALUES (@shop_id, ?, ?, ?, ?)", s.Name, s.URL, s.Icon, s.Ord); err != nil {
+			return fmt.Errorf("restore social %d: %w", s.ID, err)
+		}
+	}
+	// archived id -> new id; parents are linked once every category exists
+	catIDs := make(map[int64]int64, len(snap.Categories))
+	for _, c := range snap.Categories {
+		res, err := tx.Exec("INSERT INTO categories (shop_id, name, slug, position) VALUES (@shop_id, ?, ?, ?)", c.Name, sqlNullString(c.Slug), c.Position)
+		if err != nil {
+			return fmt.Errorf("restore category %d: %w", c.ID, err)
+		}
+		if catIDs[c.ID], err = res.LastInsertId(); err != nil {
+			return err
+		}
+	}
+	for _, c := range snap.Categories {
+		if parent, ok := catIDs[c.ParentID]; ok {
+			if _, err := tx.Exec("UPDATE categories SET parent_id = ? WHERE id = ? AND shop_id = @shop_id", parent, catIDs[c.ID]); err != nil {
+				return fmt.Errorf("restore category %d parent: %w", c.ID, err)
+			}
+		}
+	}
+	collectionIDs := make(map[int64]int64, len(snap.Collections))
+	for _, c := range snap.Collections {
+		res, err := tx.Exec("INSERT INTO collections (shop_id, name, slug, position) VALUES (@shop_id, ?, ?, ?)", c.Name, c.Slug, c.Position)
+		if err != nil {
+			return fmt.Errorf("restore collection %d: %w", c.ID, err)
+		}
+		if collectionIDs[c.ID], err = res.LastInsertId(); err != nil {
+			return err
+		}
+	}
+	productIDs := make(map[int64]int64, len(snap.Products))
+	for _, p := range snap.Products {
+		created := restoredTime(p.CreatedAt, time.Now())
+		status := p.Status
+		if !validProductStatus(status) {
+			status = statusPublished
+		}
+		res, err := tx.Exec(`INSERT INTO products (shop_id, title, description, price_minor, image_url, image_public_id, external_url, external_key, source, tag, status, stock, weight_grams, sale_price_minor, sale_starts_at, sale_ends_at, category_id, position, pinned, created_at)
+			VALUES (@shop_id, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			p.Title, p.Description, int64(p.Price), p.ImageURL, sqlNullString(p.ImagePublicID), sqlNullString(p.ExternalURL), sqlNullString(p.ExternalKey),
+			p.Source, p.Source, status, sqlNullInt(p.Stock), sqlNullInt(p.WeightGrams), sqlNullPrice(p.SalePrice), sqlNullTime(p.SaleStartsAt), sqlNullTime(p.SaleEndsAt), sqlNull(catIDs[p.CategoryID]), p.Position, p.Pinned, created)
+		if err != nil {
+			return fmt.Errorf("restore product %d: %w", p.ID, err)
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		productIDs[p.ID] = id
+		for _, c := range p.Collections {
+			cid, ok := collectionIDs[c.ID]
+			if !ok {
+				continue
+			}
+			if _, err := tx.Exec("INSERT INTO product_collections (shop_id, product_id, collection_id) VALUES (@shop_id, ?, ?)", id, cid); err != nil {
+				return fmt.Errorf("restore product %d collections: %w", p.ID, err)
+			}
+		}
+	}
+	if err := remapProductRefsTx(tx, snap.Products, productIDs, oldTitles); err != nil {
+		return err
+	}
+	// coupons first, so that orders can point at the restored ones
+	var couponIDs map[int64]int64
+	var couponCodes map[string]int64
+	if snap.Coupons != nil {
+		if couponIDs, couponCodes, err = restoreCouponsTx(tx, snap.Coupons); err != nil {
+			return err
+		}
+	} else if snap.Orders != nil {
+		if couponCodes, err = shopCouponCodes(tx); err != nil {
+			return err
+		}
+	}
+	if snap.Orders != nil {
+		if err := restoreOrdersTx(tx, snap.Orders, productIDs, couponIDs, couponCodes); err != nil {
+			return err
+		}
+	}
+	if snap.ShippingZones != nil {
+		if err := restoreShippingZonesTx(tx, snap.ShippingZones); err != nil {
+			return err
+		}
+	}
+	if snap.ProfileBlocks != nil {
+		if err := restoreProfileBlocksTx(tx, snap.ProfileBlocks, productIDs); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// restoredTime parses an archived timestamp, which is RFC 3339 or, from a
+// database read without parseTime, "2006-01-02 15:04:05"; fallback otherwise.
+func restoredTime(s string, fallback time.Time) time.Time {
+	if t := parseProductTime(s); !t.IsZero() {
+		return t
+	}
+	return fallback
+}
+
+// shopProductTitles returns the titles of the shop's products by id.
+func shopProductTitles(tx *sql.Tx) (map[int64]string, error) {
+	rows, err := tx.Query("SELECT id, title FROM products WHERE shop_id = @shop_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var title string
+		if err := rows.Scan(&id, &title); err != nil {
+			return nil, err
+		}
+		out[id] = title
+	}
+	return out, rows.Err()
+}
+
+// productRefs lists the columns outside the snapshot that hold a product id.
+var productRefs = []struct{ table, column, where string }{
+	{"price_history", "product_id", ""},
+	{"shopee_sync", "product_id", ""},
+	{"view_events", "product_id", ""},
+	{"click_events", "target_id", " AND kind = '" + clickProduct + "'"},
+	{"analytics_daily", "target_id", " AND metric IN ('" + metricView + "', '" + metricProductClick + "')"},
+	{"cart_items", "product_id", ""},
+}
+
+// remapProductRefsTx moves price history, sync state, events and cart lines to
+// the restored products. A row follows its product when the archive is of this
+// shop, i.e. the shop had a product with the archived id and the same title;
+// rows of any other product are deleted. New ids come from AUTO_INCREMENT, so
+// they never clash with the old ones being rewritten.
+func remapProductRefsTx(tx *sql.Tx, products []Product, productIDs map[int64]int64, oldTitles map[int64]string) error {
+	for _, p := range products {
+		title, ok := oldTitles[p.ID]
+		if !ok || title != p.Title {
+			continue
+		}
+		for _, ref := range productRefs {
+			if _, err := tx.Exec("UPDATE "+ref.table+" SET "+ref.column+" = ? WHERE "+ref.column+" = ? AND shop_id = @shop_id"+ref.where, productIDs[p.ID], p.ID); err != nil {
+				return fmt.Errorf("remap %s: %w", ref.table, err)
+			}
+		}
+	}
+	for _, ref := range productRefs {
+		if _, err := tx.Exec("DELETE FROM " + ref.table + " WHERE shop_id = @shop_id" + ref.where + " AND " + ref.column + " NOT IN (SELECT id FROM products WHERE shop_id = @shop_id)"); err != nil {
+			return fmt.Errorf("clear stale %s: %w", ref.table, err)
+		}
+	}
+	return nil
+}
+
+// restoreOrdersTx replaces the shop's orders. Lines of products that are not in
+// the snapshot keep product id 0, like lines of deleted products. An order's
+// coupon is found by its archived id in couponIDs (archived id -> new id), or,
+// for archives older than coupon ids on orders, by code in couponCodes.
+func restoreOrdersTx(tx *sql.Tx, orders []Order, productIDs, couponIDs map[int64]int64, couponCodes map[string]int64) error {
+	for _, table := range []string{"order_items", "orders"} {
+		if _, err := tx.Exec("DELETE FROM " + table + " WHERE shop_id = @shop_id"); err != nil {
+			return fmt.Errorf("clear %s: %w", table, err)
+		}
+	}
+	for _, o := range orders {
+		created := restoredTime(o.CreatedAt, time.Now())
+		updated := restoredTime(o.UpdatedAt, created)
+		couponID, ok := couponIDs[o.CouponID]
+		if !ok && o.CouponCode != "" {
+			couponID = couponCodes[normalizeCouponCode(o.CouponCode)]
+		}
+		res, err := tx.Exec("INSERT INTO orders (shop_id, customer_name, phone, address, note, status, admin_note, currency, subtotal_minor, discount_minor, coupon_code, coupon_id, total_minor, created_at, updated_at) VALUES (@shop_id, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			o.CustomerName, o.Phone, o.Address, o.Note, o.Status, o.AdminNote, currencyOrDefault(o.Currency).Code, int64(o.Subtotal), int64(o.Discount), sqlNullString(o.CouponCode), sqlNull(couponID), int64(o.Total), created, updated)
+		if err != nil {
+			return fmt.Errorf("restore order %d: %w", o.ID, err)
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		for _, it := range o.Items {
+			if _, err := tx.Exec("INSERT INTO order_items (shop_id, order_id, product_id, title, variant, quantity, unit_price_minor) VALUES (@shop_id, ?, ?, ?, ?, ?, ?)",
+				id, productIDs[it.ProductID], it.Title, it.Variant, it.Quantity, int64(it.UnitPrice)); err != nil {
+				return fmt.Errorf("restore order %d items: %w", o.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// restoreCouponsTx replaces the shop's coupons, keeping their use counts. It
+// returns the new coupon ids by archived id and by code.
+func restoreCouponsTx(tx *sql.Tx, coupons []Coupon) (map[int64]int64, map[string]int64, error) {
+	if _, err := tx.Exec("DELETE FROM coupons WHERE shop_id = @shop_id"); err != nil {
+		return nil, nil, fmt.Errorf("clear coupons: %w", err)
+	}
+	ids := make(map[int64]int64, len(coupons))
+	codes := make(map[string]int64, len(coupons))
+	for _, c := range coupons {
+		created := restoredTime(c.CreatedAt, time.Now())
+		maxUses := sqlNullInt(nil)
+		if c.MaxUses > 0 {
+			maxUses = c.MaxUses
+		}
+		code := normalizeCouponCode(c.Code)
+		res, err := tx.Exec("INSERT INTO coupons (shop_id, code, kind, value, amount_minor, min_order_minor, max_uses, used_count, expires_at, active, created_at) VALUES (@shop_id, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			code, c.Kind, c.Percent, sqlNullMoney(c.Amount), int64(c.MinOrder), maxUses, c.UsedCount, sqlNullTime(c.ExpiresAt), c.Active, created)
+		if err != nil {
+			return nil, nil, fmt.Errorf("restore coupon %s: %w", c.Code, err)
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return nil, nil, err
+		}
+		ids[c.ID], codes[code] = id, id
+	}
+	return ids, codes, nil
+}
+
+// shopCouponCodes returns the ids of the shop's coupons by code.
+func shopCouponCodes(tx *sql.Tx) (map[string]int64, error) {
+	rows, err := tx.Query("SELECT id, code FROM coupons WHERE shop_id = @shop_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make(map[string]int64)
+	for rows.Next() {
+		var id int64
+		var code string
+		if err := rows.Scan(&id, &code); err != nil {
+			return nil, err
+		}
+		out[code] = id
+	}
+	return out, rows.Err()
+}
+
+// restoreShippingZonesTx replaces the shop's shipping zones.
+func restoreShippingZonesTx(tx *sql.Tx, zones []ShippingZone) error {
+	if _, err := tx.Exec("DELETE FROM shipping_zones WHERE shop_id = @shop_id"); err != nil {
+		return fmt.Errorf("clear shipping zones: %w", err)
+	}
+	for _, z := range zones {
+		provinces, err := json.Marshal(z.Provinces)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("INSERT INTO shipping_zones (shop_id, name, provinces, kind, base_fee_minor, per_kg_fee_minor, included_grams, free_over_minor, position) VALUES (@shop_id, ?, ?, ?, ?, ?, ?, ?, ?)",
+			z.Name, string(provinces), z.Kind, int64(z.BaseFee), int64(z.PerKgFee), z.IncludedGrams, sqlNullMoney(z.FreeOver), z.Position); err != nil {
+			return fmt.Errorf("restore shipping zone %q: %w", z.Name, err)
+		}
+	}
+	return nil
+}
+
+// restoreProfileBlocksTx replaces the shop's profile blocks. Product blocks
+// point at the restored product; public output skips them when it is missing.
+func restoreProfileBlocksTx(tx *sql.Tx, blocks []ProfileBlock, productIDs map[int64]int64) error {
+	if _, err := tx.Exec("DELETE FROM profile_blocks WHERE shop_id = @shop_id"); err != nil {
+		return fmt.Errorf("clear profile blocks: %w", err)
+	}
+	for _, b := range blocks {
+		if _, err := tx.Exec("INSERT INTO profile_blocks (shop_id, type, title, body, url, product_id, position, visible, starts_at, ends_at, created_at) VALUES (@shop_id, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			b.Type, sqlNullString(b.Title), sqlNullString(b.Body), sqlNullString(b.URL), sqlNull(productIDs[b.ProductID]), b.Position, b.Visible, sqlNullTime(b.StartsAt), sqlNullTime(b.EndsAt), time.Now()); err != nil {
+			return fmt.Errorf("restore profile block %d: %w", b.ID, err)
+		}
+	}
+	return nil
+}
+
+// restoreArchive restores a backup archive into db (or the dev store), re-uploading
+// archived images when Cloudinary is configured.
+func restoreArchive(db *sql.DB, cloudURL string, data []byte) (backupManifest, error) {
+	snap, manifest, images, err := readBackup(data)
+	if err != nil {
+		return manifest, err
+	}
+	if db == nil {
+		// dev mode never talks to Cloudinary
+		cloudURL = ""
+	}
+	if err := reuploadImages(cloudURL, &snap, images); err != nil {
+		return manifest, err
+	}
+	return manifest, restoreSnapshot(db, snap)
+}
+
+// adminBackup serves GET /api/admin/backup[?images=1] as a zip download.
+func adminBackup(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !isAdmin(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		snap, err := loadSnapshot(db)
+		if err != nil {
+			log.Println("backup load error:", err)
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		// build in memory so a failure can still be reported as an error response
+		var buf bytes.Buffer
+		if err := writeBackup(&buf, snap, parseFormBool(r.URL.Query().Get("images"))); err != nil {
+			log.Println("backup write error:", err)
+			http.Error(w, "backup failed", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="backup-%s.zip"`, time.Now().Format("20060102-150405")))
+		_, _ = w.Write(buf.Bytes())
+	}
+}
+
+// adminRestore serves POST /api/admin/restore with the archive as multipart "file" or raw body.
+// It replaces ALL shop content.
+func adminRestore(db *sql.DB, cloudURL string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !isAdmin(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxBackupBytes)
+		var data []byte
+		var err error
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+			file, _, ferr := r.FormFile("file")
+			if ferr != nil && !errors.As(ferr, new(*http.MaxBytesError)) {
+				http.Error(w, "file required", http.StatusBadRequest)
+				return
+			}
+			err = ferr
+			if ferr == nil {
+				defer file.Close()
+				data, err = io.ReadAll(file)
+			}
+		} else {
+			data, err = io.ReadAll(r.Body)
+		}
+		if errors.As(err, new(*http.MaxBytesError)) {
+			http.Error(w, fmt.Sprintf("backup larger than %d MB", maxBackupBytes>>20), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, "read upload: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		manifest, err := restoreArchive(db, cloudURL, data)
+		if err != nil {
+			log.Println("restore error:", err)
+			http.Error(w, "restore failed: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("restored backup created_at=%s counts=%v remote=%s dbNil=%t", manifest.CreatedAt, manifest.Counts, r.RemoteAddr, db == nil)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(manifest)
+	}
+}
+
+// runBackupCommand implements the "backup" and "restore" subcommands:
+//
+//	tram backup [-images] [-o backup.zip]
+//	tram restore backup.zip
+func runBackupCommand(db *sql.DB, cloudURL string, args []string) error {
+	switch args[0] {
+	case "backup":
+		fs := flag.NewFlagSet("backup", flag.ExitOnError)
+		out := fs.String("o", "backup-"+time.Now().Format("20060102-150405")+".zip", "output file")
+		images := fs.Bool("images", false, "download images into the archive")
+		_ = fs.Parse(args[1:])
+		snap, err := loadSnapshot(db)
+		if err != nil {
+			return err
+		}
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		if err := writeBackup(f, snap, *images); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		log.Printf("wrote %s (%v)", *out, snap.counts())
+		return nil
+	case "restore":
+		if len(args) != 2 {
+			return errors.New("usage: restore <backup.zip>")
+		}
+		data, err := os.ReadFile(args[1])
+		if err != nil {
+			return err
+		}
+		manifest, err := restoreArchive(db, cloudURL, data)
+		if err != nil {
+			return err
+		}
+		log.Printf("restored %s from %s (%v)", args[1], manifest.CreatedAt, manifest.Counts)
+		return nil
+	}
+	return fmt.Errorf("unknown command %q (want backup or restore)", args[0])
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testArchive zips a current manifest and a one-product data.json, followed by extra entries.
+func testArchive(t *testing.T, images map[string]string, extra map[string][]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	add := func(name string, b []byte) {
+		f, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	manifest, _ := json.Marshal(backupManifest{Format: backupFormat, Version: backupVersion, Images: images})
+	add("manifest.json", manifest)
+	add("data.json", []byte(`{"products":[{"id":1,"title":"Áo"}]}`))
+	for name, b := range extra {
+		add(name, b)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadBackup(t *testing.T) {
+	img := []byte("\x89PNG fake")
+	snap, _, images, err := readBackup(testArchive(t,
+		map[string]string{"https://img/1.png": "images/0001.png"},
+		map[string][]byte{"images/0001.png": img, "notes.txt": []byte("ignored")},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snap.Products) != 1 || snap.Products[0].Title != "Áo" {
+		t.Errorf("products = %+v", snap.Products)
+	}
+	if !bytes.Equal(images["https://img/1.png"], img) || len(images) != 1 {
+		t.Errorf("images = %v", images)
+	}
+}
+
+func TestReadBackupLimits(t *testing.T) {
+	many := make(map[string][]byte)
+	for i := 0; i < maxBackupEntries; i++ {
+		many[fmt.Sprintf("images/%05d.jpg", i)] = nil
+	}
+	tests := []struct {
+		name  string
+		extra map[string][]byte
+		want  string
+	}{
+		{"oversized image", map[string][]byte{"images/0001.jpg": make([]byte, maxImageBytes+1)}, "larger than"},
+		{"too many entries", many, "more than"},
+	}
+	for _, tt := range tests {
+		_, _, _, err := readBackup(testArchive(t, nil, tt.extra))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: readBackup error = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestRestoredTime(t *testing.T) {
+	fallback := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{"2026-03-04T05:06:07Z", time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)},
+		{"2026-03-04 05:06:07", time.Date(2026, 3, 4, 5, 6, 7, 0, time.Local)},
+		{"", fallback},
+		{"yesterday", fallback},
+	}
+	for _, tt := range tests {
+		if got := restoredTime(tt.in, fallback); !got.Equal(tt.want) {
+			t.Errorf("restoredTime(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cloudinary/cloudinary-go/v2"
+	"github.com/cloudinary/cloudinary-go/v2/api/uploader"
+)
+
+// Bulk operations accepted by /api/admin/bulk-products.
+const (
+	bulkDelete      = "delete"
+	bulkSetCategory = "set_category"
+	bulkSetStatus   = "set_status"
+	bulkSetTag      = "set_tag" // sets the product source (mychoice/shopee)
+	bulkAdjustPrice = "adjust_price"
+)
+
+// maxBulkIDs caps how many products one bulk request may touch.
+const maxBulkIDs = 500
+
+// bulkRequest is the JSON body of a bulk product operation. Only the field
+// matching Op is used. With Atomic set, nothing is changed unless every item succeeds.
+type bulkRequest struct {
+	IDs        []int64 `json:"ids"`
+	Op         string  `json:"op"`
+	CategoryID int64   `json:"category_id"`
+	Status     string  `json:"status"`
+	Tag        string  `json:"tag"`
+	Percent    float64 `json:"percent"`
+	Atomic     bool    `json:"atomic"`
+}
+
+// bulkItemResult reports the outcome for one product id.
+type bulkItemResult struct {
+	ID    int64  `json:"id"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// validate checks the operation-level parameters.
+func (req *bulkRequest) validate(db *sql.DB) error {
+	req.IDs = dedupeIDs(req.IDs)
+	if len(req.IDs) == 0 {
+		return errors.New("ids required")
+	}
+	if len(req.IDs) > maxBulkIDs {
+		return fmt.Errorf("at most %d ids per request", maxBulkIDs)
+	}
+	req.Op = strings.ToLower(strings.TrimSpace(req.Op))
+	switch req.Op {
+	case bulkDelete:
+	case bulkSetCategory:
+		if req.CategoryID != 0 {
+			cats, err := fetchCategories(db)
+			if err != nil {
+				return err
+			}
+			if _, ok := findCategory(cats, strconv.FormatInt(req.CategoryID, 10)); !ok {
+				return errors.New("category not found")
+			}
+		}
+	case bulkSetStatus:
+		req.Status = strings.ToLower(strings.TrimSpace(req.Status))
+		if !validProductStatus(req.Status) {
+			return errors.New("invalid status")
+		}
+	case bulkSetTag, "set_source":
+		req.Op = bulkSetTag
+		req.Tag = strings.ToLower(strings.TrimSpace(req.Tag))
+		if req.Tag != sourceShopee {
+			req.Tag = sourceMyChoice
+		}
+	case bulkAdjustPrice:
+		if req.Percent == 0 || math.IsNaN(req.Percent) || math.IsInf(req.Percent, 0) || req.Percent <= -100 {
+			return errors.New("percent must be non-zero and greater than -100")
+		}
+	default:
+		return errors.New("unknown op")
+	}
+	return nil
+}
+
+// check returns a per-item error for p, or "" if the operation can be applied.
+func (req *bulkRequest) check(p Product) string {
+	if req.Op == bulkSetTag && req.Tag == sourceShopee && p.ExternalURL == "" {
+		return "external_url required when tag is shopee"
+	}
+	if req.Op == bulkAdjustPrice {
+		if p.Price <= 0 {
+			return "product has no price to adjust"
+		}
+		if _, ok := req.adjustedPrice(p.Price); !ok {
+			return "adjusted price out of range"
+		}
+	}
+	return ""
+}
+
+// adjustedPrice applies the request's percent change, rounded to the minor unit.
+// ok is false when the result is not a positive amount up to maxMoney.
+func (req *bulkRequest) adjustedPrice(price Money) (Money, bool) {
+	f := math.Round(float64(price) * (100 + req.Percent) / 100)
+	if !(f > 0 && f <= float64(maxMoney)) {
+		return 0, false
+	}
+	return Money(f), true
+}
+
+// applyDev performs the operation on the in-memory store.
+func (req *bulkRequest) applyDev(p Product) {
+	if req.Op == bulkDelete {
+		DevDeleteProduct(p.ID)
+		return
+	}
+	var catName, catSlug string
+	if c, ok := DevGetCategory(req.CategoryID); ok {
+		catName, catSlug = c.Name, c.Slug
+	}
+	DevModifyProduct(p.ID, func(dp *Product) {
+		switch req.Op {
+		case bulkSetCategory:
+			dp.CategoryID, dp.Category, dp.CategorySlug = req.CategoryID, catName, catSlug
+		case bulkSetStatus:
+			dp.Status = req.Status
+		case bulkSetTag:
+			dp.Source, dp.Tag = req.Tag, req.Tag
+			if req.Tag == sourceMyChoice {
+				dp.ExternalURL = ""
+			}
+		case bulkAdjustPrice:
+			dp.Price, _ = req.adjustedPrice(dp.Price)
+		}
+	})
+	if req.Op == bulkAdjustPrice {
+		newPrice, _ := req.adjustedPrice(p.Price)
+		_ = recordPriceChange(nil, nil, p.ID, p.Price, newPrice, priceSourceBulk)
+	}
+}
+
+// applyTx performs the operation for one product inside tx.
+func (req *bulkRequest) applyTx(tx *sql.Tx, p Product) error {
+	var err error
+	switch req.Op {
+	case bulkDelete:
+		if _, err = tx.Exec("DELETE FROM product_collections WHERE product_id=? AND shop_id = @shop_id", p.ID); err == nil {
+			_, err = tx.Exec("DELETE FROM products WHERE id=? AND shop_id = @shop_id", p.ID)
+		}
+	case bulkSetCategory:
+		_, err = tx.Exec("UPDATE products SET category_id=? WHERE id=? AND shop_id = @shop_id", sqlNull(req.CategoryID), p.ID)
+	case bulkSetStatus:
+		_, err = tx.Exec("UPDATE products SET status=? WHERE id=? AND shop_id = @shop_id", req.Status, p.ID)
+	case bulkSetTag:
+		if req.Tag == sourceMyChoice {
+			_, err = tx.Exec("UPDATE products SET source=?, external_url=NULL WHERE id=? AND shop_id = @shop_id", req.Tag, p.ID)
+		} else {
+			_, err = tx.Exec("UPDATE products SET source=? WHERE id=? AND shop_id = @shop_id", req.Tag, p.ID)
+		}
+	case bulkAdjustPrice:
+		newPrice, _ := req.adjustedPrice(p.Price)
+		if _, err = tx.Exec("UPDATE products SET price_minor=? WHERE id=? AND shop_id = @shop_id", int64(newPrice), p.ID); err == nil {
+			err = recordPriceChange(nil, tx, p.ID, p.Price, newPrice, priceSourceBulk)
+		}
+	}
+	return err
+}
+
+// adminBulkProducts provides POST /api/admin/bulk-products. All changes run in a single
+// transaction; the response lists a result per id. Missing or invalid items are reported
+// and skipped, unless "atomic" is set in which case nothing is changed (409).
+func adminBulkProducts(db *sql.DB, cloudURL string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !isAdmin(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var req bulkRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+			http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := req.validate(db); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("adminBulkProducts op=%s count=%d atomic=%t remote=%s dbNil=%t", req.Op, len(req.IDs), req.Atomic, r.RemoteAddr, db == nil)
+
+		// phase 1: load and check every item
+		results := make([]bulkItemResult, len(req.IDs))
+		targets := make([]Product, 0, len(req.IDs))
+		failed := 0
+		for i, id := range req.IDs {
+			results[i].ID = id
+			p, ok, err := fetchProduct(db, id)
+			if err != nil {
+				log.Println("adminBulkProducts fetch error:", err)
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
+			msg := "not found"
+			if ok {
+				msg = req.check(p)
+			}
+			if msg != "" {
+				results[i].Error = msg
+				failed++
+				continue
+			}
+			results[i].OK = true
+			targets = append(targets, p)
+		}
+
+		status := http.StatusOK
+		switch {
+		case req.Atomic && failed > 0:
+			// nothing applied; mark the otherwise valid items as skipped
+			for i := range results {
+				if results[i].OK {
+					results[i].OK = false
+					results[i].Error = "skipped: batch aborted"
+				}
+			}
+			status = http.StatusConflict
+		case db == nil:
+			for _, p := range targets {
+				req.applyDev(p)
+			}
+		default:
+			// phase 2: apply in a single transaction
+			tx, err := db.Begin()
+			if err != nil {
+				log.Println("adminBulkProducts begin error:", err)
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
+			defer tx.Rollback()
+			for _, p := range targets {
+				if err := req.applyTx(tx, p); err != nil {
+					log.Printf("adminBulkProducts op=%s id=%d error: %v", req.Op, p.ID, err)
+					http.Error(w, "db error", http.StatusInternalServerError)
+					return
+				}
+			}
+			if err := tx.Commit(); err != nil {
+				log.Println("adminBulkProducts commit error:", err)
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
+			// images are only removed once the rows are gone for good
+			if req.Op == bulkDelete {
+				for _, p := range targets {
+					destroyCloudinaryImage(cloudURL, p.ImagePublicID)
+				}
+			}
+		}
+
+		succeeded := 0
+		for _, res := range results {
+			if res.OK {
+				succeeded++
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"op":        req.Op,
+			"succeeded": succeeded,
+			"failed":    len(results) - succeeded,
+			"results":   results,
+		})
+	}
+}
+
+// destroyCloudinaryImage deletes an uploaded image; failures are only logged.
+func destroyCloudinaryImage(cloudURL, publicID string) {
+	if publicID == "" || cloudURL == "" {
+		return
+	}
+	cld, err := cloudinary.NewFromURL(cloudURL)
+	if err != nil {
+		log.Println("cloudinary init for delete error:", err)
+		return
+	}
+	if _, err := cld.Upload.Destroy(context.Background(), uploader.DestroyParams{PublicID: publicID}); err != nil {
+		log.Println("cloudinary destroy error:", err)
+		return
+	}
+	log.Printf("deleted cloudinary image: %s", publicID)
+}
@@ -0,0 +1,58 @@
+package main
+
+import "testing"
+
+func TestBulkAdjustedPrice(t *testing.T) {
+	tests := []struct {
+		percent float64
+		price   Money
+		want    Money
+		ok      bool
+	}{
+		{10, 199000, 218900, true},
+		{-10, 199000, 179100, true},
+		{-50, 3, 2, true}, // rounds to the minor unit
+		{-99.9999, 199000, 0, false},
+		{-99.9, 100, 0, false}, // rounds down to 0
+		{1e20, 199000, 0, false},
+		{1e12, 199000, 0, false}, // above maxMoney
+		{10, 0, 0, false},
+	}
+	for _, tt := range tests {
+		req := bulkRequest{Op: bulkAdjustPrice, Percent: tt.percent}
+		got, ok := req.adjustedPrice(tt.price)
+		if ok != tt.ok || (ok && got != tt.want) {
+			t.Errorf("adjustedPrice(%v%% of %d) = %d, %v; want %d, %v", tt.percent, tt.price, got, ok, tt.want, tt.ok)
+		}
+		msg := req.check(Product{Price: tt.price})
+		if (msg == "") != tt.ok {
+			t.Errorf("check(%v%% of %d) = %q, want ok=%v", tt.percent, tt.price, msg, tt.ok)
+		}
+	}
+}
+
+func TestBulkValidate(t *testing.T) {
+	many := make([]int64, maxBulkIDs+1)
+	for i := range many {
+		many[i] = int64(i + 1)
+	}
+	tests := []struct {
+		name    string
+		req     bulkRequest
+		wantErr bool
+	}{
+		{"adjust", bulkRequest{IDs: []int64{1, 1, 2}, Op: "Adjust_Price", Percent: 5}, false},
+		{"no ids", bulkRequest{Op: bulkDelete}, true},
+		{"too many ids", bulkRequest{IDs: many, Op: bulkDelete}, true},
+		{"zero percent", bulkRequest{IDs: []int64{1}, Op: bulkAdjustPrice}, true},
+		{"minus 100 percent", bulkRequest{IDs: []int64{1}, Op: bulkAdjustPrice, Percent: -100}, true},
+		{"bad status", bulkRequest{IDs: []int64{1}, Op: bulkSetStatus, Status: "gone"}, true},
+		{"unknown op", bulkRequest{IDs: []int64{1}, Op: "rename"}, true},
+	}
+	for _, tt := range tests {
+		err := tt.req.validate(nil)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: validate = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
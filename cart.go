@@ -0,0 +1,555 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Carts are anonymous and identified by the cart_id cookie. They expire cartTTL
+// after their last change.
+const (
+	cartCookie = "cart_id"
+	cartTTL    = 30 * 24 * time.Hour
+)
+
+// cartLine is a stored cart row; UnitPrice is the price when the item was added.
+type cartLine struct {
+	ID        int64
+	ProductID int64
+	Variant   string
+	Quantity  int
+	UnitPrice Money
+}
+
+// Cart is the API view of a cart with current product data and totals. A coupon
+// that no longer applies stays on the cart with CouponError set and no discount.
+type Cart struct {
+	ID             string     `json:"id"`
+	Items          []CartItem `json:"items"`
+	Count          int        `json:"count"`
+	Currency       string     `json:"currency"`
+	Subtotal       Money      `json:"subtotal"`
+	Discount       Money      `json:"discount"`
+	CouponCode     string     `json:"coupon_code,omitempty"`
+	CouponError    string     `json:"coupon_error,omitempty"`
+	Total          Money      `json:"total"`
+	TotalFormatted string     `json:"total_formatted"`
+	ExpiresAt      string     `json:"expires_at"`
+}
+
+// CartItem is a cart line joined with its product. PriceChanged is set when the
+// product price differs from the price at add time; checkout uses the current price.
+type CartItem struct {
+	ID           int64  `json:"id"`
+	ProductID    int64  `json:"product_id"`
+	Title        string `json:"title"`
+	ImageURL     string `json:"image_url"`
+	Variant      string `json:"variant"`
+	Quantity     int    `json:"quantity"`
+	UnitPrice    Money  `json:"unit_price"`
+	AddedPrice   Money  `json:"added_price"`
+	PriceChanged bool   `json:"price_changed"`
+	Available    bool   `json:"available"`
+	Error        string `json:"error,omitempty"`
+}
+
+// newCartID returns a random, unguessable cart id.
+func newCartID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// createCart stores a new empty cart and returns its id and expiry.
+func createCart(db *sql.DB) (string, time.Time, error) {
+	id, err := newCartID()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expires := time.Now().Add(cartTTL)
+	if db == nil {
+		DevCreateCart(id, expires)
+		return id, expires, nil
+	}
+	if _, err := db.Exec("INSERT INTO carts (id, shop_id, created_at, expires_at) VALUES (?, @shop_id, ?, ?)", id, time.Now(), expires); err != nil {
+		return "", time.Time{}, err
+	}
+	return id, expires, nil
+}
+
+// loadCartLines returns the lines and expiry of a cart. ok is false for unknown or expired carts.
+func loadCartLines(db *sql.DB, id string) ([]cartLine, time.Time, bool, error) {
+	if db == nil {
+		lines, expires, ok := DevGetCart(id)
+		return lines, expires, ok, nil
+	}
+	var expires time.Time
+	var raw interface{}
+	if err := db.QueryRow("SELECT expires_at FROM carts WHERE id = ? AND shop_id = @shop_id AND expires_at > ?", id, time.Now()).Scan(&raw); err == sql.ErrNoRows {
+		return nil, expires, false, nil
+	} else if err != nil {
+		return nil, expires, false, err
+	}
+	expires, _ = time.Parse(time.RFC3339, formatDBTime(raw))
+	rows, err := db.Query("SELECT id, product_id, IFNULL(variant,''), quantity, IFNULL(unit_price_minor,0) FROM cart_items WHERE cart_id = ? AND shop_id = @shop_id ORDER BY id", id)
+	if err != nil {
+		return nil, expires, false, err
+	}
+	defer rows.Close()
+	var lines []cartLine
+	for rows.Next() {
+		var l cartLine
+		if err := rows.Scan(&l.ID, &l.ProductID, &l.Variant, &l.Quantity, &l.UnitPrice); err != nil {
+			return nil, expires, false, err
+		}
+		lines = append(lines, l)
+	}
+	return lines, expires, true, rows.Err()
+}
+
+// touchCart extends the cart expiry after a change.
+func touchCart(db *sql.DB, id string) error {
+	expires := time.Now().Add(cartTTL)
+	if db == nil {
+		DevTouchCart(id, expires)
+		return nil
+	}
+	_, err := db.Exec("UPDATE carts SET expires_at = ? WHERE id = ? AND shop_id = @shop_id", expires, id)
+	return err
+}
+
+// addCartLine adds quantity of a product/variant, merging with an existing line.
+func addCartLine(db *sql.DB, cartID string, l cartLine, lines []cartLine) error {
+	for _, cur := range lines {
+		if cur.ProductID == l.ProductID && cur.Variant == l.Variant {
+			return setCartLineQuantity(db, cartID, cur.ID, cur.Quantity+l.Quantity)
+		}
+	}
+	if len(lines) >= maxOrderItems {
+		return orderError{fmt.Sprintf("at most %d items per cart", maxOrderItems)}
+	}
+	if db == nil {
+		DevAddCartLine(cartID, l)
+		return nil
+	}
+	_, err := db.Exec("INSERT INTO cart_items (shop_id, cart_id, product_id, variant, quantity, unit_price_minor, added_at) VALUES (@shop_id, ?, ?, ?, ?, ?, ?)",
+		cartID, l.ProductID, l.Variant, l.Quantity, int64(l.UnitPrice), time.Now())
+	return err
+}
+
+// setCartLineQuantity changes a line's quantity; 0 removes it. Returns errCartLineNotFound
+// when the line is not in the cart.
+func setCartLineQuantity(db *sql.DB, cartID string, lineID int64, qty int) error {
+	if qty < 0 || qty > maxOrderQuantity {
+		return orderError{fmt.Sprintf("quantity must be between 1 and %d", maxOrderQuantity)}
+	}
+	if db == nil {
+		if !DevSetCartLine(cartID, lineID, qty) {
+			return errCartLineNotFound
+		}
+		return nil
+	}
+	var res sql.Result
+	var err error
+	if qty == 0 {
+		res, err = db.Exec("DELETE FROM cart_items WHERE id = ? AND cart_id = ? AND shop_id = @shop_id", lineID, cartID)
+	} else {
+		res, err = db.Exec("UPDATE cart_items SET quantity = ? WHERE id = ? AND cart_id = ? AND shop_id = @shop_id", qty, lineID, cartID)
+	}
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 && qty == 0 {
+		return errCartLineNotFound
+	}
+	return nil
+}
+
+var errCartLineNotFound = errors.New("cart item not found")
+
+// cartCoupon returns the coupon code applied to a cart, if any.
+func cartCoupon(db *sql.DB, id string) (string, error) {
+	if db == nil {
+		return DevGetCartCoupon(id), nil
+	}
+	var code sql.NullString
+	err := db.QueryRow("SELECT coupon_code FROM carts WHERE id = ? AND shop_id = @shop_id", id).Scan(&code)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return code.String, err
+}
+
+// setCartCoupon applies a coupon code to a cart; "" removes it.
+func setCartCoupon(db *sql.DB, id, code string) error {
+	if db == nil {
+		DevSetCartCoupon(id, code)
+		return nil
+	}
+	_, err := db.Exec("UPDATE carts SET coupon_code = ? WHERE id = ? AND shop_id = @shop_id", sqlNullString(code), id)
+	return err
+}
+
+var errCartCheckedOut = errors.New("cart was already checked out")
+
+// takeCartTx deletes a cart and its lines within a checkout's transaction. It
+// returns errCartCheckedOut when the cart no longer exists.
+func takeCartTx(tx *sql.Tx, id string) error {
+	res, err := tx.Exec("DELETE FROM carts WHERE id = ? AND shop_id = @shop_id", id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errCartCheckedOut
+	}
+	_, err = tx.Exec("DELETE FROM cart_items WHERE cart_id = ? AND shop_id = @shop_id", id)
+	return err
+}
+
+// deleteExpiredCarts removes the shop's carts that expired before now, with their lines.
+func deleteExpiredCarts(db *sql.DB, now time.Time) error {
+	if db == nil {
+		DevDeleteExpiredCarts(now)
+		return nil
+	}
+	if _, err := db.Exec("DELETE ci FROM cart_items ci JOIN carts c ON c.id = ci.cart_id AND c.shop_id = ci.shop_id WHERE c.shop_id = @shop_id AND c.expires_at < ?", now); err != nil {
+		return err
+	}
+	_, err := db.Exec("DELETE FROM carts WHERE shop_id = @shop_id AND expires_at < ?", now)
+	return err
+}
+
+// startCartCleanup deletes the expired carts of every shop returned by shops
+// now and then every interval until ctx is done.
+func startCartCleanup(ctx context.Context, shops func() map[string]*sql.DB, interval time.Duration) {
+	run := func() {
+		for username, db := range shops() {
+			if err := deleteExpiredCarts(db, time.Now()); err != nil {
+				log.Printf("cart cleanup error (shop %s): %v", username, err)
+			}
+		}
+	}
+	go func() {
+		run()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				run()
+			}
+		}
+	}()
+}
+
+// buildCart joins cart lines with current product data and computes totals.
+// Unavailable items are listed but not counted.
+func buildCart(db *sql.DB, id string, lines []cartLine, coupon string, expires time.Time) (Cart, error) {
+	cur := shopCurrency(db)
+	c := Cart{ID: id, Items: []CartItem{}, Currency: cur.Code, ExpiresAt: expires.Format(time.RFC3339)}
+	for _, l := range lines {
+		it := CartItem{ID: l.ID, ProductID: l.ProductID, Variant: l.Variant, Quantity: l.Quantity, AddedPrice: l.UnitPrice, UnitPrice: l.UnitPrice}
+		p, err := orderableProduct(db, l.ProductID)
+		var oe orderError
+		switch {
+		case errors.As(err, &oe):
+			it.Title, it.Error = p.Title, oe.Error()
+		case err != nil:
+			return c, err
+		default:
+			it.Available = true
+			it.Title, it.ImageURL, it.UnitPrice = p.Title, p.ImageURL, p.EffectivePrice
+			it.PriceChanged = p.EffectivePrice != l.UnitPrice
+			if p.Stock != nil && *p.Stock < l.Quantity {
+				it.Error = fmt.Sprintf("chỉ còn %d sản phẩm", *p.Stock)
+			}
+			c.Count += it.Quantity
+			c.Subtotal += it.UnitPrice * Money(it.Quantity)
+		}
+		c.Items = append(c.Items, it)
+	}
+	if coupon != "" {
+		c.CouponCode = coupon
+		_, discount, err := couponDiscount(db, coupon, c.Subtotal)
+		var oe orderError
+		switch {
+		case errors.As(err, &oe):
+			c.CouponError = oe.Error()
+		case err != nil:
+			return c, err
+		default:
+			c.Discount = discount
+		}
+	}
+	c.Total = c.Subtotal - c.Discount
+	c.TotalFormatted = cur.Format(c.Total)
+	return c, nil
+}
+
+// cartHandler serves the anonymous cart API:
+//
+//	GET    /api/cart                 current cart (empty if none)
+//	POST   /api/cart                 current cart, like GET
+//	POST   /api/cart/items           {"product_id","variant","quantity"} add an item;
+//	                                 the first one creates the cart (sets the cart_id cookie)
+//	PUT    /api/cart/items/{id}      {"quantity"} change quantity (0 removes)
+//	DELETE /api/cart/items/{id}      remove an item
+//	POST   /api/cart/coupon          {"code"} apply a coupon
+//	DELETE /api/cart/coupon          remove the coupon
+//	POST   /api/cart/checkout        {"customer_name","phone","address","note"} create an order
+func cartHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/cart"), "/")
+		parts := strings.Split(rest, "/")
+
+		// resolve the cart from the cookie; unknown or expired ids are treated as no cart
+		var cartID string
+		var lines []cartLine
+		var coupon string
+		var expires time.Time
+		if ck, err := r.Cookie(cartCookie); err == nil && ck.Value != "" {
+			l, exp, ok, err := loadCartLines(db, ck.Value)
+			if err != nil {
+				log.Println("cart load error:", err)
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
+			if ok {
+				cartID, lines, expires = ck.Value, l, exp
+				if coupon, err = cartCoupon(db, cartID); err != nil {
+					log.Println("cart load error:", err)
+					http.Error(w, "db error", http.StatusInternalServerError)
+					return
+				}
+			}
+		}
+		ensureCart := func() bool {
+			if cartID != "" {
+				return true
+			}
+			id, exp, err := createCart(db)
+			if err != nil {
+				log.Println("cart create error:", err)
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return false
+			}
+			cartID, expires = id, exp
+			return true
+		}
+		respond := func(status int) {
+			if cartID != "" {
+				if err := touchCart(db, cartID); err != nil {
+					log.Println("cart touch error:", err)
+				}
+				expires = time.Now().Add(cartTTL)
+				http.SetCookie(w, &http.Cookie{Name: cartCookie, Value: cartID, Path: shopCookiePath(r), HttpOnly: true, SameSite: http.SameSiteLaxMode, Expires: expires})
+				l, _, _, err := loadCartLines(db, cartID)
+				if err != nil {
+					log.Println("cart load error:", err)
+					http.Error(w, "db error", http.StatusInternalServerError)
+					return
+				}
+				lines = l
+				if coupon, err = cartCoupon(db, cartID); err != nil {
+					log.Println("cart load error:", err)
+					http.Error(w, "db error", http.StatusInternalServerError)
+					return
+				}
+			}
+			c, err := buildCart(db, cartID, lines, coupon, expires)
+			if err != nil {
+				log.Println("cart build error:", err)
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			_ = json.NewEncoder(w).Encode(c)
+		}
+		fail := func(err error) {
+			var oe orderError
+			switch {
+			case errors.As(err, &oe):
+				http.Error(w, oe.Error(), http.StatusBadRequest)
+			case errors.Is(err, errCartLineNotFound):
+				http.Error(w, "not found", http.StatusNotFound)
+			case errors.Is(err, errCartCheckedOut):
+				http.Error(w, err.Error(), http.StatusConflict)
+			default:
+				log.Println("cart error:", err)
+				http.Error(w, "db error", http.StatusInternalServerError)
+			}
+		}
+
+		switch {
+		case rest == "" && r.Method == http.MethodGet, rest == "" && r.Method == http.MethodPost:
+			// carts are only stored once they hold something, see POST /api/cart/items
+			if cartID == "" {
+				w.Header().Set("Content-Type", "application/json")
+				_ = json.NewEncoder(w).Encode(Cart{Items: []CartItem{}})
+				return
+			}
+			respond(http.StatusOK)
+
+		case rest == "items" && r.Method == http.MethodPost:
+			var in orderItemInput
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(&in); err != nil {
+				http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if in.Quantity == 0 {
+				in.Quantity = 1
+			}
+			in.Variant = strings.TrimSpace(in.Variant)
+			if in.Quantity < 0 || in.Quantity > maxOrderQuantity {
+				fail(orderError{fmt.Sprintf("quantity must be between 1 and %d", maxOrderQuantity)})
+				return
+			}
+			if utf8.RuneCountInString(in.Variant) > 255 {
+				fail(orderError{"variant too long"})
+				return
+			}
+			p, err := orderableProduct(db, in.ProductID)
+			if err != nil {
+				fail(err)
+				return
+			}
+			if !ensureCart() {
+				return
+			}
+			if err := addCartLine(db, cartID, cartLine{ProductID: p.ID, Variant: in.Variant, Quantity: in.Quantity, UnitPrice: p.EffectivePrice}, lines); err != nil {
+				fail(err)
+				return
+			}
+			respond(http.StatusOK)
+
+		case len(parts) == 2 && parts[0] == "items" && (r.Method == http.MethodPut || r.Method == http.MethodDelete):
+			lineID, err := strconv.ParseInt(parts[1], 10, 64)
+			if err != nil {
+				http.Error(w, "invalid id", http.StatusBadRequest)
+				return
+			}
+			if cartID == "" {
+				http.Error(w, "not found", http.StatusNotFound)
+				return
+			}
+			qty := 0
+			if r.Method == http.MethodPut {
+				var payload struct {
+					Quantity int `json:"quantity"`
+				}
+				if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<10)).Decode(&payload); err != nil {
+					http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+					return
+				}
+				qty = payload.Quantity
+			}
+			found := false
+			for _, l := range lines {
+				found = found || l.ID == lineID
+			}
+			if !found {
+				http.Error(w, "not found", http.StatusNotFound)
+				return
+			}
+			if err := setCartLineQuantity(db, cartID, lineID, qty); err != nil {
+				fail(err)
+				return
+			}
+			respond(http.StatusOK)
+
+		case rest == "coupon" && r.Method == http.MethodPost:
+			var payload struct {
+				Code string `json:"code"`
+			}
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<10)).Decode(&payload); err != nil {
+				http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if cartID == "" {
+				http.Error(w, "cart is empty", http.StatusBadRequest)
+				return
+			}
+			// validate against the current cart so the customer sees why a code fails
+			c, err := buildCart(db, cartID, lines, "", expires)
+			if err != nil {
+				fail(err)
+				return
+			}
+			cp, _, err := couponDiscount(db, payload.Code, c.Subtotal)
+			if err == nil && cp.ID == 0 {
+				err = orderError{"code required"}
+			}
+			if err != nil {
+				fail(err)
+				return
+			}
+			if err := setCartCoupon(db, cartID, cp.Code); err != nil {
+				fail(err)
+				return
+			}
+			respond(http.StatusOK)
+
+		case rest == "coupon" && r.Method == http.MethodDelete:
+			if cartID != "" {
+				if err := setCartCoupon(db, cartID, ""); err != nil {
+					fail(err)
+					return
+				}
+			}
+			respond(http.StatusOK)
+
+		case rest == "checkout" && r.Method == http.MethodPost:
+			var in orderInput
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&in); err != nil {
+				http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if len(lines) == 0 {
+				http.Error(w, "cart is empty", http.StatusBadRequest)
+				return
+			}
+			in.Items, in.ProductID = nil, 0
+			if in.CouponCode == "" {
+				in.CouponCode = coupon
+			}
+			for _, l := range lines {
+				in.Items = append(in.Items, orderItemInput{ProductID: l.ProductID, Variant: l.Variant, Quantity: l.Quantity})
+			}
+			in.cartID = cartID
+			o, err := createOrder(db, in)
+			if err != nil {
+				fail(err)
+				return
+			}
+			http.SetCookie(w, &http.Cookie{Name: cartCookie, Value: "", Path: shopCookiePath(r), MaxAge: -1})
+			log.Printf("cart checkout order id=%d items=%d total=%s remote=%s", o.ID, len(o.Items), currencyOrDefault(o.Currency).Format(o.Total), r.RemoteAddr)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(o)
+
+		case rest == "" || rest == "items" || rest == "coupon" || rest == "checkout" || (len(parts) == 2 && parts[0] == "items"):
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	}
+}
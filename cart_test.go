@@ -0,0 +1,184 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useDevCarts gives one test an empty in-memory cart store besides the products.
+func useDevCarts(t *testing.T, products []Product) {
+	t.Helper()
+	useDevOrders(t, products, nil)
+	devMu.Lock()
+	saved := devCarts
+	devCarts = map[string]*devCart{}
+	devMu.Unlock()
+	t.Cleanup(func() {
+		devMu.Lock()
+		devCarts = saved
+		devMu.Unlock()
+	})
+}
+
+// cartRequest sends one request to the cart API with the cart cookie, if any.
+func cartRequest(t *testing.T, method, path, body, cartID string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if cartID != "" {
+		r.AddCookie(&http.Cookie{Name: cartCookie, Value: cartID})
+	}
+	w := httptest.NewRecorder()
+	cartHandler(nil)(w, r)
+	return w
+}
+
+func devCartCount() int {
+	devMu.Lock()
+	defer devMu.Unlock()
+	return len(devCarts)
+}
+
+func TestCartCreatedOnFirstItem(t *testing.T) {
+	useDevCarts(t, []Product{{ID: 1, Title: "Áo", Price: 100000, Status: statusPublished}})
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		w := cartRequest(t, method, "/api/cart", "", "")
+		if w.Code != http.StatusOK || len(w.Result().Cookies()) != 0 || devCartCount() != 0 {
+			t.Errorf("%s /api/cart = %d, cookies %v, %d carts; want 200 and no cart", method, w.Code, w.Result().Cookies(), devCartCount())
+		}
+	}
+	if w := cartRequest(t, http.MethodPost, "/api/cart/coupon", `{"code":"SALE"}`, ""); w.Code != http.StatusBadRequest || devCartCount() != 0 {
+		t.Errorf("coupon without cart = %d, %d carts", w.Code, devCartCount())
+	}
+	if w := cartRequest(t, http.MethodPost, "/api/cart/items", `{"product_id":99}`, ""); w.Code != http.StatusBadRequest || devCartCount() != 0 {
+		t.Errorf("unknown product = %d, %d carts; want 400 and no cart", w.Code, devCartCount())
+	}
+	w := cartRequest(t, http.MethodPost, "/api/cart/items", `{"product_id":1,"quantity":2}`, "")
+	if w.Code != http.StatusOK || len(w.Result().Cookies()) != 1 || devCartCount() != 1 {
+		t.Errorf("first item = %d, cookies %v, %d carts; want a new cart", w.Code, w.Result().Cookies(), devCartCount())
+	}
+}
+
+func TestCartItemBodyLimit(t *testing.T) {
+	useDevCarts(t, []Product{{ID: 1, Title: "Áo", Price: 100000, Status: statusPublished}})
+	w := cartRequest(t, http.MethodPost, "/api/cart/items", `{"product_id":1}`, "")
+	cartID := w.Result().Cookies()[0].Value
+	lines, _, _ := DevGetCart(cartID)
+
+	path := "/api/cart/items/" + strconv.FormatInt(lines[0].ID, 10)
+	if w := cartRequest(t, http.MethodPut, path, `{"quantity":3,"pad":"`+strings.Repeat("x", 8<<10)+`"}`, cartID); w.Code != http.StatusBadRequest {
+		t.Errorf("oversized PUT = %d, want 400", w.Code)
+	}
+	if w := cartRequest(t, http.MethodPut, path, `{"quantity":3}`, cartID); w.Code != http.StatusOK {
+		t.Errorf("PUT = %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestCartCheckoutOnce(t *testing.T) {
+	useDevCarts(t, []Product{{ID: 1, Title: "Áo", Price: 100000, Status: statusPublished}})
+	w := cartRequest(t, http.MethodPost, "/api/cart/items", `{"product_id":1}`, "")
+	cartID := w.Result().Cookies()[0].Value
+	body := `{"customer_name":"Lan","phone":"0912345678","address":"1 Lê Lợi, Q1"}`
+
+	if w := cartRequest(t, http.MethodPost, "/api/cart/checkout", body, cartID); w.Code != http.StatusCreated {
+		t.Fatalf("checkout = %d %s", w.Code, w.Body.String())
+	}
+	if devCartCount() != 0 {
+		t.Error("cart kept after checkout")
+	}
+	// a second submit that loaded the cart before the first one finished
+	o, _, err := buildOrder(nil, orderInput{CustomerName: "Lan", Phone: "0912345678", Address: "1 Lê Lợi, Q1", Items: []orderItemInput{{ProductID: 1, Quantity: 1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DevCheckout(cartID, 0, o); err != errCartCheckedOut {
+		t.Errorf("second checkout error = %v, want errCartCheckedOut", err)
+	}
+	if n := len(DevGetOrders()); n != 1 {
+		t.Errorf("%d orders, want 1", n)
+	}
+}
+
+func TestDeleteExpiredCarts(t *testing.T) {
+	useDevCarts(t, nil)
+	now := time.Now()
+	DevCreateCart("old", now.Add(-time.Minute))
+	DevCreateCart("new", now.Add(time.Hour))
+	if err := deleteExpiredCarts(nil, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := DevGetCart("new"); !ok || devCartCount() != 1 {
+		t.Errorf("%d carts left, want only the unexpired one", devCartCount())
+	}
+}
+
+func TestBuildCart(t *testing.T) {
+	stock := func(n int) *int { return &n }
+	sale := Money(150000)
+	useDevCoupons(t, []Product{
+		{ID: 1, Title: "Áo", Price: 100000, Stock: stock(5), Status: statusPublished},
+		{ID: 2, Title: "Quần", Price: 200000, SalePrice: &sale, Status: statusPublished},
+		{ID: 3, Title: "Mũ", Price: 90000, Status: statusSoldOut},
+		{ID: 4, Title: "Giày", Price: 300000, Source: sourceShopee, Status: statusPublished},
+		{ID: 5, Title: "Tất", Price: 50000, Stock: stock(1), Status: statusPublished},
+	}, []Coupon{
+		{ID: 1, Code: "SALE10", Kind: couponPercent, Percent: 10, Active: true},
+		{ID: 2, Code: "BIG", Kind: couponPercent, Percent: 50, MinOrder: 1000000, Active: true},
+	})
+	lines := []cartLine{
+		{ID: 1, ProductID: 1, Quantity: 2, UnitPrice: 100000},
+		{ID: 2, ProductID: 2, Quantity: 1, UnitPrice: 200000},
+		{ID: 3, ProductID: 3, Quantity: 1, UnitPrice: 90000},
+		{ID: 4, ProductID: 4, Quantity: 1, UnitPrice: 300000},
+		{ID: 5, ProductID: 5, Quantity: 3, UnitPrice: 50000},
+	}
+
+	tests := []struct {
+		coupon        string
+		discount      Money
+		total         Money
+		wantCouponErr bool
+	}{
+		{"", 0, 500000, false},
+		{"SALE10", 50000, 450000, false},
+		{"BIG", 0, 500000, true},
+		{"NOPE", 0, 500000, true},
+	}
+	for _, tt := range tests {
+		c, err := buildCart(nil, "c1", lines, tt.coupon, time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// sold-out and Shopee-only lines are listed but not counted
+		if c.Count != 6 || c.Subtotal != 500000 {
+			t.Errorf("coupon %q: count = %d, subtotal = %d; want 6, 500000", tt.coupon, c.Count, c.Subtotal)
+		}
+		if c.Discount != tt.discount || c.Total != tt.total || (c.CouponError != "") != tt.wantCouponErr {
+			t.Errorf("coupon %q: discount = %d, total = %d, coupon error = %q; want %d, %d, error %v",
+				tt.coupon, c.Discount, c.Total, c.CouponError, tt.discount, tt.total, tt.wantCouponErr)
+		}
+	}
+
+	c, _ := buildCart(nil, "c1", lines, "", time.Now().Add(time.Hour))
+	if len(c.Items) != len(lines) {
+		t.Fatalf("items = %d, want %d", len(c.Items), len(lines))
+	}
+	items := c.Items
+	if !items[0].Available || items[0].PriceChanged || items[0].Error != "" {
+		t.Errorf("unchanged line = %+v", items[0])
+	}
+	if !items[1].PriceChanged || items[1].UnitPrice != 150000 || items[1].AddedPrice != 200000 {
+		t.Errorf("line now on sale = %+v, want the sale price and PriceChanged", items[1])
+	}
+	for _, it := range items[2:4] {
+		if it.Available || it.Error == "" {
+			t.Errorf("unorderable line = %+v, want unavailable with an error", it)
+		}
+	}
+	if !items[4].Available || items[4].Error == "" {
+		t.Errorf("line over stock = %+v, want available with a stock error", items[4])
+	}
+}
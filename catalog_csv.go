@@ -0,0 +1,579 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// csvColumns is the column layout written by the export and understood by the import.
+// Import matches headers by name (case-insensitive) and ignores unknown columns;
+// columns missing from the file keep their current value on update. category is a path like "Quần áo > Áo khoác" and
+// collections is a "|"-separated list of collection names or slugs.
+var csvColumns = []string{"id", "external_key", "title", "description", "price", "category", "tag", "external_url", "image_url", "status", "collections"}
+
+// utf8BOM lets Excel open the exported file with Vietnamese characters intact.
+const utf8BOM = "\xef\xbb\xbf"
+
+// adminExportProductsCSV serves GET /api/admin/export/products.csv
+func adminExportProductsCSV(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !isAdmin(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		products, err := fetchProducts(db)
+		if err != nil {
+			log.Println("export products error:", err)
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		cats, err := fetchCategories(db)
+		if err != nil {
+			log.Println("export categories error:", err)
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		cur := shopCurrency(db)
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="products-%s.csv"`, time.Now().Format("20060102")))
+		_, _ = io.WriteString(w, utf8BOM)
+		cw := csv.NewWriter(w)
+		_ = cw.Write(csvColumns)
+		for _, p := range products {
+			var cols []string
+			for _, c := range p.Collections {
+				cols = append(cols, c.Name)
+			}
+			_ = cw.Write([]string{
+				strconv.FormatInt(p.ID, 10),
+				p.ExternalKey,
+				p.Title,
+				p.Description,
+				cur.Plain(p.Price),
+				categoryPath(cats, p.CategoryID),
+				p.Source,
+				p.ExternalURL,
+				p.ImageURL,
+				p.Status,
+				strings.Join(cols, "|"),
+			})
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			log.Println("export csv write error:", err)
+		}
+	}
+}
+
+// importRow is one validated CSV row.
+type importRow struct {
+	Line          int      `json:"line"`
+	Action        string   `json:"action"` // create, update or skip
+	ID            int64    `json:"id,omitempty"`
+	ExternalKey   string   `json:"external_key,omitempty"`
+	Title         string   `json:"title"`
+	Errors        []string `json:"errors,omitempty"`
+	NewCategories []string `json:"new_categories,omitempty"`
+
+	product       Product
+	oldPrice      Money    // price before an update, for the price history
+	categoryPath  []string // names still to be created under product.CategoryID
+	collectionIDs []int64
+	hasCollection bool
+}
+
+// importReport is returned by the import endpoint.
+type importReport struct {
+	DryRun  bool        `json:"dry_run"`
+	Created int         `json:"created"`
+	Updated int         `json:"updated"`
+	Skipped int         `json:"skipped"`
+	Rows    []importRow `json:"rows"`
+}
+
+// maxImportCSVBytes caps an uploaded CSV, including multipart overhead.
+const maxImportCSVBytes = 10 << 20
+
+// readCSVUpload returns the uploaded CSV from the multipart "file" field or the raw body.
+// A body over maxImportCSVBytes fails with an *http.MaxBytesError.
+func readCSVUpload(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportCSVBytes)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		if err := r.ParseMultipartForm(maxImportCSVBytes); err != nil {
+			return nil, err
+		}
+		f, _, err := r.FormFile("file")
+		if err != nil {
+			return nil, errors.New("file required")
+		}
+		defer f.Close()
+		return io.ReadAll(f)
+	}
+	return io.ReadAll(r.Body)
+}
+
+// newCSVReader strips a UTF-8 BOM and detects ";" delimited files (Excel in vi-VN locale).
+func newCSVReader(data []byte) *csv.Reader {
+	data = bytes.TrimPrefix(data, []byte(utf8BOM))
+	firstLine, _, _ := bufio.NewReader(bytes.NewReader(data)).ReadLine()
+	cr := csv.NewReader(bytes.NewReader(data))
+	if bytes.Count(firstLine, []byte(";")) > bytes.Count(firstLine, []byte(",")) {
+		cr.Comma = ';'
+	}
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	return cr
+}
+
+// importThousands matches amounts written with thousands separators, e.g. "150.000" or "1,500,000".
+var importThousands = regexp.MustCompile(`^\d{1,3}([.,]\d{3})+$`)
+
+// parseImportPrice returns minor units of cur for a CSV price, optionally with a
+// currency symbol. Spreadsheets write thousands separators, so for currencies
+// without a subunit (đồng) "150.000" and "150,000" both mean 150000. Where a
+// subunit exists a comma may only be the decimal mark ("12,50"); "1,500" could
+// mean either and is rejected.
+func parseImportPrice(s string, cur Currency) (Money, error) {
+	s = strings.TrimSpace(strings.NewReplacer(cur.Symbol, "", cur.Code, "", "₫", "", "đ", "", " ", "").Replace(s))
+	if s == "" {
+		return 0, nil
+	}
+	if cur.Exponent == 0 {
+		if importThousands.MatchString(s) {
+			s = strings.NewReplacer(".", "", ",", "").Replace(s)
+		}
+		return cur.Parse(s)
+	}
+	if strings.Contains(s, ",") {
+		if strings.Count(s, ",") > 1 || strings.Contains(s, ".") || len(s)-strings.LastIndex(s, ",") == 4 {
+			return 0, fmt.Errorf("ambiguous %s price %q; write digits with a decimal point, e.g. 1500.00", cur.Code, s)
+		}
+		s = strings.Replace(s, ",", ".", 1)
+	}
+	return cur.Parse(s)
+}
+
+// maxImportShortLinks caps the short links one import resolves.
+const maxImportShortLinks = 50
+
+// validateImport parses the CSV and validates every row against the current catalog.
+// On a dry run short links are not resolved.
+func validateImport(db *sql.DB, data []byte, createCategories, dryRun bool) ([]importRow, error) {
+	cr := newCSVReader(data)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	col := make(map[string]int)
+	for i, h := range header {
+		col[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	_, hasTitle := col["title"]
+	_, hasID := col["id"]
+	_, hasKey := col["external_key"]
+	if !hasTitle && !hasID && !hasKey {
+		return nil, errors.New("need a title, id or external_key column")
+	}
+	products, err := fetchProducts(db)
+	if err != nil {
+		return nil, err
+	}
+	cats, err := fetchCategories(db)
+	if err != nil {
+		return nil, err
+	}
+	currency := shopCurrency(db)
+	collections, err := fetchCollections(db)
+	if err != nil {
+		return nil, err
+	}
+	byKey := make(map[string]Product)
+	byID := make(map[int64]Product)
+	for _, p := range products {
+		byID[p.ID] = p
+		if p.ExternalKey != "" {
+			byKey[p.ExternalKey] = p
+		}
+	}
+	seenKeys := make(map[string]int)
+	shortLinks := 0
+
+	var rows []importRow
+	for line := 2; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		get := func(name string) (string, bool) {
+			i, ok := col[name]
+			if !ok || i >= len(rec) {
+				return "", false
+			}
+			return strings.TrimSpace(rec[i]), true
+		}
+		blank := true
+		for _, v := range rec {
+			if strings.TrimSpace(v) != "" {
+				blank = false
+				break
+			}
+		}
+		if blank {
+			continue
+		}
+
+		row := importRow{Line: line, Action: "create"}
+		fail := func(format string, args ...interface{}) {
+			row.Errors = append(row.Errors, fmt.Sprintf(format, args...))
+		}
+		row.ExternalKey, _ = get("external_key")
+
+		// upsert target: external_key first, then id
+		var cur Product
+		var exists bool
+		if row.ExternalKey != "" {
+			if prev, dup := seenKeys[row.ExternalKey]; dup {
+				fail("external_key duplicates line %d", prev)
+			}
+			seenKeys[row.ExternalKey] = line
+			cur, exists = byKey[row.ExternalKey]
+		}
+		if idStr, _ := get("id"); !exists && idStr != "" {
+			id, err := strconv.ParseInt(idStr, 10, 64)
+			if err != nil {
+				fail("invalid id")
+			} else if cur, exists = byID[id]; !exists && row.ExternalKey == "" {
+				fail("product id %d not found", id)
+			}
+		}
+		p := Product{Status: statusPublished}
+		if exists {
+			p = cur
+			row.Action = "update"
+			row.ID = cur.ID
+			row.oldPrice = cur.Price
+			if row.ExternalKey == "" {
+				row.ExternalKey = cur.ExternalKey
+			}
+		}
+		p.ExternalKey = row.ExternalKey
+
+		if v, ok := get("title"); ok {
+			p.Title = v
+		}
+		if p.Title == "" {
+			fail("title required")
+		}
+		row.Title = p.Title
+		if v, ok := get("description"); ok {
+			p.Description = v
+		}
+		if v, ok := get("price"); ok {
+			price, err := parseImportPrice(v, currency)
+			if err != nil {
+				fail("%v", err)
+			}
+			p.Price = price
+		}
+		if v, ok := get("image_url"); ok && v != "" {
+			if !strings.HasPrefix(v, "http://") && !strings.HasPrefix(v, "https://") {
+				fail("image_url must be an http(s) URL")
+			}
+			p.ImageURL = v
+		}
+		if v, ok := get("status"); ok && v != "" {
+			v = strings.ToLower(v)
+			if !validProductStatus(v) {
+				fail("invalid status %q", v)
+			}
+			p.Status = v
+		}
+
+		// source/tag follows the same rules as createProduct
+		ext, hasExt := get("external_url")
+		if tag, ok := get("tag"); ok && tag != "" {
+			p.Source = strings.ToLower(tag)
+			if p.Source != sourceShopee && p.Source != sourceMyChoice {
+				fail("tag must be mychoice or shopee")
+			}
+		} else if hasExt && ext != "" {
+			p.Source = sourceShopee
+		}
+		if p.Source == "" {
+			p.Source = sourceMyChoice
+		}
+		// the link is only checked (and short links resolved) for Shopee rows;
+		// resolving costs a request to Shopee, so a dry run leaves short links
+		// as they are and an import resolves at most maxImportShortLinks
+		switch {
+		case !hasExt || p.Source != sourceShopee:
+		case isShopeeShortLink(ext) && dryRun:
+			p.ExternalURL = ext
+		case isShopeeShortLink(ext) && shortLinks >= maxImportShortLinks:
+			fail("more than %d shp.ee short links in one import; paste the full product URL", maxImportShortLinks)
+		default:
+			if isShopeeShortLink(ext) {
+				shortLinks++
+			}
+			norm, err := normalizeShopeeURL(ext)
+			if err != nil {
+				fail("%s", err.Error())
+			}
+			p.ExternalURL = norm
+		}
+		if p.Source == sourceShopee && p.ExternalURL == "" {
+			fail("external_url required for shopee tag")
+		}
+		if p.Source == sourceMyChoice {
+			p.ExternalURL = ""
+		}
+
+		if v, ok := get("category"); ok {
+			names := splitCategoryPath(v)
+			p.CategoryID = 0
+			if len(names) > 0 {
+				id, missing := findCategoryPath(cats, names)
+				p.CategoryID = id
+				if len(missing) > 0 {
+					if createCategories {
+						row.categoryPath = missing
+						row.NewCategories = missing
+					} else {
+						fail("category %q not found", strings.Join(names, " > "))
+					}
+				}
+			}
+		}
+		if v, ok := get("collections"); ok {
+			row.hasCollection = true
+			for _, ref := range strings.Split(v, "|") {
+				if ref = strings.TrimSpace(ref); ref == "" {
+					continue
+				}
+				c, found := findCollection(collections, ref)
+				if !found {
+					c, found = findCollectionByName(collections, ref)
+				}
+				if !found {
+					fail("collection %q not found", ref)
+					continue
+				}
+				row.collectionIDs = append(row.collectionIDs, c.ID)
+			}
+		}
+
+		if len(row.Errors) > 0 {
+			row.Action = "skip"
+		}
+		row.product = p
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// findCollectionByName matches a collection name case- and diacritic-insensitively.
+func findCollectionByName(cols []Collection, name string) (Collection, bool) {
+	for _, c := range cols {
+		if foldVietnamese(c.Name) == foldVietnamese(name) {
+			return c, true
+		}
+	}
+	return Collection{}, false
+}
+
+// createImportCategories creates the missing category path of a row inside tx
+// (or in the dev store when tx is nil), reusing categories created for earlier
+// rows, and returns the leaf id. flat is the category list, which grows with
+// every category created.
+func createImportCategories(tx *sql.Tx, flat *[]Category, parent int64, names []string, created map[string]int64) (int64, error) {
+	for _, name := range names {
+		key := strconv.FormatInt(parent, 10) + "/" + foldVietnamese(name)
+		if id, ok := created[key]; ok {
+			parent = id
+			continue
+		}
+		c := Category{Name: name, ParentID: parent}
+		c.Slug = uniqueSlug(slugify(name), "danh-muc", categorySlugs(*flat, 0))
+		if tx == nil {
+			c = DevAddCategory(c)
+		} else {
+			res, err := tx.Exec("INSERT INTO categories (shop_id, name, slug, parent_id, position) VALUES (@shop_id, ?, ?, ?, ?)", c.Name, c.Slug, sqlNull(c.ParentID), c.Position)
+			if err != nil {
+				return 0, err
+			}
+			if c.ID, err = res.LastInsertId(); err != nil {
+				return 0, err
+			}
+		}
+		*flat = append(*flat, c)
+		created[key] = c.ID
+		parent = c.ID
+	}
+	return parent, nil
+}
+
+// applyImportRow writes one valid row inside tx (or to the dev store when tx is nil).
+func applyImportRow(db *sql.DB, tx *sql.Tx, row *importRow) error {
+	p := row.product
+	if tx == nil {
+		if row.Action == "create" {
+			row.ID = DevAddProduct(p.Title, p.Description, p.Price, p.ImageURL, p.CategoryID, p.ExternalURL, p.Source)
+		} else {
+			DevUpdateProduct(row.ID, p.Title, p.Description, p.Price, p.ImageURL, p.CategoryID, p.ExternalURL, p.Source)
+			_ = recordPriceChange(nil, nil, row.ID, row.oldPrice, p.Price, priceSourceImport)
+		}
+		DevModifyProduct(row.ID, func(dp *Product) {
+			dp.ExternalKey = p.ExternalKey
+			dp.Status = p.Status
+		})
+		if row.hasCollection {
+			DevSetProductCollections(row.ID, row.collectionIDs)
+		}
+		return nil
+	}
+	if row.Action == "create" {
+		res, err := tx.Exec("INSERT INTO products (shop_id, title, description, price_minor, image_url, external_url, external_key, source, status, category_id, created_at) VALUES (@shop_id, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			p.Title, p.Description, int64(p.Price), p.ImageURL, sqlNullString(p.ExternalURL), sqlNullString(p.ExternalKey), p.Source, p.Status, sqlNull(p.CategoryID), time.Now())
+		if err != nil {
+			return err
+		}
+		row.ID, _ = res.LastInsertId()
+	} else {
+		if _, err := tx.Exec("UPDATE products SET title=?, description=?, price_minor=?, image_url=?, external_url=?, external_key=?, source=?, status=?, category_id=? WHERE id=? AND shop_id = @shop_id",
+			p.Title, p.Description, int64(p.Price), p.ImageURL, sqlNullString(p.ExternalURL), sqlNullString(p.ExternalKey), p.Source, p.Status, sqlNull(p.CategoryID), row.ID); err != nil {
+			return err
+		}
+		if err := recordPriceChange(db, tx, row.ID, row.oldPrice, p.Price, priceSourceImport); err != nil {
+			return err
+		}
+	}
+	if row.hasCollection {
+		if _, err := tx.Exec("DELETE FROM product_collections WHERE product_id = ? AND shop_id = @shop_id", row.ID); err != nil {
+			return err
+		}
+		for _, cid := range row.collectionIDs {
+			if _, err := tx.Exec("INSERT INTO product_collections (shop_id, product_id, collection_id) VALUES (@shop_id, ?, ?)", row.ID, cid); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// adminImportProductsCSV serves POST /api/admin/import/products. The CSV is sent as the
+// multipart "file" field or as the raw body. Query flags: dry_run=1 validates only,
+// create_categories=1 creates missing categories. Rows with errors are skipped and
+// reported; all other rows are written in a single transaction.
+func adminImportProductsCSV(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !isAdmin(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		dryRun := parseFormBool(r.URL.Query().Get("dry_run"))
+		createCategories := parseFormBool(r.URL.Query().Get("create_categories"))
+		data, err := readCSVUpload(w, r)
+		if errors.As(err, new(*http.MaxBytesError)) {
+			http.Error(w, fmt.Sprintf("csv larger than %d MB", maxImportCSVBytes>>20), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, "read upload: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		rows, err := validateImport(db, data, createCategories, dryRun)
+		if err != nil {
+			http.Error(w, "invalid csv: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("import products rows=%d dryRun=%t createCategories=%t remote=%s dbNil=%t", len(rows), dryRun, createCategories, r.RemoteAddr, db == nil)
+
+		report := importReport{DryRun: dryRun, Rows: rows}
+		if rows == nil {
+			report.Rows = []importRow{}
+		}
+		if !dryRun {
+			flat, err := fetchCategories(db)
+			if err != nil {
+				log.Println("import categories error:", err)
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
+			var tx *sql.Tx
+			if db != nil {
+				if tx, err = db.Begin(); err != nil {
+					log.Println("import begin error:", err)
+					http.Error(w, "db error", http.StatusInternalServerError)
+					return
+				}
+				defer tx.Rollback()
+			}
+			// missing categories are created in the same transaction, so a failed
+			// import leaves none behind
+			created := make(map[string]int64)
+			for i := range rows {
+				if rows[i].Action != "skip" && len(rows[i].categoryPath) > 0 {
+					id, err := createImportCategories(tx, &flat, rows[i].product.CategoryID, rows[i].categoryPath, created)
+					if err != nil {
+						log.Println("import create categories error:", err)
+						http.Error(w, "db error", http.StatusInternalServerError)
+						return
+					}
+					rows[i].product.CategoryID = id
+				}
+			}
+			for i := range rows {
+				if rows[i].Action == "skip" {
+					continue
+				}
+				if err := applyImportRow(db, tx, &rows[i]); err != nil {
+					log.Printf("import line %d error: %v", rows[i].Line, err)
+					http.Error(w, fmt.Sprintf("db error on line %d", rows[i].Line), http.StatusInternalServerError)
+					return
+				}
+			}
+			if tx != nil {
+				if err := tx.Commit(); err != nil {
+					log.Println("import commit error:", err)
+					http.Error(w, "db error", http.StatusInternalServerError)
+					return
+				}
+			}
+		}
+		for _, row := range rows {
+			switch row.Action {
+			case "create":
+				report.Created++
+			case "update":
+				report.Updated++
+			default:
+				report.Skipped++
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(report)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseImportPrice(t *testing.T) {
+	vnd, usd := currencies["VND"], currencies["USD"]
+	tests := []struct {
+		cur     Currency
+		in      string
+		want    Money
+		wantErr bool
+	}{
+		{vnd, "150000", 150000, false},
+		{vnd, "150.000", 150000, false},
+		{vnd, "150.000 ₫", 150000, false},
+		{vnd, "1,500", 1500, false},
+		{vnd, "1.500.000đ", 1500000, false},
+		{vnd, "", 0, false},
+		{vnd, "150000.50", 0, true},
+		{vnd, "12.5", 0, true},
+		{vnd, "1.50.000", 0, true},
+		{usd, "12.50", 1250, false},
+		{usd, "$12.50", 1250, false},
+		{usd, "12,50", 1250, false},
+		{usd, "1500", 150000, false},
+		{usd, "1,500", 0, true}, // US$1.50 or US$1,500?
+		{usd, "1,500.00", 0, true},
+		{usd, "12.995", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseImportPrice(tt.in, tt.cur)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s parseImportPrice(%q) = %d, want error", tt.cur.Code, tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s parseImportPrice(%q) = %d, %v; want %d", tt.cur.Code, tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestReadCSVUploadLimit(t *testing.T) {
+	small := []byte("title,price\nÁo,150000\n")
+	r := httptest.NewRequest(http.MethodPost, "/api/admin/import/products", bytes.NewReader(small))
+	got, err := readCSVUpload(httptest.NewRecorder(), r)
+	if err != nil || !bytes.Equal(got, small) {
+		t.Errorf("readCSVUpload = %q, %v", got, err)
+	}
+
+	big := bytes.Repeat([]byte("x"), maxImportCSVBytes+1)
+	r = httptest.NewRequest(http.MethodPost, "/api/admin/import/products", bytes.NewReader(big))
+	if _, err := readCSVUpload(httptest.NewRecorder(), r); !errors.As(err, new(*http.MaxBytesError)) {
+		t.Errorf("oversized upload error = %v, want *http.MaxBytesError", err)
+	}
+}
+
+func TestValidateImportDryRunKeepsShortLinks(t *testing.T) {
+	useDevOrders(t, nil, nil)
+	csv := "title,price,tag,external_url\nÁo,150000,shopee,https://shp.ee/abc123\n"
+	rows, err := validateImport(nil, []byte(csv), false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || len(rows[0].Errors) != 0 || rows[0].product.ExternalURL != "https://shp.ee/abc123" {
+		t.Errorf("rows = %+v, want the short link kept unresolved", rows)
+	}
+}
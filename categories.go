@@ -0,0 +1,302 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// slugify turns a (Vietnamese) name into a URL slug, e.g. "Áo khoác" -> "ao-khoac".
+func slugify(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range foldVietnamese(s) {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+// uniqueSlug returns base (or base-2, base-3, ...) so that it is not in used.
+// fallback is used when base is empty, e.g. for names without any letters.
+func uniqueSlug(base, fallback string, used map[string]bool) string {
+	if base == "" {
+		base = fallback
+	}
+	slug := base
+	for n := 2; used[slug]; n++ {
+		slug = base + "-" + strconv.Itoa(n)
+	}
+	return slug
+}
+
+// categorySlugs returns the slugs used by categories other than selfID.
+func categorySlugs(cats []Category, selfID int64) map[string]bool {
+	used := make(map[string]bool, len(cats))
+	for _, c := range cats {
+		if c.ID != selfID && c.Slug != "" {
+			used[c.Slug] = true
+		}
+	}
+	return used
+}
+
+// sortCategories orders categories by explicit position, then name.
+func sortCategories(cats []Category) {
+	sort.SliceStable(cats, func(i, j int) bool {
+		if cats[i].Position != cats[j].Position {
+			return cats[i].Position < cats[j].Position
+		}
+		return foldVietnamese(cats[i].Name) < foldVietnamese(cats[j].Name)
+	})
+}
+
+// fetchCategories returns the flat category list in display order (or dev categories when db==nil).
+func fetchCategories(db *sql.DB) ([]Category, error) {
+	if db == nil {
+		cats := DevGetCategories()
+		sortCategories(cats)
+		return cats, nil
+	}
+	rows, err := db.Query("SELECT id, name, IFNULL(slug,''), IFNULL(parent_id,0), IFNULL(position,0) FROM categories WHERE shop_id = @shop_id ORDER BY position ASC, name ASC")
+	if err != nil {
+		return nil, fmt.Errorf("query categories: %w", err)
+	}
+	defer rows.Close()
+	var cats []Category
+	for rows.Next() {
+		var c Category
+		if err := rows.Scan(&c.ID, &c.Name, &c.Slug, &c.ParentID, &c.Position); err != nil {
+			return nil, fmt.Errorf("scan category: %w", err)
+		}
+		cats = append(cats, c)
+	}
+	return cats, rows.Err()
+}
+
+// buildCategoryTree nests a flat, ordered category list. Categories whose parent
+// no longer exists are treated as roots.
+func buildCategoryTree(flat []Category) []Category {
+	known := make(map[int64]bool, len(flat))
+	for _, c := range flat {
+		known[c.ID] = true
+	}
+	children := make(map[int64][]Category)
+	for _, c := range flat {
+		parent := c.ParentID
+		if !known[parent] {
+			parent = 0
+		}
+		children[parent] = append(children[parent], c)
+	}
+	var build func(parent int64) []Category
+	build = func(parent int64) []Category {
+		nodes := children[parent]
+		for i := range nodes {
+			nodes[i].Children = build(nodes[i].ID)
+		}
+		return nodes
+	}
+	return build(0)
+}
+
+// categoryDescendants returns the ids of id and all of its descendants.
+func categoryDescendants(flat []Category, id int64) map[int64]bool {
+	out := map[int64]bool{id: true}
+	for changed := true; changed; {
+		changed = false
+		for _, c := range flat {
+			if out[c.ParentID] && !out[c.ID] {
+				out[c.ID] = true
+				changed = true
+			}
+		}
+	}
+	return out
+}
+
+// findCategory resolves a category by numeric id or slug.
+func findCategory(flat []Category, ref string) (Category, bool) {
+	ref = strings.TrimSpace(ref)
+	if id, err := strconv.ParseInt(ref, 10, 64); err == nil {
+		for _, c := range flat {
+			if c.ID == id {
+				return c, true
+			}
+		}
+		return Category{}, false
+	}
+	for _, c := range flat {
+		if c.Slug == ref {
+			return c, true
+		}
+	}
+	return Category{}, false
+}
+
+var errCategoryCycle = errors.New("category cannot be its own ancestor")
+
+// validateCategoryParent checks that parentID exists and that attaching id under it
+// would not create a cycle. parentID 0 means top level.
+func validateCategoryParent(flat []Category, id, parentID int64) error {
+	if parentID == 0 {
+		return nil
+	}
+	if _, ok := findCategory(flat, strconv.FormatInt(parentID, 10)); !ok {
+		return errors.New("parent category not found")
+	}
+	if id != 0 && categoryDescendants(flat, id)[parentID] {
+		return errCategoryCycle
+	}
+	return nil
+}
+
+// deleteCategory removes a category, detaching its products and moving its children
+// up to the deleted category's parent. Returns false if it did not exist.
+func deleteCategory(db *sql.DB, id int64) (bool, error) {
+	if db == nil {
+		return DevDeleteCategory(id), nil
+	}
+	var parent sql.NullInt64
+	if err := db.QueryRow("SELECT parent_id FROM categories WHERE id=? AND shop_id = @shop_id", id).Scan(&parent); err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("UPDATE products SET category_id=NULL WHERE category_id=? AND shop_id = @shop_id", id); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec("UPDATE categories SET parent_id=? WHERE parent_id=? AND shop_id = @shop_id", parent, id); err != nil {
+		return false, err
+	}
+	res, err := tx.Exec("DELETE FROM categories WHERE id=? AND shop_id = @shop_id", id)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	return true, tx.Commit()
+}
+
+// insertCategory stores a validated category and returns it with its new id.
+func insertCategory(db *sql.DB, c Category) (Category, error) {
+	if db == nil {
+		return DevAddCategory(c), nil
+	}
+	res, err := db.Exec("INSERT INTO categories (shop_id, name, slug, parent_id, position) VALUES (@shop_id, ?, ?, ?, ?)", c.Name, c.Slug, sqlNull(c.ParentID), c.Position)
+	if err != nil {
+		return Category{}, err
+	}
+	c.ID, _ = res.LastInsertId()
+	return c, nil
+}
+
+// categoryPath returns "Parent > Child" for a category id, or "" for none.
+func categoryPath(flat []Category, id int64) string {
+	var names []string
+	for depth := 0; id != 0 && depth < len(flat); depth++ {
+		c, ok := findCategory(flat, strconv.FormatInt(id, 10))
+		if !ok {
+			break
+		}
+		names = append([]string{c.Name}, names...)
+		id = c.ParentID
+	}
+	return strings.Join(names, " > ")
+}
+
+// splitCategoryPath splits "Quần áo > Áo khoác" into trimmed, non-empty names.
+func splitCategoryPath(path string) []string {
+	var out []string
+	for _, part := range strings.Split(path, ">") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// findCategoryPath resolves a path of names (matched case- and diacritic-insensitively)
+// walking down from the top level. It returns the deepest matching category id and
+// the remaining names that do not exist yet.
+func findCategoryPath(flat []Category, names []string) (int64, []string) {
+	var parent int64
+	for i, name := range names {
+		found := false
+		for _, c := range flat {
+			if c.ParentID == parent && foldVietnamese(c.Name) == foldVietnamese(name) {
+				parent, found = c.ID, true
+				break
+			}
+		}
+		if !found {
+			// a single name may also refer to a nested category anywhere in the tree
+			if len(names) == 1 {
+				for _, c := range flat {
+					if foldVietnamese(c.Name) == foldVietnamese(name) {
+						return c.ID, nil
+					}
+				}
+			}
+			return parent, names[i:]
+		}
+	}
+	return parent, nil
+}
+
+// ensureCategorySlugs fills in slugs for categories created before slugs existed.
+func ensureCategorySlugs(db *sql.DB) error {
+	cats, err := fetchCategories(db)
+	if err != nil {
+		return err
+	}
+	for i, c := range cats {
+		if c.Slug != "" {
+			continue
+		}
+		slug := uniqueSlug(slugify(c.Name), "danh-muc", categorySlugs(cats, c.ID))
+		if _, err := db.Exec("UPDATE categories SET slug=? WHERE id=? AND shop_id = @shop_id", slug, c.ID); err != nil {
+			return err
+		}
+		cats[i].Slug = slug
+	}
+	return nil
+}
+
+// flattenTree lists every node of a category tree (depth first), keeping children attached.
+func flattenTree(tree []Category) []Category {
+	var out []Category
+	for _, c := range tree {
+		out = append(out, c)
+		out = append(out, flattenTree(c.Children)...)
+	}
+	return out
+}
+
+// filterProductsByCategory keeps products whose category is in ids.
+func filterProductsByCategory(products []Product, ids map[int64]bool) []Product {
+	var out []Product
+	for _, p := range products {
+		if ids[p.CategoryID] {
+			out = append(out, p)
+		}
+	}
+	return out
+}
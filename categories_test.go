@@ -0,0 +1,118 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"Quần áo", "quan-ao"},
+		{"Đồ gia dụng", "do-gia-dung"},
+		{"  Áo khoác / Jacket  ", "ao-khoac-jacket"},
+		{"Size XL!!", "size-xl"},
+		{"2024 Sale", "2024-sale"},
+		{"★☆", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := slugify(tt.in); got != tt.want {
+			t.Errorf("slugify(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestUniqueSlug(t *testing.T) {
+	used := map[string]bool{"ao": true, "ao-2": true, "danh-muc": true}
+	tests := []struct{ base, want string }{
+		{"quan", "quan"},
+		{"ao", "ao-3"},
+		{"", "danh-muc-2"},
+	}
+	for _, tt := range tests {
+		if got := uniqueSlug(tt.base, "danh-muc", used); got != tt.want {
+			t.Errorf("uniqueSlug(%q) = %q, want %q", tt.base, got, tt.want)
+		}
+	}
+}
+
+// testCategories is Clothes > Jackets > Winter, plus a separate Shoes root.
+var testCategories = []Category{
+	{ID: 1, Name: "Quần áo", Slug: "quan-ao"},
+	{ID: 2, Name: "Áo khoác", Slug: "ao-khoac", ParentID: 1},
+	{ID: 3, Name: "Mùa đông", Slug: "mua-dong", ParentID: 2},
+	{ID: 4, Name: "Giày", Slug: "giay"},
+}
+
+func TestValidateCategoryParent(t *testing.T) {
+	tests := []struct {
+		name         string
+		id, parentID int64
+		wantErr      bool
+	}{
+		{"top level", 2, 0, false},
+		{"new category", 0, 3, false},
+		{"move to another root", 2, 4, false},
+		{"unknown parent", 2, 99, true},
+		{"own parent", 2, 2, true},
+		{"under a child", 1, 2, true},
+		{"under a grandchild", 1, 3, true},
+	}
+	for _, tt := range tests {
+		if err := validateCategoryParent(testCategories, tt.id, tt.parentID); (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateCategoryParent(%d, %d) = %v, wantErr %v", tt.name, tt.id, tt.parentID, err, tt.wantErr)
+		}
+	}
+	if err := validateCategoryParent(testCategories, 1, 3); err != errCategoryCycle {
+		t.Errorf("cycle error = %v, want errCategoryCycle", err)
+	}
+}
+
+func TestBuildCategoryTree(t *testing.T) {
+	flat := append([]Category{{ID: 5, Name: "Orphan", ParentID: 42}}, testCategories...)
+	tree := buildCategoryTree(flat)
+	var roots []int64
+	for _, c := range tree {
+		roots = append(roots, c.ID)
+	}
+	if want := []int64{5, 1, 4}; !reflect.DeepEqual(roots, want) {
+		t.Fatalf("roots = %v, want %v", roots, want)
+	}
+	if c := tree[1]; len(c.Children) != 1 || c.Children[0].ID != 2 || len(c.Children[0].Children) != 1 || c.Children[0].Children[0].ID != 3 {
+		t.Errorf("clothes subtree = %+v", c)
+	}
+	if n := len(flattenTree(tree)); n != len(flat) {
+		t.Errorf("flattenTree has %d nodes, want %d", n, len(flat))
+	}
+	if got := categoryDescendants(testCategories, 1); !reflect.DeepEqual(got, map[int64]bool{1: true, 2: true, 3: true}) {
+		t.Errorf("categoryDescendants(1) = %v", got)
+	}
+}
+
+func TestCategoryPaths(t *testing.T) {
+	if got := categoryPath(testCategories, 3); got != "Quần áo > Áo khoác > Mùa đông" {
+		t.Errorf("categoryPath(3) = %q", got)
+	}
+	// a cycle in stored data must not loop forever
+	cyclic := []Category{{ID: 1, Name: "A", ParentID: 2}, {ID: 2, Name: "B", ParentID: 1}}
+	if got := categoryPath(cyclic, 1); got != "B > A" {
+		t.Errorf("categoryPath on a cycle = %q, want %q", got, "B > A")
+	}
+
+	tests := []struct {
+		path    string
+		wantID  int64
+		missing []string
+	}{
+		{"quan ao > AO KHOAC", 2, nil},
+		{"Quần áo > Áo khoác > Mùa hè", 2, []string{"Mùa hè"}},
+		{"Mùa đông", 3, nil},
+		{"Túi xách > Ví", 0, []string{"Túi xách", "Ví"}},
+	}
+	for _, tt := range tests {
+		id, missing := findCategoryPath(testCategories, splitCategoryPath(tt.path))
+		if id != tt.wantID || !reflect.DeepEqual(missing, tt.missing) {
+			t.Errorf("findCategoryPath(%q) = %d, %q; want %d, %q", tt.path, id, missing, tt.wantID, tt.missing)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Click targets served by /go/{kind}/{id}.
+const (
+	clickProduct = "product" // a product's external_url (Shopee listing)
+	clickSocial  = "social"  // a social link's URL
+)
+
+// trackedURL is the redirect path that records a click before sending the visitor on.
+func trackedURL(kind string, id int64) string {
+	return "/go/" + kind + "/" + strconv.FormatInt(id, 10)
+}
+
+// setTrackedURLs fills TrackedURL for products that link out.
+func setTrackedURLs(products []Product) {
+	for i := range products {
+		if products[i].ExternalURL != "" {
+			products[i].TrackedURL = trackedURL(clickProduct, products[i].ID)
+		}
+	}
+}
+
+// setSocialTrackedURLs fills TrackedURL for social links.
+func setSocialTrackedURLs(socials []Social) {
+	for i := range socials {
+		socials[i].TrackedURL = trackedURL(clickSocial, socials[i].ID)
+	}
+}
+
+// anonymizeIP drops the host part of an address: the last octet of IPv4 and all
+// but the first 48 bits of IPv6, so "203.0.113.42" is stored as "203.0.113.0".
+func anonymizeIP(s string) string {
+	ip := net.ParseIP(strings.TrimSpace(s))
+	if ip == nil {
+		return ""
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// trustedProxies are the proxies allowed to report the visitor address in
+// X-Forwarded-For, from TRUSTED_PROXIES (comma-separated IPs or CIDRs). Without
+// it the header is ignored, since anyone can send it.
+var trustedProxies = parseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+
+// parseTrustedProxies parses a TRUSTED_PROXIES value; invalid entries are logged and skipped.
+func parseTrustedProxies(s string) []*net.IPNet {
+	var out []*net.IPNet
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if !strings.Contains(f, "/") {
+			if ip := net.ParseIP(f); ip != nil && ip.To4() != nil {
+				f += "/32"
+			} else {
+				f += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(f)
+		if err != nil {
+			log.Printf("TRUSTED_PROXIES: ignoring %q", f)
+			continue
+		}
+		out = append(out, n)
+	}
+	return out
+}
+
+// isTrustedProxy reports whether addr is one of trustedProxies.
+func isTrustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the visitor address. X-Forwarded-For is only read when the
+// request comes from a trusted proxy; its entries are walked from the right,
+// past any further trusted proxies, to the first address one of them saw.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !isTrustedProxy(host) {
+		return host
+	}
+	parts := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(parts) - 1; i >= 0; i-- {
+		addr := strings.TrimSpace(parts[i])
+		if net.ParseIP(addr) == nil {
+			break
+		}
+		host = addr
+		if !isTrustedProxy(addr) {
+			break
+		}
+	}
+	return host
+}
+
+// uaClass buckets a user agent into bot, tablet, mobile or desktop.
+func uaClass(ua string) string {
+	ua = strings.ToLower(ua)
+	switch {
+	case ua == "":
+		return "bot"
+	case strings.Contains(ua, "bot") || strings.Contains(ua, "crawl") || strings.Contains(ua, "spider") ||
+		strings.Contains(ua, "facebookexternalhit") || strings.Contains(ua, "curl") || strings.Contains(ua, "wget"):
+		return "bot"
+	case strings.Contains(ua, "ipad") || strings.Contains(ua, "tablet"):
+		return "tablet"
+	case strings.Contains(ua, "mobi") || strings.Contains(ua, "android") || strings.Contains(ua, "iphone"):
+		return "mobile"
+	default:
+		return "desktop"
+	}
+}
+
+// clickTarget resolves the outbound URL for kind/id; ok is false when there is none.
+func clickTarget(db *sql.DB, kind string, id int64) (string, bool, error) {
+	var target string
+	switch kind {
+	case clickProduct:
+		p, found, err := fetchProduct(db, id)
+		if err != nil || !found || p.Status == statusDraft {
+			return "", false, err
+		}
+		profile, err := fetchProfile(db)
+		if err != nil {
+			return "", false, err
+		}
+		target = withAffiliateParams(p.ExternalURL, profile.ShopeeAffiliateParams)
+	case clickSocial:
+		if db == nil {
+			for _, s := range DevGetSocials() {
+				if s.ID == id {
+					target = s.URL
+				}
+			}
+		} else {
+			err := db.QueryRow("SELECT url FROM socials WHERE id = ? AND shop_id = @shop_id", id).Scan(&target)
+			if err == sql.ErrNoRows {
+				return "", false, nil
+			}
+			if err != nil {
+				return "", false, err
+			}
+		}
+	default:
+		return "", false, nil
+	}
+	u, err := url.Parse(strings.TrimSpace(target))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", false, nil
+	}
+	return u.String(), true, nil
+}
+
+// recordClick stores a click event.
+func recordClick(db *sql.DB, e ClickEvent) error {
+	if db == nil {
+		DevAddClickEvent(e)
+		return nil
+	}
+	_, err := db.Exec("INSERT INTO click_events (shop_id, kind, target_id, referrer, ua_class, ip, created_at) VALUES (@shop_id, ?, ?, ?, ?, ?, ?)",
+		e.Kind, e.TargetID, sqlNullString(e.Referrer), e.UAClass, sqlNullString(e.IP), e.CreatedAt)
+	return err
+}
+
+// clickRedirectHandler serves /go/{kind}/{id}: it records the click and redirects
+// to the product's Shopee link or the social URL. Recording failures are logged
+// and never block the redirect.
+func clickRedirectHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/go/"), "/"), "/")
+		if len(parts) != 2 {
+			http.NotFound(w, r)
+			return
+		}
+		id, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		target, ok, err := clickTarget(db, parts[0], id)
+		if err != nil {
+			log.Println("clickTarget error:", err)
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method == http.MethodGet {
+			referrer := r.Referer()
+			if len(referrer) > 512 {
+				referrer = referrer[:512]
+			}
+			e := ClickEvent{
+				Kind:      parts[0],
+				TargetID:  id,
+				Referrer:  referrer,
+				UAClass:   uaClass(r.UserAgent()),
+				IP:        anonymizeIP(clientIP(r)),
+				CreatedAt: time.Now(),
+			}
+			if err := recordClick(db, e); err != nil {
+				log.Println("recordClick error:", err)
+			}
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, target, http.StatusFound)
+	}
+}
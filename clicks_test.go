@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	saved := trustedProxies
+	t.Cleanup(func() { trustedProxies = saved })
+	trustedProxies = parseTrustedProxies("10.0.0.0/8, 192.0.2.7, not-an-ip")
+
+	tests := []struct {
+		name   string
+		remote string
+		xff    string
+		want   string
+	}{
+		{"direct visitor", "203.0.113.5:4321", "", "203.0.113.5"},
+		{"spoofed header from a visitor", "203.0.113.5:4321", "1.2.3.4", "203.0.113.5"},
+		{"trusted proxy", "10.1.2.3:80", "198.51.100.9", "198.51.100.9"},
+		{"trusted single ip", "192.0.2.7:80", "198.51.100.9", "198.51.100.9"},
+		{"visitor-supplied entries are skipped", "10.1.2.3:80", "1.2.3.4, 198.51.100.9", "198.51.100.9"},
+		{"chained trusted proxies", "10.1.2.3:80", "198.51.100.9, 10.9.9.9", "198.51.100.9"},
+		{"trusted proxy without header", "10.1.2.3:80", "", "10.1.2.3"},
+		{"garbage header", "10.1.2.3:80", "unknown", "10.1.2.3"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/go/product/1", nil)
+		r.RemoteAddr = tt.remote
+		if tt.xff != "" {
+			r.Header.Set("X-Forwarded-For", tt.xff)
+		}
+		if got := clientIP(r); got != tt.want {
+			t.Errorf("%s: clientIP = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// fetchCollections returns all collections in display order (or dev collections when db==nil).
+func fetchCollections(db *sql.DB) ([]Collection, error) {
+	if db == nil {
+		cols := DevGetCollections()
+		sort.SliceStable(cols, func(i, j int) bool {
+			if cols[i].Position != cols[j].Position {
+				return cols[i].Position < cols[j].Position
+			}
+			return foldVietnamese(cols[i].Name) < foldVietnamese(cols[j].Name)
+		})
+		return cols, nil
+	}
+	rows, err := db.Query(`SELECT c.id, c.name, c.slug, IFNULL(c.position,0), COUNT(pc.product_id)
+		FROM collections c
+		LEFT JOIN product_collections pc ON pc.collection_id = c.id
+		WHERE c.shop_id = @shop_id
+		GROUP BY c.id, c.name, c.slug, c.position
+		ORDER BY c.position ASC, c.name ASC`)
+	if err != nil {
+		return nil, fmt.Errorf("query collections: %w", err)
+	}
+	defer rows.Close()
+	var out []Collection
+	for rows.Next() {
+		var c Collection
+		if err := rows.Scan(&c.ID, &c.Name, &c.Slug, &c.Position, &c.ProductCount); err != nil {
+			return nil, fmt.Errorf("scan collection: %w", err)
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// findCollection resolves a collection by numeric id or slug.
+func findCollection(cols []Collection, ref string) (Collection, bool) {
+	ref = strings.TrimSpace(ref)
+	id, idErr := strconv.ParseInt(ref, 10, 64)
+	for _, c := range cols {
+		if (idErr == nil && c.ID == id) || (idErr != nil && c.Slug == ref) {
+			return c, true
+		}
+	}
+	return Collection{}, false
+}
+
+// collectionSlugs returns the slugs used by collections other than selfID.
+func collectionSlugs(cols []Collection, selfID int64) map[string]bool {
+	used := make(map[string]bool, len(cols))
+	for _, c := range cols {
+		if c.ID != selfID {
+			used[c.Slug] = true
+		}
+	}
+	return used
+}
+
+// fetchProductCollectionIDs returns product id -> collection ids. productID 0 loads all products.
+func fetchProductCollectionIDs(db *sql.DB, productID int64) (map[int64][]int64, error) {
+	if db == nil {
+		all := DevGetProductCollections()
+		if productID == 0 {
+			return all, nil
+		}
+		return map[int64][]int64{productID: all[productID]}, nil
+	}
+	query := "SELECT product_id, collection_id FROM product_collections WHERE shop_id = @shop_id"
+	var args []interface{}
+	if productID != 0 {
+		query += " AND product_id = ?"
+		args = append(args, productID)
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query product collections: %w", err)
+	}
+	defer rows.Close()
+	out := make(map[int64][]int64)
+	for rows.Next() {
+		var pid, cid int64
+		if err := rows.Scan(&pid, &cid); err != nil {
+			return nil, fmt.Errorf("scan product collection: %w", err)
+		}
+		out[pid] = append(out[pid], cid)
+	}
+	return out, rows.Err()
+}
+
+// attachCollections fills Product.Collections for each product (in collection display order).
+func attachCollections(db *sql.DB, products []Product, productID int64) error {
+	if len(products) == 0 {
+		return nil
+	}
+	cols, err := fetchCollections(db)
+	if err != nil {
+		return err
+	}
+	links, err := fetchProductCollectionIDs(db, productID)
+	if err != nil {
+		return err
+	}
+	for i := range products {
+		member := make(map[int64]bool)
+		for _, cid := range links[products[i].ID] {
+			member[cid] = true
+		}
+		products[i].Collections = []Collection{}
+		for _, c := range cols {
+			if member[c.ID] {
+				c.ProductCount = 0
+				products[i].Collections = append(products[i].Collections, c)
+			}
+		}
+	}
+	return nil
+}
+
+// filterProductsByCollection keeps products that belong to collection id.
+func filterProductsByCollection(products []Product, id int64) []Product {
+	var out []Product
+	for _, p := range products {
+		for _, c := range p.Collections {
+			if c.ID == id {
+				out = append(out, p)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// parseIDList accepts repeated and/or comma-separated ids ("1,2" or ["1","2"]); blanks are ignored.
+func parseIDList(vals []string) ([]int64, error) {
+	var out []int64
+	for _, v := range vals {
+		for _, part := range strings.Split(v, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			id, err := strconv.ParseInt(part, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid id %q", part)
+			}
+			out = append(out, id)
+		}
+	}
+	return dedupeIDs(out), nil
+}
+
+// dedupeIDs drops repeated ids, keeping the first occurrence.
+func dedupeIDs(ids []int64) []int64 {
+	var out []int64
+	seen := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+var errUnknownCollection = errors.New("collection not found")
+
+// validateCollectionIDs returns an errUnknownCollection error if any id does not exist.
+func validateCollectionIDs(db *sql.DB, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	cols, err := fetchCollections(db)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if _, ok := findCollection(cols, strconv.FormatInt(id, 10)); !ok {
+			return fmt.Errorf("%w: %d", errUnknownCollection, id)
+		}
+	}
+	return nil
+}
+
+// setProductCollections replaces the collections assigned to a product.
+func setProductCollections(db *sql.DB, productID int64, ids []int64) error {
+	if err := validateCollectionIDs(db, ids); err != nil {
+		return err
+	}
+	if db == nil {
+		DevSetProductCollections(productID, ids)
+		return nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM product_collections WHERE product_id = ? AND shop_id = @shop_id", productID); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if _, err := tx.Exec("INSERT INTO product_collections (shop_id, product_id, collection_id) VALUES (@shop_id, ?, ?)", productID, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// collectionPayload is the JSON body for collection POST/PUT. Nil fields are left unchanged on PUT.
+type collectionPayload struct {
+	Name     *string `json:"name"`
+	Slug     *string `json:"slug"`
+	Position *int    `json:"position"`
+}
+
+// apply copies the provided fields onto c, validating name and slug uniqueness.
+func (p collectionPayload) apply(c *Collection, cols []Collection) (string, bool) {
+	if p.Name != nil {
+		c.Name = strings.TrimSpace(*p.Name)
+	}
+	if c.Name == "" {
+		return "name required", false
+	}
+	if p.Position != nil {
+		c.Position = *p.Position
+	}
+	if p.Slug != nil {
+		c.Slug = slugify(*p.Slug)
+		if c.Slug == "" {
+			return "invalid slug", false
+		}
+		if collectionSlugs(cols, c.ID)[c.Slug] {
+			return "slug already in use", false
+		}
+	}
+	if c.Slug == "" {
+		c.Slug = uniqueSlug(slugify(c.Name), "bo-suu-tap", collectionSlugs(cols, c.ID))
+	}
+	return "", true
+}
+
+// collectionsHandler provides GET (public) and POST (admin) for /api/collections
+func collectionsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			cols, err := fetchCollections(db)
+			if err != nil {
+				log.Println("collections GET error:", err)
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
+			if cols == nil {
+				cols = []Collection{}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(cols)
+			return
+
+		case http.MethodPost:
+			if !isAdmin(r) {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			var payload collectionPayload
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+				http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			cols, err := fetchCollections(db)
+			if err != nil {
+				log.Println("collections POST fetch error:", err)
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
+			var c Collection
+			if msg, ok := payload.apply(&c, cols); !ok {
+				http.Error(w, msg, http.StatusBadRequest)
+				return
+			}
+			if db == nil {
+				c = DevAddCollection(c)
+			} else {
+				res, err := db.Exec("INSERT INTO collections (shop_id, name, slug, position) VALUES (@shop_id, ?, ?, ?)", c.Name, c.Slug, c.Position)
+				if err != nil {
+					log.Println("collections POST db.Exec error:", err)
+					http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+					return
+				}
+				c.ID, _ = res.LastInsertId()
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(c)
+			return
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+	}
+}
+
+// collectionItemHandler handles GET (by id or slug), PUT and DELETE for /api/collections/{id}
+func collectionItemHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(r.URL.Path, "/")
+		if len(parts) < 4 || parts[3] == "" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		cols, err := fetchCollections(db)
+		if err != nil {
+			log.Println("collectionItemHandler fetch error:", err)
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		cur, found := findCollection(cols, parts[3])
+		if r.Method != http.MethodGet && !isAdmin(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !found {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(cur)
+			return
+
+		case http.MethodPut:
+			var payload collectionPayload
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+				http.Error(w, "invalid json", http.StatusBadRequest)
+				return
+			}
+			if msg, ok := payload.apply(&cur, cols); !ok {
+				http.Error(w, msg, http.StatusBadRequest)
+				return
+			}
+			if db == nil {
+				DevUpdateCollection(cur)
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			if _, err := db.Exec("UPDATE collections SET name=?, slug=?, position=? WHERE id=? AND shop_id = @shop_id", cur.Name, cur.Slug, cur.Position, cur.ID); err != nil {
+				log.Println("collection PUT db.Exec error:", err)
+				http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+
+		case http.MethodDelete:
+			if db == nil {
+				DevDeleteCollection(cur.ID)
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			if _, err := db.Exec("DELETE FROM product_collections WHERE collection_id=? AND shop_id = @shop_id", cur.ID); err != nil {
+				log.Println("collection DELETE unlink error:", err)
+				http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if _, err := db.Exec("DELETE FROM collections WHERE id=? AND shop_id = @shop_id", cur.ID); err != nil {
+				log.Println("collection DELETE db.Exec error:", err)
+				http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+	}
+}
+
+// productCollectionsHandler handles GET and PUT {"collection_ids":[...]} for /api/products/{id}/collections
+func productCollectionsHandler(db *sql.DB, productID int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok, err := fetchProduct(db, productID)
+		if err != nil {
+			log.Println("productCollections fetch error:", err)
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(p.Collections)
+			return
+
+		case http.MethodPut:
+			if !isAdmin(r) {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			var payload struct {
+				CollectionIDs []int64 `json:"collection_ids"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+				http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := setProductCollections(db, productID, dedupeIDs(payload.CollectionIDs)); err != nil {
+				if errors.Is(err, errUnknownCollection) {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				log.Println("productCollections PUT error:", err)
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+	}
+}
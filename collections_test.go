@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// useDevCollections gives one test its own in-memory collections and assignments.
+func useDevCollections(t *testing.T, cols []Collection) {
+	t.Helper()
+	devMu.Lock()
+	saved, savedNext, savedLinks := devCollections, devNextCollectionID, devProductCollections
+	devCollections, devNextCollectionID, devProductCollections = cols, 100, map[int64][]int64{}
+	devMu.Unlock()
+	t.Cleanup(func() {
+		devMu.Lock()
+		devCollections, devNextCollectionID, devProductCollections = saved, savedNext, savedLinks
+		devMu.Unlock()
+	})
+}
+
+func TestParseIDList(t *testing.T) {
+	tests := []struct {
+		in      []string
+		want    []int64
+		wantErr bool
+	}{
+		{nil, nil, false},
+		{[]string{"1,2", "3"}, []int64{1, 2, 3}, false},
+		{[]string{" 2 , ,1", "2"}, []int64{2, 1}, false},
+		{[]string{"1,x"}, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseIDList(tt.in)
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseIDList(%q) = %v, %v; want %v, wantErr %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestCollectionPayloadApply(t *testing.T) {
+	cols := []Collection{{ID: 1, Name: "Hàng mới", Slug: "hang-moi"}, {ID: 2, Name: "Sale", Slug: "sale"}}
+	str := func(s string) *string { return &s }
+	tests := []struct {
+		name     string
+		c        Collection
+		p        collectionPayload
+		wantSlug string
+		wantMsg  string
+	}{
+		{"slug from name", Collection{}, collectionPayload{Name: str("Quà tặng")}, "qua-tang", ""},
+		{"name slug taken", Collection{}, collectionPayload{Name: str("Sale")}, "sale-2", ""},
+		{"explicit slug", Collection{}, collectionPayload{Name: str("Tết"), Slug: str("Tet 2025")}, "tet-2025", ""},
+		{"explicit slug taken", Collection{}, collectionPayload{Name: str("X"), Slug: str("sale")}, "", "slug already in use"},
+		{"keeps own slug", cols[1], collectionPayload{Slug: str("sale")}, "sale", ""},
+		{"blank slug", Collection{}, collectionPayload{Name: str("X"), Slug: str("!!")}, "", "invalid slug"},
+		{"blank name", Collection{}, collectionPayload{Name: str("  ")}, "", "name required"},
+	}
+	for _, tt := range tests {
+		c := tt.c
+		msg, ok := tt.p.apply(&c, cols)
+		if msg != tt.wantMsg || ok != (tt.wantMsg == "") {
+			t.Errorf("%s: apply = %q, %v; want %q", tt.name, msg, ok, tt.wantMsg)
+			continue
+		}
+		if ok && c.Slug != tt.wantSlug {
+			t.Errorf("%s: slug = %q, want %q", tt.name, c.Slug, tt.wantSlug)
+		}
+	}
+}
+
+func TestProductCollections(t *testing.T) {
+	useDevCollections(t, []Collection{{ID: 1, Name: "Sale", Slug: "sale", Position: 2}, {ID: 2, Name: "Hàng mới", Slug: "hang-moi", Position: 1}})
+	if err := setProductCollections(nil, 10, []int64{1, 99}); !errors.Is(err, errUnknownCollection) {
+		t.Fatalf("unknown collection: err = %v, want errUnknownCollection", err)
+	}
+	if err := setProductCollections(nil, 10, []int64{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := setProductCollections(nil, 11, []int64{1}); err != nil {
+		t.Fatal(err)
+	}
+
+	products := []Product{{ID: 10}, {ID: 11}, {ID: 12}}
+	if err := attachCollections(nil, products, 0); err != nil {
+		t.Fatal(err)
+	}
+	var slugs []string
+	for _, c := range products[0].Collections {
+		slugs = append(slugs, c.Slug)
+	}
+	if want := []string{"hang-moi", "sale"}; !reflect.DeepEqual(slugs, want) {
+		t.Errorf("product 10 collections = %v, want %v in display order", slugs, want)
+	}
+	if products[2].Collections == nil || len(products[2].Collections) != 0 {
+		t.Errorf("product 12 collections = %#v, want an empty list", products[2].Collections)
+	}
+	if got := filterProductsByCollection(products, 1); len(got) != 2 {
+		t.Errorf("products in sale = %d, want 2", len(got))
+	}
+	if got := filterProductsByCollection(products, 2); len(got) != 1 || got[0].ID != 10 {
+		t.Errorf("products in hang-moi = %+v, want product 10", got)
+	}
+
+	// deleting a collection drops its assignments; clearing empties the product
+	DevDeleteCollection(1)
+	if got := DevGetProductCollections()[11]; len(got) != 0 {
+		t.Errorf("product 11 still in deleted collection: %v", got)
+	}
+	if err := setProductCollections(nil, 10, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := DevGetProductCollections()[10]; ok {
+		t.Error("clearing product 10 collections left an entry")
+	}
+}
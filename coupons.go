@@ -0,0 +1,356 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Coupon kinds.
+const (
+	couponPercent = "percent"
+	couponFixed   = "fixed"
+)
+
+var errCouponUsedUp = orderError{"mã giảm giá đã hết lượt sử dụng"}
+
+// normalizeCouponCode makes codes case-insensitive: " sale10 " -> "SALE10".
+func normalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// discountFor returns the discount c gives on subtotal at now, or an orderError
+// explaining why the coupon cannot be used. cur is only used in messages.
+func (c Coupon) discountFor(subtotal Money, now time.Time, cur Currency) (Money, error) {
+	if !c.Active {
+		return 0, orderError{"mã giảm giá không hợp lệ"}
+	}
+	if c.ExpiresAt != nil && !now.Before(*c.ExpiresAt) {
+		return 0, orderError{"mã giảm giá đã hết hạn"}
+	}
+	if c.MaxUses > 0 && c.UsedCount >= c.MaxUses {
+		return 0, errCouponUsedUp
+	}
+	if subtotal < c.MinOrder {
+		return 0, orderError{fmt.Sprintf("đơn tối thiểu %s để dùng mã %s", cur.Format(c.MinOrder), c.Code)}
+	}
+	d := c.Amount
+	if c.Kind == couponPercent {
+		d = subtotal.Percent(c.Percent)
+	}
+	if d > subtotal {
+		d = subtotal
+	}
+	return d, nil
+}
+
+// couponColumns are the columns scanCoupon reads.
+const couponColumns = "id, code, kind, value, IFNULL(amount_minor,0), IFNULL(min_order_minor,0), IFNULL(max_uses,0), used_count, expires_at, active, created_at"
+
+// scanCoupon reads a row of couponColumns.
+func scanCoupon(s interface{ Scan(...interface{}) error }) (Coupon, error) {
+	var c Coupon
+	var value string
+	var expires, created interface{}
+	if err := s.Scan(&c.ID, &c.Code, &c.Kind, &value, &c.Amount, &c.MinOrder, &c.MaxUses, &c.UsedCount, &expires, &c.Active, &created); err != nil {
+		return c, err
+	}
+	if c.Kind == couponPercent {
+		c.Percent, _ = strconv.ParseFloat(value, 64)
+	}
+	c.ExpiresAt = parseDBTime(expires)
+	c.CreatedAt = formatDBTime(created)
+	return c, nil
+}
+
+// fetchCoupons returns all coupons, newest first.
+func fetchCoupons(db *sql.DB) ([]Coupon, error) {
+	if db == nil {
+		return DevGetCoupons(), nil
+	}
+	rows, err := db.Query("SELECT " + couponColumns + " FROM coupons WHERE shop_id = @shop_id ORDER BY id DESC")
+	if err != nil {
+		return nil, fmt.Errorf("query coupons: %w", err)
+	}
+	defer rows.Close()
+	var out []Coupon
+	for rows.Next() {
+		c, err := scanCoupon(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan coupon: %w", err)
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// findCoupon looks a coupon up by code (case-insensitive).
+func findCoupon(db *sql.DB, code string) (Coupon, bool, error) {
+	code = normalizeCouponCode(code)
+	if db == nil {
+		for _, c := range DevGetCoupons() {
+			if c.Code == code {
+				return c, true, nil
+			}
+		}
+		return Coupon{}, false, nil
+	}
+	c, err := scanCoupon(db.QueryRow("SELECT "+couponColumns+" FROM coupons WHERE code = ? AND shop_id = @shop_id", code))
+	if err == sql.ErrNoRows {
+		return Coupon{}, false, nil
+	} else if err != nil {
+		return Coupon{}, false, fmt.Errorf("find coupon: %w", err)
+	}
+	return c, true, nil
+}
+
+// couponDiscount resolves code and computes its discount on subtotal.
+// An empty code gives no discount.
+func couponDiscount(db *sql.DB, code string, subtotal Money) (Coupon, Money, error) {
+	if strings.TrimSpace(code) == "" {
+		return Coupon{}, 0, nil
+	}
+	c, ok, err := findCoupon(db, code)
+	if err != nil {
+		return c, 0, err
+	}
+	if !ok {
+		return c, 0, orderError{"mã giảm giá không hợp lệ"}
+	}
+	d, err := c.discountFor(subtotal, time.Now(), shopCurrency(db))
+	return c, d, err
+}
+
+// useCouponTx counts one use of a coupon inside tx, failing when the limit is reached.
+func useCouponTx(tx *sql.Tx, id int64) error {
+	res, err := tx.Exec("UPDATE coupons SET used_count = used_count + 1 WHERE id = ? AND shop_id = @shop_id AND (max_uses IS NULL OR max_uses = 0 OR used_count < max_uses)", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errCouponUsedUp
+	}
+	return nil
+}
+
+// couponPayload is the admin JSON body for creating or updating a coupon. Amounts
+// are in minor units, like everywhere else in the API.
+type couponPayload struct {
+	Code      *string  `json:"code"`
+	Kind      *string  `json:"kind"`
+	Percent   *float64 `json:"percent"`
+	Amount    *Money   `json:"amount"`
+	MinOrder  *Money   `json:"min_order"`
+	MaxUses   *int     `json:"max_uses"`
+	ExpiresAt *string  `json:"expires_at"` // RFC 3339 or date; "" clears
+	Active    *bool    `json:"active"`
+}
+
+// apply copies the provided fields onto c and validates the result.
+func (p couponPayload) apply(c *Coupon, existing []Coupon) error {
+	if p.Code != nil {
+		c.Code = normalizeCouponCode(*p.Code)
+	}
+	if p.Kind != nil {
+		c.Kind = strings.ToLower(strings.TrimSpace(*p.Kind))
+	}
+	if p.Percent != nil {
+		c.Percent = *p.Percent
+	}
+	if p.Amount != nil {
+		c.Amount = *p.Amount
+	}
+	if p.MinOrder != nil {
+		c.MinOrder = *p.MinOrder
+	}
+	if p.MaxUses != nil {
+		c.MaxUses = *p.MaxUses
+	}
+	if p.ExpiresAt != nil {
+		t, err := parseFormTime(*p.ExpiresAt)
+		if err != nil {
+			return err
+		}
+		c.ExpiresAt = t
+	}
+	if p.Active != nil {
+		c.Active = *p.Active
+	}
+	if c.Code == "" || len(c.Code) > 64 || strings.ContainsAny(c.Code, " \t") {
+		return errors.New("code required (no spaces, at most 64 characters)")
+	}
+	for _, other := range existing {
+		if other.Code == c.Code && other.ID != c.ID {
+			return errors.New("code already exists")
+		}
+	}
+	switch c.Kind {
+	case couponPercent:
+		if !(c.Percent > 0 && c.Percent <= 100) {
+			return errors.New("percent must be between 0 and 100")
+		}
+		c.Amount = 0
+	case couponFixed:
+		if c.Amount <= 0 || c.Amount > maxMoney {
+			return errors.New("amount must be positive")
+		}
+		c.Percent = 0
+	default:
+		return errors.New("kind must be percent or fixed")
+	}
+	if c.MinOrder < 0 || c.MinOrder > maxMoney || c.MaxUses < 0 {
+		return errors.New("min_order and max_uses must not be negative")
+	}
+	return nil
+}
+
+// saveCoupon inserts (ID 0) or updates a coupon and returns it.
+func saveCoupon(db *sql.DB, c Coupon) (Coupon, error) {
+	if db == nil {
+		if c.ID == 0 {
+			return DevAddCoupon(c), nil
+		}
+		if !DevUpdateCoupon(c) {
+			return c, sql.ErrNoRows
+		}
+		return c, nil
+	}
+	maxUses := sqlNullInt(nil)
+	if c.MaxUses > 0 {
+		maxUses = c.MaxUses
+	}
+	if c.ID == 0 {
+		res, err := db.Exec("INSERT INTO coupons (shop_id, code, kind, value, amount_minor, min_order_minor, max_uses, expires_at, active) VALUES (@shop_id, ?, ?, ?, ?, ?, ?, ?, ?)",
+			c.Code, c.Kind, c.Percent, sqlNullMoney(c.Amount), int64(c.MinOrder), maxUses, sqlNullTime(c.ExpiresAt), c.Active)
+		if err != nil {
+			return c, err
+		}
+		c.ID, _ = res.LastInsertId()
+		return c, nil
+	}
+	_, err := db.Exec("UPDATE coupons SET code=?, kind=?, value=?, amount_minor=?, min_order_minor=?, max_uses=?, expires_at=?, active=? WHERE id=? AND shop_id = @shop_id",
+		c.Code, c.Kind, c.Percent, sqlNullMoney(c.Amount), int64(c.MinOrder), maxUses, sqlNullTime(c.ExpiresAt), c.Active, c.ID)
+	return c, err
+}
+
+// couponsHandler serves admin GET (list) and POST (create) on /api/coupons.
+func couponsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		coupons, err := fetchCoupons(db)
+		if err != nil {
+			log.Println("fetchCoupons error:", err)
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			if coupons == nil {
+				coupons = []Coupon{}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(coupons)
+
+		case http.MethodPost:
+			var payload couponPayload
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(&payload); err != nil {
+				http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			c := Coupon{Active: true}
+			if err := payload.apply(&c, coupons); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			c, err := saveCoupon(db, c)
+			if err != nil {
+				log.Println("create coupon error:", err)
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(c)
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// couponItemHandler serves admin GET, PUT and DELETE on /api/coupons/{id}.
+func couponItemHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/api/coupons/"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		coupons, err := fetchCoupons(db)
+		if err != nil {
+			log.Println("fetchCoupons error:", err)
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		var cur Coupon
+		found := false
+		for _, c := range coupons {
+			if c.ID == id {
+				cur, found = c, true
+			}
+		}
+		if !found {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(cur)
+
+		case http.MethodPut:
+			var payload couponPayload
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(&payload); err != nil {
+				http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := payload.apply(&cur, coupons); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if _, err := saveCoupon(db, cur); err != nil {
+				log.Println("update coupon error:", err)
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(cur)
+
+		case http.MethodDelete:
+			if db == nil {
+				DevDeleteCoupon(id)
+			} else if _, err := db.Exec("DELETE FROM coupons WHERE id = ? AND shop_id = @shop_id", id); err != nil {
+				log.Println("delete coupon error:", err)
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useDevCoupons gives one test its own in-memory coupons besides the products.
+func useDevCoupons(t *testing.T, products []Product, coupons []Coupon) {
+	t.Helper()
+	useDevOrders(t, products, nil)
+	devMu.Lock()
+	saved, savedNext := devCoupons, devNextCouponID
+	devCoupons, devNextCouponID = coupons, 100
+	devMu.Unlock()
+	t.Cleanup(func() {
+		devMu.Lock()
+		devCoupons, devNextCouponID = saved, savedNext
+		devMu.Unlock()
+	})
+}
+
+func TestFindCoupon(t *testing.T) {
+	useDevCoupons(t, nil, []Coupon{{ID: 1, Code: "SALE10", Kind: couponPercent, Percent: 10, Active: true}})
+	for _, code := range []string{"SALE10", " sale10 "} {
+		if c, ok, err := findCoupon(nil, code); err != nil || !ok || c.ID != 1 {
+			t.Errorf("findCoupon(%q) = %+v, %v, %v", code, c, ok, err)
+		}
+	}
+	if _, ok, _ := findCoupon(nil, "SALE"); ok {
+		t.Error("findCoupon matched a prefix")
+	}
+}
+
+func TestCancelReleasesRenamedCoupon(t *testing.T) {
+	useDevCoupons(t,
+		[]Product{{ID: 1, Title: "Áo", Price: 100000, Status: statusPublished}},
+		[]Coupon{{ID: 1, Code: "SALE10", Kind: couponPercent, Percent: 10, Active: true, MaxUses: 1}},
+	)
+	o, err := createOrder(nil, orderInput{CustomerName: "Lan", Phone: "0912345678", Address: "1 Lê Lợi", ProductID: 1, CouponCode: "sale10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.CouponID != 1 || DevGetCoupons()[0].UsedCount != 1 {
+		t.Fatalf("order coupon_id = %d, used = %d", o.CouponID, DevGetCoupons()[0].UsedCount)
+	}
+	renamed := DevGetCoupons()[0]
+	renamed.Code = "SUMMER"
+	DevUpdateCoupon(renamed)
+	if _, err := transitionOrder(nil, o.ID, orderCancelled, nil); err != nil {
+		t.Fatal(err)
+	}
+	if c := DevGetCoupons()[0]; c.UsedCount != 0 {
+		t.Errorf("used_count after cancel = %d, want 0", c.UsedCount)
+	}
+}
+
+func TestAdminBodyLimits(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "t")
+	useDevCoupons(t, nil, []Coupon{{ID: 1, Code: "SALE10", Kind: couponPercent, Percent: 10, Active: true}})
+	pad := strings.Repeat("x", 80<<10)
+	coupon := `{"code":"BIG","kind":"percent","percent":5,"pad":"` + pad + `"}`
+	block := `{"type":"text","text":"hello","pad":"` + pad + `"}`
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		path    string
+		body    string
+	}{
+		{"create coupon", couponsHandler(nil), http.MethodPost, "/api/coupons", coupon},
+		{"update coupon", couponItemHandler(nil), http.MethodPut, "/api/coupons/1", coupon},
+		{"create block", profileBlocksHandler(nil), http.MethodPost, "/api/profile/blocks", block},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		r.Header.Set("X-Admin-Token", "t")
+		w := httptest.NewRecorder()
+		tt.handler(w, r)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "too large") {
+			t.Errorf("%s with an oversized body = %d %q, want 400 request body too large", tt.name, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestDiscountFor(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+	vnd := currencies["VND"]
+	tests := []struct {
+		name     string
+		c        Coupon
+		subtotal Money
+		want     Money
+		wantErr  bool
+	}{
+		{"percent", Coupon{Kind: couponPercent, Percent: 10, Active: true}, 250000, 25000, false},
+		{"percent rounds", Coupon{Kind: couponPercent, Percent: 15, Active: true}, 99999, 15000, false},
+		{"fixed", Coupon{Kind: couponFixed, Amount: 30000, Active: true}, 250000, 30000, false},
+		{"fixed capped at subtotal", Coupon{Kind: couponFixed, Amount: 30000, Active: true}, 20000, 20000, false},
+		{"min order met", Coupon{Kind: couponFixed, Amount: 10000, MinOrder: 100000, Active: true}, 100000, 10000, false},
+		{"min order not met", Coupon{Kind: couponFixed, Amount: 10000, MinOrder: 100000, Active: true}, 99999, 0, true},
+		{"inactive", Coupon{Kind: couponPercent, Percent: 10}, 250000, 0, true},
+		{"expired", Coupon{Kind: couponPercent, Percent: 10, Active: true, ExpiresAt: &past}, 250000, 0, true},
+		{"expires later", Coupon{Kind: couponPercent, Percent: 10, Active: true, ExpiresAt: &future}, 250000, 25000, false},
+		{"used up", Coupon{Kind: couponPercent, Percent: 10, Active: true, MaxUses: 3, UsedCount: 3}, 250000, 0, true},
+		{"uses left", Coupon{Kind: couponPercent, Percent: 10, Active: true, MaxUses: 3, UsedCount: 2}, 250000, 25000, false},
+	}
+	for _, tt := range tests {
+		got, err := tt.c.discountFor(tt.subtotal, now, vnd)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("%s: discountFor(%d) = %d, %v; want %d, wantErr %v", tt.name, tt.subtotal, got, err, tt.want, tt.wantErr)
+		}
+	}
+	if _, err := (Coupon{Kind: couponPercent, Percent: 10, Active: true, MaxUses: 1, UsedCount: 1}).discountFor(1, now, vnd); err != errCouponUsedUp {
+		t.Errorf("used up coupon err = %v, want errCouponUsedUp", err)
+	}
+}
+
+func TestApplyPricing(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	before, after := now.Add(-time.Hour), now.Add(time.Hour)
+	price := func(m Money) *Money { return &m }
+	tests := []struct {
+		name       string
+		sale       *Money
+		starts     *time.Time
+		ends       *time.Time
+		want       Money
+		wantOnSale bool
+	}{
+		{"no sale", nil, nil, nil, 100000, false},
+		{"open ended sale", price(80000), nil, nil, 80000, true},
+		{"inside window", price(80000), &before, &after, 80000, true},
+		{"not started", price(80000), &after, nil, 100000, false},
+		{"ended", price(80000), nil, &before, 100000, false},
+		{"ends now", price(80000), nil, &now, 100000, false},
+		{"starts now", price(80000), &now, nil, 80000, true},
+		{"sale not below price", price(100000), nil, nil, 100000, false},
+	}
+	for _, tt := range tests {
+		p := Product{Price: 100000, SalePrice: tt.sale, SaleStartsAt: tt.starts, SaleEndsAt: tt.ends}
+		p.applyPricing(now, currencies["VND"])
+		if p.EffectivePrice != tt.want || p.OnSale != tt.wantOnSale {
+			t.Errorf("%s: effective price = %d, on sale = %v; want %d, %v", tt.name, p.EffectivePrice, p.OnSale, tt.want, tt.wantOnSale)
+		}
+	}
+}
+
+func TestSaleInput(t *testing.T) {
+	usd := currencies["USD"]
+	in, err := parseSaleInput(map[string][]string{"sale_price": {"12.50"}, "sale_ends_at": {"2025-06-30T00:00:00Z"}}, usd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	starts := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	p := Product{Price: 2000, SaleStartsAt: &starts}
+	if err := in.apply(&p); err != nil {
+		t.Fatal(err)
+	}
+	if p.SalePrice == nil || *p.SalePrice != 1250 || p.SaleStartsAt != &starts || p.SaleEndsAt == nil {
+		t.Errorf("after apply: sale = %v, starts = %v, ends = %v", p.SalePrice, p.SaleStartsAt, p.SaleEndsAt)
+	}
+
+	// a blank sale price clears the sale; other fields stay
+	in, _ = parseSaleInput(map[string][]string{"sale_price": {""}}, usd)
+	if err := in.apply(&p); err != nil || p.SalePrice != nil || p.SaleEndsAt == nil {
+		t.Errorf("clearing sale_price: err = %v, sale = %v, ends = %v", err, p.SalePrice, p.SaleEndsAt)
+	}
+
+	in, _ = parseSaleInput(map[string][]string{"sale_ends_at": {"2025-05-01"}}, usd)
+	if err := in.apply(&p); err == nil {
+		t.Error("sale ending before it starts was accepted")
+	}
+	if _, err := parseSaleInput(map[string][]string{"sale_price": {"abc"}}, usd); err == nil {
+		t.Error("invalid sale_price was accepted")
+	}
+}
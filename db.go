@@ -69,16 +69,6 @@ func ensureTable(db *sql.DB) error {
 		return err
 	}
 
-	// seed default categories only in DEV_MODE. In production we avoid auto-creating categories
-	// so that admin deletions are permanent and categories are managed explicitly.
-	if os.Getenv("DEV_MODE") == "true" {
-		if _, err := db.Exec(`INSERT INTO categories (name)
-		SELECT * FROM (SELECT 'Quần áo' UNION SELECT 'Đầm' UNION SELECT 'Giày dép') AS defaults
-		WHERE NOT EXISTS (SELECT 1 FROM categories)`); err != nil {
-			return err
-		}
-	}
-
 	// ensure category_id column exists (in case table was created earlier without)
 	if _, err := db.Exec(`ALTER TABLE products ADD COLUMN IF NOT EXISTS category_id BIGINT NULL`); err == nil {
 		_, _ = db.Exec(`ALTER TABLE products ADD INDEX IF NOT EXISTS idx_products_category (category_id)`)
@@ -99,5 +89,393 @@ func ensureTable(db *sql.DB) error {
 		return err
 	}
 
+	// explicit product source (own stock vs Shopee affiliate), migrated from the legacy tag column
+	if _, err := db.Exec(`ALTER TABLE products ADD COLUMN IF NOT EXISTS source VARCHAR(16) NULL`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`UPDATE products SET source = IF(tag = 'shopee', 'shopee', 'mychoice') WHERE source IS NULL`); err != nil {
+		return err
+	}
+
+	// product status (published, draft, sold_out)
+	if _, err := db.Exec(`ALTER TABLE products ADD COLUMN IF NOT EXISTS status VARCHAR(16) DEFAULT 'published'`); err != nil {
+		return err
+	}
+
+	// external key for CSV import upserts
+	if _, err := db.Exec(`ALTER TABLE products ADD COLUMN IF NOT EXISTS external_key VARCHAR(191) NULL`); err != nil {
+		return err
+	}
+
+	// manual ordering and pinning of products on the storefront grid
+	if _, err := db.Exec(`ALTER TABLE products ADD COLUMN IF NOT EXISTS position INT DEFAULT 0`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`ALTER TABLE products ADD COLUMN IF NOT EXISTS pinned TINYINT(1) DEFAULT 0`); err != nil {
+		return err
+	}
+
+	// stock on hand; NULL means the product's stock is not tracked
+	if _, err := db.Exec(`ALTER TABLE products ADD COLUMN IF NOT EXISTS stock INT NULL`); err != nil {
+		return err
+	}
+
+	// shipping weight of one unit in grams; NULL means unknown
+	if _, err := db.Exec(`ALTER TABLE products ADD COLUMN IF NOT EXISTS weight_grams INT NULL`); err != nil {
+		return err
+	}
+	// updated_at is maintained by the database on every UPDATE; NULL until the first change
+	if _, err := db.Exec(`ALTER TABLE products ADD COLUMN IF NOT EXISTS updated_at DATETIME NULL ON UPDATE CURRENT_TIMESTAMP`); err != nil {
+		return err
+	}
+
+	// optional sale price with time window
+	if _, err := db.Exec(`ALTER TABLE products ADD COLUMN IF NOT EXISTS sale_price DECIMAL(10,2) NULL`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`ALTER TABLE products ADD COLUMN IF NOT EXISTS sale_starts_at DATETIME NULL`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`ALTER TABLE products ADD COLUMN IF NOT EXISTS sale_ends_at DATETIME NULL`); err != nil {
+		return err
+	}
+
+	// collections (many-to-many with products)
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS collections (
+		id BIGINT AUTO_INCREMENT PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		slug VARCHAR(255) NOT NULL UNIQUE,
+		position INT DEFAULT 0
+	)`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS product_collections (
+		product_id BIGINT NOT NULL,
+		collection_id BIGINT NOT NULL,
+		PRIMARY KEY (product_id, collection_id),
+		INDEX idx_product_collections_collection (collection_id)
+	)`); err != nil {
+		return err
+	}
+
+	// order requests from the storefront and their lines
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS orders (
+		id BIGINT AUTO_INCREMENT PRIMARY KEY,
+		customer_name VARCHAR(255) NOT NULL,
+		phone VARCHAR(32) NOT NULL,
+		address TEXT NOT NULL,
+		note TEXT,
+		status VARCHAR(16) NOT NULL DEFAULT 'new',
+		admin_note TEXT,
+		total DECIMAL(12,2) DEFAULT 0.00,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+		INDEX idx_orders_status (status)
+	)`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS order_items (
+		id BIGINT AUTO_INCREMENT PRIMARY KEY,
+		order_id BIGINT NOT NULL,
+		product_id BIGINT NOT NULL,
+		title VARCHAR(255) NOT NULL,
+		variant VARCHAR(255),
+		quantity INT NOT NULL,
+		unit_price DECIMAL(10,2) DEFAULT 0.00,
+		INDEX idx_order_items_order (order_id)
+	)`); err != nil {
+		return err
+	}
+
+	// anonymous shopping carts keyed by the cart_id cookie
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS carts (
+		id VARCHAR(64) PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		expires_at TIMESTAMP NOT NULL,
+		INDEX idx_carts_expires (expires_at)
+	)`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS cart_items (
+		id BIGINT AUTO_INCREMENT PRIMARY KEY,
+		cart_id VARCHAR(64) NOT NULL,
+		product_id BIGINT NOT NULL,
+		variant VARCHAR(255),
+		quantity INT NOT NULL,
+		unit_price DECIMAL(10,2) DEFAULT 0.00,
+		added_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		INDEX idx_cart_items_cart (cart_id)
+	)`); err != nil {
+		return err
+	}
+
+	// discount coupons; orders keep the code and amounts they were placed with
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS coupons (
+		id BIGINT AUTO_INCREMENT PRIMARY KEY,
+		code VARCHAR(64) NOT NULL UNIQUE,
+		kind VARCHAR(16) NOT NULL,
+		value DECIMAL(10,2) NOT NULL,
+		min_order DECIMAL(12,2) DEFAULT 0.00,
+		max_uses INT NULL,
+		used_count INT NOT NULL DEFAULT 0,
+		expires_at DATETIME NULL,
+		active TINYINT(1) DEFAULT 1,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`ALTER TABLE orders ADD COLUMN IF NOT EXISTS subtotal DECIMAL(12,2) DEFAULT 0.00`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`ALTER TABLE orders ADD COLUMN IF NOT EXISTS discount DECIMAL(12,2) DEFAULT 0.00`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`ALTER TABLE orders ADD COLUMN IF NOT EXISTS coupon_code VARCHAR(64) NULL`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`ALTER TABLE orders ADD COLUMN IF NOT EXISTS coupon_id BIGINT NULL`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`UPDATE orders SET subtotal = total WHERE subtotal = 0 AND total > 0`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`ALTER TABLE carts ADD COLUMN IF NOT EXISTS coupon_code VARCHAR(64) NULL`); err != nil {
+		return err
+	}
+
+	// outbound clicks recorded by the /go redirect; ip is anonymized before storing
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS click_events (
+		id BIGINT AUTO_INCREMENT PRIMARY KEY,
+		kind VARCHAR(16) NOT NULL,
+		target_id BIGINT NOT NULL,
+		referrer VARCHAR(512) NULL,
+		ua_class VARCHAR(16) NOT NULL,
+		ip VARCHAR(64) NULL,
+		created_at DATETIME NOT NULL,
+		INDEX idx_click_events_target (kind, target_id, created_at)
+	)`); err != nil {
+		return err
+	}
+
+	// product detail views reported by the storefront
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS view_events (
+		id BIGINT AUTO_INCREMENT PRIMARY KEY,
+		product_id BIGINT NOT NULL,
+		referrer VARCHAR(512) NULL,
+		ua_class VARCHAR(16) NOT NULL,
+		ip VARCHAR(64) NULL,
+		created_at DATETIME NOT NULL,
+		INDEX idx_view_events_created (created_at)
+	)`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_click_events_created ON click_events (created_at)`); err != nil {
+		return err
+	}
+
+	// daily rollup of views and clicks, rebuilt by the analytics job; day is YYYY-MM-DD shop time
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS analytics_daily (
+		day CHAR(10) NOT NULL,
+		metric VARCHAR(16) NOT NULL,
+		target_id BIGINT NOT NULL,
+		referrer_host VARCHAR(255) NOT NULL DEFAULT '',
+		ua_class VARCHAR(16) NOT NULL,
+		count INT NOT NULL,
+		PRIMARY KEY (day, metric, target_id, referrer_host, ua_class)
+	)`); err != nil {
+		return err
+	}
+
+	// affiliate query parameters appended to outbound Shopee links
+	if _, err := db.Exec(`ALTER TABLE profile ADD COLUMN IF NOT EXISTS shopee_affiliate_params VARCHAR(512) NULL`); err != nil {
+		return err
+	}
+
+	// last Shopee metadata sync per product; flag marks changes the admin should review
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS shopee_sync (
+		product_id BIGINT PRIMARY KEY,
+		synced_at DATETIME NULL,
+		upstream_price_minor BIGINT NULL,
+		previous_price_minor BIGINT NULL,
+		available TINYINT(1) NOT NULL DEFAULT 1,
+		flag VARCHAR(16) NULL,
+		error VARCHAR(512) NULL
+	)`); err != nil {
+		return err
+	}
+
+	// every product price change, newest last; drives the price history and "price dropped" badge
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS price_history (
+		id BIGINT AUTO_INCREMENT PRIMARY KEY,
+		product_id BIGINT NOT NULL,
+		old_price_minor BIGINT NOT NULL,
+		new_price_minor BIGINT NOT NULL,
+		source VARCHAR(16) NOT NULL,
+		changed_at DATETIME NOT NULL,
+		INDEX idx_price_history_product (product_id, changed_at)
+	)`); err != nil {
+		return err
+	}
+
+	// Linktree-style profile blocks: links, headers, text, videos and featured products
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS profile_blocks (
+		id BIGINT AUTO_INCREMENT PRIMARY KEY,
+		type VARCHAR(16) NOT NULL,
+		title VARCHAR(255) NULL,
+		body TEXT NULL,
+		url VARCHAR(1024) NULL,
+		product_id BIGINT NULL,
+		position INT NOT NULL DEFAULT 0,
+		visible TINYINT(1) NOT NULL DEFAULT 1,
+		starts_at DATETIME NULL,
+		ends_at DATETIME NULL,
+		created_at DATETIME NOT NULL
+	)`); err != nil {
+		return err
+	}
+
+	// shipping zones; provinces is a JSON array of province/city names
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS shipping_zones (
+		id BIGINT AUTO_INCREMENT PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		provinces TEXT,
+		kind VARCHAR(16) NOT NULL DEFAULT 'flat',
+		base_fee_minor BIGINT NOT NULL DEFAULT 0,
+		per_kg_fee_minor BIGINT NOT NULL DEFAULT 0,
+		included_grams INT NOT NULL DEFAULT 0,
+		free_over_minor BIGINT NULL,
+		position INT DEFAULT 0,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`); err != nil {
+		return err
+	}
+
+	// hierarchical categories: parent link, explicit display order and URL slug
+	if _, err := db.Exec(`ALTER TABLE categories ADD COLUMN IF NOT EXISTS parent_id BIGINT NULL`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`ALTER TABLE categories ADD COLUMN IF NOT EXISTS position INT DEFAULT 0`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`ALTER TABLE categories ADD COLUMN IF NOT EXISTS slug VARCHAR(255) NULL`); err != nil {
+		return err
+	}
+
+	// shops (tenants) and the shop_id column on every shop-owned table
+	if err := ensureShopColumns(db); err != nil {
+		return err
+	}
+
+	// seed default categories only in DEV_MODE. In production we avoid auto-creating categories
+	// so that admin deletions are permanent and categories are managed explicitly.
+	if os.Getenv("DEV_MODE") == "true" {
+		if _, err := db.Exec(`INSERT INTO categories (shop_id, name)
+		SELECT @shop_id, name FROM (SELECT 'Quần áo' AS name UNION SELECT 'Đầm' UNION SELECT 'Giày dép') AS defaults
+		WHERE NOT EXISTS (SELECT 1 FROM categories WHERE shop_id = @shop_id)`); err != nil {
+			return err
+		}
+	}
+
+	if err := ensureCategorySlugs(db); err != nil {
+		return err
+	}
+	_, _ = db.Exec(`ALTER TABLE categories ADD UNIQUE INDEX IF NOT EXISTS idx_categories_shop_slug (shop_id, slug)`)
+	_, _ = db.Exec(`ALTER TABLE categories ADD INDEX IF NOT EXISTS idx_categories_parent (parent_id)`)
+
+	return ensureMoneyColumns(db)
+}
+
+// shopTables lists every table whose rows belong to one shop.
+var shopTables = []string{
+	"images", "products", "profile", "categories", "socials", "collections", "product_collections",
+	"orders", "order_items", "carts", "cart_items", "coupons", "click_events", "view_events",
+	"analytics_daily", "shopee_sync", "price_history", "profile_blocks", "shipping_zones",
+}
+
+// ensureShopColumns creates the shops table with the default shop and adds
+// shop_id to every shop-owned table. Rows that existed before multi-shop
+// support belong to the default shop. The column default is dropped again
+// afterwards so that an INSERT which forgets shop_id fails instead of
+// silently landing in the default shop.
+func ensureShopColumns(db *sql.DB) error {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS shops (
+		id BIGINT AUTO_INCREMENT PRIMARY KEY,
+		username VARCHAR(64) NOT NULL UNIQUE,
+		name VARCHAR(255) NOT NULL,
+		admin_token_hash CHAR(64) NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`); err != nil {
+		return err
+	}
+	username := defaultShopUsername()
+	if _, err := db.Exec(`INSERT INTO shops (id, username, name)
+		SELECT ?, ?, IFNULL((SELECT display_name FROM profile WHERE id = 1), ?)
+		WHERE NOT EXISTS (SELECT 1 FROM shops WHERE id = ?)`, defaultShopID, username, username, defaultShopID); err != nil {
+		return err
+	}
+
+	// the profile was a single row with id 1; each shop now has its own, with id = shop id
+	if _, err := db.Exec(`ALTER TABLE profile MODIFY COLUMN id BIGINT NOT NULL`); err != nil {
+		return err
+	}
+	for _, table := range shopTables {
+		if _, err := db.Exec(`ALTER TABLE ` + table + ` ADD COLUMN IF NOT EXISTS shop_id BIGINT NOT NULL DEFAULT 1`); err != nil {
+			return err
+		}
+		if _, err := db.Exec(`ALTER TABLE ` + table + ` ALTER COLUMN shop_id DROP DEFAULT`); err != nil {
+			return err
+		}
+		_, _ = db.Exec(`ALTER TABLE ` + table + ` ADD INDEX IF NOT EXISTS idx_` + table + `_shop (shop_id)`)
+	}
+
+	// names, slugs, codes and import keys are unique within a shop, not globally
+	_, _ = db.Exec(`ALTER TABLE categories DROP INDEX IF EXISTS name`)
+	_, _ = db.Exec(`ALTER TABLE categories DROP INDEX IF EXISTS idx_categories_slug`)
+	_, _ = db.Exec(`ALTER TABLE categories ADD UNIQUE INDEX IF NOT EXISTS idx_categories_shop_name (shop_id, name)`)
+	_, _ = db.Exec(`ALTER TABLE collections DROP INDEX IF EXISTS slug`)
+	_, _ = db.Exec(`ALTER TABLE collections ADD UNIQUE INDEX IF NOT EXISTS idx_collections_shop_slug (shop_id, slug)`)
+	_, _ = db.Exec(`ALTER TABLE coupons DROP INDEX IF EXISTS code`)
+	_, _ = db.Exec(`ALTER TABLE coupons ADD UNIQUE INDEX IF NOT EXISTS idx_coupons_shop_code (shop_id, code)`)
+	_, _ = db.Exec(`ALTER TABLE products DROP INDEX IF EXISTS idx_products_external_key`)
+	_, _ = db.Exec(`ALTER TABLE products ADD UNIQUE INDEX IF NOT EXISTS idx_products_shop_external_key (shop_id, external_key)`)
 	return nil
 }
+
+// ensureMoneyColumns adds the integer minor-unit amount columns and the shop
+// currency, and fills the new columns from the legacy DECIMAL ones. Rows already
+// migrated keep their values, so this is safe to run on every start.
+func ensureMoneyColumns(db *sql.DB) error {
+	if _, err := db.Exec(`ALTER TABLE profile ADD COLUMN IF NOT EXISTS currency VARCHAR(3) DEFAULT 'VND'`); err != nil {
+		return err
+	}
+	columns := []struct{ table, column, legacy, where string }{
+		{"products", "price_minor", "price", ""},
+		{"products", "sale_price_minor", "sale_price", ""},
+		{"orders", "subtotal_minor", "subtotal", ""},
+		{"orders", "discount_minor", "discount", ""},
+		{"orders", "total_minor", "total", ""},
+		{"order_items", "unit_price_minor", "unit_price", ""},
+		{"cart_items", "unit_price_minor", "unit_price", ""},
+		{"coupons", "amount_minor", "value", " AND kind = 'fixed'"},
+		{"coupons", "min_order_minor", "min_order", ""},
+	}
+	unit := shopCurrency(db).unit()
+	for _, c := range columns {
+		if _, err := db.Exec(`ALTER TABLE ` + c.table + ` ADD COLUMN IF NOT EXISTS ` + c.column + ` BIGINT NULL`); err != nil {
+			return err
+		}
+		if _, err := db.Exec(`UPDATE `+c.table+` SET `+c.column+` = ROUND(`+c.legacy+` * ?) WHERE `+c.column+` IS NULL AND `+c.legacy+` IS NOT NULL`+c.where, unit); err != nil {
+			return err
+		}
+	}
+	// sale_price is nullable in the new column too; drop the legacy copy so that a
+	// sale cleared later is not filled back in on the next start
+	if _, err := db.Exec(`UPDATE products SET sale_price = NULL WHERE sale_price IS NOT NULL`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`ALTER TABLE orders ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NULL`); err != nil {
+		return err
+	}
+	_, err := db.Exec(`UPDATE orders o SET currency = (SELECT IFNULL(p.currency, 'VND') FROM profile p WHERE p.shop_id = o.shop_id) WHERE currency IS NULL`)
+	return err
+}
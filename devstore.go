@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// devStorePersister keeps the in-memory dev store in a JSON file (a ShopSnapshot,
+// the same format as data.json in backups). Writes go to a temp file that is then
+// renamed, so a crash never leaves a half-written store behind.
+type devStorePersister struct {
+	path string
+
+	mu   sync.Mutex
+	last []byte // last content written or loaded; unchanged stores are not rewritten
+}
+
+// seedDevStore fills the dev store from, in order of preference: the persisted store
+// file, a JSON fixture file, or a backup archive. Empty paths are skipped.
+func seedDevStore(p *devStorePersister, fixtureFile, backupFile string) {
+	if p != nil {
+		ok, err := p.load()
+		if err != nil {
+			log.Printf("warning: load DEV_STORE_FILE: %v", err)
+		}
+		if ok {
+			log.Printf("dev store loaded from %s", p.path)
+			return
+		}
+	}
+	if fixtureFile != "" {
+		if err := loadDevFixture(fixtureFile); err != nil {
+			log.Printf("warning: load DEV_FIXTURE_FILE: %v", err)
+		} else {
+			log.Printf("dev store seeded from fixture %s", fixtureFile)
+			return
+		}
+	}
+	if backupFile != "" {
+		if data, err := os.ReadFile(backupFile); err != nil {
+			log.Printf("warning: read DEV_SEED_BACKUP: %v", err)
+		} else if _, err := restoreArchive(nil, "", data); err != nil {
+			log.Printf("warning: restore DEV_SEED_BACKUP: %v", err)
+		} else {
+			log.Printf("dev store seeded from %s", backupFile)
+		}
+	}
+}
+
+// loadDevFixture replaces the dev store with a JSON snapshot file.
+func loadDevFixture(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var snap ShopSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	DevRestoreSnapshot(snap)
+	return nil
+}
+
+// load restores the store file if it exists. It returns false when there is no file yet.
+func (p *devStorePersister) load() (bool, error) {
+	data, err := os.ReadFile(p.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var snap ShopSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return false, fmt.Errorf("parse %s: %w", p.path, err)
+	}
+	DevRestoreSnapshot(snap)
+	p.mu.Lock()
+	p.last = data
+	p.mu.Unlock()
+	return true, nil
+}
+
+// flush writes the current dev store if it changed since the last write.
+func (p *devStorePersister) flush() error {
+	snap, err := loadSnapshot(nil)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if bytes.Equal(data, p.last) {
+		return nil
+	}
+	if err := writeFileAtomic(p.path, data); err != nil {
+		return err
+	}
+	p.last = data
+	return nil
+}
+
+// run flushes every interval until ctx is cancelled. The caller flushes once more on shutdown.
+func (p *devStorePersister) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		log.Printf("dev store persistence enabled, writing %s every %s", p.path, interval)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := p.flush(); err != nil {
+					log.Printf("dev store flush error: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// writeFileAtomic writes data to a temp file next to path and renames it into place.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// useDevSnapshot restores the dev store as it was before the test.
+func useDevSnapshot(t *testing.T) {
+	t.Helper()
+	saved, err := loadSnapshot(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// sections left nil are skipped by DevRestoreSnapshot, so restore empty ones too
+	if saved.Orders == nil {
+		saved.Orders = []Order{}
+	}
+	if saved.Coupons == nil {
+		saved.Coupons = []Coupon{}
+	}
+	if saved.ShippingZones == nil {
+		saved.ShippingZones = []ShippingZone{}
+	}
+	if saved.ProfileBlocks == nil {
+		saved.ProfileBlocks = []ProfileBlock{}
+	}
+	t.Cleanup(func() {
+		DevRestoreSnapshot(saved)
+		invalidateCatalogCache()
+	})
+}
+
+func writeSnapshotFile(t *testing.T, path string, snap ShopSnapshot) {
+	t.Helper()
+	data, err := json.Marshal(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func devProductTitles() []string {
+	var out []string
+	for _, p := range DevGetProducts() {
+		out = append(out, p.Title)
+	}
+	return out
+}
+
+func TestDevStoreFlushAndLoad(t *testing.T) {
+	useDevSnapshot(t)
+	DevRestoreSnapshot(ShopSnapshot{Products: []Product{{ID: 7, Title: "Áo thun", Price: 150000}}})
+	dir := t.TempDir()
+	p := &devStorePersister{path: filepath.Join(dir, "store.json")}
+
+	if err := p.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(p.path); err != nil {
+		t.Fatalf("first flush did not write the store: %v", err)
+	}
+	// nothing changed, so nothing is rewritten
+	if err := p.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(p.path); !os.IsNotExist(err) {
+		t.Errorf("unchanged store was rewritten (stat err = %v)", err)
+	}
+	DevAddCollection(Collection{Name: "Sale", Slug: "sale"})
+	if err := p.flush(); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("store dir has %d entries, want only the store file (no temp files)", len(entries))
+	}
+
+	DevRestoreSnapshot(ShopSnapshot{})
+	loaded := &devStorePersister{path: p.path}
+	if ok, err := loaded.load(); !ok || err != nil {
+		t.Fatalf("load = %v, %v", ok, err)
+	}
+	if titles := devProductTitles(); len(titles) != 1 || titles[0] != "Áo thun" {
+		t.Errorf("products after load = %q", titles)
+	}
+	if cols := DevGetCollections(); len(cols) != 1 || cols[0].Slug != "sale" {
+		t.Errorf("collections after load = %+v", cols)
+	}
+	// a freshly loaded store is not written back
+	if err := os.Remove(p.path); err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(p.path); !os.IsNotExist(err) {
+		t.Errorf("store was rewritten right after load (stat err = %v)", err)
+	}
+}
+
+func TestSeedDevStore(t *testing.T) {
+	useDevSnapshot(t)
+	dir := t.TempDir()
+	fixture := filepath.Join(dir, "fixture.json")
+	writeSnapshotFile(t, fixture, ShopSnapshot{Products: []Product{{ID: 1, Title: "fixture"}}})
+	stored := filepath.Join(dir, "store.json")
+	writeSnapshotFile(t, stored, ShopSnapshot{Products: []Product{{ID: 1, Title: "stored"}}})
+	broken := filepath.Join(dir, "broken.json")
+	if err := os.WriteFile(broken, []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, store, fixture string
+		want                 string
+	}{
+		{"store file wins", stored, fixture, "stored"},
+		{"no store file yet", filepath.Join(dir, "missing.json"), fixture, "fixture"},
+		{"unreadable store file", broken, fixture, "fixture"},
+		{"no persister", "", fixture, "fixture"},
+	}
+	for _, tt := range tests {
+		DevRestoreSnapshot(ShopSnapshot{})
+		var p *devStorePersister
+		if tt.store != "" {
+			p = &devStorePersister{path: tt.store}
+		}
+		seedDevStore(p, tt.fixture, "")
+		if titles := devProductTitles(); len(titles) != 1 || titles[0] != tt.want {
+			t.Errorf("%s: products = %q, want [%s]", tt.name, titles, tt.want)
+		}
+	}
+}
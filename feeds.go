@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Catalog feed formats served under /feeds/.
+const (
+	feedFacebookCSV = "facebook.csv" // Facebook / Instagram Shopping data feed (CSV)
+	feedFacebookXML = "facebook.xml" // Facebook data feed (RSS 2.0 with g: fields)
+	feedGoogleXML   = "google.xml"   // Google Merchant Center product feed (RSS 2.0)
+)
+
+// feedItem is one product as both feed formats describe it.
+type feedItem struct {
+	ID                 string
+	Title              string
+	Description        string
+	Availability       string // "in stock" or "out of stock"
+	Condition          string
+	Price              string // "199000 VND"
+	SalePrice          string
+	SalePriceEffective string // ISO 8601 interval
+	Link               string
+	ImageLink          string
+	Brand              string
+	ProductType        string // category path, e.g. "Quần áo > Áo khoác"
+	IdentifierExists   string // "no": second-hand and own-label items have no GTIN/MPN
+}
+
+// feedFilter narrows a feed to a category (and its subcategories) and/or a tag.
+type feedFilter struct {
+	categories map[int64]bool // nil = all
+	tag        string         // sourceMyChoice, sourceShopee or "" for both
+}
+
+// parseFeedFilter reads ?category=<id or slug>&tag=<mychoice|shopee>.
+func parseFeedFilter(r *http.Request, cats []Category) (feedFilter, error) {
+	var f feedFilter
+	q := r.URL.Query()
+	if ref := strings.TrimSpace(q.Get("category")); ref != "" {
+		c, ok := findCategory(cats, ref)
+		if !ok {
+			return f, errors.New("unknown category")
+		}
+		f.categories = categoryDescendants(cats, c.ID)
+	}
+	if tag := strings.ToLower(strings.TrimSpace(q.Get("tag"))); tag != "" {
+		if tag != sourceMyChoice && tag != sourceShopee {
+			return f, errors.New("tag must be mychoice or shopee")
+		}
+		f.tag = tag
+	}
+	return f, nil
+}
+
+// feedCondition is the condition reported for every item: FEED_CONDITION when it
+// is new, refurbished or used, otherwise new.
+func feedCondition() string {
+	switch v := strings.ToLower(os.Getenv("FEED_CONDITION")); v {
+	case "new", "refurbished", "used":
+		return v
+	}
+	return "new"
+}
+
+// buildFeedItems converts the public products matching f. Products without an
+// image or a price cannot be listed by either platform and are left out.
+func buildFeedItems(db *sql.DB, base string, f feedFilter, cats []Category) ([]feedItem, error) {
+	products, err := fetchProducts(db)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := fetchProfile(db)
+	if err != nil {
+		return nil, err
+	}
+	cur := shopCurrency(db)
+	condition := feedCondition()
+	var items []feedItem
+	for _, p := range products {
+		if p.Status == statusDraft || p.ImageURL == "" || p.Price <= 0 {
+			continue
+		}
+		if (f.categories != nil && !f.categories[p.CategoryID]) || (f.tag != "" && p.Source != f.tag) {
+			continue
+		}
+		it := feedItem{
+			ID:               strconv.FormatInt(p.ID, 10),
+			Title:            truncateRunes(p.Title, 150),
+			Description:      truncateRunes(p.Description, 5000),
+			Availability:     "in stock",
+			Condition:        condition,
+			Price:            cur.Plain(p.Price) + " " + cur.Code,
+			Link:             base + p.PageURL,
+			ImageLink:        p.ImageURL,
+			Brand:            profile.DisplayName,
+			ProductType:      categoryPath(cats, p.CategoryID),
+			IdentifierExists: "no",
+		}
+		if it.Description == "" {
+			it.Description = it.Title
+		}
+		if p.Status == statusSoldOut || (p.Stock != nil && *p.Stock <= 0) {
+			it.Availability = "out of stock"
+		}
+		if p.OnSale {
+			it.SalePrice = cur.Plain(p.EffectivePrice) + " " + cur.Code
+			if p.SaleEndsAt != nil {
+				start := time.Now()
+				if p.SaleStartsAt != nil {
+					start = *p.SaleStartsAt
+				}
+				it.SalePriceEffective = start.Format("2006-01-02T15:04-0700") + "/" + p.SaleEndsAt.Format("2006-01-02T15:04-0700")
+			}
+		}
+		items = append(items, it)
+	}
+	return items, nil
+}
+
+// feedCSVColumns follows the Facebook catalog data feed template.
+var feedCSVColumns = []string{"id", "title", "description", "availability", "condition", "price", "sale_price", "sale_price_effective_date", "link", "image_link", "brand", "product_type"}
+
+func renderFeedCSV(items []feedItem) ([]byte, error) {
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	_ = cw.Write(feedCSVColumns)
+	for _, it := range items {
+		_ = cw.Write([]string{it.ID, it.Title, it.Description, it.Availability, it.Condition, it.Price, it.SalePrice, it.SalePriceEffective, it.Link, it.ImageLink, it.Brand, it.ProductType})
+	}
+	cw.Flush()
+	return buf.Bytes(), cw.Error()
+}
+
+// rssFeedItem is an <item> with Google's g: product fields, which Facebook's XML
+// feed uses too.
+type rssFeedItem struct {
+	ID                 string `xml:"g:id"`
+	Title              string `xml:"g:title"`
+	Description        string `xml:"g:description"`
+	Link               string `xml:"g:link"`
+	ImageLink          string `xml:"g:image_link"`
+	Availability       string `xml:"g:availability"`
+	Condition          string `xml:"g:condition"`
+	Price              string `xml:"g:price"`
+	SalePrice          string `xml:"g:sale_price,omitempty"`
+	SalePriceEffective string `xml:"g:sale_price_effective_date,omitempty"`
+	Brand              string `xml:"g:brand,omitempty"`
+	ProductType        string `xml:"g:product_type,omitempty"`
+	IdentifierExists   string `xml:"g:identifier_exists,omitempty"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name `xml:"rss"`
+	Version string   `xml:"version,attr"`
+	NSG     string   `xml:"xmlns:g,attr"`
+	Channel struct {
+		Title       string        `xml:"title"`
+		Link        string        `xml:"link"`
+		Description string        `xml:"description"`
+		Items       []rssFeedItem `xml:"item"`
+	} `xml:"channel"`
+}
+
+// renderFeedRSS writes items as an RSS 2.0 product feed. Google spells
+// availability with underscores ("in_stock"); Facebook accepts both.
+func renderFeedRSS(items []feedItem, title, link string, google bool) ([]byte, error) {
+	feed := rssFeed{Version: "2.0", NSG: "http://base.google.com/ns/1.0"}
+	feed.Channel.Title, feed.Channel.Link, feed.Channel.Description = title, link, title
+	for _, it := range items {
+		ri := rssFeedItem{
+			ID: it.ID, Title: it.Title, Description: it.Description, Link: it.Link, ImageLink: it.ImageLink,
+			Availability: it.Availability, Condition: it.Condition, Price: it.Price, SalePrice: it.SalePrice,
+			SalePriceEffective: it.SalePriceEffective, Brand: it.Brand, ProductType: it.ProductType,
+		}
+		if google {
+			ri.Availability = strings.ReplaceAll(ri.Availability, " ", "_")
+			ri.IdentifierExists = it.IdentifierExists
+		}
+		feed.Channel.Items = append(feed.Channel.Items, ri)
+	}
+	return encodeXMLDoc(feed)
+}
+
+// catalogFeedHandler serves /feeds/{facebook.csv,facebook.xml,google.xml}
+// with optional ?category= and ?tag= filters. When FEED_TOKEN is set the feeds
+// require the shop's ?token= (shopFeedToken) so only the configured platforms
+// can fetch them.
+func catalogFeedHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if token := shopFeedToken(requestShop(r).ID); token != "" && subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		format := strings.TrimPrefix(r.URL.Path, "/feeds/")
+		var contentType string
+		switch format {
+		case feedFacebookCSV:
+			contentType = "text/csv; charset=utf-8"
+		case feedFacebookXML, feedGoogleXML:
+			contentType = "application/xml; charset=utf-8"
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		cats, err := fetchCategories(db)
+		if err != nil {
+			log.Println("feed categories error:", err)
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		filter, err := parseFeedFilter(r, cats)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		base := siteURL(r)
+		key := "feed " + format + " " + r.URL.Query().Get("category") + " " + filter.tag
+		body, err := renderedCatalog.cached(r, key, func() ([]byte, error) {
+			items, err := buildFeedItems(db, base, filter, cats)
+			if err != nil {
+				return nil, err
+			}
+			if format == feedFacebookCSV {
+				return renderFeedCSV(items)
+			}
+			profile, err := fetchProfile(db)
+			if err != nil {
+				return nil, err
+			}
+			return renderFeedRSS(items, profile.DisplayName, base+"/", format == feedGoogleXML)
+		})
+		if err != nil {
+			log.Println("feed error:", err)
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Cache-Control", "public, max-age=900")
+		_, _ = w.Write(body)
+	}
+}
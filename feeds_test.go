@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useDevFeedCatalog loads a small catalog for the feed tests: a sale item in a
+// subcategory, a Shopee item that is sold out, and products no feed can list.
+func useDevFeedCatalog(t *testing.T) {
+	t.Helper()
+	useDevSnapshot(t)
+	stock := 0
+	sale := Money(150000)
+	ends := time.Date(2030, 1, 31, 0, 0, 0, 0, analyticsTZ)
+	DevRestoreSnapshot(ShopSnapshot{
+		Profile: Profile{DisplayName: "Tram Shop", Currency: "VND"},
+		Categories: []Category{
+			{ID: 1, Name: "Quần áo", Slug: "quan-ao"},
+			{ID: 2, Name: "Áo khoác", Slug: "ao-khoac", ParentID: 1},
+			{ID: 3, Name: "Giày", Slug: "giay"},
+		},
+		Products: []Product{
+			{ID: 1, Title: "Áo khoác jean", Price: 200000, SalePrice: &sale, SaleEndsAt: &ends, ImageURL: "https://img.test/1.jpg", CategoryID: 2, Status: statusPublished, CreatedAt: "2025-01-01T00:00:00Z"},
+			{ID: 2, Title: "Giày thể thao", Description: "Size 40", Price: 500000, ImageURL: "https://img.test/2.jpg", CategoryID: 3, Source: sourceShopee, Status: statusSoldOut, CreatedAt: "2025-01-02T00:00:00Z"},
+			{ID: 3, Title: "Áo len", Price: 90000, ImageURL: "https://img.test/3.jpg", CategoryID: 1, Stock: &stock, Status: statusPublished, CreatedAt: "2025-01-03T00:00:00Z"},
+			{ID: 4, Title: "Bản nháp", Price: 100000, ImageURL: "https://img.test/4.jpg", Status: statusDraft, CreatedAt: "2025-01-04T00:00:00Z"},
+			{ID: 5, Title: "Không ảnh", Price: 100000, Status: statusPublished, CreatedAt: "2025-01-05T00:00:00Z"},
+			{ID: 6, Title: "Không giá", ImageURL: "https://img.test/6.jpg", Status: statusPublished, CreatedAt: "2025-01-06T00:00:00Z"},
+		},
+	})
+	invalidateCatalogCache()
+	t.Setenv("PUBLIC_BASE_URL", "https://tram.test")
+	t.Setenv("FEED_TOKEN", "")
+	t.Setenv("FEED_CONDITION", "")
+}
+
+func feedRequest(path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	catalogFeedHandler(nil)(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w
+}
+
+func TestParseFeedFilter(t *testing.T) {
+	tests := []struct {
+		query   string
+		cats    []int64
+		tag     string
+		wantErr bool
+	}{
+		{"", nil, "", false},
+		{"?category=quan-ao", []int64{1, 2, 3}, "", false},
+		{"?category=2&tag=Shopee", []int64{2, 3}, sourceShopee, false},
+		{"?category=giay", []int64{4}, "", false},
+		{"?category=tui-xach", nil, "", true},
+		{"?tag=lazada", nil, "", true},
+	}
+	for _, tt := range tests {
+		f, err := parseFeedFilter(httptest.NewRequest(http.MethodGet, "/feeds/google.xml"+tt.query, nil), testCategories)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseFeedFilter(%s) err = %v, wantErr %v", tt.query, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if len(f.categories) != len(tt.cats) || f.tag != tt.tag {
+			t.Errorf("parseFeedFilter(%s) = %v, %q; want %v, %q", tt.query, f.categories, f.tag, tt.cats, tt.tag)
+		}
+		for _, id := range tt.cats {
+			if !f.categories[id] {
+				t.Errorf("parseFeedFilter(%s) is missing category %d", tt.query, id)
+			}
+		}
+	}
+}
+
+func TestFacebookCSVFeed(t *testing.T) {
+	useDevFeedCatalog(t)
+	w := feedRequest("/feeds/facebook.csv")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("status = %d, content type = %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 4 || strings.Join(records[0], ",") != strings.Join(feedCSVColumns, ",") {
+		t.Fatalf("feed has %d rows (header %q), want a header and 3 products", len(records), records[0])
+	}
+	col := make(map[string]int)
+	for i, name := range records[0] {
+		col[name] = i
+	}
+	rows := make(map[string][]string)
+	for _, rec := range records[1:] {
+		rows[rec[col["id"]]] = rec
+	}
+	tests := []struct{ id, field, want string }{
+		{"1", "price", "200000 VND"},
+		{"1", "sale_price", "150000 VND"},
+		{"1", "product_type", "Quần áo > Áo khoác"},
+		{"1", "link", "https://tram.test/p/1-ao-khoac-jean"},
+		{"1", "description", "Áo khoác jean"},
+		{"1", "brand", "Tram Shop"},
+		{"1", "availability", "in stock"},
+		{"1", "condition", "new"},
+		{"2", "availability", "out of stock"},
+		{"2", "sale_price", ""},
+		{"3", "availability", "out of stock"},
+	}
+	for _, tt := range tests {
+		row, ok := rows[tt.id]
+		if !ok {
+			t.Errorf("product %s missing from the feed", tt.id)
+			continue
+		}
+		if got := row[col[tt.field]]; got != tt.want {
+			t.Errorf("product %s %s = %q, want %q", tt.id, tt.field, got, tt.want)
+		}
+	}
+	if eff := rows["1"][col["sale_price_effective_date"]]; !strings.HasSuffix(eff, "/2030-01-31T00:00+0700") {
+		t.Errorf("sale_price_effective_date = %q, want it to end 2030-01-31", eff)
+	}
+}
+
+func TestGoogleFeedAndFilters(t *testing.T) {
+	useDevFeedCatalog(t)
+	body := feedRequest("/feeds/google.xml").Body.String()
+	for _, want := range []string{`xmlns:g="http://base.google.com/ns/1.0"`, "<g:availability>in_stock</g:availability>", "<g:availability>out_of_stock</g:availability>", "<g:identifier_exists>no</g:identifier_exists>"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("google.xml is missing %s", want)
+		}
+	}
+	if fb := feedRequest("/feeds/facebook.xml").Body.String(); strings.Contains(fb, "identifier_exists") || !strings.Contains(fb, "<g:availability>in stock</g:availability>") {
+		t.Errorf("facebook.xml uses Google-only fields:\n%s", fb)
+	}
+
+	tests := []struct {
+		query string
+		ids   []string
+	}{
+		{"?category=quan-ao", []string{"1", "3"}},
+		{"?category=ao-khoac", []string{"1"}},
+		{"?tag=shopee", []string{"2"}},
+		{"?category=quan-ao&tag=shopee", nil},
+	}
+	for _, tt := range tests {
+		body := feedRequest("/feeds/google.xml" + tt.query).Body.String()
+		if n := strings.Count(body, "<item>"); n != len(tt.ids) {
+			t.Errorf("%s: %d items, want %d", tt.query, n, len(tt.ids))
+		}
+		for _, id := range tt.ids {
+			if !strings.Contains(body, "<g:id>"+id+"</g:id>") {
+				t.Errorf("%s: product %s missing", tt.query, id)
+			}
+		}
+	}
+}
+
+func TestCatalogFeedErrors(t *testing.T) {
+	useDevFeedCatalog(t)
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/feeds/tiktok.csv", http.StatusNotFound},
+		{"/feeds/google.xml?category=tui-xach", http.StatusBadRequest},
+		{"/feeds/google.xml?tag=lazada", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if w := feedRequest(tt.path); w.Code != tt.want {
+			t.Errorf("%s = %d, want %d", tt.path, w.Code, tt.want)
+		}
+	}
+
+	t.Setenv("FEED_TOKEN", "secret")
+	for path, want := range map[string]int{"/feeds/google.xml": http.StatusUnauthorized, "/feeds/google.xml?token=nope": http.StatusUnauthorized, "/feeds/google.xml?token=secret": http.StatusOK} {
+		if w := feedRequest(path); w.Code != want {
+			t.Errorf("with FEED_TOKEN %s = %d, want %d", path, w.Code, want)
+		}
+	}
+}
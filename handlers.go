@@ -2,8 +2,10 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"os"
@@ -17,25 +19,18 @@ import (
 
 // isAdmin checks the session cookie for a simple admin flag.
 func isAdmin(r *http.Request) bool {
-	c, err := r.Cookie("session")
-	if err != nil {
-		// no cookie, fallback to token header/query
-	} else if c.Value == "admin" {
-		return true
-	}
-	adminToken := os.Getenv("ADMIN_TOKEN")
-	if adminToken == "" {
-		return false
-	}
-	headerToken := r.Header.Get("X-Admin-Token")
-	if headerToken != "" && headerToken == adminToken {
-		return true
+	shop := requestShop(r)
+	// shops sharing a host may each have set a session cookie; any of them may match
+	session := shop.sessionValue()
+	for _, c := range r.Cookies() {
+		if c.Name == "session" && subtle.ConstantTimeCompare([]byte(c.Value), []byte(session)) == 1 {
+			return true
+		}
 	}
-	queryToken := r.URL.Query().Get("token")
-	if queryToken != "" && queryToken == adminToken {
+	if shop.checkAdminToken(r.Header.Get("X-Admin-Token")) {
 		return true
 	}
-	return false
+	return shop.checkAdminToken(r.URL.Query().Get("token"))
 }
 
 // loginHandler expects JSON {"username","password"} and sets a session cookie for admin.
@@ -53,11 +48,13 @@ func loginHandler() http.HandlerFunc {
 			http.Error(w, "invalid json", http.StatusBadRequest)
 			return
 		}
-		if cred.Username == "admin" && cred.Password == "admin123" {
+		// the built-in credentials only ever managed the default shop
+		shop := requestShop(r)
+		if shop.ID == defaultShopID && cred.Username == "admin" && cred.Password == "admin123" {
 			http.SetCookie(w, &http.Cookie{
 				Name:     "session",
-				Value:    "admin",
-				Path:     "/",
+				Value:    shop.sessionValue(),
+				Path:     shopCookiePath(r),
 				HttpOnly: true,
 				// In production set Secure: true and SameSite
 			})
@@ -70,7 +67,7 @@ func loginHandler() http.HandlerFunc {
 
 func logoutHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		http.SetCookie(w, &http.Cookie{Name: "session", Value: "", Path: "/", MaxAge: -1})
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "", Path: shopCookiePath(r), MaxAge: -1})
 		w.WriteHeader(http.StatusOK)
 	}
 }
@@ -79,74 +76,63 @@ func logoutHandler() http.HandlerFunc {
 func listProducts(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		log.Printf("listProducts called, method=%s, devMode=%t, remote=%s", r.Method, db == nil, r.RemoteAddr)
-		// support dev-mode with in-memory store when db is nil
-		if db == nil {
-			out := DevGetProducts()
-			w.Header().Set("Content-Type", "application/json")
-			_ = json.NewEncoder(w).Encode(out)
-			return
-		}
-
-		rows, err := db.Query(`SELECT p.id, p.title, p.description, p.price, p.image_url, IFNULL(p.image_public_id,''), IFNULL(p.external_url,''), IFNULL(p.tag,'mychoice'), IFNULL(p.category_id, 0), IFNULL(c.name,''), p.created_at
-			FROM products p
-			LEFT JOIN categories c ON c.id = p.category_id
-			ORDER BY p.id DESC`)
+		out, err := fetchProducts(db)
 		if err != nil {
-			log.Println("listProducts db.Query error:", err)
+			log.Println("listProducts error:", err)
 			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
-		defer rows.Close()
-		var out []Product
-		for rows.Next() {
-			var p Product
-			var priceStr string
-			var publicID sql.NullString
-			var external sql.NullString
-			var tagNull sql.NullString
-			var created interface{}
-			var catNull sql.NullInt64
-			if err := rows.Scan(&p.ID, &p.Title, &p.Description, &priceStr, &p.ImageURL, &publicID, &external, &tagNull, &catNull, &p.Category, &created); err != nil {
-				log.Println("listProducts rows.Scan error:", err)
-				http.Error(w, "db scan error: "+err.Error(), http.StatusInternalServerError)
-				return
-			}
-			// price comes as string from DECIMAL
-			p.Price, _ = strconv.ParseFloat(priceStr, 64)
-			// handle created_at which may be time.Time or []byte/string depending on driver
-			switch v := created.(type) {
-			case nil:
-				p.CreatedAt = ""
-			case string:
-				p.CreatedAt = v
-			case []byte:
-				p.CreatedAt = string(v)
-			case time.Time:
-				p.CreatedAt = v.Format(time.RFC3339)
-			default:
-				p.CreatedAt = ""
+		// drafts are only listed for admins, who may also filter by ?status=
+		if !isAdmin(r) {
+			out = visibleProducts(out)
+		} else if st := r.URL.Query().Get("status"); st != "" {
+			var kept []Product
+			for _, p := range out {
+				if p.Status == st {
+					kept = append(kept, p)
+				}
 			}
-			if catNull.Valid {
-				p.CategoryID = catNull.Int64
-			} else {
-				p.CategoryID = 0
+			out = kept
+		}
+		// ?category=<id|slug> filters by a category including its descendants
+		if ref := r.URL.Query().Get("category"); ref != "" {
+			cats, err := fetchCategories(db)
+			if err != nil {
+				log.Println("listProducts categories error:", err)
+				http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+				return
 			}
-			if external.Valid {
-				p.ExternalURL = external.String
-			} else {
-				p.ExternalURL = ""
+			c, ok := findCategory(cats, ref)
+			if !ok {
+				http.Error(w, "category not found", http.StatusNotFound)
+				return
 			}
-			if publicID.Valid {
-				p.ImagePublicID = publicID.String
-			} else {
-				p.ImagePublicID = ""
+			out = filterProductsByCategory(out, categoryDescendants(cats, c.ID))
+		}
+		// ?collection=<id|slug> filters by collection membership
+		if ref := r.URL.Query().Get("collection"); ref != "" {
+			cols, err := fetchCollections(db)
+			if err != nil {
+				log.Println("listProducts collections error:", err)
+				http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+				return
 			}
-			if tagNull.Valid && tagNull.String != "" {
-				p.Tag = tagNull.String
-			} else {
-				p.Tag = "mychoice"
+			c, ok := findCollection(cols, ref)
+			if !ok {
+				http.Error(w, "collection not found", http.StatusNotFound)
+				return
+			}
+			out = filterProductsByCollection(out, c.ID)
+		}
+		// ?source=mychoice|shopee
+		if src := strings.ToLower(r.URL.Query().Get("source")); src != "" {
+			var kept []Product
+			for _, p := range out {
+				if p.Source == src {
+					kept = append(kept, p)
+				}
 			}
-			out = append(out, p)
+			out = kept
 		}
 		w.Header().Set("Content-Type", "application/json")
 		_ = json.NewEncoder(w).Encode(out)
@@ -175,14 +161,85 @@ func createProduct(db *sql.DB, cloudURL string) http.HandlerFunc {
 		description := r.FormValue("description")
 		priceStr := r.FormValue("price")
 		categoryStr := r.FormValue("category_id")
+		// "source" is the explicit own-stock/Shopee field; "tag" is accepted from older clients
+		sourceVal := r.FormValue("source")
+		if sourceVal == "" {
+			sourceVal = r.FormValue("tag")
+		}
+		sourceVal = strings.TrimSpace(strings.ToLower(sourceVal))
 		externalStr := strings.TrimSpace(r.FormValue("external_url"))
-		tagVal := strings.TrimSpace(strings.ToLower(r.FormValue("tag")))
+		// if a link was provided but source not explicitly set, infer shopee
+		if sourceVal == "" && externalStr != "" {
+			sourceVal = sourceShopee
+		}
+		if sourceVal == sourceShopee {
+			// links are stored in canonical form (shopee.vn/product/<shop>/<item>); anything
+			// that is not a Shopee listing is rejected
+			norm, err := normalizeShopeeURL(externalStr)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if norm == "" {
+				http.Error(w, "external_url required for shopee source", http.StatusBadRequest)
+				return
+			}
+			externalStr = norm
+		} else {
+			// anything other than 'shopee' is own stock, which carries no link
+			sourceVal = sourceMyChoice
+			externalStr = ""
+		}
+		statusVal := strings.TrimSpace(strings.ToLower(r.FormValue("status")))
+		if statusVal == "" {
+			statusVal = statusPublished
+		}
+		if !validProductStatus(statusVal) {
+			http.Error(w, "invalid status", http.StatusBadRequest)
+			return
+		}
+		stock, serr := parseStock(r.FormValue("stock"))
+		if serr != nil {
+			http.Error(w, serr.Error(), http.StatusBadRequest)
+			return
+		}
+		weight, werr := parseWeight(r.FormValue("weight_grams"))
+		if werr != nil {
+			http.Error(w, werr.Error(), http.StatusBadRequest)
+			return
+		}
+		// prices are entered in major units of the shop currency; blank means 0 ("Liên hệ")
+		cur := shopCurrency(db)
+		var price Money
+		if strings.TrimSpace(priceStr) != "" {
+			p, err := cur.Parse(priceStr)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			price = p
+		}
+		var sale Product
+		if in, err := parseSaleInput(r.MultipartForm.Value, cur); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err := in.apply(&sale); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		if title == "" {
 			http.Error(w, "title required", http.StatusBadRequest)
 			return
 		}
-		price, _ := strconv.ParseFloat(priceStr, 64)
 		categoryID, _ := strconv.ParseInt(categoryStr, 10, 64)
+		collectionIDs, cerr := parseIDList(r.MultipartForm.Value["collection_ids"])
+		if cerr == nil {
+			cerr = validateCollectionIDs(db, collectionIDs)
+		}
+		if cerr != nil {
+			http.Error(w, cerr.Error(), http.StatusBadRequest)
+			return
+		}
 
 		file, _, err := r.FormFile("file")
 		var imageURL string
@@ -205,21 +262,12 @@ func createProduct(db *sql.DB, cloudURL string) http.HandlerFunc {
 			} else {
 				imageURL = ""
 			}
-			// if a link was provided but tag not explicitly set, infer shopee
-			if externalStr != "" && tagVal != "shopee" {
-				tagVal = "shopee"
-			}
-			// validate tag / external behavior in dev similarly: if shopee require external
-			if tagVal == "shopee" && externalStr == "" {
-				http.Error(w, "external_url required for shopee tag", http.StatusBadRequest)
-				return
-			}
-			if tagVal != "shopee" {
-				tagVal = "mychoice"
-				// clear external for mychoice
-				externalStr = ""
-			}
-			id := DevAddProduct(title, description, price, imageURL, categoryID, externalStr, tagVal)
+			id := DevAddProduct(title, description, price, imageURL, categoryID, externalStr, sourceVal)
+			DevSetProductCollections(id, collectionIDs)
+			DevModifyProduct(id, func(p *Product) {
+				p.Status, p.Stock, p.WeightGrams = statusVal, stock, weight
+				p.SalePrice, p.SaleStartsAt, p.SaleEndsAt = sale.SalePrice, sale.SaleStartsAt, sale.SaleEndsAt
+			})
 			w.Header().Set("Content-Type", "application/json")
 			_ = json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "image_url": imageURL})
 			return
@@ -227,7 +275,7 @@ func createProduct(db *sql.DB, cloudURL string) http.HandlerFunc {
 
 		if categoryID != 0 {
 			var name string
-			if err := db.QueryRow("SELECT name FROM categories WHERE id = ?", categoryID).Scan(&name); err != nil {
+			if err := db.QueryRow("SELECT name FROM categories WHERE id = ? AND shop_id = @shop_id", categoryID).Scan(&name); err != nil {
 				http.Error(w, "category not found", http.StatusBadRequest)
 				return
 			}
@@ -259,24 +307,23 @@ func createProduct(db *sql.DB, cloudURL string) http.HandlerFunc {
 			imageURL = ""
 		}
 
-		// if a link was provided but tag not explicitly set, infer shopee
-		if externalStr != "" && tagVal != "shopee" {
-			tagVal = "shopee"
-		}
-		// sanitize tag for DB: anything other than 'shopee' becomes 'mychoice'
-		if tagVal != "shopee" {
-			tagVal = "mychoice"
-			externalStr = ""
-		}
-		log.Printf("createProduct: title=%q tag=%q external=%q category=%d", title, tagVal, externalStr, categoryID)
-		res, err := db.Exec("INSERT INTO products (title, description, price, image_url, image_public_id, external_url, tag, category_id, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
-			title, description, FormatPrice(price), imageURL, sqlNullString(imagePublicID), sqlNullString(externalStr), tagVal, sqlNull(categoryID), time.Now())
+		log.Printf("createProduct: title=%q source=%q external=%q category=%d", title, sourceVal, externalStr, categoryID)
+		res, err := db.Exec("INSERT INTO products (shop_id, title, description, price_minor, image_url, image_public_id, external_url, source, status, stock, weight_grams, sale_price_minor, sale_starts_at, sale_ends_at, category_id, created_at) VALUES (@shop_id, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			title, description, int64(price), imageURL, sqlNullString(imagePublicID), sqlNullString(externalStr), sourceVal, statusVal, sqlNullInt(stock), sqlNullInt(weight),
+			sqlNullPrice(sale.SalePrice), sqlNullTime(sale.SaleStartsAt), sqlNullTime(sale.SaleEndsAt), sqlNull(categoryID), time.Now())
 		if err != nil {
 			log.Println("db insert error:", err)
 			http.Error(w, "db error", http.StatusInternalServerError)
 			return
 		}
 		id, _ := res.LastInsertId()
+		if len(collectionIDs) > 0 {
+			if err := setProductCollections(db, id, collectionIDs); err != nil {
+				log.Println("createProduct set collections error:", err)
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
+		}
 		w.Header().Set("Content-Type", "application/json")
 		_ = json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "image_url": imageURL})
 	}
@@ -297,71 +344,32 @@ func productItemHandler(db *sql.DB, cloudURL string) http.HandlerFunc {
 			http.Error(w, "invalid id", http.StatusBadRequest)
 			return
 		}
+		// sub-resources: /api/products/{id}/collections, /api/products/{id}/price-history
+		if len(parts) > 4 && parts[4] != "" {
+			switch parts[4] {
+			case "collections":
+				productCollectionsHandler(db, id)(w, r)
+			case "price-history":
+				priceHistoryHandler(db, id)(w, r)
+			default:
+				http.Error(w, "not found", http.StatusNotFound)
+			}
+			return
+		}
 
 		switch r.Method {
 		case http.MethodGet:
 			// get single product
-			if db == nil {
-				// dev
-				for _, p := range DevGetProducts() {
-					if p.ID == id {
-						w.Header().Set("Content-Type", "application/json")
-						_ = json.NewEncoder(w).Encode(p)
-						return
-					}
-				}
-				http.Error(w, "not found", http.StatusNotFound)
+			p, ok, err := fetchProduct(db, id)
+			if err != nil {
+				log.Println("productItem GET error:", err)
+				http.Error(w, "db error", http.StatusInternalServerError)
 				return
 			}
-			var p Product
-			var priceStr string
-			row := db.QueryRow(`SELECT p.id, p.title, p.description, p.price, p.image_url, IFNULL(p.image_public_id,''), IFNULL(p.external_url,''), IFNULL(p.tag,'mychoice'), IFNULL(p.category_id, 0), IFNULL(c.name,''), p.created_at
-				FROM products p LEFT JOIN categories c ON c.id = p.category_id WHERE p.id = ?`, id)
-			var created interface{}
-			var catNull sql.NullInt64
-			var external sql.NullString
-			var tagNull sql.NullString
-			var publicID sql.NullString
-			if err := row.Scan(&p.ID, &p.Title, &p.Description, &priceStr, &p.ImageURL, &publicID, &external, &tagNull, &catNull, &p.Category, &created); err != nil {
-				log.Println("productItem GET row.Scan error:", err)
+			if !ok || (p.Status == statusDraft && !isAdmin(r)) {
 				http.Error(w, "not found", http.StatusNotFound)
 				return
 			}
-			p.Price, _ = strconv.ParseFloat(priceStr, 64)
-			if catNull.Valid {
-				p.CategoryID = catNull.Int64
-			} else {
-				p.CategoryID = 0
-			}
-
-			if external.Valid {
-				p.ExternalURL = external.String
-			} else {
-				p.ExternalURL = ""
-			}
-			if publicID.Valid {
-				p.ImagePublicID = publicID.String
-			} else {
-				p.ImagePublicID = ""
-			}
-			if tagNull.Valid && tagNull.String != "" {
-				p.Tag = tagNull.String
-			} else {
-				p.Tag = "mychoice"
-			}
-
-			switch v := created.(type) {
-			case nil:
-				p.CreatedAt = ""
-			case string:
-				p.CreatedAt = v
-			case []byte:
-				p.CreatedAt = string(v)
-			case time.Time:
-				p.CreatedAt = v.Format(time.RFC3339)
-			default:
-				p.CreatedAt = ""
-			}
 			w.Header().Set("Content-Type", "application/json")
 			_ = json.NewEncoder(w).Encode(p)
 			return
@@ -383,7 +391,131 @@ func productItemHandler(db *sql.DB, cloudURL string) http.HandlerFunc {
 			priceVals, hasPrice := mf.Value["price"]
 			catVals, hasCat := mf.Value["category_id"]
 			externalVals, hasExternal := mf.Value["external_url"]
-			tagVals, hasTag := mf.Value["tag"]
+			sourceVals, hasSource := mf.Value["source"]
+			if !hasSource {
+				sourceVals, hasSource = mf.Value["tag"]
+			}
+			// a link is only checked when the product stays (or becomes) a Shopee one,
+			// going by the source sent or else the stored one; own-stock products
+			// carry no link, so one sent for them is dropped unchecked
+			if hasExternal && len(externalVals) > 0 {
+				source := ""
+				if hasSource && len(sourceVals) > 0 {
+					source = strings.TrimSpace(strings.ToLower(sourceVals[0]))
+				} else {
+					stored, found, err := fetchProduct(db, id)
+					if err != nil {
+						log.Println("productItem PUT load error:", err)
+						http.Error(w, "db error", http.StatusInternalServerError)
+						return
+					}
+					if !found {
+						http.Error(w, "not found", http.StatusNotFound)
+						return
+					}
+					source = stored.Source
+				}
+				if source == sourceShopee {
+					v, err := normalizeShopeeURL(externalVals[0])
+					if err != nil {
+						http.Error(w, err.Error(), http.StatusBadRequest)
+						return
+					}
+					externalVals = []string{v}
+				} else if hasSource {
+					externalVals = []string{""}
+				} else {
+					externalVals, hasExternal = nil, false
+				}
+			}
+			var statusPtr *string
+			if vals, ok := mf.Value["status"]; ok && len(vals) > 0 && strings.TrimSpace(vals[0]) != "" {
+				v := strings.TrimSpace(strings.ToLower(vals[0]))
+				if !validProductStatus(v) {
+					http.Error(w, "invalid status", http.StatusBadRequest)
+					return
+				}
+				statusPtr = &v
+			}
+			// storefront placement: pinned flag and manual position
+			var pinnedPtr *bool
+			var positionPtr *int
+			if vals, ok := mf.Value["pinned"]; ok && len(vals) > 0 {
+				v := parseFormBool(vals[0])
+				pinnedPtr = &v
+			}
+			if vals, ok := mf.Value["position"]; ok && len(vals) > 0 && strings.TrimSpace(vals[0]) != "" {
+				v, err := strconv.Atoi(strings.TrimSpace(vals[0]))
+				if err != nil {
+					http.Error(w, "invalid position", http.StatusBadRequest)
+					return
+				}
+				positionPtr = &v
+			}
+			var stockPtr *int
+			stockVals, hasStock := mf.Value["stock"]
+			if hasStock && len(stockVals) > 0 {
+				v, err := parseStock(stockVals[0])
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				stockPtr = v
+			}
+			var weightPtr *int
+			weightVals, hasWeight := mf.Value["weight_grams"]
+			if hasWeight && len(weightVals) > 0 {
+				v, err := parseWeight(weightVals[0])
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				weightPtr = v
+			}
+			var pricePtr *Money
+			if hasPrice && len(priceVals) > 0 {
+				v, err := parseOptionalPrice(priceVals[0], shopCurrency(db))
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				if v == nil {
+					v = new(Money)
+				}
+				pricePtr = v
+			}
+			sale, serr := parseSaleInput(mf.Value, shopCurrency(db))
+			if serr != nil {
+				http.Error(w, serr.Error(), http.StatusBadRequest)
+				return
+			}
+			var saleProduct Product
+			if sale.any() {
+				cur, found, err := fetchProduct(db, id)
+				if err != nil {
+					log.Println("productItem PUT load error:", err)
+					http.Error(w, "db error", http.StatusInternalServerError)
+					return
+				}
+				if !found {
+					http.Error(w, "not found", http.StatusNotFound)
+					return
+				}
+				saleProduct = cur
+				if err := sale.apply(&saleProduct); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			collectionVals, hasCollections := mf.Value["collection_ids"]
+			collectionIDs, cerr := parseIDList(collectionVals)
+			if cerr == nil {
+				cerr = validateCollectionIDs(db, collectionIDs)
+			}
+			if cerr != nil {
+				http.Error(w, cerr.Error(), http.StatusBadRequest)
+				return
+			}
 			// file presence
 			file, _, ferr := r.FormFile("file")
 			var imageURL string
@@ -407,17 +539,15 @@ func productItemHandler(db *sql.DB, cloudURL string) http.HandlerFunc {
 				newPrice := cur.Price
 				newCat := cur.CategoryID
 				newExternal := ""
-				newTag := ""
+				newSource := ""
 				if hasTitle && len(titleVals) > 0 {
 					newTitle = titleVals[0]
 				}
 				if hasDesc && len(descVals) > 0 {
 					newDesc = descVals[0]
 				}
-				if hasPrice && len(priceVals) > 0 {
-					if v, err := strconv.ParseFloat(priceVals[0], 64); err == nil {
-						newPrice = v
-					}
+				if pricePtr != nil {
+					newPrice = *pricePtr
 				}
 				if hasCat && len(catVals) > 0 {
 					if v, err := strconv.ParseInt(catVals[0], 10, 64); err == nil {
@@ -427,9 +557,9 @@ func productItemHandler(db *sql.DB, cloudURL string) http.HandlerFunc {
 				if hasExternal && len(externalVals) > 0 {
 					newExternal = externalVals[0]
 				}
-				// tag may be provided in multipart form
-				if vals, ok := mf.Value["tag"]; ok && len(vals) > 0 {
-					newTag = strings.TrimSpace(strings.ToLower(vals[0]))
+				// source may be provided in multipart form
+				if hasSource && len(sourceVals) > 0 {
+					newSource = strings.TrimSpace(strings.ToLower(sourceVals[0]))
 				}
 				if ferr == nil {
 					_ = file.Close()
@@ -443,17 +573,45 @@ func productItemHandler(db *sql.DB, cloudURL string) http.HandlerFunc {
 					}
 				}
 				// Dev store: external_url allowed, just update field if provided
-				ok := DevUpdateProduct(id, newTitle, newDesc, newPrice, imageURL, newCat, newExternal, newTag)
+				ok := DevUpdateProduct(id, newTitle, newDesc, newPrice, imageURL, newCat, newExternal, newSource)
 				// NOTE: DevUpdateProduct currently doesn't store external_url; it's fine for dev mode
 				if !ok {
 					http.Error(w, "not found", http.StatusNotFound)
 					return
 				}
+				_ = recordPriceChange(nil, nil, id, cur.Price, newPrice, priceSourceAdmin)
+				if hasCollections {
+					DevSetProductCollections(id, collectionIDs)
+				}
+				DevSetProductPlacement(id, pinnedPtr, positionPtr)
+				if statusPtr != nil {
+					DevModifyProduct(id, func(p *Product) { p.Status = *statusPtr })
+				}
+				if hasStock {
+					DevModifyProduct(id, func(p *Product) { p.Stock = stockPtr })
+				}
+				if hasWeight {
+					DevModifyProduct(id, func(p *Product) { p.WeightGrams = weightPtr })
+				}
+				if sale.any() {
+					DevModifyProduct(id, func(p *Product) {
+						p.SalePrice, p.SaleStartsAt, p.SaleEndsAt = saleProduct.SalePrice, saleProduct.SaleStartsAt, saleProduct.SaleEndsAt
+					})
+				}
 				w.WriteHeader(http.StatusOK)
 				return
 			}
 
 			// DB mode: build dynamic UPDATE using only provided fields
+			var exists int
+			if err := db.QueryRow("SELECT 1 FROM products WHERE id = ? AND shop_id = @shop_id", id).Scan(&exists); err == sql.ErrNoRows {
+				http.Error(w, "not found", http.StatusNotFound)
+				return
+			} else if err != nil {
+				log.Println("productItem PUT lookup error:", err)
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
 			// validate category if provided
 			var catID int64
 			var externalVal string
@@ -463,7 +621,7 @@ func productItemHandler(db *sql.DB, cloudURL string) http.HandlerFunc {
 				}
 				if catID != 0 {
 					var name string
-					if err := db.QueryRow("SELECT name FROM categories WHERE id = ?", catID).Scan(&name); err != nil {
+					if err := db.QueryRow("SELECT name FROM categories WHERE id = ? AND shop_id = @shop_id", catID).Scan(&name); err != nil {
 						http.Error(w, "category not found", http.StatusBadRequest)
 						return
 					}
@@ -472,9 +630,9 @@ func productItemHandler(db *sql.DB, cloudURL string) http.HandlerFunc {
 			if hasExternal && len(externalVals) > 0 {
 				externalVal = strings.TrimSpace(externalVals[0])
 			}
-			var tagVal string
-			if hasTag && len(tagVals) > 0 {
-				tagVal = strings.TrimSpace(strings.ToLower(tagVals[0]))
+			var sourceVal string
+			if hasSource && len(sourceVals) > 0 {
+				sourceVal = strings.TrimSpace(strings.ToLower(sourceVals[0]))
 			}
 
 			// handle file upload if present
@@ -509,11 +667,9 @@ func productItemHandler(db *sql.DB, cloudURL string) http.HandlerFunc {
 				setCols = append(setCols, "description = ?")
 				args = append(args, descVals[0])
 			}
-			if hasPrice && len(priceVals) > 0 {
-				if v, err := strconv.ParseFloat(priceVals[0], 64); err == nil {
-					setCols = append(setCols, "price = ?")
-					args = append(args, FormatPrice(v))
-				}
+			if pricePtr != nil {
+				setCols = append(setCols, "price_minor = ?")
+				args = append(args, int64(*pricePtr))
 			}
 			if ferr == nil && imageURL != "" {
 				setCols = append(setCols, "image_url = ?")
@@ -525,29 +681,29 @@ func productItemHandler(db *sql.DB, cloudURL string) http.HandlerFunc {
 				setCols = append(setCols, "external_url = ?")
 				args = append(args, sqlNullString(externalVal))
 			}
-			if hasTag {
+			if hasSource {
 				// enforce shopee requires external_url; mychoice should clear the link
-				if tagVal != "shopee" {
-					tagVal = "mychoice"
+				if sourceVal != sourceShopee {
+					sourceVal = sourceMyChoice
 				}
-				if tagVal == "shopee" {
+				if sourceVal == sourceShopee {
 					// if external is not provided in this request, ensure existing product has one
 					if !(hasExternal && externalVal != "") {
 						var curExt sql.NullString
-						if err := db.QueryRow("SELECT IFNULL(external_url,'') FROM products WHERE id = ?", id).Scan(&curExt); err != nil {
+						if err := db.QueryRow("SELECT IFNULL(external_url,'') FROM products WHERE id = ? AND shop_id = @shop_id", id).Scan(&curExt); err != nil {
 							log.Println("check existing external error:", err)
 							http.Error(w, "failed to validate external_url", http.StatusInternalServerError)
 							return
 						}
 						if curExt.String == "" {
-							http.Error(w, "external_url required when tag is shopee", http.StatusBadRequest)
+							http.Error(w, "external_url required when source is shopee", http.StatusBadRequest)
 							return
 						}
 					}
 				}
-				setCols = append(setCols, "tag = ?")
-				args = append(args, tagVal)
-				if tagVal == "mychoice" {
+				setCols = append(setCols, "source = ?")
+				args = append(args, sourceVal)
+				if sourceVal == sourceMyChoice {
 					// clear external_url when switching back to mychoice
 					setCols = append(setCols, "external_url = ?")
 					args = append(args, nil)
@@ -557,18 +713,49 @@ func productItemHandler(db *sql.DB, cloudURL string) http.HandlerFunc {
 				setCols = append(setCols, "category_id = ?")
 				args = append(args, sqlNull(catID))
 			}
-			if len(setCols) == 0 {
+			if statusPtr != nil {
+				setCols = append(setCols, "status = ?")
+				args = append(args, *statusPtr)
+			}
+			if pinnedPtr != nil {
+				setCols = append(setCols, "pinned = ?")
+				args = append(args, *pinnedPtr)
+			}
+			if positionPtr != nil {
+				setCols = append(setCols, "position = ?")
+				args = append(args, *positionPtr)
+			}
+			if hasStock {
+				setCols = append(setCols, "stock = ?")
+				args = append(args, sqlNullInt(stockPtr))
+			}
+			if hasWeight {
+				setCols = append(setCols, "weight_grams = ?")
+				args = append(args, sqlNullInt(weightPtr))
+			}
+			if sale.any() {
+				setCols = append(setCols, "sale_price_minor = ?", "sale_starts_at = ?", "sale_ends_at = ?")
+				args = append(args, sqlNullPrice(saleProduct.SalePrice), sqlNullTime(saleProduct.SaleStartsAt), sqlNullTime(saleProduct.SaleEndsAt))
+			}
+			if len(setCols) == 0 && !hasCollections {
 				http.Error(w, "no fields to update", http.StatusBadRequest)
 				return
 			}
-			// build query
-			query := "UPDATE products SET " + strings.Join(setCols, ", ") + " WHERE id = ?"
-			args = append(args, id)
-			_, err := db.Exec(query, args...)
-			if err != nil {
-				log.Println("db update error:", err)
-				http.Error(w, "db error", http.StatusInternalServerError)
-				return
+			if len(setCols) > 0 {
+				// the old price is read under a row lock so the history entry matches
+				// what this update replaced, and is written in the same transaction
+				if err := updateProductTx(db, id, setCols, args, pricePtr); err != nil {
+					log.Println("db update error:", err)
+					http.Error(w, "db error", http.StatusInternalServerError)
+					return
+				}
+			}
+			if hasCollections {
+				if err := setProductCollections(db, id, collectionIDs); err != nil {
+					log.Println("product collections update error:", err)
+					http.Error(w, "db error", http.StatusInternalServerError)
+					return
+				}
 			}
 			w.WriteHeader(http.StatusOK)
 			return
@@ -591,7 +778,7 @@ func productItemHandler(db *sql.DB, cloudURL string) http.HandlerFunc {
 			}
 			// Attempt to delete cloudinary image if present
 			var imgPublicID sql.NullString
-			if err := db.QueryRow("SELECT image_public_id FROM products WHERE id = ?", id).Scan(&imgPublicID); err != nil {
+			if err := db.QueryRow("SELECT image_public_id FROM products WHERE id = ? AND shop_id = @shop_id", id).Scan(&imgPublicID); err != nil {
 				log.Println("product DELETE select image_public_id error:", err)
 			} else {
 				if imgPublicID.Valid && imgPublicID.String != "" {
@@ -609,7 +796,10 @@ func productItemHandler(db *sql.DB, cloudURL string) http.HandlerFunc {
 				}
 			}
 
-			res, err := db.Exec("DELETE FROM products WHERE id=?", id)
+			if _, err := db.Exec("DELETE FROM product_collections WHERE product_id=? AND shop_id = @shop_id", id); err != nil {
+				log.Println("product DELETE unlink collections error:", err)
+			}
+			res, err := db.Exec("DELETE FROM products WHERE id=? AND shop_id = @shop_id", id)
 			if err != nil {
 				log.Println("db delete error:", err)
 				http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
@@ -631,30 +821,60 @@ func productItemHandler(db *sql.DB, cloudURL string) http.HandlerFunc {
 	}
 }
 
+// categoryPayload is the JSON body accepted by category POST/PUT. Nil fields are left unchanged on PUT.
+type categoryPayload struct {
+	Name     *string `json:"name"`
+	Slug     *string `json:"slug"`
+	ParentID *int64  `json:"parent_id"`
+	Position *int    `json:"position"`
+}
+
+// apply copies the provided fields onto c, validating name, slug uniqueness and parent.
+func (p categoryPayload) apply(c *Category, flat []Category) (string, bool) {
+	if p.Name != nil {
+		c.Name = strings.TrimSpace(*p.Name)
+	}
+	if c.Name == "" {
+		return "name required", false
+	}
+	if p.ParentID != nil {
+		c.ParentID = *p.ParentID
+	}
+	if err := validateCategoryParent(flat, c.ID, c.ParentID); err != nil {
+		return err.Error(), false
+	}
+	if p.Position != nil {
+		c.Position = *p.Position
+	}
+	if p.Slug != nil {
+		c.Slug = slugify(*p.Slug)
+		if c.Slug == "" {
+			return "invalid slug", false
+		}
+		if categorySlugs(flat, c.ID)[c.Slug] {
+			return "slug already in use", false
+		}
+	}
+	if c.Slug == "" {
+		c.Slug = uniqueSlug(slugify(c.Name), "danh-muc", categorySlugs(flat, c.ID))
+	}
+	return "", true
+}
+
+// categoriesHandler lists categories (GET, ?tree=1 for the nested tree) and creates them (POST, admin).
 func categoriesHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		log.Printf("categoriesHandler called method=%s remote=%s admin=%t dbNil=%t", r.Method, r.RemoteAddr, isAdmin(r), db == nil)
 		switch r.Method {
 		case http.MethodGet:
-			var cats []Category
-			if db == nil {
-				cats = DevGetCategories()
-			} else {
-				rows, err := db.Query("SELECT id, name FROM categories ORDER BY name ASC")
-				if err != nil {
-					log.Println("categoriesHandler db.Query error:", err)
-					http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
-					return
-				}
-				defer rows.Close()
-				for rows.Next() {
-					var c Category
-					if err := rows.Scan(&c.ID, &c.Name); err != nil {
-						http.Error(w, "scan error", http.StatusInternalServerError)
-						return
-					}
-					cats = append(cats, c)
-				}
+			cats, err := fetchCategories(db)
+			if err != nil {
+				log.Println("categoriesHandler fetch error:", err)
+				http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if v := r.URL.Query().Get("tree"); v == "1" || v == "true" {
+				cats = buildCategoryTree(cats)
 			}
 			w.Header().Set("Content-Type", "application/json")
 			_ = json.NewEncoder(w).Encode(cats)
@@ -666,33 +886,30 @@ func categoriesHandler(db *sql.DB) http.HandlerFunc {
 				http.Error(w, "unauthorized", http.StatusUnauthorized)
 				return
 			}
-			var payload struct {
-				Name string `json:"name"`
-			}
+			var payload categoryPayload
 			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 				http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
 				return
 			}
-			payload.Name = strings.TrimSpace(payload.Name)
-			if payload.Name == "" {
-				http.Error(w, "name required", http.StatusBadRequest)
+			flat, err := fetchCategories(db)
+			if err != nil {
+				log.Println("categories POST fetch error:", err)
+				http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
 				return
 			}
-			if db == nil {
-				c := DevAddCategory(payload.Name)
-				w.Header().Set("Content-Type", "application/json")
-				_ = json.NewEncoder(w).Encode(c)
+			var c Category
+			if msg, ok := payload.apply(&c, flat); !ok {
+				http.Error(w, msg, http.StatusBadRequest)
 				return
 			}
-			res, err := db.Exec("INSERT INTO categories (name) VALUES (?)", payload.Name)
+			c, err = insertCategory(db, c)
 			if err != nil {
-				log.Println("categories POST db.Exec error:", err)
+				log.Println("categories POST insert error:", err)
 				http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
 				return
 			}
-			id, _ := res.LastInsertId()
 			w.Header().Set("Content-Type", "application/json")
-			_ = json.NewEncoder(w).Encode(Category{ID: id, Name: payload.Name})
+			_ = json.NewEncoder(w).Encode(c)
 			return
 
 		default:
@@ -702,56 +919,71 @@ func categoriesHandler(db *sql.DB) http.HandlerFunc {
 	}
 }
 
+// categoryItemHandler handles GET (by id or slug, with subtree), PUT and DELETE for /api/categories/{id}
 func categoryItemHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		parts := strings.Split(r.URL.Path, "/")
-		if len(parts) < 4 {
+		if len(parts) < 4 || parts[3] == "" {
 			http.Error(w, "bad request", http.StatusBadRequest)
 			return
 		}
-		id, err := strconv.ParseInt(parts[3], 10, 64)
+		ref := parts[3]
+		log.Printf("categoryItemHandler called method=%s ref=%s remote=%s admin=%t dbNil=%t", r.Method, ref, r.RemoteAddr, isAdmin(r), db == nil)
+		flat, err := fetchCategories(db)
 		if err != nil {
-			http.Error(w, "invalid id", http.StatusBadRequest)
+			log.Println("categoryItemHandler fetch error:", err)
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
-		log.Printf("categoryItemHandler called method=%s id=%d remote=%s admin=%t dbNil=%t", r.Method, id, r.RemoteAddr, isAdmin(r), db == nil)
+		cur, found := findCategory(flat, ref)
 
 		switch r.Method {
+		case http.MethodGet:
+			if !found {
+				http.Error(w, "not found", http.StatusNotFound)
+				return
+			}
+			for _, node := range flattenTree(buildCategoryTree(flat)) {
+				if node.ID == cur.ID {
+					cur = node
+					break
+				}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(cur)
+			return
+
 		case http.MethodPut:
 			if !isAdmin(r) {
 				http.Error(w, "unauthorized", http.StatusUnauthorized)
 				return
 			}
-			var payload struct {
-				Name string `json:"name"`
+			if !found {
+				http.Error(w, "not found", http.StatusNotFound)
+				return
 			}
+			var payload categoryPayload
 			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 				http.Error(w, "invalid json", http.StatusBadRequest)
 				return
 			}
-			payload.Name = strings.TrimSpace(payload.Name)
-			if payload.Name == "" {
-				http.Error(w, "name required", http.StatusBadRequest)
+			if msg, ok := payload.apply(&cur, flat); !ok {
+				http.Error(w, msg, http.StatusBadRequest)
 				return
 			}
 			if db == nil {
-				if !DevUpdateCategory(id, payload.Name) {
+				if !DevUpdateCategory(cur) {
 					http.Error(w, "not found", http.StatusNotFound)
 					return
 				}
 				w.WriteHeader(http.StatusOK)
 				return
 			}
-			res, err := db.Exec("UPDATE categories SET name=? WHERE id=?", payload.Name, id)
-			if err != nil {
+			if _, err := db.Exec("UPDATE categories SET name=?, slug=?, parent_id=?, position=? WHERE id=? AND shop_id = @shop_id", cur.Name, cur.Slug, sqlNull(cur.ParentID), cur.Position, cur.ID); err != nil {
 				log.Println("category PUT db.Exec error:", err)
 				http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
 				return
 			}
-			if n, _ := res.RowsAffected(); n == 0 {
-				http.Error(w, "not found", http.StatusNotFound)
-				return
-			}
 			w.WriteHeader(http.StatusOK)
 			return
 
@@ -760,26 +992,17 @@ func categoryItemHandler(db *sql.DB) http.HandlerFunc {
 				http.Error(w, "unauthorized", http.StatusUnauthorized)
 				return
 			}
-			if db == nil {
-				if !DevDeleteCategory(id) {
-					http.Error(w, "not found", http.StatusNotFound)
-					return
-				}
-				w.WriteHeader(http.StatusOK)
-				return
-			}
-			if _, err := db.Exec("UPDATE products SET category_id=NULL WHERE category_id=?", id); err != nil {
-				log.Println("category DELETE update products error:", err)
-				http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			if !found {
+				http.Error(w, "not found", http.StatusNotFound)
 				return
 			}
-			res, err := db.Exec("DELETE FROM categories WHERE id=?", id)
+			ok, err := deleteCategory(db, cur.ID)
 			if err != nil {
-				log.Println("category DELETE db.Exec error:", err)
+				log.Println("category DELETE error:", err)
 				http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
 				return
 			}
-			if n, _ := res.RowsAffected(); n == 0 {
+			if !ok {
 				http.Error(w, "not found", http.StatusNotFound)
 				return
 			}
@@ -799,10 +1022,12 @@ func socialsHandler(db *sql.DB) http.HandlerFunc {
 		switch r.Method {
 		case http.MethodGet:
 			if db == nil {
-				_ = json.NewEncoder(w).Encode(DevGetSocials())
+				socials := DevGetSocials()
+				setSocialTrackedURLs(socials)
+				_ = json.NewEncoder(w).Encode(socials)
 				return
 			}
-			rows, err := db.Query("SELECT id, name, url, IFNULL(icon,''), ord FROM socials ORDER BY ord ASC, id ASC")
+			rows, err := db.Query("SELECT id, name, url, IFNULL(icon,''), ord FROM socials WHERE shop_id = @shop_id ORDER BY ord ASC, id ASC")
 			if err != nil {
 				log.Println("socials GET db.Query error:", err)
 				http.Error(w, "db error", http.StatusInternalServerError)
@@ -816,6 +1041,7 @@ func socialsHandler(db *sql.DB) http.HandlerFunc {
 					out = append(out, s)
 				}
 			}
+			setSocialTrackedURLs(out)
 			w.Header().Set("Content-Type", "application/json")
 			_ = json.NewEncoder(w).Encode(out)
 			return
@@ -847,7 +1073,7 @@ func socialsHandler(db *sql.DB) http.HandlerFunc {
 				_ = json.NewEncoder(w).Encode(s)
 				return
 			}
-			res, err := db.Exec("INSERT INTO socials (name, url, icon, ord) VALUES (?, ?, ?, ?)", payload.Name, payload.URL, payload.Icon, payload.Ord)
+			res, err := db.Exec("INSERT INTO socials (shop_id, name, url, icon, ord) VALUES (@shop_id, ?, ?, ?, ?)", payload.Name, payload.URL, payload.Icon, payload.Ord)
 			if err != nil {
 				log.Println("socials POST db.Exec error:", err)
 				http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
@@ -908,7 +1134,7 @@ func socialItemHandler(db *sql.DB) http.HandlerFunc {
 				w.WriteHeader(http.StatusOK)
 				return
 			}
-			res, err := db.Exec("UPDATE socials SET name=?, url=?, icon=?, ord=? WHERE id=?", payload.Name, payload.URL, payload.Icon, payload.Ord, id)
+			res, err := db.Exec("UPDATE socials SET name=?, url=?, icon=?, ord=? WHERE id=? AND shop_id = @shop_id", payload.Name, payload.URL, payload.Icon, payload.Ord, id)
 			if err != nil {
 				log.Println("social PUT db.Exec error:", err)
 				http.Error(w, "db error", http.StatusInternalServerError)
@@ -930,7 +1156,7 @@ func socialItemHandler(db *sql.DB) http.HandlerFunc {
 				w.WriteHeader(http.StatusOK)
 				return
 			}
-			res, err := db.Exec("DELETE FROM socials WHERE id = ?", id)
+			res, err := db.Exec("DELETE FROM socials WHERE id = ? AND shop_id = @shop_id", id)
 			if err != nil {
 				log.Println("social DELETE db.Exec error:", err)
 				http.Error(w, "db error", http.StatusInternalServerError)
@@ -974,6 +1200,49 @@ func staticImagesHandler(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(out)
 }
 
+// parseFormBool accepts the usual truthy form values ("1", "true", "on", "yes").
+func parseFormBool(v string) bool {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "1", "true", "on", "yes":
+		return true
+	}
+	return false
+}
+
+// parseStock parses a stock form value; blank means stock is not tracked (nil).
+func parseStock(v string) (*int, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return nil, errors.New("invalid stock")
+	}
+	return &n, nil
+}
+
+// parseWeight parses a weight_grams form value; blank means unknown (nil).
+func parseWeight(v string) (*int, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return nil, errors.New("invalid weight_grams")
+	}
+	return &n, nil
+}
+
+// sqlNullInt maps a nil pointer to NULL.
+func sqlNullInt(n *int) interface{} {
+	if n == nil {
+		return nil
+	}
+	return *n
+}
+
 func sqlNull(id int64) interface{} {
 	if id == 0 {
 		return nil
@@ -998,6 +1267,11 @@ func profileHandler(db *sql.DB, cloudURL string) http.HandlerFunc {
 				http.Error(w, "profile not ready", http.StatusInternalServerError)
 				return
 			}
+			if p.Blocks, err = publicProfileBlocks(db); err != nil {
+				log.Println("profile blocks error:", err)
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
 			w.Header().Set("Content-Type", "application/json")
 			_ = json.NewEncoder(w).Encode(p)
 			return
@@ -1024,6 +1298,27 @@ func profileHandler(db *sql.DB, cloudURL string) http.HandlerFunc {
 				http.Error(w, "profile not ready", http.StatusInternalServerError)
 				return
 			}
+			// amounts are stored in minor units and are not converted when the
+			// currency changes, so switching only makes sense before pricing products
+			currency := current.Currency
+			if v := strings.TrimSpace(r.FormValue("currency")); v != "" {
+				c, ok := lookupCurrency(v)
+				if !ok {
+					http.Error(w, "unsupported currency", http.StatusBadRequest)
+					return
+				}
+				currency = c.Code
+			}
+			// affiliate parameters are kept unless the field is sent; blank clears them
+			affiliate := current.ShopeeAffiliateParams
+			if vals, ok := r.MultipartForm.Value["shopee_affiliate_params"]; ok && len(vals) > 0 {
+				v, err := validateAffiliateParams(vals[0])
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				affiliate = v
+			}
 			avatarURL := current.AvatarURL
 			file, _, ferr := r.FormFile("avatar")
 			if ferr == nil {
@@ -1050,11 +1345,13 @@ func profileHandler(db *sql.DB, cloudURL string) http.HandlerFunc {
 			}
 
 			toSave := Profile{
-				DisplayName: displayName,
-				Username:    username,
-				Bio:         bio,
-				Highlight:   highlight,
-				AvatarURL:   avatarURL,
+				DisplayName:           displayName,
+				Username:              username,
+				Bio:                   bio,
+				Highlight:             highlight,
+				AvatarURL:             avatarURL,
+				Currency:              currency,
+				ShopeeAffiliateParams: affiliate,
 			}
 			if err := saveProfile(db, toSave); err != nil {
 				log.Println("save profile:", err)
@@ -1102,7 +1399,7 @@ func adminDeleteProduct(db *sql.DB) http.HandlerFunc {
 		}
 		// delete cloudinary image if any
 		var imgPublicID sql.NullString
-		if err := db.QueryRow("SELECT image_public_id FROM products WHERE id = ?", id).Scan(&imgPublicID); err != nil {
+		if err := db.QueryRow("SELECT image_public_id FROM products WHERE id = ? AND shop_id = @shop_id", id).Scan(&imgPublicID); err != nil {
 			log.Println("adminDeleteProduct select image_public_id error:", err)
 		} else {
 			if imgPublicID.Valid && imgPublicID.String != "" {
@@ -1120,7 +1417,10 @@ func adminDeleteProduct(db *sql.DB) http.HandlerFunc {
 			}
 		}
 
-		res, err := db.Exec("DELETE FROM products WHERE id=?", id)
+		if _, err := db.Exec("DELETE FROM product_collections WHERE product_id=? AND shop_id = @shop_id", id); err != nil {
+			log.Println("adminDeleteProduct unlink collections error:", err)
+		}
+		res, err := db.Exec("DELETE FROM products WHERE id=? AND shop_id = @shop_id", id)
 		if err != nil {
 			log.Println("adminDeleteProduct db.Exec error:", err)
 			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
@@ -1154,29 +1454,81 @@ func adminDeleteCategory(db *sql.DB) http.HandlerFunc {
 		}
 		id := payload.ID
 		log.Printf("adminDeleteCategory called id=%d remote=%s dbNil=%t", id, r.RemoteAddr, db == nil)
-		if db == nil {
-			if !DevDeleteCategory(id) {
-				http.Error(w, "not found", http.StatusNotFound)
-				return
-			}
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-		if _, err := db.Exec("UPDATE products SET category_id=NULL WHERE category_id=?", id); err != nil {
-			log.Println("adminDeleteCategory update products error:", err)
-			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-		res, err := db.Exec("DELETE FROM categories WHERE id=?", id)
+		ok, err := deleteCategory(db, id)
 		if err != nil {
-			log.Println("adminDeleteCategory db.Exec error:", err)
+			log.Println("adminDeleteCategory error:", err)
 			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
-		if n, _ := res.RowsAffected(); n == 0 {
+		if !ok {
 			http.Error(w, "not found", http.StatusNotFound)
 			return
 		}
 		w.WriteHeader(http.StatusOK)
 	}
 }
+
+// adminReorderProducts provides a POST JSON endpoint {"ids":[...]} that sets the manual
+// grid order: the first id gets position 1, the next 2, and so on.
+func adminReorderProducts(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !isAdmin(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var payload struct {
+			IDs []int64 `json:"ids"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		ids := dedupeIDs(payload.IDs)
+		if len(ids) == 0 {
+			http.Error(w, "ids required", http.StatusBadRequest)
+			return
+		}
+		log.Printf("adminReorderProducts called count=%d remote=%s dbNil=%t", len(ids), r.RemoteAddr, db == nil)
+		var missing []int64
+		if db == nil {
+			missing = DevReorderProducts(ids)
+		} else {
+			tx, err := db.Begin()
+			if err != nil {
+				log.Println("adminReorderProducts begin error:", err)
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
+			defer tx.Rollback()
+			for pos, id := range ids {
+				res, err := tx.Exec("UPDATE products SET position = ? WHERE id = ? AND shop_id = @shop_id", pos+1, id)
+				if err != nil {
+					log.Println("adminReorderProducts update error:", err)
+					http.Error(w, "db error", http.StatusInternalServerError)
+					return
+				}
+				if n, _ := res.RowsAffected(); n == 0 {
+					// RowsAffected is 0 both for unknown ids and unchanged rows
+					var exists int
+					if err := tx.QueryRow("SELECT 1 FROM products WHERE id = ? AND shop_id = @shop_id", id).Scan(&exists); err != nil {
+						missing = append(missing, id)
+					}
+				}
+			}
+			if err := tx.Commit(); err != nil {
+				log.Println("adminReorderProducts commit error:", err)
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
+		}
+		if missing == nil {
+			missing = []int64{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"updated": len(ids) - len(missing), "missing": missing})
+	}
+}
@@ -82,7 +82,9 @@ func main() {
 		}
 	}
 
+	// db is the default shop's pool; other shops get their own (see shopRegistry)
 	var db *sql.DB
+	var dbConfig *mysql.Config
 	var err error
 	if !devMode {
 		// If DSN requests tls=tidb, register a TLS config named "tidb".
@@ -106,7 +108,11 @@ func main() {
 			}
 		}
 
-		db, err = sql.Open("mysql", dsn)
+		dbConfig, err = mysql.ParseDSN(dsn)
+		if err != nil {
+			log.Fatalf("parse MYSQL_DSN: %v", err)
+		}
+		db, err = openShopDB(dbConfig, defaultShopID)
 		if err != nil {
 			log.Fatalf("open db: %v", err)
 		}
@@ -128,12 +134,141 @@ func main() {
 		}
 	}
 
+	// dev mode can keep its in-memory store in a JSON file between runs and be seeded
+	// from a fixture (JSON snapshot) or a backup archive produced by "backup"
+	var devStore *devStorePersister
+	if devMode {
+		if path := os.Getenv("DEV_STORE_FILE"); path != "" {
+			devStore = &devStorePersister{path: path}
+		}
+		seedDevStore(devStore, os.Getenv("DEV_FIXTURE_FILE"), os.Getenv("DEV_SEED_BACKUP"))
+	}
+
+	// maintenance subcommands (backup/restore) run against the same store and exit;
+	// SHOP=<username> selects a shop other than the default one
+	if len(os.Args) > 1 {
+		target := db
+		if username := os.Getenv("SHOP"); username != "" && db != nil {
+			sites, err := fetchShopSites(db, username)
+			if err != nil {
+				log.Fatalf("find shop: %v", err)
+			}
+			if len(sites) == 0 {
+				log.Fatalf("shop %q not found", username)
+			}
+			if target, err = openShopDB(dbConfig, sites[0].ID); err != nil {
+				log.Fatalf("open db: %v", err)
+			}
+		}
+		if err := runBackupCommand(target, cloudURL, os.Args[1:]); err != nil {
+			log.Fatalf("%s: %v", os.Args[1], err)
+		}
+		if devStore != nil {
+			if err := devStore.flush(); err != nil {
+				log.Fatalf("dev store flush: %v", err)
+			}
+		}
+		return
+	}
+
+	// Self-ping configuration: read URL and optional interval (minutes)
+	pingURL := os.Getenv("SELF_PING_URL")
+	if pingURL == "" {
+		// fallback for older name
+		pingURL = os.Getenv("RENDER_PING_URL")
+	}
+	intervalMin := 14
+	if v := os.Getenv("SELF_PING_INTERVAL_MIN"); v != "" {
+		if iv, err := strconv.Atoi(v); err == nil && iv > 0 {
+			intervalMin = iv
+		}
+	}
+
+	// create a cancellable context that listens for SIGINT/SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// start the self-pinger (no-op if pingURL is empty)
+	startSelfPing(ctx, pingURL, time.Duration(intervalMin)*time.Minute)
+
+	// Shopee listing metadata sync, one syncer per shop
+	fetcher, err := newShopeeFetcher()
+	if err != nil {
+		log.Printf("warning: shopee fetcher disabled: %v", err)
+	}
+	syncHours := 6
+	if v := os.Getenv("SHOPEE_SYNC_HOURS"); v != "" {
+		if iv, err := strconv.Atoi(v); err == nil && iv >= 0 {
+			syncHours = iv
+		}
+	}
+
+	// every shop gets its own routes and Shopee sync, built when the shop is first opened
+	shops := newShopRegistry(db, dbConfig, func(db *sql.DB) http.Handler {
+		shopeeSync := &shopeeSyncer{db: db, fetcher: fetcher, delay: 2 * time.Second}
+		if shopeeSync.fetcher != nil && syncHours > 0 {
+			shopeeSync.start(ctx, time.Duration(syncHours)*time.Hour)
+		}
+		return shopRoutes(db, cloudURL, shopeeSync)
+	})
+	if err := shops.load(); err != nil {
+		log.Fatalf("load shops: %v", err)
+	}
+
+	rollupMin := 10
+	if v := os.Getenv("ANALYTICS_ROLLUP_MIN"); v != "" {
+		if iv, err := strconv.Atoi(v); err == nil && iv > 0 {
+			rollupMin = iv
+		}
+	}
+	startAnalyticsRollup(ctx, shops.dbs, time.Duration(rollupMin)*time.Minute)
+	startCartCleanup(ctx, shops.dbs, time.Hour)
+
+	if devStore != nil {
+		flushSec := 5
+		if v := os.Getenv("DEV_STORE_FLUSH_SEC"); v != "" {
+			if iv, err := strconv.Atoi(v); err == nil && iv > 0 {
+				flushSec = iv
+			}
+		}
+		devStore.run(ctx, time.Duration(flushSec)*time.Second)
+	}
+
+	srv := &http.Server{Addr: ":8000", Handler: shops}
+	go func() {
+		log.Println("server listening on :8000")
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("server: %v", err)
+		}
+	}()
+
+	// wait for interrupt
+	<-ctx.Done()
+	log.Println("shutdown signal received, shutting down server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Fatalf("server shutdown failed: %v", err)
+	}
+	if devStore != nil {
+		if err := devStore.flush(); err != nil {
+			log.Printf("dev store flush error: %v", err)
+		}
+	}
+	log.Println("server gracefully stopped")
+}
+
+// shopRoutes registers the storefront, admin and API routes of one shop. db is
+// the shop's scoped pool (nil in dev mode).
+func shopRoutes(db *sql.DB, cloudURL string, shopeeSync *shopeeSyncer) *http.ServeMux {
+	mux := http.NewServeMux()
+
 	// Static assets and pages under /static
 	fs := http.FileServer(http.Dir("./static"))
-	http.Handle("/static/", http.StripPrefix("/static/", fs))
+	mux.Handle("/static/", http.StripPrefix("/static/", fs))
 
-	// Admin handler: allow login via secret link ?token=ADMIN_TOKEN or existing session cookie
-	http.HandleFunc("/admin", func(w http.ResponseWriter, r *http.Request) {
+	// Admin handler: allow login via secret link ?token=<shop admin token> or existing session cookie
+	mux.HandleFunc("/admin", func(w http.ResponseWriter, r *http.Request) {
 		// if session cookie present, serve admin
 		if isAdmin(r) {
 			http.ServeFile(w, r, "./static/admin.html")
@@ -141,9 +276,9 @@ func main() {
 		}
 		// otherwise check token
 		token := r.URL.Query().Get("token")
-		if token != "" && token == os.Getenv("ADMIN_TOKEN") {
+		if shop := requestShop(r); shop.checkAdminToken(token) {
 			// set admin cookie and serve
-			http.SetCookie(w, &http.Cookie{Name: "session", Value: "admin", Path: "/", HttpOnly: true})
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: shop.sessionValue(), Path: shopCookiePath(r), HttpOnly: true})
 			http.ServeFile(w, r, "./static/admin.html")
 			return
 		}
@@ -152,9 +287,10 @@ func main() {
 	})
 
 	// API endpoints
-	http.HandleFunc("/api/login", loginHandler())
-	http.HandleFunc("/api/logout", logoutHandler())
-	http.HandleFunc("/api/products", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/api/login", loginHandler())
+	mux.HandleFunc("/api/logout", logoutHandler())
+	// handlers wrapped in invalidatesCatalog change what the sitemap and feeds show
+	mux.HandleFunc("/api/products", invalidatesCatalog(func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
 			listProducts(db)(w, r)
@@ -163,18 +299,53 @@ func main() {
 		default:
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		}
-	})
+	}))
 	// product item endpoints (GET/PUT/DELETE)
-	http.HandleFunc("/api/products/", productItemHandler(db, cloudURL))
+	mux.HandleFunc("/api/products/", invalidatesCatalog(productItemHandler(db, cloudURL)))
+	// full-text product search (diacritic-insensitive, typo tolerant)
+	mux.HandleFunc("/api/search", searchHandler(db))
 	// categories endpoints
-	http.HandleFunc("/api/categories", categoriesHandler(db))
-	http.HandleFunc("/api/categories/", categoryItemHandler(db))
+	mux.HandleFunc("/api/categories", invalidatesCatalog(categoriesHandler(db)))
+	mux.HandleFunc("/api/categories/", invalidatesCatalog(categoryItemHandler(db)))
+	// collections (free-form product groupings)
+	mux.HandleFunc("/api/collections", invalidatesCatalog(collectionsHandler(db)))
+	mux.HandleFunc("/api/collections/", invalidatesCatalog(collectionItemHandler(db)))
+	// order requests: public submit, admin list/update
+	// (only an admin's status change moves stock, hence availability)
+	mux.HandleFunc("/api/orders", ordersHandler(db))
+	mux.HandleFunc("/api/orders/", invalidatesCatalog(orderItemHandler(db)))
+	// anonymous cart (cookie based) with checkout into an order request
+	mux.HandleFunc("/api/cart", cartHandler(db))
+	mux.HandleFunc("/api/cart/", cartHandler(db))
+	// discount coupons (admin)
+	mux.HandleFunc("/api/coupons", couponsHandler(db))
+	mux.HandleFunc("/api/coupons/", couponItemHandler(db))
+	// outbound click tracking redirect for Shopee and social links
+	mux.HandleFunc("/go/", clickRedirectHandler(db))
+	// server-rendered product pages, sitemap and robots.txt for crawlers and link previews
+	mux.HandleFunc("/p/", productPageHandler(db))
+	mux.HandleFunc("/sitemap.xml", sitemapHandler(db))
+	mux.HandleFunc("/robots.txt", robotsHandler())
+	// product catalog feeds for Facebook/Instagram Shopping and Google Merchant Center
+	mux.HandleFunc("/feeds/", catalogFeedHandler(db))
+	// new-arrivals feeds for followers (Atom and RSS)
+	mux.HandleFunc("/feed.xml", arrivalsFeedHandler(db, true))
+	mux.HandleFunc("/feed.rss", arrivalsFeedHandler(db, false))
+	// product view beacon and the admin analytics built from views and clicks
+	mux.HandleFunc("/api/events/view", viewEventHandler(db))
+	mux.HandleFunc("/api/admin/analytics", adminAnalytics(db))
+	// Shopee listing metadata sync (scheduled per shop, or on demand)
+	mux.HandleFunc("/api/admin/shopee-sync", adminShopeeSync(shopeeSync))
+	// shipping zones (admin) and public shipping fee quotes
+	mux.HandleFunc("/api/shipping/zones", shippingZonesHandler(db))
+	mux.HandleFunc("/api/shipping/zones/", shippingZoneItemHandler(db))
+	mux.HandleFunc("/api/shipping/quote", shippingQuoteHandler(db))
 	// socials endpoints and static images list
-	http.HandleFunc("/api/socials", socialsHandler(db))
-	http.HandleFunc("/api/socials/", socialItemHandler(db))
-	http.HandleFunc("/api/static-imgs", staticImagesHandler)
+	mux.HandleFunc("/api/socials", socialsHandler(db))
+	mux.HandleFunc("/api/socials/", socialItemHandler(db))
+	mux.HandleFunc("/api/static-imgs", staticImagesHandler)
 	// simple ping endpoint used by self-pinger; protected by SELF_PING_TOKEN if set
-	http.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
 		expected := os.Getenv("SELF_PING_TOKEN")
 		if expected != "" {
 			got := r.URL.Query().Get("token")
@@ -186,57 +357,31 @@ func main() {
 		fmt.Fprintln(w, "Pong")
 	})
 	// admin convenience endpoints for delete operations (POST JSON {id})
-	http.HandleFunc("/api/admin/delete-product", adminDeleteProduct(db))
-	http.HandleFunc("/api/admin/delete-category", adminDeleteCategory(db))
+	mux.HandleFunc("/api/admin/delete-product", invalidatesCatalog(adminDeleteProduct(db)))
+	mux.HandleFunc("/api/admin/delete-category", invalidatesCatalog(adminDeleteCategory(db)))
+	mux.HandleFunc("/api/admin/reorder-products", invalidatesCatalog(adminReorderProducts(db)))
+	mux.HandleFunc("/api/admin/bulk-products", invalidatesCatalog(adminBulkProducts(db, cloudURL)))
+	mux.HandleFunc("/api/admin/export/products.csv", adminExportProductsCSV(db))
+	mux.HandleFunc("/api/admin/import/products", invalidatesCatalog(adminImportProductsCSV(db)))
+	mux.HandleFunc("/api/admin/backup", adminBackup(db))
+	mux.HandleFunc("/api/admin/restore", invalidatesCatalog(adminRestore(db, cloudURL)))
 	// profile info endpoint
-	http.HandleFunc("/api/profile", profileHandler(db, cloudURL))
+	mux.HandleFunc("/api/profile", invalidatesCatalog(profileHandler(db, cloudURL)))
+	// Linktree-style profile blocks (admin CRUD; the visible ones are part of GET /api/profile)
+	mux.HandleFunc("/api/profile/blocks", profileBlocksHandler(db))
+	mux.HandleFunc("/api/profile/blocks/", profileBlockItemHandler(db))
 
 	// Serve root files (index.html and admin.html live under ./static)
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		// Let static handler serve files; default to index.html
+	storefront := storefrontHandler(db)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		// the storefront (static/index.html) is rendered server-side
 		if r.URL.Path == "/" {
-			http.ServeFile(w, r, "./static/index.html")
+			storefront(w, r)
 			return
 		}
 		// For other top-level files, try static
 		http.ServeFile(w, r, "./static"+r.URL.Path)
 	})
 
-	// Self-ping configuration: read URL and optional interval (minutes)
-	pingURL := os.Getenv("SELF_PING_URL")
-	if pingURL == "" {
-		// fallback for older name
-		pingURL = os.Getenv("RENDER_PING_URL")
-	}
-	intervalMin := 14
-	if v := os.Getenv("SELF_PING_INTERVAL_MIN"); v != "" {
-		if iv, err := strconv.Atoi(v); err == nil && iv > 0 {
-			intervalMin = iv
-		}
-	}
-
-	// create a cancellable context that listens for SIGINT/SIGTERM
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
-	defer stop()
-
-	// start the self-pinger (no-op if pingURL is empty)
-	startSelfPing(ctx, pingURL, time.Duration(intervalMin)*time.Minute)
-
-	srv := &http.Server{Addr: ":8000"}
-	go func() {
-		log.Println("server listening on :8000")
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("server: %v", err)
-		}
-	}()
-
-	// wait for interrupt
-	<-ctx.Done()
-	log.Println("shutdown signal received, shutting down server")
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	if err := srv.Shutdown(shutdownCtx); err != nil {
-		log.Fatalf("server shutdown failed: %v", err)
-	}
-	log.Println("server gracefully stopped")
+	return mux
 }
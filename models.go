@@ -1,28 +1,99 @@
 package main
 
+import "time"
+
 // Product represents a product in the shop.
 type Product struct {
-	ID            int64   `json:"id"`
-	Title         string  `json:"title"`
-	Description   string  `json:"description"`
-	Price         float64 `json:"price"`
-	ImageURL      string  `json:"image_url"`
-	ImagePublicID string  `json:"image_public_id"`
-	ExternalURL   string  `json:"external_url"`
-	Tag           string  `json:"tag"`
-	CategoryID    int64   `json:"category_id"`
-	Category      string  `json:"category"`
-	CreatedAt     string  `json:"created_at"`
+	ID            int64  `json:"id"`
+	Title         string `json:"title"`
+	Description   string `json:"description"`
+	Price         Money  `json:"price"` // minor units of Currency
+	ImageURL      string `json:"image_url"`
+	ImagePublicID string `json:"image_public_id"`
+	ExternalURL   string `json:"external_url"`
+	ExternalKey   string `json:"external_key"` // stable key used to upsert catalog imports
+	Source        string `json:"source"`       // sourceMyChoice (own stock) or sourceShopee (affiliate)
+	Tag           string `json:"tag"`          // deprecated mirror of Source for older clients
+	CategoryID    int64  `json:"category_id"`
+	Category      string `json:"category"`
+	CategorySlug  string `json:"category_slug"`
+	Status        string `json:"status"`       // statusPublished, statusDraft or statusSoldOut
+	Position      int    `json:"position"`     // manual grid order (ascending); 0 = not yet placed
+	Pinned        bool   `json:"pinned"`       // pinned products are listed before all others
+	Stock         *int   `json:"stock"`        // units on hand; nil = stock not tracked
+	WeightGrams   *int   `json:"weight_grams"` // shipping weight of one unit; nil = unknown
+	// optional sale: SalePrice applies between SaleStartsAt and SaleEndsAt (nil = open ended)
+	SalePrice      *Money     `json:"sale_price"`
+	SaleStartsAt   *time.Time `json:"sale_starts_at"`
+	SaleEndsAt     *time.Time `json:"sale_ends_at"`
+	EffectivePrice Money      `json:"effective_price"` // what a customer pays now; set by applyPricing
+	OnSale         bool       `json:"on_sale"`
+	CreatedAt      string     `json:"created_at"`
+	UpdatedAt      string     `json:"updated_at"` // last change; falls back to CreatedAt
+
+	// display fields set by applyPricing from the shop currency
+	Currency                string `json:"currency"`
+	PriceFormatted          string `json:"price_formatted"`
+	EffectivePriceFormatted string `json:"effective_price_formatted"`
+	TrackedURL              string `json:"tracked_url,omitempty"` // click-tracking redirect to ExternalURL
+	PageURL                 string `json:"page_url"`              // shareable server-rendered page, see productPagePath
+
+	// set by setPriceDrops when EffectivePrice is below the highest price of the last priceDropWindow
+	PriceDropped   bool  `json:"price_dropped"`
+	RecentMaxPrice Money `json:"recent_max_price,omitempty"`
+
+	Collections []Collection `json:"collections"`
 }
 
+// Product statuses. Drafts are only visible to admins; sold-out items stay listed.
+const (
+	statusPublished = "published"
+	statusDraft     = "draft"
+	statusSoldOut   = "sold_out"
+)
+
+// validProductStatus reports whether s is one of the known product statuses.
+func validProductStatus(s string) bool {
+	return s == statusPublished || s == statusDraft || s == statusSoldOut
+}
+
+// Product sources: own stock vs Shopee affiliate listing.
+const (
+	sourceMyChoice = "mychoice"
+	sourceShopee   = "shopee"
+)
+
 // Profile represents public store/profile info for the Linktree-style page.
 type Profile struct {
-	DisplayName string   `json:"display_name"`
-	Username    string   `json:"username"`
-	Bio         string   `json:"bio"`
-	Highlight   string   `json:"highlight"`
-	AvatarURL   string   `json:"avatar_url"`
-	Socials     []Social `json:"socials,omitempty"`
+	DisplayName           string   `json:"display_name"`
+	Username              string   `json:"username"`
+	Bio                   string   `json:"bio"`
+	Highlight             string   `json:"highlight"`
+	AvatarURL             string   `json:"avatar_url"`
+	Currency              string   `json:"currency"`                // ISO 4217 code of all shop prices; see currencies
+	ShopeeAffiliateParams string   `json:"shopee_affiliate_params"` // query added to outbound Shopee links
+	Socials               []Social `json:"socials,omitempty"`
+
+	Blocks []ProfileBlock `json:"blocks,omitempty"` // blocks shown now; set by the profile GET
+}
+
+// ProfileBlock is one entry of the Linktree-style profile: a link button, header,
+// text, embedded video or featured product (see the block* types).
+type ProfileBlock struct {
+	ID        int64      `json:"id"`
+	Type      string     `json:"type"`
+	Title     string     `json:"title"`          // button label, heading or optional caption
+	Body      string     `json:"body,omitempty"` // text blocks
+	URL       string     `json:"url,omitempty"`  // link target or video page
+	ProductID int64      `json:"product_id,omitempty"`
+	Position  int        `json:"position"`
+	Visible   bool       `json:"visible"`   // manual toggle
+	StartsAt  *time.Time `json:"starts_at"` // shown from (nil = always)
+	EndsAt    *time.Time `json:"ends_at"`   // shown until, exclusive (nil = open ended)
+
+	// filled for public output by publicProfileBlocks
+	EmbedURL string   `json:"embed_url,omitempty"`
+	Product  *Product `json:"product,omitempty"`
 }
 
 // Social represents a social network link shown on the profile (ordered).
@@ -32,10 +103,127 @@ type Social struct {
 	URL  string `json:"url"`
 	Icon string `json:"icon"` // filename under static/img
 	Ord  int    `json:"ord"`
+
+	TrackedURL string `json:"tracked_url"` // click-tracking redirect to URL
 }
 
-// Category represents a product category/tag.
+// Category represents a product category. Categories form a tree via ParentID
+// (0 = top level) and are displayed by Position, then Name.
 type Category struct {
-	ID   int64  `json:"id"`
-	Name string `json:"name"`
+	ID       int64      `json:"id"`
+	Name     string     `json:"name"`
+	Slug     string     `json:"slug"`
+	ParentID int64      `json:"parent_id"`
+	Position int        `json:"position"`
+	Children []Category `json:"children,omitempty"`
+}
+
+// Collection is a free-form product grouping such as "Sale" or "Đồ đi biển".
+// Products can belong to any number of collections.
+type Collection struct {
+	ID           int64  `json:"id"`
+	Name         string `json:"name"`
+	Slug         string `json:"slug"`
+	Position     int    `json:"position"`
+	ProductCount int    `json:"product_count,omitempty"`
+}
+
+// Order is a customer's order request from the storefront. Items keep the title
+// and price the customer saw when ordering.
+type Order struct {
+	ID           int64       `json:"id"`
+	CustomerName string      `json:"customer_name"`
+	Phone        string      `json:"phone"`
+	Address      string      `json:"address"`
+	Note         string      `json:"note"`
+	Status       string      `json:"status"` // see orderNew ... orderCancelled
+	AdminNote    string      `json:"admin_note"`
+	Items        []OrderItem `json:"items"`
+	Currency     string      `json:"currency"`
+	Subtotal     Money       `json:"subtotal"`
+	Discount     Money       `json:"discount"`
+	CouponCode   string      `json:"coupon_code,omitempty"`
+	CouponID     int64       `json:"coupon_id,omitempty"` // the coupon as used, even if its code changed later
+	Total        Money       `json:"total"`
+	// TotalFormatted is Total rendered in Currency; set when orders are loaded
+	TotalFormatted string `json:"total_formatted"`
+	CreatedAt      string `json:"created_at"`
+	UpdatedAt      string `json:"updated_at"`
+}
+
+// OrderItem is one line of an order. Variant is free text such as "size M, màu đen".
+type OrderItem struct {
+	ProductID int64  `json:"product_id"`
+	Title     string `json:"title"`
+	Variant   string `json:"variant"`
+	Quantity  int    `json:"quantity"`
+	UnitPrice Money  `json:"unit_price"`
+}
+
+// Coupon is a discount code. Kind is couponPercent (Percent off the subtotal) or
+// couponFixed (Amount off, in minor units). MaxUses 0 means unlimited.
+type Coupon struct {
+	ID        int64      `json:"id"`
+	Code      string     `json:"code"`
+	Kind      string     `json:"kind"`
+	Percent   float64    `json:"percent,omitempty"`
+	Amount    Money      `json:"amount,omitempty"`
+	MinOrder  Money      `json:"min_order"`
+	MaxUses   int        `json:"max_uses"`
+	UsedCount int        `json:"used_count"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	Active    bool       `json:"active"`
+	CreatedAt string     `json:"created_at"`
+}
+
+// ClickEvent is one outbound click recorded by the /go redirect.
+type ClickEvent struct {
+	Kind      string    `json:"kind"`
+	TargetID  int64     `json:"target_id"`
+	Referrer  string    `json:"referrer"`
+	UAClass   string    `json:"ua_class"` // bot, mobile, tablet or desktop
+	IP        string    `json:"ip"`       // anonymized, see anonymizeIP
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ViewEvent is one product detail view reported by the storefront.
+type ViewEvent struct {
+	ProductID int64     `json:"product_id"`
+	Referrer  string    `json:"referrer"`
+	UAClass   string    `json:"ua_class"`
+	IP        string    `json:"ip"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PriceChange is one entry of a product's price history.
+type PriceChange struct {
+	ID        int64     `json:"id"`
+	ProductID int64     `json:"product_id"`
+	OldPrice  Money     `json:"old_price"`
+	NewPrice  Money     `json:"new_price"`
+	Source    string    `json:"source"` // admin, bulk, import or shopee_sync
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// ShippingZone is a set of provinces/cities sharing a shipping rate. A zone with
+// no provinces is the fallback for destinations no other zone lists.
+type ShippingZone struct {
+	ID            int64    `json:"id"`
+	Name          string   `json:"name"`
+	Provinces     []string `json:"provinces"`
+	Kind          string   `json:"kind"` // flat or weight
+	BaseFee       Money    `json:"base_fee"`
+	PerKgFee      Money    `json:"per_kg_fee"`
+	IncludedGrams int      `json:"included_grams"` // weight covered by BaseFee
+	FreeOver      Money    `json:"free_over"`      // free shipping from this order value; 0 = never
+	Position      int      `json:"position"`
+}
+
+// Shop is one seller's storefront (tenant). Every catalog, profile, order and
+// analytics row carries the id of the shop it belongs to.
+type Shop struct {
+	ID        int64  `json:"id"`
+	Username  string `json:"username"` // subdomain and /@username path prefix
+	Name      string `json:"name"`
+	CreatedAt string `json:"created_at,omitempty"`
 }
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount in the minor unit of the shop currency: đồng for VND (which
+// has no subunit), cents for USD. It is encoded in JSON as an integer.
+type Money int64
+
+// Currency describes how amounts in one currency are parsed and displayed.
+type Currency struct {
+	Code        string `json:"code"`
+	Exponent    int    `json:"exponent"` // digits after the decimal point; 0 for VND
+	Symbol      string `json:"symbol"`
+	SymbolAfter bool   `json:"symbol_after"` // "120.000 ₫" rather than "$12.00"
+	Thousands   string `json:"thousands"`
+	Decimal     string `json:"decimal"`
+}
+
+const defaultCurrency = "VND"
+
+// currencies lists the supported shop currencies.
+var currencies = map[string]Currency{
+	"VND": {Code: "VND", Exponent: 0, Symbol: "₫", SymbolAfter: true, Thousands: ".", Decimal: ","},
+	"USD": {Code: "USD", Exponent: 2, Symbol: "$", Thousands: ",", Decimal: "."},
+	"EUR": {Code: "EUR", Exponent: 2, Symbol: "€", SymbolAfter: true, Thousands: ".", Decimal: ","},
+}
+
+// maxMoney bounds parsed amounts well inside int64 so totals cannot overflow.
+const maxMoney = Money(1e15)
+
+// lookupCurrency returns the currency for an ISO 4217 code (case-insensitive).
+func lookupCurrency(code string) (Currency, bool) {
+	c, ok := currencies[strings.ToUpper(strings.TrimSpace(code))]
+	return c, ok
+}
+
+// currencyOrDefault is lookupCurrency falling back to VND for unknown codes.
+func currencyOrDefault(code string) Currency {
+	if c, ok := lookupCurrency(code); ok {
+		return c
+	}
+	return currencies[defaultCurrency]
+}
+
+// shopCurrency returns the currency configured on the shop profile.
+func shopCurrency(db *sql.DB) Currency {
+	if db == nil {
+		return currencyOrDefault(DevGetProfile().Currency)
+	}
+	var code sql.NullString
+	_ = db.QueryRow("SELECT currency FROM profile WHERE shop_id = @shop_id").Scan(&code)
+	return currencyOrDefault(code.String)
+}
+
+// unit is the number of minor units in one major unit (1 for VND, 100 for USD).
+func (c Currency) unit() int64 {
+	n := int64(1)
+	for i := 0; i < c.Exponent; i++ {
+		n *= 10
+	}
+	return n
+}
+
+// Parse reads a non-negative decimal amount in major units such as "120000" or
+// "12.50". More precision than the currency has is rejected rather than rounded
+// away. Currencies without a subunit (VND) take no fractional part at all, so a
+// displayed "120.000" is refused instead of being read as 120.
+func (c Currency) Parse(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, errors.New("price required")
+	}
+	if strings.HasPrefix(s, "-") {
+		return 0, errors.New("price must not be negative")
+	}
+	whole, frac, hasFrac := strings.Cut(s, ".")
+	if hasFrac && c.Exponent == 0 {
+		return 0, errors.New(c.Code + " prices have no decimals; write digits only, e.g. 120000")
+	}
+	if whole == "" {
+		whole = "0"
+	}
+	if !allDigits(whole) || !allDigits(frac) {
+		return 0, errors.New("invalid price")
+	}
+	frac = strings.TrimRight(frac, "0")
+	if len(frac) > c.Exponent {
+		return 0, errors.New("price has more decimals than " + c.Code + " allows")
+	}
+	frac += strings.Repeat("0", c.Exponent-len(frac))
+	w, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || w > int64(maxMoney)/c.unit() {
+		return 0, errors.New("price too large")
+	}
+	m := w * c.unit()
+	if frac != "" {
+		f, _ := strconv.ParseInt(frac, 10, 64)
+		m += f
+	}
+	return Money(m), nil
+}
+
+// Plain renders m in major units without grouping or symbol ("120000", "12.50"),
+// the format used for CSV export and form inputs.
+func (c Currency) Plain(m Money) string {
+	s := strconv.FormatInt(int64(m)/c.unit(), 10)
+	if c.Exponent > 0 {
+		frac := strconv.FormatInt(abs64(int64(m)%c.unit()), 10)
+		s += "." + strings.Repeat("0", c.Exponent-len(frac)) + frac
+	}
+	if m < 0 && !strings.HasPrefix(s, "-") {
+		s = "-" + s
+	}
+	return s
+}
+
+// Format renders m for display, e.g. "120.000 ₫" or "$12.50".
+func (c Currency) Format(m Money) string {
+	neg := m < 0
+	plain := c.Plain(Money(abs64(int64(m))))
+	whole, frac, _ := strings.Cut(plain, ".")
+	var b strings.Builder
+	for i, r := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteString(c.Thousands)
+		}
+		b.WriteRune(r)
+	}
+	s := b.String()
+	if frac != "" {
+		s += c.Decimal + frac
+	}
+	if c.SymbolAfter {
+		s += " " + c.Symbol
+	} else {
+		s = c.Symbol + s
+	}
+	if neg {
+		s = "-" + s
+	}
+	return s
+}
+
+// sqlNullMoney maps a zero amount to NULL, for optional amount columns.
+func sqlNullMoney(m Money) interface{} {
+	if m == 0 {
+		return nil
+	}
+	return int64(m)
+}
+
+// Percent returns pct percent of m, rounded to the nearest minor unit.
+func (m Money) Percent(pct float64) Money {
+	return Money(math.Round(float64(m) * pct / 100))
+}
+
+func allDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func abs64(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
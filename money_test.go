@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestCurrencyParse(t *testing.T) {
+	vnd, usd := currencies["VND"], currencies["USD"]
+	tests := []struct {
+		cur     Currency
+		in      string
+		want    Money
+		wantErr bool
+	}{
+		{vnd, "120000", 120000, false},
+		{vnd, " 120000 ", 120000, false},
+		{vnd, "120.000", 0, true}, // the displayed format, not 120 ₫
+		{vnd, "120000.00", 0, true},
+		{vnd, "12.5", 0, true},
+		{vnd, "1,000", 0, true},
+		{vnd, "-5", 0, true},
+		{vnd, "", 0, true},
+		{usd, "12.5", 1250, false},
+		{usd, "12.50", 1250, false},
+		{usd, ".99", 99, false},
+		{usd, "120000", 12000000, false},
+		{usd, "12.505", 0, true},
+		{usd, "1,000", 0, true},
+		{usd, "abc", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := tt.cur.Parse(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s Parse(%q) = %d, want error", tt.cur.Code, tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s Parse(%q) = %d, %v; want %d", tt.cur.Code, tt.in, got, err, tt.want)
+		}
+	}
+}
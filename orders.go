@@ -0,0 +1,638 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Order statuses. Stock is reserved when an order is confirmed and given back
+// when a confirmed or shipped order is cancelled.
+const (
+	orderNew       = "new"
+	orderConfirmed = "confirmed"
+	orderShipped   = "shipped"
+	orderDone      = "done"
+	orderCancelled = "cancelled"
+)
+
+// orderTransitions lists the statuses an order may move to from each status.
+var orderTransitions = map[string][]string{
+	orderNew:       {orderConfirmed, orderCancelled},
+	orderConfirmed: {orderShipped, orderCancelled},
+	orderShipped:   {orderDone, orderCancelled},
+}
+
+const (
+	maxOrderItems    = 20
+	maxOrderQuantity = 99
+)
+
+// orderError is a validation error shown to the customer (400).
+type orderError struct{ msg string }
+
+func (e orderError) Error() string { return e.msg }
+
+var (
+	errOrderNotFound     = errors.New("order not found")
+	errInsufficientStock = errors.New("insufficient stock")
+)
+
+// validOrderStatus reports whether s is a known order status.
+func validOrderStatus(s string) bool {
+	switch s {
+	case orderNew, orderConfirmed, orderShipped, orderDone, orderCancelled:
+		return true
+	}
+	return false
+}
+
+// canTransitionOrder reports whether an order may move from one status to another.
+func canTransitionOrder(from, to string) bool {
+	for _, s := range orderTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// stockReserved reports whether an order in status holds stock.
+func stockReserved(status string) bool {
+	return status == orderConfirmed || status == orderShipped || status == orderDone
+}
+
+// orderItemInput is one requested line.
+type orderItemInput struct {
+	ProductID int64  `json:"product_id"`
+	Variant   string `json:"variant"`
+	Quantity  int    `json:"quantity"`
+}
+
+// orderInput is the body of POST /api/orders. A single product may be given with the
+// top-level product_id/variant/quantity fields instead of items.
+type orderInput struct {
+	CustomerName string           `json:"customer_name"`
+	Phone        string           `json:"phone"`
+	Address      string           `json:"address"`
+	Note         string           `json:"note"`
+	Items        []orderItemInput `json:"items"`
+	ProductID    int64            `json:"product_id"`
+	Variant      string           `json:"variant"`
+	Quantity     int              `json:"quantity"`
+	CouponCode   string           `json:"coupon_code"`
+
+	cartID string // the cart being checked out, deleted with the order's creation
+}
+
+// normalizePhone accepts Vietnamese numbers like "0912 345 678" or "+84912345678"
+// and returns them as "0912345678".
+func normalizePhone(s string) (string, bool) {
+	s = strings.NewReplacer(" ", "", ".", "", "-", "", "(", "", ")", "").Replace(strings.TrimSpace(s))
+	if strings.HasPrefix(s, "+84") {
+		s = "0" + s[3:]
+	} else if strings.HasPrefix(s, "84") && len(s) == 11 {
+		s = "0" + s[2:]
+	}
+	if len(s) < 10 || len(s) > 11 || s[0] != '0' {
+		return "", false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return "", false
+		}
+	}
+	return s, true
+}
+
+// orderableProduct loads a product that customers may order from the shop: it must
+// be visible, in stock and sold by the shop itself rather than on Shopee.
+func orderableProduct(db *sql.DB, id int64) (Product, error) {
+	p, found, err := fetchProduct(db, id)
+	if err != nil {
+		return p, err
+	}
+	if !found || p.Status == statusDraft {
+		return p, orderError{fmt.Sprintf("product %d not found", id)}
+	}
+	if p.Status == statusSoldOut {
+		return p, orderError{fmt.Sprintf("%s đã hết hàng", p.Title)}
+	}
+	if p.Source == sourceShopee {
+		return p, orderError{fmt.Sprintf("%s chỉ bán trên Shopee", p.Title)}
+	}
+	return p, nil
+}
+
+// orderItems validates the requested lines against the catalog, merging repeated
+// product/variant lines, and prices them at the current effective price.
+func orderItems(db *sql.DB, in orderInput) ([]OrderItem, error) {
+	var out []OrderItem
+	items := in.Items
+	if len(items) == 0 && in.ProductID != 0 {
+		items = []orderItemInput{{ProductID: in.ProductID, Variant: in.Variant, Quantity: in.Quantity}}
+	}
+	if len(items) == 0 {
+		return nil, orderError{"items required"}
+	}
+	if len(items) > maxOrderItems {
+		return nil, orderError{fmt.Sprintf("at most %d items per order", maxOrderItems)}
+	}
+
+	// merge repeated product/variant lines
+	type lineKey struct {
+		id      int64
+		variant string
+	}
+	index := make(map[lineKey]int)
+	for _, it := range items {
+		if it.Quantity == 0 {
+			it.Quantity = 1
+		}
+		if it.Quantity < 0 || it.Quantity > maxOrderQuantity {
+			return nil, orderError{fmt.Sprintf("quantity must be between 1 and %d", maxOrderQuantity)}
+		}
+		it.Variant = strings.TrimSpace(it.Variant)
+		if utf8.RuneCountInString(it.Variant) > 255 {
+			return nil, orderError{"variant too long"}
+		}
+		k := lineKey{it.ProductID, it.Variant}
+		if i, ok := index[k]; ok {
+			out[i].Quantity += it.Quantity
+			continue
+		}
+		p, err := orderableProduct(db, it.ProductID)
+		if err != nil {
+			return nil, err
+		}
+		index[k] = len(out)
+		out = append(out, OrderItem{ProductID: p.ID, Title: p.Title, Variant: it.Variant, Quantity: it.Quantity, UnitPrice: p.EffectivePrice})
+	}
+	// stock is only reserved on confirmation, but refuse what clearly cannot be fulfilled
+	need := make(map[int64]int)
+	for _, it := range out {
+		need[it.ProductID] += it.Quantity
+		if need[it.ProductID] > maxOrderQuantity {
+			return nil, orderError{fmt.Sprintf("quantity must be between 1 and %d", maxOrderQuantity)}
+		}
+	}
+	for id, n := range need {
+		p, _, err := fetchProduct(db, id)
+		if err != nil {
+			return nil, err
+		}
+		if p.Stock != nil && *p.Stock < n {
+			return nil, orderError{fmt.Sprintf("%s chỉ còn %d sản phẩm", p.Title, *p.Stock)}
+		}
+	}
+	return out, nil
+}
+
+// buildOrder validates in against the catalog and returns the order to store along
+// with the coupon it uses (zero ID when none).
+func buildOrder(db *sql.DB, in orderInput) (Order, Coupon, error) {
+	o := Order{
+		CustomerName: strings.TrimSpace(in.CustomerName),
+		Address:      strings.TrimSpace(in.Address),
+		Note:         strings.TrimSpace(in.Note),
+		Status:       orderNew,
+		Currency:     shopCurrency(db).Code,
+	}
+	if o.CustomerName == "" || utf8.RuneCountInString(o.CustomerName) > 255 {
+		return o, Coupon{}, orderError{"customer_name required"}
+	}
+	phone, ok := normalizePhone(in.Phone)
+	if !ok {
+		return o, Coupon{}, orderError{"invalid phone number"}
+	}
+	o.Phone = phone
+	if o.Address == "" || utf8.RuneCountInString(o.Address) > 1000 {
+		return o, Coupon{}, orderError{"address required"}
+	}
+	if utf8.RuneCountInString(o.Note) > 2000 {
+		return o, Coupon{}, orderError{"note too long"}
+	}
+	items, err := orderItems(db, in)
+	if err != nil {
+		return o, Coupon{}, err
+	}
+	o.Items = items
+	for _, it := range o.Items {
+		o.Subtotal += it.UnitPrice * Money(it.Quantity)
+	}
+	c, discount, err := couponDiscount(db, in.CouponCode, o.Subtotal)
+	if err != nil {
+		return o, Coupon{}, err
+	}
+	o.CouponCode, o.CouponID, o.Discount = c.Code, c.ID, discount
+	o.Total = o.Subtotal - o.Discount
+	o.TotalFormatted = currencyOrDefault(o.Currency).Format(o.Total)
+	return o, c, nil
+}
+
+// createOrder validates and stores a new order request.
+func createOrder(db *sql.DB, in orderInput) (Order, error) {
+	o, coupon, err := buildOrder(db, in)
+	if err != nil {
+		return o, err
+	}
+	now := time.Now()
+	o.CreatedAt = now.Format(time.RFC3339)
+	o.UpdatedAt = o.CreatedAt
+	if db == nil {
+		return DevCheckout(in.cartID, coupon.ID, o)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return o, err
+	}
+	defer tx.Rollback()
+	if in.cartID != "" {
+		// deleting the cart row locks it, so a second checkout of the same cart
+		// waits for this one and then finds nothing to delete
+		if err := takeCartTx(tx, in.cartID); err != nil {
+			return o, err
+		}
+	}
+	if coupon.ID != 0 {
+		if err := useCouponTx(tx, coupon.ID); err != nil {
+			return o, err
+		}
+	}
+	res, err := tx.Exec("INSERT INTO orders (shop_id, customer_name, phone, address, note, status, currency, subtotal_minor, discount_minor, coupon_code, coupon_id, total_minor, created_at, updated_at) VALUES (@shop_id, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		o.CustomerName, o.Phone, o.Address, o.Note, o.Status, o.Currency, int64(o.Subtotal), int64(o.Discount), sqlNullString(o.CouponCode), sqlNull(o.CouponID), int64(o.Total), now, now)
+	if err != nil {
+		return o, err
+	}
+	o.ID, _ = res.LastInsertId()
+	for _, it := range o.Items {
+		if _, err := tx.Exec("INSERT INTO order_items (shop_id, order_id, product_id, title, variant, quantity, unit_price_minor) VALUES (@shop_id, ?, ?, ?, ?, ?, ?)",
+			o.ID, it.ProductID, it.Title, it.Variant, it.Quantity, int64(it.UnitPrice)); err != nil {
+			return o, err
+		}
+	}
+	return o, tx.Commit()
+}
+
+// fetchOrders returns orders newest first, optionally only those with status.
+func fetchOrders(db *sql.DB, status string) ([]Order, error) {
+	if db == nil {
+		var out []Order
+		for _, o := range DevGetOrders() {
+			if status == "" || o.Status == status {
+				out = append(out, o)
+			}
+		}
+		return out, nil
+	}
+	if status != "" {
+		return queryOrders(db, maxListedOrders, "AND status = ?", status)
+	}
+	return queryOrders(db, maxListedOrders, "")
+}
+
+// fetchOrder returns a single order. The boolean is false when it does not exist.
+func fetchOrder(db *sql.DB, id int64) (Order, bool, error) {
+	if db == nil {
+		for _, o := range DevGetOrders() {
+			if o.ID == id {
+				return o, true, nil
+			}
+		}
+		return Order{}, false, nil
+	}
+	orders, err := queryOrders(db, 1, "AND id = ?", id)
+	if err != nil || len(orders) == 0 {
+		return Order{}, false, err
+	}
+	return orders[0], true, nil
+}
+
+// maxListedOrders caps the admin order list.
+const maxListedOrders = 500
+
+// fetchAllOrders returns every order of the shop, newest first, for backups.
+func fetchAllOrders(db *sql.DB) ([]Order, error) {
+	if db == nil {
+		return DevGetOrders(), nil
+	}
+	return queryOrders(db, 0, "")
+}
+
+// queryOrders loads the shop's orders matching the extra conditions in where, e.g.
+// "AND id = ?" (newest first, at most limit; 0 loads all), with their items.
+func queryOrders(db *sql.DB, limit int, where string, args ...interface{}) ([]Order, error) {
+	q := "SELECT id, customer_name, phone, address, IFNULL(note,''), status, IFNULL(admin_note,''), IFNULL(currency,''), IFNULL(subtotal_minor,0), IFNULL(discount_minor,0), IFNULL(coupon_code,''), IFNULL(coupon_id,0), IFNULL(total_minor,0), created_at, updated_at FROM orders WHERE shop_id = @shop_id " +
+		where + " ORDER BY created_at DESC, id DESC"
+	if limit > 0 {
+		q += " LIMIT " + strconv.Itoa(limit)
+	}
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query orders: %w", err)
+	}
+	defer rows.Close()
+	var out []Order
+	byID := make(map[int64]int)
+	for rows.Next() {
+		var o Order
+		var created, updated interface{}
+		if err := rows.Scan(&o.ID, &o.CustomerName, &o.Phone, &o.Address, &o.Note, &o.Status, &o.AdminNote, &o.Currency, &o.Subtotal, &o.Discount, &o.CouponCode, &o.CouponID, &o.Total, &created, &updated); err != nil {
+			return nil, fmt.Errorf("scan order: %w", err)
+		}
+		o.TotalFormatted = currencyOrDefault(o.Currency).Format(o.Total)
+		o.CreatedAt, o.UpdatedAt = formatDBTime(created), formatDBTime(updated)
+		byID[o.ID] = len(out)
+		out = append(out, o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return out, nil
+	}
+	itemQuery := "SELECT order_id, product_id, title, IFNULL(variant,''), quantity, IFNULL(unit_price_minor,0) FROM order_items WHERE shop_id = @shop_id"
+	var ids []interface{}
+	if limit > 0 {
+		// all orders are loaded for backups; skip the (possibly huge) id list then
+		itemQuery += " AND order_id IN (" + strings.TrimSuffix(strings.Repeat("?,", len(out)), ",") + ")"
+		for _, o := range out {
+			ids = append(ids, o.ID)
+		}
+	}
+	itemRows, err := db.Query(itemQuery+" ORDER BY id", ids...)
+	if err != nil {
+		return nil, fmt.Errorf("query order items: %w", err)
+	}
+	defer itemRows.Close()
+	for itemRows.Next() {
+		var orderID int64
+		var it OrderItem
+		if err := itemRows.Scan(&orderID, &it.ProductID, &it.Title, &it.Variant, &it.Quantity, &it.UnitPrice); err != nil {
+			return nil, fmt.Errorf("scan order item: %w", err)
+		}
+		if i, ok := byID[orderID]; ok {
+			out[i].Items = append(out[i].Items, it)
+		}
+	}
+	return out, itemRows.Err()
+}
+
+// transitionOrder changes the status (and optionally the admin note) of an order,
+// reserving or releasing stock as needed. to may equal the current status to only
+// update the note.
+func transitionOrder(db *sql.DB, id int64, to string, adminNote *string) (Order, error) {
+	if db == nil {
+		return DevTransitionOrder(id, to, adminNote)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return Order{}, err
+	}
+	defer tx.Rollback()
+	var from, coupon string
+	var couponID int64
+	if err := tx.QueryRow("SELECT status, IFNULL(coupon_code,''), IFNULL(coupon_id,0) FROM orders WHERE id = ? AND shop_id = @shop_id FOR UPDATE", id).Scan(&from, &coupon, &couponID); err == sql.ErrNoRows {
+		return Order{}, errOrderNotFound
+	} else if err != nil {
+		return Order{}, err
+	}
+	if to != from {
+		if !canTransitionOrder(from, to) {
+			return Order{}, orderError{fmt.Sprintf("cannot change order from %s to %s", from, to)}
+		}
+		reserve, release := !stockReserved(from) && stockReserved(to), stockReserved(from) && !stockReserved(to)
+		if reserve || release {
+			if err := adjustOrderStock(tx, id, reserve); err != nil {
+				return Order{}, err
+			}
+		}
+		// a cancelled order gives its coupon use back; orders stored before
+		// coupon_id existed only know the code
+		if to == orderCancelled && (couponID != 0 || coupon != "") {
+			where, arg := "id = ?", interface{}(couponID)
+			if couponID == 0 {
+				where, arg = "code = ?", coupon
+			}
+			if _, err := tx.Exec("UPDATE coupons SET used_count = used_count - 1 WHERE "+where+" AND shop_id = @shop_id AND used_count > 0", arg); err != nil {
+				return Order{}, err
+			}
+		}
+	}
+	query := "UPDATE orders SET status = ?"
+	args := []interface{}{to}
+	if adminNote != nil {
+		query += ", admin_note = ?"
+		args = append(args, *adminNote)
+	}
+	if _, err := tx.Exec(query+" WHERE id = ? AND shop_id = @shop_id", append(args, id)...); err != nil {
+		return Order{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Order{}, err
+	}
+	o, _, err := fetchOrder(db, id)
+	return o, err
+}
+
+// adjustOrderStock takes (reserve) or gives back the stock of every line of an order.
+// Products without tracked stock or that were deleted are skipped. A product whose
+// stock reaches zero is marked sold out, and back to published when restocked.
+func adjustOrderStock(tx *sql.Tx, orderID int64, reserve bool) error {
+	rows, err := tx.Query("SELECT product_id, SUM(quantity) FROM order_items WHERE order_id = ? AND shop_id = @shop_id GROUP BY product_id", orderID)
+	if err != nil {
+		return err
+	}
+	need := make(map[int64]int)
+	for rows.Next() {
+		var pid int64
+		var n int
+		if err := rows.Scan(&pid, &n); err != nil {
+			rows.Close()
+			return err
+		}
+		need[pid] = n
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for pid, n := range need {
+		var title string
+		var stock sql.NullInt64
+		err := tx.QueryRow("SELECT title, stock FROM products WHERE id = ? AND shop_id = @shop_id FOR UPDATE", pid).Scan(&title, &stock)
+		if err == sql.ErrNoRows || (err == nil && !stock.Valid) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		left := int(stock.Int64) + n
+		if reserve {
+			left = int(stock.Int64) - n
+			if left < 0 {
+				return fmt.Errorf("%w: %s (còn %d)", errInsufficientStock, title, stock.Int64)
+			}
+		}
+		if _, err := tx.Exec(`UPDATE products SET stock = ?,
+			status = CASE WHEN ? = 0 AND status = 'published' THEN 'sold_out' WHEN ? > 0 AND status = 'sold_out' THEN 'published' ELSE status END
+			WHERE id = ? AND shop_id = @shop_id`, left, left, left, pid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stockStatus returns the product status after its stock changed to left.
+func stockStatus(status string, left int) string {
+	switch {
+	case left == 0 && status == statusPublished:
+		return statusSoldOut
+	case left > 0 && status == statusSoldOut:
+		return statusPublished
+	}
+	return status
+}
+
+// ordersHandler serves /api/orders: POST (public) submits an order request,
+// GET (admin) lists orders, optionally filtered by ?status=.
+func ordersHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			var in orderInput
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&in); err != nil {
+				http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			o, err := createOrder(db, in)
+			var oe orderError
+			if errors.As(err, &oe) {
+				http.Error(w, oe.Error(), http.StatusBadRequest)
+				return
+			}
+			if err != nil {
+				log.Println("createOrder error:", err)
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
+			log.Printf("new order id=%d items=%d total=%s remote=%s", o.ID, len(o.Items), currencyOrDefault(o.Currency).Format(o.Total), r.RemoteAddr)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(o)
+
+		case http.MethodGet:
+			if !isAdmin(r) {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			status := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("status")))
+			if status != "" && !validOrderStatus(status) {
+				http.Error(w, "invalid status", http.StatusBadRequest)
+				return
+			}
+			orders, err := fetchOrders(db, status)
+			if err != nil {
+				log.Println("fetchOrders error:", err)
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
+			if orders == nil {
+				orders = []Order{}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(orders)
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// orderItemHandler serves admin GET and PUT {"status", "admin_note"} on /api/orders/{id}.
+func orderItemHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/api/orders/"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			o, ok, err := fetchOrder(db, id)
+			if err != nil {
+				log.Println("fetchOrder error:", err)
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				http.Error(w, "not found", http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(o)
+
+		case http.MethodPut:
+			var payload struct {
+				Status    string  `json:"status"`
+				AdminNote *string `json:"admin_note"`
+			}
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(&payload); err != nil {
+				http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			cur, ok, err := fetchOrder(db, id)
+			if err != nil {
+				log.Println("fetchOrder error:", err)
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				http.Error(w, "not found", http.StatusNotFound)
+				return
+			}
+			to := strings.ToLower(strings.TrimSpace(payload.Status))
+			if to == "" {
+				to = cur.Status
+			}
+			if !validOrderStatus(to) {
+				http.Error(w, "invalid status", http.StatusBadRequest)
+				return
+			}
+			o, err := transitionOrder(db, id, to, payload.AdminNote)
+			var oe orderError
+			switch {
+			case errors.As(err, &oe):
+				http.Error(w, oe.Error(), http.StatusBadRequest)
+				return
+			case errors.Is(err, errInsufficientStock):
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			case errors.Is(err, errOrderNotFound):
+				http.Error(w, "not found", http.StatusNotFound)
+				return
+			case err != nil:
+				log.Println("transitionOrder error:", err)
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
+			log.Printf("order id=%d status %s -> %s", id, cur.Status, o.Status)
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(o)
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
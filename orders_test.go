@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCanTransitionOrder(t *testing.T) {
+	allowed := map[[2]string]bool{
+		{orderNew, orderConfirmed}:       true,
+		{orderNew, orderCancelled}:       true,
+		{orderConfirmed, orderShipped}:   true,
+		{orderConfirmed, orderCancelled}: true,
+		{orderShipped, orderDone}:        true,
+		{orderShipped, orderCancelled}:   true,
+	}
+	statuses := []string{orderNew, orderConfirmed, orderShipped, orderDone, orderCancelled, "bogus"}
+	for _, from := range statuses {
+		for _, to := range statuses {
+			want := allowed[[2]string{from, to}]
+			if got := canTransitionOrder(from, to); got != want {
+				t.Errorf("canTransitionOrder(%s, %s) = %v, want %v", from, to, got, want)
+			}
+		}
+	}
+}
+
+func TestStockStatus(t *testing.T) {
+	tests := []struct {
+		status string
+		left   int
+		want   string
+	}{
+		{statusPublished, 0, statusSoldOut},
+		{statusPublished, 3, statusPublished},
+		{statusSoldOut, 1, statusPublished},
+		{statusSoldOut, 0, statusSoldOut},
+		{statusDraft, 0, statusDraft},
+		{statusDraft, 5, statusDraft},
+	}
+	for _, tt := range tests {
+		if got := stockStatus(tt.status, tt.left); got != tt.want {
+			t.Errorf("stockStatus(%s, %d) = %s, want %s", tt.status, tt.left, got, tt.want)
+		}
+	}
+}
+
+// useDevOrders replaces the in-memory products and orders for one test.
+func useDevOrders(t *testing.T, products []Product, orders []Order) {
+	t.Helper()
+	devMu.Lock()
+	savedProducts, savedOrders := devProducts, devOrders
+	devProducts, devOrders = products, orders
+	devMu.Unlock()
+	invalidateCatalogCache()
+	t.Cleanup(func() {
+		devMu.Lock()
+		devProducts, devOrders = savedProducts, savedOrders
+		devMu.Unlock()
+		invalidateCatalogCache()
+	})
+}
+
+func devStock(t *testing.T, id int64) (int, string) {
+	t.Helper()
+	for _, p := range DevGetProducts() {
+		if p.ID == id {
+			if p.Stock == nil {
+				return -1, p.Status
+			}
+			return *p.Stock, p.Status
+		}
+	}
+	t.Fatalf("product %d not found", id)
+	return 0, ""
+}
+
+func TestDevTransitionOrderStock(t *testing.T) {
+	intp := func(n int) *int { return &n }
+	items := []OrderItem{
+		{ProductID: 1, Quantity: 2},
+		{ProductID: 1, Quantity: 1},
+		{ProductID: 2, Quantity: 4}, // untracked stock
+	}
+	useDevOrders(t,
+		[]Product{
+			{ID: 1, Title: "Áo", Status: statusPublished, Stock: intp(3)},
+			{ID: 2, Title: "Quần", Status: statusPublished},
+		},
+		[]Order{{ID: 1, Status: orderNew, Items: items}},
+	)
+
+	if _, err := DevTransitionOrder(1, orderConfirmed, nil); err != nil {
+		t.Fatalf("confirm: %v", err)
+	}
+	if n, status := devStock(t, 1); n != 0 || status != statusSoldOut {
+		t.Errorf("after confirm: stock %d %s, want 0 %s", n, status, statusSoldOut)
+	}
+	if n, _ := devStock(t, 2); n != -1 {
+		t.Errorf("untracked stock became %d", n)
+	}
+	if _, err := DevTransitionOrder(1, orderShipped, nil); err != nil {
+		t.Fatalf("ship: %v", err)
+	}
+	if n, _ := devStock(t, 1); n != 0 {
+		t.Errorf("shipping moved stock to %d", n)
+	}
+	if _, err := DevTransitionOrder(1, orderCancelled, nil); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	if n, status := devStock(t, 1); n != 3 || status != statusPublished {
+		t.Errorf("after cancel: stock %d %s, want 3 %s", n, status, statusPublished)
+	}
+	if _, err := DevTransitionOrder(1, orderConfirmed, nil); err == nil {
+		t.Error("cancelled order was confirmed again")
+	}
+}
+
+func TestDevTransitionOrderCancelNewKeepsStock(t *testing.T) {
+	stock := 5
+	useDevOrders(t,
+		[]Product{{ID: 1, Status: statusPublished, Stock: &stock}},
+		[]Order{{ID: 1, Status: orderNew, Items: []OrderItem{{ProductID: 1, Quantity: 2}}}},
+	)
+	if _, err := DevTransitionOrder(1, orderCancelled, nil); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	if n, _ := devStock(t, 1); n != 5 {
+		t.Errorf("cancelling a new order changed stock to %d, want 5", n)
+	}
+}
+
+func TestDevTransitionOrderInsufficientStock(t *testing.T) {
+	stock := 1
+	useDevOrders(t,
+		[]Product{{ID: 1, Title: "Áo", Status: statusPublished, Stock: &stock}},
+		[]Order{{ID: 1, Status: orderNew, Items: []OrderItem{{ProductID: 1, Quantity: 2}}}},
+	)
+	_, err := DevTransitionOrder(1, orderConfirmed, nil)
+	if !errors.Is(err, errInsufficientStock) {
+		t.Fatalf("confirm = %v, want errInsufficientStock", err)
+	}
+	if n, _ := devStock(t, 1); n != 1 {
+		t.Errorf("failed confirm changed stock to %d", n)
+	}
+	if o := DevGetOrders()[0]; o.Status != orderNew {
+		t.Errorf("failed confirm moved order to %s", o.Status)
+	}
+}
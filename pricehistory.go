@@ -0,0 +1,208 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Where a price change came from.
+const (
+	priceSourceAdmin  = "admin"       // product edit
+	priceSourceBulk   = "bulk"        // bulk price adjustment
+	priceSourceImport = "import"      // CSV catalog import
+	priceSourceShopee = "shopee_sync" // Shopee listing sync
+)
+
+// priceDropWindow: a product shows as price dropped while its price is below the
+// highest price it had within this window.
+const priceDropWindow = 30 * 24 * time.Hour
+
+// recordPriceChange appends a price_history entry when oldPrice != newPrice. It
+// writes inside tx when one is given, otherwise to db (or the dev store).
+func recordPriceChange(db *sql.DB, tx *sql.Tx, productID int64, oldPrice, newPrice Money, source string) error {
+	if oldPrice == newPrice {
+		return nil
+	}
+	c := PriceChange{ProductID: productID, OldPrice: oldPrice, NewPrice: newPrice, Source: source, ChangedAt: time.Now()}
+	const q = "INSERT INTO price_history (shop_id, product_id, old_price_minor, new_price_minor, source, changed_at) VALUES (@shop_id, ?, ?, ?, ?, ?)"
+	var err error
+	switch {
+	case tx != nil:
+		_, err = tx.Exec(q, c.ProductID, int64(c.OldPrice), int64(c.NewPrice), c.Source, c.ChangedAt)
+	case db != nil:
+		_, err = db.Exec(q, c.ProductID, int64(c.OldPrice), int64(c.NewPrice), c.Source, c.ChangedAt)
+	default:
+		DevAddPriceChange(c)
+	}
+	return err
+}
+
+// updateProductTx applies an UPDATE built from setCols/args to product id. When
+// newPrice is set, the old price is locked and read first and the change is
+// recorded in price_history, all in one transaction.
+func updateProductTx(db *sql.DB, id int64, setCols []string, args []interface{}, newPrice *Money) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var oldPrice Money
+	if newPrice != nil {
+		if err := tx.QueryRow("SELECT IFNULL(price_minor,0) FROM products WHERE id = ? AND shop_id = @shop_id FOR UPDATE", id).Scan(&oldPrice); err != nil {
+			return fmt.Errorf("load old price: %w", err)
+		}
+	}
+	query := "UPDATE products SET " + strings.Join(setCols, ", ") + " WHERE id = ? AND shop_id = @shop_id"
+	if _, err := tx.Exec(query, append(args, id)...); err != nil {
+		return err
+	}
+	if newPrice != nil {
+		if err := recordPriceChange(nil, tx, id, oldPrice, *newPrice, priceSourceAdmin); err != nil {
+			return fmt.Errorf("record price change: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// fetchPriceHistory returns the newest limit price changes of a product, newest first.
+func fetchPriceHistory(db *sql.DB, productID int64, limit int) ([]PriceChange, error) {
+	if db == nil {
+		var out []PriceChange
+		all := DevGetPriceHistory()
+		for i := len(all) - 1; i >= 0 && len(out) < limit; i-- {
+			if all[i].ProductID == productID {
+				out = append(out, all[i])
+			}
+		}
+		return out, nil
+	}
+	rows, err := db.Query(`SELECT id, product_id, old_price_minor, new_price_minor, source, changed_at
+		FROM price_history WHERE product_id = ? AND shop_id = @shop_id ORDER BY changed_at DESC, id DESC LIMIT ?`, productID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query price history: %w", err)
+	}
+	defer rows.Close()
+	var out []PriceChange
+	for rows.Next() {
+		var c PriceChange
+		var changed interface{}
+		if err := rows.Scan(&c.ID, &c.ProductID, &c.OldPrice, &c.NewPrice, &c.Source, &changed); err != nil {
+			return nil, fmt.Errorf("scan price history: %w", err)
+		}
+		if t := parseDBTime(changed); t != nil {
+			c.ChangedAt = *t
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// recentMaxPrices returns, per product, the highest price replaced since since.
+// onlyID limits the lookup to one product (0 = all).
+func recentMaxPrices(db *sql.DB, since time.Time, onlyID int64) (map[int64]Money, error) {
+	out := map[int64]Money{}
+	if db == nil {
+		for _, c := range DevGetPriceHistory() {
+			if c.ChangedAt.Before(since) || (onlyID != 0 && c.ProductID != onlyID) {
+				continue
+			}
+			if c.OldPrice > out[c.ProductID] {
+				out[c.ProductID] = c.OldPrice
+			}
+		}
+		return out, nil
+	}
+	q := "SELECT product_id, MAX(old_price_minor) FROM price_history WHERE shop_id = @shop_id AND changed_at >= ?"
+	args := []interface{}{since}
+	if onlyID != 0 {
+		q += " AND product_id = ?"
+		args = append(args, onlyID)
+	}
+	rows, err := db.Query(q+" GROUP BY product_id", args...)
+	if err != nil {
+		return nil, fmt.Errorf("query recent prices: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var high Money
+		if err := rows.Scan(&id, &high); err != nil {
+			return nil, fmt.Errorf("scan recent prices: %w", err)
+		}
+		out[id] = high
+	}
+	return out, rows.Err()
+}
+
+// setPriceDrops fills PriceDropped and RecentMaxPrice for products whose current
+// (effective) price is below their highest regular price within priceDropWindow,
+// so a running sale counts as a drop too. onlyID narrows the lookup as in
+// recentMaxPrices. Run it after applyPricing.
+func setPriceDrops(db *sql.DB, products []Product, onlyID int64) error {
+	maxes, err := recentMaxPrices(db, time.Now().Add(-priceDropWindow), onlyID)
+	if err != nil {
+		return err
+	}
+	for i := range products {
+		p := &products[i]
+		high := maxes[p.ID]
+		if p.Price > high {
+			high = p.Price
+		}
+		if p.EffectivePrice > 0 && p.EffectivePrice < high {
+			p.PriceDropped, p.RecentMaxPrice = true, high
+		}
+	}
+	return nil
+}
+
+// priceHistoryHandler serves GET /api/products/{id}/price-history?limit=N
+// (default 100, at most 500), newest change first.
+func priceHistoryHandler(db *sql.DB, productID int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		p, ok, err := fetchProduct(db, productID)
+		if err != nil {
+			log.Println("priceHistory fetch error:", err)
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		if !ok || (p.Status == statusDraft && !isAdmin(r)) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		limit := 100
+		if s := r.URL.Query().Get("limit"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 {
+				http.Error(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+			if n < 500 {
+				limit = n
+			} else {
+				limit = 500
+			}
+		}
+		history, err := fetchPriceHistory(db, productID, limit)
+		if err != nil {
+			log.Println("priceHistory error:", err)
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		if history == nil {
+			history = []PriceChange{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(history)
+	}
+}
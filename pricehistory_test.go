@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// useDevPriceHistory gives one test its own in-memory price history.
+func useDevPriceHistory(t *testing.T, changes []PriceChange) {
+	t.Helper()
+	devMu.Lock()
+	saved, savedNext := devPriceHistory, devNextPriceChangeID
+	devPriceHistory, devNextPriceChangeID = changes, 100
+	devMu.Unlock()
+	t.Cleanup(func() {
+		devMu.Lock()
+		devPriceHistory, devNextPriceChangeID = saved, savedNext
+		devMu.Unlock()
+	})
+}
+
+func TestRecordPriceChange(t *testing.T) {
+	useDevPriceHistory(t, nil)
+	steps := []struct{ old, new Money }{{100000, 100000}, {100000, 90000}, {90000, 95000}, {95000, 80000}}
+	for _, s := range steps {
+		if err := recordPriceChange(nil, nil, 1, s.old, s.new, priceSourceAdmin); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := recordPriceChange(nil, nil, 2, 5000, 6000, priceSourceBulk); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := fetchPriceHistory(nil, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the unchanged price is not recorded; newest first, limited
+	if len(history) != 2 || history[0].NewPrice != 80000 || history[1].NewPrice != 95000 {
+		t.Errorf("history = %+v, want the 80000 and 95000 changes", history)
+	}
+	if all, _ := fetchPriceHistory(nil, 1, 100); len(all) != 3 {
+		t.Errorf("product 1 has %d changes, want 3", len(all))
+	}
+}
+
+func TestSetPriceDrops(t *testing.T) {
+	now := time.Now()
+	useDevPriceHistory(t, []PriceChange{
+		{ProductID: 1, OldPrice: 120000, NewPrice: 100000, ChangedAt: now.Add(-24 * time.Hour)},
+		{ProductID: 2, OldPrice: 150000, NewPrice: 100000, ChangedAt: now.Add(-priceDropWindow - time.Hour)},
+		{ProductID: 3, OldPrice: 80000, NewPrice: 100000, ChangedAt: now.Add(-time.Hour)},
+	})
+	sale := Money(70000)
+	products := []Product{
+		{ID: 1, Price: 100000},                   // cut yesterday
+		{ID: 2, Price: 100000},                   // cut before the window
+		{ID: 3, Price: 100000},                   // raised
+		{ID: 4, Price: 100000, SalePrice: &sale}, // running sale, no history
+		{ID: 5, Price: 0},                        // free / price unknown
+		{ID: 6, Price: 100000, SalePrice: &sale}, // sale that has not started
+	}
+	later := now.Add(time.Hour)
+	products[5].SaleStartsAt = &later
+	applyPricing(products, currencies["VND"])
+	if err := setPriceDrops(nil, products, 0); err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		dropped bool
+		high    Money
+	}{{true, 120000}, {false, 0}, {false, 0}, {true, 100000}, {false, 0}, {false, 0}}
+	for i, p := range products {
+		if p.PriceDropped != want[i].dropped || p.RecentMaxPrice != want[i].high {
+			t.Errorf("product %d: dropped = %v, recent max = %d; want %v, %d", p.ID, p.PriceDropped, p.RecentMaxPrice, want[i].dropped, want[i].high)
+		}
+	}
+
+	// onlyID ignores the history of other products
+	one := []Product{{ID: 1, Price: 100000, EffectivePrice: 100000}}
+	if err := setPriceDrops(nil, one, 3); err != nil {
+		t.Fatal(err)
+	}
+	if one[0].PriceDropped {
+		t.Error("product 1 flagged from a lookup limited to product 3")
+	}
+}
+
+func TestPriceHistoryHandler(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "t")
+	useDevOrders(t, []Product{{ID: 1, Price: 100000, Status: statusPublished}, {ID: 2, Price: 100000, Status: statusDraft}}, nil)
+	useDevPriceHistory(t, nil)
+	tests := []struct {
+		name, query, token string
+		id                 int64
+		want               int
+	}{
+		{"published", "", "", 1, http.StatusOK},
+		{"limit", "?limit=1000", "", 1, http.StatusOK},
+		{"bad limit", "?limit=0", "", 1, http.StatusBadRequest},
+		{"unknown product", "", "", 9, http.StatusNotFound},
+		{"draft", "", "", 2, http.StatusNotFound},
+		{"draft as admin", "", "t", 2, http.StatusOK},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/api/products/x/price-history"+tt.query, nil)
+		if tt.token != "" {
+			r.Header.Set("X-Admin-Token", tt.token)
+		}
+		w := httptest.NewRecorder()
+		priceHistoryHandler(nil, tt.id)(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
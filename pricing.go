@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// applyPricing sets EffectivePrice and OnSale from the regular price and the sale
+// window, and the display fields for cur. A sale price that is not below the
+// regular price is ignored.
+func (p *Product) applyPricing(now time.Time, cur Currency) {
+	p.Currency = cur.Code
+	p.PriceFormatted = cur.Format(p.Price)
+	p.EffectivePrice, p.OnSale = p.Price, false
+	defer func() { p.EffectivePriceFormatted = cur.Format(p.EffectivePrice) }()
+	if p.SalePrice == nil || *p.SalePrice >= p.Price {
+		return
+	}
+	if p.SaleStartsAt != nil && now.Before(*p.SaleStartsAt) {
+		return
+	}
+	if p.SaleEndsAt != nil && !now.Before(*p.SaleEndsAt) {
+		return
+	}
+	p.EffectivePrice, p.OnSale = *p.SalePrice, true
+}
+
+// applyPricing prices every product at the current time.
+func applyPricing(products []Product, cur Currency) {
+	now := time.Now()
+	for i := range products {
+		products[i].applyPricing(now, cur)
+	}
+}
+
+// parseOptionalPrice parses a non-negative price in major units of cur; blank
+// means none (nil).
+func parseOptionalPrice(v string, cur Currency) (*Money, error) {
+	if strings.TrimSpace(v) == "" {
+		return nil, nil
+	}
+	m, err := cur.Parse(v)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// parseFormTime accepts RFC 3339, an HTML datetime-local value or a plain date
+// (the latter two in server local time). Blank means none (nil).
+func parseFormTime(v string) (*time.Time, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return &t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04", "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, v, time.Local); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, errors.New("invalid time " + strconv.Quote(v))
+}
+
+// parseDBTime converts a nullable DATETIME scanned into interface{} (time.Time or
+// text depending on the driver's parseTime setting).
+func parseDBTime(v interface{}) *time.Time {
+	switch t := v.(type) {
+	case time.Time:
+		return &t
+	case []byte, string:
+		s := formatDBTime(t)
+		for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05"} {
+			if parsed, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+				return &parsed
+			}
+		}
+	}
+	return nil
+}
+
+// sqlNullTime maps a nil time to NULL.
+func sqlNullTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return *t
+}
+
+// sqlNullPrice maps a nil price to NULL.
+func sqlNullPrice(p *Money) interface{} {
+	if p == nil {
+		return nil
+	}
+	return int64(*p)
+}
+
+// saleInput holds the sale fields submitted with a product form. The has* flags
+// report which fields were present, so partial updates leave the others alone.
+type saleInput struct {
+	price                        *Money
+	starts, ends                 *time.Time
+	hasPrice, hasStarts, hasEnds bool
+}
+
+// parseSaleInput reads sale_price (major units of cur), sale_starts_at and
+// sale_ends_at from form values.
+func parseSaleInput(values map[string][]string, cur Currency) (saleInput, error) {
+	var in saleInput
+	var err error
+	if v, ok := values["sale_price"]; ok && len(v) > 0 {
+		in.hasPrice = true
+		if in.price, err = parseOptionalPrice(v[0], cur); err != nil {
+			return in, errors.New("invalid sale_price: " + err.Error())
+		}
+	}
+	if v, ok := values["sale_starts_at"]; ok && len(v) > 0 {
+		in.hasStarts = true
+		if in.starts, err = parseFormTime(v[0]); err != nil {
+			return in, err
+		}
+	}
+	if v, ok := values["sale_ends_at"]; ok && len(v) > 0 {
+		in.hasEnds = true
+		if in.ends, err = parseFormTime(v[0]); err != nil {
+			return in, err
+		}
+	}
+	return in, nil
+}
+
+// any reports whether any sale field was submitted.
+func (in saleInput) any() bool { return in.hasPrice || in.hasStarts || in.hasEnds }
+
+// apply copies the submitted fields onto p and validates the resulting window.
+func (in saleInput) apply(p *Product) error {
+	if in.hasPrice {
+		p.SalePrice = in.price
+	}
+	if in.hasStarts {
+		p.SaleStartsAt = in.starts
+	}
+	if in.hasEnds {
+		p.SaleEndsAt = in.ends
+	}
+	if p.SaleStartsAt != nil && p.SaleEndsAt != nil && !p.SaleEndsAt.After(*p.SaleStartsAt) {
+		return errors.New("sale_ends_at must be after sale_starts_at")
+	}
+	return nil
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"database/sql"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// productPagePath is the shareable server-rendered page of p, e.g. "/p/12-ao-khoac-jean".
+func productPagePath(p Product) string {
+	path := "/p/" + strconv.FormatInt(p.ID, 10)
+	if slug := slugify(p.Title); slug != "" {
+		path += "-" + slug
+	}
+	return path
+}
+
+// setPagePaths fills PageURL for every product.
+func setPagePaths(products []Product) {
+	for i := range products {
+		products[i].PageURL = productPagePath(products[i])
+	}
+}
+
+// siteURL is the public address of the shop used in absolute links: its origin
+// (PUBLIC_BASE_URL when set, otherwise derived from the request: its host when
+// known to publicHost, and X-Forwarded-Proto when a trusted proxy sent it)
+// followed by the shop's /@username prefix, if any. Shops served on their own
+// subdomain use <username>.SHOP_DOMAIN with the scheme of PUBLIC_BASE_URL, or
+// the request's host when it is not set.
+func siteURL(r *http.Request) string {
+	sr, _ := r.Context().Value(shopContextKey{}).(shopRequest)
+	if v := strings.TrimRight(os.Getenv("PUBLIC_BASE_URL"), "/"); v != "" {
+		if sr.subdomain {
+			scheme, _, _ := strings.Cut(v, "://")
+			return scheme + "://" + sr.site.Username + "." + strings.ToLower(strings.Trim(os.Getenv("SHOP_DOMAIN"), "."))
+		}
+		return v + sr.base
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	if p := r.Header.Get("X-Forwarded-Proto"); (p == "https" || p == "http") && isTrustedProxy(remote) {
+		scheme = p
+	}
+	return scheme + "://" + publicHost(r) + sr.base
+}
+
+// publicHost returns r.Host when it is a known host of the site: an
+// ALLOWED_HOSTS entry, SHOP_DOMAIN or one of its subdomains, or a loopback
+// address. Any other Host header may be spoofed and would end up in canonical
+// links, so the first ALLOWED_HOSTS entry, else SHOP_DOMAIN, else "localhost"
+// stands in for it.
+func publicHost(r *http.Request) string {
+	host := strings.ToLower(r.Host)
+	name := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		name = h
+	}
+	name = strings.TrimSuffix(name, ".")
+	var allowed []string
+	for _, h := range strings.Split(os.Getenv("ALLOWED_HOSTS"), ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			allowed = append(allowed, h)
+		}
+	}
+	for _, h := range allowed {
+		if h == host || h == name {
+			return host
+		}
+	}
+	domain := strings.ToLower(strings.Trim(os.Getenv("SHOP_DOMAIN"), "."))
+	if domain != "" && (name == domain || strings.HasSuffix(name, "."+domain)) {
+		return host
+	}
+	if ip := net.ParseIP(strings.Trim(name, "[]")); name == "localhost" || (ip != nil && ip.IsLoopback()) {
+		return host
+	}
+	switch {
+	case len(allowed) > 0:
+		return allowed[0]
+	case domain != "":
+		return domain
+	}
+	return "localhost"
+}
+
+// truncateRunes shortens s to at most n runes, adding an ellipsis when cut.
+func truncateRunes(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	r := []rune(s)
+	return strings.TrimSpace(string(r[:n-1])) + "…"
+}
+
+// productPageData feeds productPageTmpl.
+type productPageData struct {
+	Base        string // "/@username" when the shop is served under a path, else ""
+	Product     Product
+	Shop        string
+	URL         string // absolute canonical URL of the page
+	Image       string
+	Description string // plain, shortened for meta tags
+	Price       string // major units for product:price:amount
+	Currency    string
+	InStock     bool
+	BuyURL      string // Shopee link (tracked) for affiliate products
+	ShopURL     string // storefront with the product modal open
+	JSONLD      map[string]interface{}
+}
+
+// productJSONLD describes p as a schema.org Product with a single Offer.
+func productJSONLD(d productPageData) map[string]interface{} {
+	availability := "https://schema.org/InStock"
+	if !d.InStock {
+		availability = "https://schema.org/OutOfStock"
+	}
+	ld := map[string]interface{}{
+		"@context":    "https://schema.org",
+		"@type":       "Product",
+		"name":        d.Product.Title,
+		"description": d.Description,
+		"sku":         strconv.FormatInt(d.Product.ID, 10),
+		"url":         d.URL,
+		"offers": map[string]interface{}{
+			"@type":         "Offer",
+			"price":         d.Price,
+			"priceCurrency": d.Currency,
+			"availability":  availability,
+			"url":           d.URL,
+		},
+	}
+	if d.Image != "" {
+		ld["image"] = []string{d.Image}
+	}
+	if d.Product.Category != "" {
+		ld["category"] = d.Product.Category
+	}
+	if d.Shop != "" {
+		ld["brand"] = map[string]string{"@type": "Brand", "name": d.Shop}
+	}
+	return ld
+}
+
+var productPageTmpl = template.Must(template.New("product").Parse(`<!doctype html>
+<html lang="vi">
+  <head>
+    <meta charset="utf-8" />
+    <meta name="viewport" content="width=device-width,initial-scale=1" />
+    <title>{{.Product.Title}}{{if .Shop}} – {{.Shop}}{{end}}</title>
+    <meta name="description" content="{{.Description}}">
+    <link rel="canonical" href="{{.URL}}">
+    <meta property="og:type" content="product">
+    <meta property="og:title" content="{{.Product.Title}}">
+    <meta property="og:description" content="{{.Description}}">
+    <meta property="og:url" content="{{.URL}}">
+    {{if .Shop}}<meta property="og:site_name" content="{{.Shop}}">{{end}}
+    {{if .Image}}<meta property="og:image" content="{{.Image}}">{{end}}
+    <meta property="og:locale" content="vi_VN">
+    <meta property="product:price:amount" content="{{.Price}}">
+    <meta property="product:price:currency" content="{{.Currency}}">
+    <meta property="product:availability" content="{{if .InStock}}in stock{{else}}out of stock{{end}}">
+    <meta name="twitter:card" content="{{if .Image}}summary_large_image{{else}}summary{{end}}">
+    <meta name="twitter:title" content="{{.Product.Title}}">
+    <meta name="twitter:description" content="{{.Description}}">
+    {{if .Image}}<meta name="twitter:image" content="{{.Image}}">{{end}}
+    <script type="application/ld+json">{{.JSONLD}}</script>
+    <link rel="stylesheet" href="/static/style.css">
+  </head>
+  <body>
+    <main class="linktree-shell">
+      <section class="links-panel">
+        <p class="label"><a href="{{.Base}}/">← {{if .Shop}}{{.Shop}}{{else}}Về shop{{end}}</a></p>
+        {{if .Image}}<img src="{{.Image}}" alt="{{.Product.Title}}" style="width:100%;border-radius:12px">{{end}}
+        <h1>{{.Product.Title}}</h1>
+        <p class="price" style="font-size:1.2rem">
+          {{if .Product.OnSale}}<s class="muted">{{.Product.PriceFormatted}}</s> <span class="sale-price">{{.Product.EffectivePriceFormatted}}</span>
+          {{else if gt .Product.Price 0}}{{.Product.PriceFormatted}}{{else}}Liên hệ{{end}}
+        </p>
+        {{if .Product.Category}}<p class="muted">Danh mục: {{.Product.Category}}</p>{{end}}
+        {{if not .InStock}}<p class="muted">Sản phẩm đã hết hàng.</p>{{end}}
+        <p style="white-space:pre-line">{{.Product.Description}}</p>
+        <div class="form-actions" style="margin-top:1rem">
+          {{if .BuyURL}}<a class="btn primary" href="{{.BuyURL}}" rel="noreferrer">Mua trên Shopee</a>
+          {{else}}<a class="btn primary" href="{{.ShopURL}}">Đặt hàng</a>{{end}}
+        </div>
+      </section>
+    </main>
+  </body>
+</html>
+`))
+
+// productPageHandler serves /p/{id}-{slug}: a server-rendered product page with
+// Open Graph, Twitter card and schema.org JSON-LD so shared links get a preview.
+// Requests with a missing or outdated slug are redirected to the canonical path.
+func productPageHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		rest := strings.TrimPrefix(r.URL.Path, "/p/")
+		idStr, _, _ := strings.Cut(rest, "-")
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil || strings.Contains(rest, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		p, ok, err := fetchProduct(db, id)
+		if err != nil {
+			log.Println("productPage fetch error:", err)
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		if !ok || (p.Status == statusDraft && !isAdmin(r)) {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Path != p.PageURL {
+			target := shopBase(r) + p.PageURL
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+		profile, err := fetchProfile(db)
+		if err != nil {
+			log.Println("productPage profile error:", err)
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		cur := shopCurrency(db)
+		base := siteURL(r)
+		d := productPageData{
+			Base:        shopBase(r),
+			Product:     p,
+			Shop:        profile.DisplayName,
+			URL:         base + p.PageURL,
+			Image:       p.ImageURL,
+			Description: truncateRunes(p.Description, 200),
+			Price:       cur.Plain(p.EffectivePrice),
+			Currency:    cur.Code,
+			InStock:     p.Status != statusSoldOut && (p.Stock == nil || *p.Stock > 0),
+			ShopURL:     shopBase(r) + "/?product=" + strconv.FormatInt(p.ID, 10),
+		}
+		if d.Description == "" {
+			d.Description = p.Title
+		}
+		if p.Source == sourceShopee && p.TrackedURL != "" {
+			d.BuyURL = d.Base + p.TrackedURL
+		}
+		d.JSONLD = productJSONLD(d)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := productPageTmpl.Execute(w, d); err != nil {
+			log.Println("productPage render error:", err)
+		}
+	}
+}
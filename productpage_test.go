@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSiteURL(t *testing.T) {
+	saved := trustedProxies
+	t.Cleanup(func() { trustedProxies = saved })
+	trustedProxies = parseTrustedProxies("10.0.0.1")
+	anna := &shopSite{Shop: Shop{ID: 2, Username: "anna"}}
+
+	tests := []struct {
+		name, public, allowed, domain string
+		host, remote, proto           string
+		sr                            shopRequest
+		want                          string
+	}{
+		{"public base url", "https://shop.example.com/", "", "", "evil.test", "203.0.113.9:1", "", shopRequest{}, "https://shop.example.com"},
+		{"public base url with path shop", "https://shop.example.com", "", "", "evil.test", "203.0.113.9:1", "", shopRequest{site: anna, base: "/@anna"}, "https://shop.example.com/@anna"},
+		{"public base url with subdomain shop", "https://shop.example.com", "", "shops.example.com", "anna.shops.example.com", "203.0.113.9:1", "", shopRequest{site: anna, subdomain: true}, "https://anna.shops.example.com"},
+		{"allowed host", "", "shop.example.com, www.shop.example.com", "", "www.shop.example.com", "203.0.113.9:1", "", shopRequest{}, "http://www.shop.example.com"},
+		{"allowed host with port", "", "shop.example.com", "", "shop.example.com:8000", "203.0.113.9:1", "", shopRequest{}, "http://shop.example.com:8000"},
+		{"spoofed host", "", "shop.example.com", "", "evil.test", "203.0.113.9:1", "", shopRequest{}, "http://shop.example.com"},
+		{"shop subdomain", "", "", "shops.example.com", "anna.shops.example.com", "203.0.113.9:1", "", shopRequest{site: anna, subdomain: true}, "http://anna.shops.example.com"},
+		{"spoofed host falls back to SHOP_DOMAIN", "", "", "shops.example.com", "evil.test", "203.0.113.9:1", "", shopRequest{site: anna, base: "/@anna"}, "http://shops.example.com/@anna"},
+		{"lookalike of SHOP_DOMAIN", "", "", "shops.example.com", "evilshops.example.com", "203.0.113.9:1", "", shopRequest{}, "http://shops.example.com"},
+		{"loopback", "", "", "", "localhost:8000", "127.0.0.1:1", "", shopRequest{}, "http://localhost:8000"},
+		{"loopback ip", "", "", "", "[::1]:8000", "127.0.0.1:1", "", shopRequest{}, "http://[::1]:8000"},
+		{"unconfigured", "", "", "", "evil.test", "203.0.113.9:1", "", shopRequest{}, "http://localhost"},
+		{"proto from trusted proxy", "", "shop.example.com", "", "shop.example.com", "10.0.0.1:1", "https", shopRequest{}, "https://shop.example.com"},
+		{"proto from anyone else", "", "shop.example.com", "", "shop.example.com", "203.0.113.9:1", "https", shopRequest{}, "http://shop.example.com"},
+		{"bad proto", "", "shop.example.com", "", "shop.example.com", "10.0.0.1:1", "javascript", shopRequest{}, "http://shop.example.com"},
+	}
+	for _, tt := range tests {
+		t.Setenv("PUBLIC_BASE_URL", tt.public)
+		t.Setenv("ALLOWED_HOSTS", tt.allowed)
+		t.Setenv("SHOP_DOMAIN", tt.domain)
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Host, r.RemoteAddr = tt.host, tt.remote
+		if tt.proto != "" {
+			r.Header.Set("X-Forwarded-Proto", tt.proto)
+		}
+		if tt.sr.site != nil {
+			r = r.WithContext(context.WithValue(r.Context(), shopContextKey{}, tt.sr))
+		}
+		if got := siteURL(r); got != tt.want {
+			t.Errorf("%s: siteURL = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// productColumns is the SELECT list shared by every product lookup; keep it in
+// sync with scanProduct.
+const productColumns = `p.id, p.title, p.description, IFNULL(p.price_minor,0), p.image_url, IFNULL(p.image_public_id,''), IFNULL(p.external_url,''), IFNULL(p.external_key,''), IFNULL(p.source, IFNULL(p.tag,'mychoice')), IFNULL(p.category_id, 0), IFNULL(c.name,''), IFNULL(c.slug,''), IFNULL(p.status,'published'), IFNULL(p.position,0), IFNULL(p.pinned,0), p.stock, p.weight_grams, p.sale_price_minor, p.sale_starts_at, p.sale_ends_at, p.created_at, COALESCE(p.updated_at, p.created_at)`
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanProduct reads one row selected with productColumns.
+func scanProduct(row rowScanner) (Product, error) {
+	var p Product
+	var publicID sql.NullString
+	var external sql.NullString
+	var sourceNull sql.NullString
+	var created, updated interface{}
+	var catNull sql.NullInt64
+	var stock, weight sql.NullInt64
+	var salePrice sql.NullInt64
+	var saleStarts, saleEnds interface{}
+	if err := row.Scan(&p.ID, &p.Title, &p.Description, &p.Price, &p.ImageURL, &publicID, &external, &p.ExternalKey, &sourceNull, &catNull, &p.Category, &p.CategorySlug, &p.Status, &p.Position, &p.Pinned, &stock, &weight, &salePrice, &saleStarts, &saleEnds, &created, &updated); err != nil {
+		return Product{}, err
+	}
+	p.CreatedAt = formatDBTime(created)
+	p.UpdatedAt = formatDBTime(updated)
+	if catNull.Valid {
+		p.CategoryID = catNull.Int64
+	}
+	if external.Valid {
+		p.ExternalURL = external.String
+	}
+	if stock.Valid {
+		n := int(stock.Int64)
+		p.Stock = &n
+	}
+	if weight.Valid {
+		n := int(weight.Int64)
+		p.WeightGrams = &n
+	}
+	if salePrice.Valid {
+		m := Money(salePrice.Int64)
+		p.SalePrice = &m
+	}
+	p.SaleStartsAt, p.SaleEndsAt = parseDBTime(saleStarts), parseDBTime(saleEnds)
+	if publicID.Valid {
+		p.ImagePublicID = publicID.String
+	}
+	if sourceNull.Valid && sourceNull.String != "" {
+		p.Source = sourceNull.String
+	} else {
+		p.Source = sourceMyChoice
+	}
+	p.Tag = p.Source
+	return p, nil
+}
+
+// formatDBTime handles timestamps which may be time.Time or []byte/string depending on driver.
+func formatDBTime(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case []byte:
+		return string(t)
+	case time.Time:
+		return t.Format(time.RFC3339)
+	default:
+		return ""
+	}
+}
+
+// sortProducts applies the storefront order: pinned first, then manual position,
+// then newest first. It mirrors the ORDER BY used by fetchProducts.
+func sortProducts(ps []Product) {
+	sort.SliceStable(ps, func(i, j int) bool {
+		a, b := ps[i], ps[j]
+		if a.Pinned != b.Pinned {
+			return a.Pinned
+		}
+		if a.Position != b.Position {
+			return a.Position < b.Position
+		}
+		if a.CreatedAt != b.CreatedAt {
+			return a.CreatedAt > b.CreatedAt
+		}
+		return a.ID > b.ID
+	})
+}
+
+// fetchProducts returns all products in storefront order (or the dev products when db==nil).
+func fetchProducts(db *sql.DB) ([]Product, error) {
+	return fetchFilteredProducts(db, productFilter{})
+}
+
+// productFilter narrows fetchFilteredProducts in the query itself; the zero
+// value matches every product.
+type productFilter struct {
+	publicOnly  bool           // leave out drafts, as visibleProducts does
+	categoryIDs map[int64]bool // nil means any category
+	source      string         // sourceMyChoice or sourceShopee; "" means any
+	limit       int            // at most this many rows in storefront order; 0 means all
+	offset      int            // rows skipped before limit; only used with a limit
+	ids         []int64        // only these products; nil means any
+}
+
+// fetchFilteredProducts is fetchProducts restricted to the products matching f.
+func fetchFilteredProducts(db *sql.DB, f productFilter) ([]Product, error) {
+	if db == nil {
+		var ids map[int64]bool
+		if f.ids != nil {
+			ids = make(map[int64]bool, len(f.ids))
+			for _, id := range f.ids {
+				ids[id] = true
+			}
+		}
+		var out []Product
+		for _, p := range DevGetProducts() {
+			if f.publicOnly && p.Status == statusDraft {
+				continue
+			}
+			if f.categoryIDs != nil && !f.categoryIDs[p.CategoryID] {
+				continue
+			}
+			if ids != nil && !ids[p.ID] {
+				continue
+			}
+			if f.source != "" && p.Source != f.source {
+				continue
+			}
+			out = append(out, p)
+		}
+		sortProducts(out)
+		if f.limit > 0 {
+			if f.offset > len(out) {
+				f.offset = len(out)
+			}
+			out = out[f.offset:]
+			if len(out) > f.limit {
+				out = out[:f.limit]
+			}
+		}
+		applyPricing(out, shopCurrency(db))
+		setTrackedURLs(out)
+		setPagePaths(out)
+		if err := setPriceDrops(db, out, 0); err != nil {
+			return nil, err
+		}
+		return out, attachCollections(db, out, 0)
+	}
+	where := "p.shop_id = @shop_id"
+	var args []interface{}
+	if f.publicOnly {
+		where += " AND IFNULL(p.status,'published') <> ?"
+		args = append(args, statusDraft)
+	}
+	if f.categoryIDs != nil {
+		if len(f.categoryIDs) == 0 {
+			return nil, nil
+		}
+		ids := make([]int64, 0, len(f.categoryIDs))
+		for id := range f.categoryIDs {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		marks := make([]string, len(ids))
+		for i, id := range ids {
+			marks[i] = "?"
+			args = append(args, id)
+		}
+		where += " AND IFNULL(p.category_id,0) IN (" + strings.Join(marks, ",") + ")"
+	}
+	if f.ids != nil {
+		if len(f.ids) == 0 {
+			return nil, nil
+		}
+		marks := make([]string, len(f.ids))
+		for i, id := range f.ids {
+			marks[i] = "?"
+			args = append(args, id)
+		}
+		where += " AND p.id IN (" + strings.Join(marks, ",") + ")"
+	}
+	if f.source != "" {
+		where += " AND IFNULL(p.source, IFNULL(p.tag,'mychoice')) = ?"
+		args = append(args, f.source)
+	}
+	query := `SELECT ` + productColumns + `
+		FROM products p
+		LEFT JOIN categories c ON c.id = p.category_id
+		WHERE ` + where + `
+		ORDER BY p.pinned DESC, p.position ASC, p.created_at DESC, p.id DESC`
+	if f.limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, f.limit, f.offset)
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query products: %w", err)
+	}
+	defer rows.Close()
+	var out []Product
+	for rows.Next() {
+		p, err := scanProduct(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan product: %w", err)
+		}
+		out = append(out, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	applyPricing(out, shopCurrency(db))
+	setTrackedURLs(out)
+	setPagePaths(out)
+	if err := setPriceDrops(db, out, 0); err != nil {
+		return nil, err
+	}
+	return out, attachCollections(db, out, 0)
+}
+
+// fetchProduct returns a single product by id. The boolean is false when it does not exist.
+func fetchProduct(db *sql.DB, id int64) (Product, bool, error) {
+	if db == nil {
+		for _, p := range DevGetProducts() {
+			if p.ID == id {
+				one := []Product{p}
+				applyPricing(one, shopCurrency(db))
+				setTrackedURLs(one)
+				setPagePaths(one)
+				if err := setPriceDrops(db, one, id); err != nil {
+					return Product{}, false, err
+				}
+				err := attachCollections(db, one, id)
+				return one[0], true, err
+			}
+		}
+		return Product{}, false, nil
+	}
+	row := db.QueryRow(`SELECT `+productColumns+`
+		FROM products p LEFT JOIN categories c ON c.id = p.category_id WHERE p.id = ? AND p.shop_id = @shop_id`, id)
+	p, err := scanProduct(row)
+	if err == sql.ErrNoRows {
+		return Product{}, false, nil
+	}
+	if err != nil {
+		return Product{}, false, fmt.Errorf("scan product: %w", err)
+	}
+	one := []Product{p}
+	applyPricing(one, shopCurrency(db))
+	setTrackedURLs(one)
+	setPagePaths(one)
+	if err := setPriceDrops(db, one, id); err != nil {
+		return Product{}, false, err
+	}
+	if err := attachCollections(db, one, id); err != nil {
+		return Product{}, false, err
+	}
+	return one[0], true, nil
+}
+
+// visibleProducts drops drafts, which only admins may see.
+func visibleProducts(products []Product) []Product {
+	var out []Product
+	for _, p := range products {
+		if p.Status != statusDraft {
+			out = append(out, p)
+		}
+	}
+	return out
+}
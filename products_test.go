@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func productIDs(ps []Product) []int64 {
+	var out []int64
+	for _, p := range ps {
+		out = append(out, p.ID)
+	}
+	return out
+}
+
+func TestSortProducts(t *testing.T) {
+	ps := []Product{
+		{ID: 1, CreatedAt: "2025-01-01T00:00:00Z"},
+		{ID: 2, CreatedAt: "2025-03-01T00:00:00Z"},
+		{ID: 3, CreatedAt: "2025-02-01T00:00:00Z", Position: 2},
+		{ID: 4, CreatedAt: "2025-01-01T00:00:00Z", Position: 1},
+		{ID: 5, CreatedAt: "2024-01-01T00:00:00Z", Pinned: true, Position: 9},
+		{ID: 6, CreatedAt: "2024-01-01T00:00:00Z", Pinned: true},
+		{ID: 7, CreatedAt: "2025-01-01T00:00:00Z"},
+	}
+	sortProducts(ps)
+	// pinned first, then position (0 = unplaced comes before placed), then newest, then id
+	if got, want := productIDs(ps), []int64{6, 5, 2, 7, 1, 4, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sortProducts order = %v, want %v", got, want)
+	}
+}
+
+func TestParseFormBool(t *testing.T) {
+	for v, want := range map[string]bool{"1": true, "true": true, " ON ": true, "yes": true, "0": false, "false": false, "": false, "nope": false} {
+		if got := parseFormBool(v); got != want {
+			t.Errorf("parseFormBool(%q) = %v, want %v", v, got, want)
+		}
+	}
+}
+
+func TestAdminReorderProducts(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "t")
+	useDevOrders(t, []Product{
+		{ID: 1, CreatedAt: "2025-01-01T00:00:00Z"},
+		{ID: 2, CreatedAt: "2025-02-01T00:00:00Z"},
+		{ID: 3, CreatedAt: "2025-03-01T00:00:00Z"},
+	}, nil)
+	pinned := true
+	DevSetProductPlacement(1, &pinned, nil)
+
+	tests := []struct {
+		name, token, body string
+		wantCode          int
+	}{
+		{"no token", "", `{"ids":[1]}`, http.StatusUnauthorized},
+		{"bad json", "t", `{"ids":`, http.StatusBadRequest},
+		{"no ids", "t", `{"ids":[]}`, http.StatusBadRequest},
+		{"reorder", "t", `{"ids":[2,99,3,2]}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/api/admin/products/reorder", strings.NewReader(tt.body))
+		if tt.token != "" {
+			r.Header.Set("X-Admin-Token", tt.token)
+		}
+		w := httptest.NewRecorder()
+		adminReorderProducts(nil)(w, r)
+		if w.Code != tt.wantCode {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.wantCode)
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var resp struct {
+			Updated int     `json:"updated"`
+			Missing []int64 `json:"missing"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Updated != 2 || !reflect.DeepEqual(resp.Missing, []int64{99}) {
+			t.Errorf("%s: response = %+v, want 2 updated and 99 missing", tt.name, resp)
+		}
+	}
+
+	products, err := fetchProducts(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the pinned product stays first; the reordered ones follow in the posted order
+	if got, want := productIDs(products), []int64{1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("storefront order = %v, want %v", got, want)
+	}
+}
@@ -9,17 +9,20 @@ import (
 func fetchProfile(db *sql.DB) (Profile, error) {
 	if db == nil {
 		p := DevGetProfile()
+		p.Currency = currencyOrDefault(p.Currency).Code
 		// attach dev socials
 		p.Socials = DevGetSocials()
+		setSocialTrackedURLs(p.Socials)
 		return p, nil
 	}
 	var p Profile
-	row := db.QueryRow("SELECT display_name, username, bio, highlight, avatar_url FROM profile WHERE id = 1")
-	if err := row.Scan(&p.DisplayName, &p.Username, &p.Bio, &p.Highlight, &p.AvatarURL); err != nil {
+	row := db.QueryRow("SELECT display_name, username, bio, highlight, avatar_url, IFNULL(currency,''), IFNULL(shopee_affiliate_params,'') FROM profile WHERE shop_id = @shop_id")
+	if err := row.Scan(&p.DisplayName, &p.Username, &p.Bio, &p.Highlight, &p.AvatarURL, &p.Currency, &p.ShopeeAffiliateParams); err != nil {
 		return Profile{}, fmt.Errorf("scan profile: %w", err)
 	}
+	p.Currency = currencyOrDefault(p.Currency).Code
 	// load socials
-	rows, err := db.Query("SELECT id, name, url, IFNULL(icon,''), ord FROM socials ORDER BY ord ASC, id ASC")
+	rows, err := db.Query("SELECT id, name, url, IFNULL(icon,''), ord FROM socials WHERE shop_id = @shop_id ORDER BY ord ASC, id ASC")
 	if err == nil {
 		defer rows.Close()
 		var socs []Social
@@ -30,6 +33,7 @@ func fetchProfile(db *sql.DB) (Profile, error) {
 			}
 		}
 		p.Socials = socs
+		setSocialTrackedURLs(p.Socials)
 	}
 	return p, nil
 }
@@ -40,8 +44,8 @@ func saveProfile(db *sql.DB, p Profile) error {
 		DevUpdateProfile(p)
 		return nil
 	}
-	_, err := db.Exec(`UPDATE profile SET display_name=?, username=?, bio=?, highlight=?, avatar_url=? WHERE id = 1`,
-		p.DisplayName, p.Username, p.Bio, p.Highlight, p.AvatarURL)
+	_, err := db.Exec(`UPDATE profile SET display_name=?, username=?, bio=?, highlight=?, avatar_url=?, currency=?, shopee_affiliate_params=? WHERE shop_id = @shop_id`,
+		p.DisplayName, p.Username, p.Bio, p.Highlight, p.AvatarURL, currencyOrDefault(p.Currency).Code, sqlNullString(p.ShopeeAffiliateParams))
 	if err != nil {
 		return fmt.Errorf("update profile: %w", err)
 	}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Profile block types.
+const (
+	blockLink    = "link"    // button to URL labelled Title
+	blockHeader  = "header"  // section heading (Title)
+	blockText    = "text"    // paragraph (Body)
+	blockVideo   = "video"   // embedded YouTube or TikTok video (URL)
+	blockProduct = "product" // featured product card (ProductID)
+)
+
+var (
+	youtubeID = regexp.MustCompile(`^[A-Za-z0-9_-]{11}$`)
+	tiktokID  = regexp.MustCompile(`^/@[^/]+/video/(\d+)`)
+)
+
+// videoEmbedURL returns the iframe URL for a YouTube or TikTok video link.
+func videoEmbedURL(raw string) (string, bool) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", false
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	host = strings.TrimPrefix(host, "m.")
+	var id string
+	switch host {
+	case "youtube.com":
+		switch {
+		case u.Path == "/watch":
+			id = u.Query().Get("v")
+		case strings.HasPrefix(u.Path, "/shorts/"), strings.HasPrefix(u.Path, "/embed/"), strings.HasPrefix(u.Path, "/live/"):
+			id = u.Path[strings.LastIndex(u.Path, "/")+1:]
+		}
+	case "youtu.be":
+		id = strings.TrimPrefix(u.Path, "/")
+	case "tiktok.com":
+		if m := tiktokID.FindStringSubmatch(u.Path); m != nil {
+			return "https://www.tiktok.com/embed/v2/" + m[1], true
+		}
+		return "", false
+	}
+	if !youtubeID.MatchString(id) {
+		return "", false
+	}
+	return "https://www.youtube-nocookie.com/embed/" + id, true
+}
+
+// activeAt reports whether the block is shown at t: visible and inside its schedule.
+func (b ProfileBlock) activeAt(t time.Time) bool {
+	if !b.Visible {
+		return false
+	}
+	if b.StartsAt != nil && t.Before(*b.StartsAt) {
+		return false
+	}
+	if b.EndsAt != nil && !t.Before(*b.EndsAt) {
+		return false
+	}
+	return true
+}
+
+// sortBlocks orders blocks by position, then id.
+func sortBlocks(blocks []ProfileBlock) {
+	sort.SliceStable(blocks, func(i, j int) bool {
+		if blocks[i].Position != blocks[j].Position {
+			return blocks[i].Position < blocks[j].Position
+		}
+		return blocks[i].ID < blocks[j].ID
+	})
+}
+
+// fetchProfileBlocks returns every block in display order.
+func fetchProfileBlocks(db *sql.DB) ([]ProfileBlock, error) {
+	if db == nil {
+		blocks := DevGetProfileBlocks()
+		sortBlocks(blocks)
+		return blocks, nil
+	}
+	rows, err := db.Query("SELECT id, type, IFNULL(title,''), IFNULL(body,''), IFNULL(url,''), IFNULL(product_id,0), position, visible, starts_at, ends_at FROM profile_blocks WHERE shop_id = @shop_id ORDER BY position, id")
+	if err != nil {
+		return nil, fmt.Errorf("query profile blocks: %w", err)
+	}
+	defer rows.Close()
+	var out []ProfileBlock
+	for rows.Next() {
+		var b ProfileBlock
+		var starts, ends interface{}
+		if err := rows.Scan(&b.ID, &b.Type, &b.Title, &b.Body, &b.URL, &b.ProductID, &b.Position, &b.Visible, &starts, &ends); err != nil {
+			return nil, fmt.Errorf("scan profile block: %w", err)
+		}
+		b.StartsAt, b.EndsAt = parseDBTime(starts), parseDBTime(ends)
+		out = append(out, b)
+	}
+	return out, rows.Err()
+}
+
+// publicProfileBlocks returns the blocks shown now, with video embeds and
+// featured products filled in. Product blocks whose product is missing or a
+// draft are left out.
+func publicProfileBlocks(db *sql.DB) ([]ProfileBlock, error) {
+	blocks, err := fetchProfileBlocks(db)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	out := []ProfileBlock{}
+	for _, b := range blocks {
+		if !b.activeAt(now) {
+			continue
+		}
+		switch b.Type {
+		case blockVideo:
+			b.EmbedURL, _ = videoEmbedURL(b.URL)
+		case blockProduct:
+			p, ok, err := fetchProduct(db, b.ProductID)
+			if err != nil {
+				return nil, err
+			}
+			if !ok || p.Status == statusDraft {
+				continue
+			}
+			b.Product = &p
+		}
+		out = append(out, b)
+	}
+	return out, nil
+}
+
+// profileBlockPayload is the admin JSON body for creating or updating a block.
+// starts_at/ends_at take RFC 3339 or datetime-local values; "" clears them.
+type profileBlockPayload struct {
+	Type      *string `json:"type"`
+	Title     *string `json:"title"`
+	Body      *string `json:"body"`
+	URL       *string `json:"url"`
+	ProductID *int64  `json:"product_id"`
+	Position  *int    `json:"position"`
+	Visible   *bool   `json:"visible"`
+	StartsAt  *string `json:"starts_at"`
+	EndsAt    *string `json:"ends_at"`
+}
+
+// apply copies the provided fields onto b and validates the result for its type.
+func (p profileBlockPayload) apply(db *sql.DB, b *ProfileBlock) error {
+	if p.Type != nil {
+		b.Type = strings.ToLower(strings.TrimSpace(*p.Type))
+	}
+	if p.Title != nil {
+		b.Title = strings.TrimSpace(*p.Title)
+	}
+	if p.Body != nil {
+		b.Body = strings.TrimSpace(*p.Body)
+	}
+	if p.URL != nil {
+		b.URL = strings.TrimSpace(*p.URL)
+	}
+	if p.ProductID != nil {
+		b.ProductID = *p.ProductID
+	}
+	if p.Position != nil {
+		b.Position = *p.Position
+	}
+	if p.Visible != nil {
+		b.Visible = *p.Visible
+	}
+	for _, f := range []struct {
+		in  *string
+		out **time.Time
+	}{{p.StartsAt, &b.StartsAt}, {p.EndsAt, &b.EndsAt}} {
+		if f.in == nil {
+			continue
+		}
+		t, err := parseFormTime(*f.in)
+		if err != nil {
+			return err
+		}
+		*f.out = t
+	}
+	if b.StartsAt != nil && b.EndsAt != nil && !b.EndsAt.After(*b.StartsAt) {
+		return errors.New("ends_at must be after starts_at")
+	}
+	if len(b.Title) > 255 || len(b.Body) > 2000 || len(b.URL) > 1024 {
+		return errors.New("title, body or url too long")
+	}
+	switch b.Type {
+	case blockLink:
+		u, err := url.Parse(b.URL)
+		if b.Title == "" || err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("link blocks need a title and an http(s) url")
+		}
+	case blockHeader:
+		if b.Title == "" {
+			return errors.New("header blocks need a title")
+		}
+	case blockText:
+		if b.Body == "" {
+			return errors.New("text blocks need a body")
+		}
+	case blockVideo:
+		if _, ok := videoEmbedURL(b.URL); !ok {
+			return errors.New("video blocks need a YouTube or TikTok video url")
+		}
+	case blockProduct:
+		if _, ok, err := fetchProduct(db, b.ProductID); err != nil {
+			return err
+		} else if !ok {
+			return errors.New("product not found")
+		}
+	default:
+		return errors.New("type must be link, header, text, video or product")
+	}
+	return nil
+}
+
+// saveProfileBlock inserts (ID 0) or updates a block and returns it.
+func saveProfileBlock(db *sql.DB, b ProfileBlock) (ProfileBlock, error) {
+	if db == nil {
+		if b.ID == 0 {
+			return DevAddProfileBlock(b), nil
+		}
+		if !DevUpdateProfileBlock(b) {
+			return b, sql.ErrNoRows
+		}
+		return b, nil
+	}
+	if b.ID == 0 {
+		res, err := db.Exec("INSERT INTO profile_blocks (shop_id, type, title, body, url, product_id, position, visible, starts_at, ends_at, created_at) VALUES (@shop_id, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			b.Type, sqlNullString(b.Title), sqlNullString(b.Body), sqlNullString(b.URL), sqlNull(b.ProductID), b.Position, b.Visible, sqlNullTime(b.StartsAt), sqlNullTime(b.EndsAt), time.Now())
+		if err != nil {
+			return b, err
+		}
+		b.ID, _ = res.LastInsertId()
+		return b, nil
+	}
+	_, err := db.Exec("UPDATE profile_blocks SET type=?, title=?, body=?, url=?, product_id=?, position=?, visible=?, starts_at=?, ends_at=? WHERE id=? AND shop_id = @shop_id",
+		b.Type, sqlNullString(b.Title), sqlNullString(b.Body), sqlNullString(b.URL), sqlNull(b.ProductID), b.Position, b.Visible, sqlNullTime(b.StartsAt), sqlNullTime(b.EndsAt), b.ID)
+	return b, err
+}
+
+// reorderProfileBlocks sets positions 1..n following ids; unknown ids are ignored.
+func reorderProfileBlocks(db *sql.DB, ids []int64) error {
+	if db == nil {
+		DevReorderProfileBlocks(ids)
+		return nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for i, id := range ids {
+		if _, err := tx.Exec("UPDATE profile_blocks SET position=? WHERE id=? AND shop_id = @shop_id", i+1, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// profileBlocksHandler serves /api/profile/blocks: admin GET lists every block
+// (hidden and scheduled ones included), POST creates one.
+func profileBlocksHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			blocks, err := fetchProfileBlocks(db)
+			if err != nil {
+				log.Println("fetchProfileBlocks error:", err)
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
+			if blocks == nil {
+				blocks = []ProfileBlock{}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(blocks)
+
+		case http.MethodPost:
+			var payload profileBlockPayload
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&payload); err != nil {
+				http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			b := ProfileBlock{Visible: true}
+			if payload.Position == nil {
+				// new blocks go last
+				blocks, err := fetchProfileBlocks(db)
+				if err != nil {
+					log.Println("fetchProfileBlocks error:", err)
+					http.Error(w, "db error", http.StatusInternalServerError)
+					return
+				}
+				if n := len(blocks); n > 0 {
+					b.Position = blocks[n-1].Position + 1
+				}
+			}
+			if err := payload.apply(db, &b); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			b, err := saveProfileBlock(db, b)
+			if err != nil {
+				log.Println("create profile block error:", err)
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(b)
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// profileBlockItemHandler serves admin GET, PUT and DELETE on
+// /api/profile/blocks/{id}, and PUT /api/profile/blocks/order {"ids": [...]}
+// to reorder all blocks at once.
+func profileBlockItemHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		rest := strings.TrimPrefix(r.URL.Path, "/api/profile/blocks/")
+		if rest == "order" {
+			if r.Method != http.MethodPut {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			var payload struct {
+				IDs []int64 `json:"ids"`
+			}
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&payload); err != nil {
+				http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := reorderProfileBlocks(db, dedupeIDs(payload.IDs)); err != nil {
+				log.Println("reorder profile blocks error:", err)
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		id, err := strconv.ParseInt(rest, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		blocks, err := fetchProfileBlocks(db)
+		if err != nil {
+			log.Println("fetchProfileBlocks error:", err)
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		var cur ProfileBlock
+		found := false
+		for _, b := range blocks {
+			if b.ID == id {
+				cur, found = b, true
+			}
+		}
+		if !found {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(cur)
+
+		case http.MethodPut:
+			var payload profileBlockPayload
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&payload); err != nil {
+				http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := payload.apply(db, &cur); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if _, err := saveProfileBlock(db, cur); err != nil {
+				log.Println("update profile block error:", err)
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(cur)
+
+		case http.MethodDelete:
+			if db == nil {
+				DevDeleteProfileBlock(id)
+			} else if _, err := db.Exec("DELETE FROM profile_blocks WHERE id = ? AND shop_id = @shop_id", id); err != nil {
+				log.Println("delete profile block error:", err)
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
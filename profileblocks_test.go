@@ -0,0 +1,189 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVideoEmbedURL(t *testing.T) {
+	const yt = "https://www.youtube-nocookie.com/embed/dQw4w9WgXcQ"
+	tests := []struct {
+		in, want string
+	}{
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ&t=42", yt},
+		{"https://m.youtube.com/watch?v=dQw4w9WgXcQ", yt},
+		{"https://youtu.be/dQw4w9WgXcQ", yt},
+		{"http://youtube.com/shorts/dQw4w9WgXcQ", yt},
+		{"https://www.youtube.com/embed/dQw4w9WgXcQ", yt},
+		{"https://www.youtube.com/live/dQw4w9WgXcQ?si=x", yt},
+		{" https://youtu.be/dQw4w9WgXcQ ", yt},
+		{"https://www.tiktok.com/@tram.shop/video/7234567890123456789?lang=vi", "https://www.tiktok.com/embed/v2/7234567890123456789"},
+		{"https://www.youtube.com/watch?v=short", ""},
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ\"><script>", ""},
+		{"https://www.youtube.com/channel/UCabc", ""},
+		{"https://www.tiktok.com/@tram.shop", ""},
+		{"https://vimeo.com/123456", ""},
+		{"javascript://youtu.be/dQw4w9WgXcQ", ""},
+		{"youtu.be/dQw4w9WgXcQ", ""},
+		{"https://evil.test/youtu.be/dQw4w9WgXcQ", ""},
+	}
+	for _, tt := range tests {
+		got, ok := videoEmbedURL(tt.in)
+		if got != tt.want || ok != (tt.want != "") {
+			t.Errorf("videoEmbedURL(%q) = %q, %v; want %q", tt.in, got, ok, tt.want)
+		}
+	}
+}
+
+func TestBlockActiveAt(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	before, after := now.Add(-time.Hour), now.Add(time.Hour)
+	tests := []struct {
+		name         string
+		visible      bool
+		starts, ends *time.Time
+		want         bool
+	}{
+		{"always", true, nil, nil, true},
+		{"hidden", false, nil, nil, false},
+		{"hidden in window", false, &before, &after, false},
+		{"in window", true, &before, &after, true},
+		{"not started", true, &after, nil, false},
+		{"starts now", true, &now, nil, true},
+		{"ended", true, nil, &before, false},
+		{"ends now", true, nil, &now, false},
+	}
+	for _, tt := range tests {
+		b := ProfileBlock{Visible: tt.visible, StartsAt: tt.starts, EndsAt: tt.ends}
+		if got := b.activeAt(now); got != tt.want {
+			t.Errorf("%s: activeAt = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestProfileBlockPayloadApply(t *testing.T) {
+	useDevOrders(t, []Product{{ID: 1, Title: "Áo", Status: statusPublished}}, nil)
+	str := func(s string) *string { return &s }
+	id := func(n int64) *int64 { return &n }
+	tests := []struct {
+		name    string
+		p       profileBlockPayload
+		wantErr string
+	}{
+		{"link", profileBlockPayload{Type: str(" Link "), Title: str("Shopee"), URL: str("https://shopee.vn/tram")}, ""},
+		{"link without title", profileBlockPayload{Type: str("link"), URL: str("https://shopee.vn/tram")}, "link blocks need"},
+		{"link to javascript", profileBlockPayload{Type: str("link"), Title: str("x"), URL: str("javascript:alert(1)")}, "link blocks need"},
+		{"header", profileBlockPayload{Type: str("header"), Title: str("Ưu đãi")}, ""},
+		{"empty header", profileBlockPayload{Type: str("header"), Title: str("  ")}, "header blocks need"},
+		{"text", profileBlockPayload{Type: str("text"), Body: str("Giao hàng toàn quốc")}, ""},
+		{"empty text", profileBlockPayload{Type: str("text")}, "text blocks need"},
+		{"video", profileBlockPayload{Type: str("video"), URL: str("https://youtu.be/dQw4w9WgXcQ")}, ""},
+		{"other video site", profileBlockPayload{Type: str("video"), URL: str("https://vimeo.com/1")}, "video blocks need"},
+		{"product", profileBlockPayload{Type: str("product"), ProductID: id(1)}, ""},
+		{"unknown product", profileBlockPayload{Type: str("product"), ProductID: id(9)}, "product not found"},
+		{"unknown type", profileBlockPayload{Type: str("image")}, "type must be"},
+		{"long title", profileBlockPayload{Type: str("header"), Title: str(strings.Repeat("x", 256))}, "too long"},
+		{"schedule", profileBlockPayload{Type: str("header"), Title: str("Tết"), StartsAt: str("2025-01-20"), EndsAt: str("2025-02-10")}, ""},
+		{"schedule backwards", profileBlockPayload{Type: str("header"), Title: str("Tết"), StartsAt: str("2025-02-10"), EndsAt: str("2025-01-20")}, "ends_at must be after"},
+		{"bad time", profileBlockPayload{Type: str("header"), Title: str("Tết"), StartsAt: str("tomorrow")}, "invalid"},
+	}
+	for _, tt := range tests {
+		var b ProfileBlock
+		err := tt.p.apply(nil, &b)
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("%s: apply = %v", tt.name, err)
+		}
+		if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: apply = %v, want an error containing %q", tt.name, err, tt.wantErr)
+		}
+	}
+
+	// PUT keeps fields that are not sent and "" clears a schedule
+	ends := time.Now().Add(time.Hour)
+	b := ProfileBlock{ID: 3, Type: blockLink, Title: "Shopee", URL: "https://shopee.vn/tram", Visible: true, EndsAt: &ends}
+	if err := (profileBlockPayload{Title: str("Shopee Mall"), EndsAt: str("")}).apply(nil, &b); err != nil {
+		t.Fatal(err)
+	}
+	if b.Title != "Shopee Mall" || b.URL != "https://shopee.vn/tram" || !b.Visible || b.EndsAt != nil {
+		t.Errorf("after partial update = %+v", b)
+	}
+}
+
+func TestPublicProfileBlocks(t *testing.T) {
+	useDevSnapshot(t)
+	now := time.Now()
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+	DevRestoreSnapshot(ShopSnapshot{
+		Products: []Product{{ID: 1, Title: "Áo", Status: statusPublished}, {ID: 2, Title: "Nháp", Status: statusDraft}},
+		ProfileBlocks: []ProfileBlock{
+			{ID: 1, Type: blockHeader, Title: "Mới", Position: 3, Visible: true},
+			{ID: 2, Type: blockVideo, URL: "https://youtu.be/dQw4w9WgXcQ", Position: 1, Visible: true},
+			{ID: 3, Type: blockProduct, ProductID: 1, Position: 2, Visible: true},
+			{ID: 4, Type: blockProduct, ProductID: 2, Position: 2, Visible: true},
+			{ID: 5, Type: blockProduct, ProductID: 9, Position: 2, Visible: true},
+			{ID: 6, Type: blockText, Body: "ẩn", Position: 0, Visible: false},
+			{ID: 7, Type: blockText, Body: "sắp tới", Position: 0, Visible: true, StartsAt: &future},
+			{ID: 8, Type: blockText, Body: "đã hết", Position: 0, Visible: true, EndsAt: &past},
+			{ID: 9, Type: blockText, Body: "đang chạy", Position: 3, Visible: true, StartsAt: &past, EndsAt: &future},
+		},
+	})
+	blocks, err := publicProfileBlocks(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int64
+	for _, b := range blocks {
+		ids = append(ids, b.ID)
+	}
+	if len(ids) != 4 || ids[0] != 2 || ids[1] != 3 || ids[2] != 1 || ids[3] != 9 {
+		t.Fatalf("public blocks = %v, want [2 3 1 9]", ids)
+	}
+	if blocks[0].EmbedURL != "https://www.youtube-nocookie.com/embed/dQw4w9WgXcQ" {
+		t.Errorf("video embed = %q", blocks[0].EmbedURL)
+	}
+	if blocks[1].Product == nil || blocks[1].Product.Title != "Áo" {
+		t.Errorf("product block = %+v, want the product attached", blocks[1])
+	}
+
+	// the admin list includes hidden and scheduled blocks
+	t.Setenv("ADMIN_TOKEN", "t")
+	r := httptest.NewRequest(http.MethodGet, "/api/profile/blocks", nil)
+	r.Header.Set("X-Admin-Token", "t")
+	w := httptest.NewRecorder()
+	profileBlocksHandler(nil)(w, r)
+	if n := strings.Count(w.Body.String(), `"type"`); w.Code != http.StatusOK || n != 9 {
+		t.Errorf("admin list = %d with %d blocks, want 200 with 9", w.Code, n)
+	}
+}
+
+func TestProfileBlockReorder(t *testing.T) {
+	useDevSnapshot(t)
+	DevRestoreSnapshot(ShopSnapshot{ProfileBlocks: []ProfileBlock{
+		{ID: 1, Type: blockHeader, Title: "A", Position: 1, Visible: true},
+		{ID: 2, Type: blockHeader, Title: "B", Position: 2, Visible: true},
+		{ID: 3, Type: blockHeader, Title: "C", Position: 3, Visible: true},
+	}})
+	t.Setenv("ADMIN_TOKEN", "t")
+	r := httptest.NewRequest(http.MethodPut, "/api/profile/blocks/order", strings.NewReader(`{"ids":[3,1,3,99]}`))
+	r.Header.Set("X-Admin-Token", "t")
+	w := httptest.NewRecorder()
+	profileBlockItemHandler(nil)(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("reorder = %d %s", w.Code, w.Body.String())
+	}
+	blocks, err := fetchProfileBlocks(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var titles []string
+	for _, b := range blocks {
+		titles = append(titles, b.Title)
+	}
+	// unlisted blocks keep their old position; ties sort by id
+	if got := strings.Join(titles, ""); got != "CAB" {
+		t.Errorf("order after reorder = %s, want CAB", got)
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// vietnameseFold maps precomposed Vietnamese letters to their ASCII base letter.
+var vietnameseFold = func() map[rune]rune {
+	groups := map[rune]string{
+		'a': "àáảãạăằắẳẵặâầấẩẫậ",
+		'e': "èéẻẽẹêềếểễệ",
+		'i': "ìíỉĩị",
+		'o': "òóỏõọôồốổỗộơờớởỡợ",
+		'u': "ùúủũụưừứửữự",
+		'y': "ỳýỷỹỵ",
+		'd': "đ",
+	}
+	m := make(map[rune]rune)
+	for base, chars := range groups {
+		for _, c := range chars {
+			m[c] = base
+		}
+	}
+	return m
+}()
+
+// foldVietnamese lowercases s and strips Vietnamese diacritics so that "Đầm" matches "dam".
+// Combining marks (decomposed input) are dropped as well.
+func foldVietnamese(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, r := range strings.ToLower(s) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if f, ok := vietnameseFold[r]; ok {
+			r = f
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// searchTokens folds s and splits it into letter/digit tokens.
+func searchTokens(s string) []string {
+	return strings.FieldsFunc(foldVietnamese(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// SearchResult is a product together with its relevance score.
+type SearchResult struct {
+	Product
+	Score float64 `json:"score"`
+}
+
+// Field weights used by searchProducts; title matches count most.
+const (
+	searchWeightTitle       = 3.0
+	searchWeightCategory    = 2.0
+	searchWeightDescription = 1.0
+)
+
+// indexedProduct holds the pre-tokenized fields of a product.
+type indexedProduct struct {
+	product     Product
+	title       []string
+	category    []string
+	description []string
+	foldedTitle string
+}
+
+func indexProducts(products []Product) []indexedProduct {
+	idx := make([]indexedProduct, 0, len(products))
+	for _, p := range products {
+		idx = append(idx, indexedProduct{
+			product:     p,
+			title:       searchTokens(p.Title),
+			category:    searchTokens(p.Category),
+			description: searchTokens(p.Description),
+			foldedTitle: strings.Join(searchTokens(p.Title), " "),
+		})
+	}
+	return idx
+}
+
+// maxTypos returns how many edits are tolerated for a query term of the given length.
+func maxTypos(n int) int {
+	switch {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// matchTerm scores a single query term against a field's tokens:
+// exact match 1, prefix match 0.7, typo-tolerant match 0.4, otherwise 0.
+func matchTerm(term string, tokens []string) float64 {
+	best := 0.0
+	k := maxTypos(len([]rune(term)))
+	for _, tok := range tokens {
+		switch {
+		case tok == term:
+			return 1
+		case len(term) >= 2 && strings.HasPrefix(tok, term):
+			if best < 0.7 {
+				best = 0.7
+			}
+		case k > 0 && best < 0.4 && withinEditDistance(term, tok, k):
+			best = 0.4
+		}
+	}
+	return best
+}
+
+// withinEditDistance reports whether the edit distance between a and b is at most k.
+// Adjacent transpositions ("khaoc" vs "khoac") count as a single edit.
+func withinEditDistance(a, b string, k int) bool {
+	ra, rb := []rune(a), []rune(b)
+	if d := len(ra) - len(rb); d > k || -d > k {
+		return false
+	}
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] && prev2[j-2]+1 < cur[j] {
+				cur[j] = prev2[j-2] + 1
+			}
+			if cur[j] < rowMin {
+				rowMin = cur[j]
+			}
+		}
+		if rowMin > k {
+			return false
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(rb)] <= k
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// searchProducts ranks products against query. Every query term must match at least one
+// of title, category or description; results are ordered by score then newest first.
+func searchProducts(products []Product, query string) []SearchResult {
+	return rankProducts(indexProducts(products), query, nil)
+}
+
+// rankProducts is searchProducts over an index, leaving out products for which
+// keep (when not nil) is false.
+func rankProducts(idx []indexedProduct, query string, keep func(Product) bool) []SearchResult {
+	terms := searchTokens(query)
+	if len(terms) == 0 {
+		return nil
+	}
+	phrase := strings.Join(terms, " ")
+	var out []SearchResult
+	for _, ip := range idx {
+		if keep != nil && !keep(ip.product) {
+			continue
+		}
+		score := 0.0
+		matched := true
+		for _, t := range terms {
+			s := matchTerm(t, ip.title)*searchWeightTitle +
+				matchTerm(t, ip.category)*searchWeightCategory +
+				matchTerm(t, ip.description)*searchWeightDescription
+			if s == 0 {
+				matched = false
+				break
+			}
+			score += s
+		}
+		if !matched {
+			continue
+		}
+		// reward titles containing the whole query as a phrase
+		if len(terms) > 1 && strings.Contains(ip.foldedTitle, phrase) {
+			score += searchWeightTitle
+		}
+		out = append(out, SearchResult{Product: ip.product, Score: score})
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Score != out[j].Score {
+			return out[i].Score > out[j].Score
+		}
+		return out[i].ID > out[j].ID
+	})
+	return out
+}
+
+// searchIndexCache keeps each shop's tokenized catalog between queries, so a
+// query ranks every product without loading the catalog again. It is rebuilt
+// after a catalog write on this instance (invalidateCatalogCache) and at least
+// every catalogCacheMaxAge, for writes on other instances.
+type searchIndexCache struct {
+	mu     sync.Mutex
+	byShop map[int64]*shopSearchIndex
+	gen    uint64 // bumped by invalidate
+}
+
+type shopSearchIndex struct {
+	mu       sync.Mutex // held while building, so a shop's index is built once
+	products []indexedProduct
+	gen      uint64
+	builtAt  time.Time
+}
+
+var searchIndexes = &searchIndexCache{byShop: map[int64]*shopSearchIndex{}}
+
+// get returns the index of the shop with db, building it when missing or stale.
+func (c *searchIndexCache) get(db *sql.DB, shopID int64) ([]indexedProduct, error) {
+	c.mu.Lock()
+	s, ok := c.byShop[shopID]
+	if !ok {
+		s = &shopSearchIndex{}
+		c.byShop[shopID] = s
+	}
+	gen := c.gen
+	c.mu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.products != nil && s.gen == gen && time.Since(s.builtAt) < catalogCacheMaxAge {
+		return s.products, nil
+	}
+	products, err := fetchProducts(db)
+	if err != nil {
+		return nil, err
+	}
+	s.products, s.gen, s.builtAt = indexProducts(products), gen, time.Now()
+	return s.products, nil
+}
+
+func (c *searchIndexCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+}
+
+// searchCatalog ranks the shop's products matching f against query and returns
+// results offset..offset+limit together with the number of matches. The index
+// may lag behind other instances, so the returned page is read again from the
+// database: its prices and status are current, and products that no longer
+// match f are dropped.
+func searchCatalog(db *sql.DB, shopID int64, query string, f productFilter, offset, limit int) ([]SearchResult, int, error) {
+	idx, err := searchIndexes.get(db, shopID)
+	if err != nil {
+		return nil, 0, err
+	}
+	ranked := rankProducts(idx, query, func(p Product) bool {
+		return (!f.publicOnly || p.Status != statusDraft) && (f.categoryIDs == nil || f.categoryIDs[p.CategoryID]) &&
+			(f.source == "" || p.Source == f.source)
+	})
+	total := len(ranked)
+	if offset > len(ranked) {
+		offset = len(ranked)
+	}
+	ranked = ranked[offset:]
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+	if len(ranked) == 0 {
+		return []SearchResult{}, total, nil
+	}
+	f.ids = make([]int64, len(ranked))
+	for i, res := range ranked {
+		f.ids[i] = res.ID
+	}
+	f.limit, f.offset = 0, 0
+	fresh, err := fetchFilteredProducts(db, f)
+	if err != nil {
+		return nil, 0, err
+	}
+	byID := make(map[int64]Product, len(fresh))
+	for _, p := range fresh {
+		byID[p.ID] = p
+	}
+	out := make([]SearchResult, 0, len(ranked))
+	for _, res := range ranked {
+		if p, ok := byID[res.ID]; ok {
+			out = append(out, SearchResult{Product: p, Score: res.Score})
+		}
+	}
+	return out, total, nil
+}
+
+// Page size of /api/search; larger limits are clamped to maxSearchLimit.
+const (
+	defaultSearchLimit = 50
+	maxSearchLimit     = 200
+)
+
+// searchHandler serves GET /api/search?q=...&category=<id|slug>&limit=N for both
+// MySQL and dev stores. Every product of the shop is ranked (see
+// searchIndexCache); drafts (for visitors) and other categories are left out.
+// X-Total-Count holds the number of matches, which may exceed the page.
+func searchHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		q := strings.TrimSpace(r.URL.Query().Get("q"))
+		limit := defaultSearchLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			if n, err := strconv.Atoi(v); err == nil && n > 0 {
+				limit = n
+			}
+		}
+		if limit > maxSearchLimit {
+			limit = maxSearchLimit
+		}
+		filter := productFilter{publicOnly: !isAdmin(r)}
+		if ref := r.URL.Query().Get("category"); ref != "" {
+			cats, err := fetchCategories(db)
+			if err != nil {
+				log.Println("searchHandler categories error:", err)
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
+			c, ok := findCategory(cats, ref)
+			if !ok {
+				http.Error(w, "category not found", http.StatusNotFound)
+				return
+			}
+			filter.categoryIDs = categoryDescendants(cats, c.ID)
+		}
+		results, total := []SearchResult{}, 0
+		if searchTokens(q) != nil {
+			var err error
+			results, total, err = searchCatalog(db, requestShop(r).ID, q, filter, 0, limit)
+			if err != nil {
+				log.Println("searchHandler error:", err)
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Total-Count", strconv.Itoa(total))
+		_ = json.NewEncoder(w).Encode(results)
+	}
+}
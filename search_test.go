@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFoldVietnamese(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Đầm", "dam"},
+		{"ÁO KHOÁC", "ao khoac"},
+		{"Giày thể thao", "giay the thao"},
+		{"Mũ bảo hiểm", "mu bao hiem"},
+		{"Dưỡng ẩm", "duong am"},
+		{"Ve\u0301 xe", "ve xe"}, // decomposed é
+		{"iPhone 15", "iphone 15"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := foldVietnamese(tt.in); got != tt.want {
+			t.Errorf("foldVietnamese(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWithinEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		k    int
+		want bool
+	}{
+		{"khoac", "khoac", 0, true},
+		{"khoac", "khaoc", 1, true}, // adjacent transposition
+		{"khoac", "khoa", 1, true},  // deletion
+		{"khoac", "khoacc", 1, true},
+		{"khoac", "khuac", 1, true}, // substitution
+		{"khoac", "khuac", 0, false},
+		{"khoac", "kaoc", 1, false},
+		{"somi", "sommii", 1, false}, // length differs by more than k
+		{"giay", "giya", 1, true},
+		{"balo", "bolo", 1, true},
+		{"dongho", "hodong", 2, false},
+	}
+	for _, tt := range tests {
+		if got := withinEditDistance(tt.a, tt.b, tt.k); got != tt.want {
+			t.Errorf("withinEditDistance(%q, %q, %d) = %v, want %v", tt.a, tt.b, tt.k, got, tt.want)
+		}
+	}
+}
+
+func TestMatchTerm(t *testing.T) {
+	tokens := searchTokens("Áo khoác dù nam")
+	tests := []struct {
+		term string
+		want float64
+	}{
+		{"khoac", 1},   // exact
+		{"kho", 0.7},   // prefix
+		{"khaoc", 0.4}, // one typo
+		{"ao", 1},      // short terms match exactly
+		{"an", 0},      // short terms tolerate no typos
+		{"quan", 0},
+	}
+	for _, tt := range tests {
+		if got := matchTerm(tt.term, tokens); got != tt.want {
+			t.Errorf("matchTerm(%q) = %v, want %v", tt.term, got, tt.want)
+		}
+	}
+}
+
+func TestSearchProductsRanking(t *testing.T) {
+	products := []Product{
+		{ID: 1, Title: "Áo khoác dù", Category: "Áo"},
+		{ID: 2, Title: "Khoác len mỏng", Description: "áo mặc nhà"},
+		{ID: 3, Title: "Quần jean", Description: "đi kèm áo khoác"},
+		{ID: 4, Title: "Giày thể thao"},
+	}
+	ids := func(rs []SearchResult) []int64 {
+		var out []int64
+		for _, r := range rs {
+			out = append(out, r.ID)
+		}
+		return out
+	}
+	tests := []struct {
+		query string
+		want  []int64
+	}{
+		{"áo khoác", []int64{1, 2, 3}}, // phrase in title, then title word, then description only
+		{"khoac", []int64{2, 1, 3}},    // equal title scores fall back to newest first
+		{"khaoc", []int64{2, 1, 3}},    // typo still finds every coat
+		{"gia", []int64{4}},            // prefix
+		{"giay do", nil},               // every term must match
+		{"  ", nil},
+	}
+	for _, tt := range tests {
+		got := ids(searchProducts(products, tt.query))
+		if len(got) != len(tt.want) {
+			t.Errorf("searchProducts(%q) = %v, want %v", tt.query, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("searchProducts(%q) = %v, want %v", tt.query, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestSearchCatalog(t *testing.T) {
+	products := make([]Product, 0, 6002)
+	for i := 1; i <= 6000; i++ {
+		products = append(products, Product{ID: int64(i), Title: "Quần jean", Price: 100000, Status: statusPublished, Position: i})
+	}
+	// ranked last in storefront order, past where a candidate cap would cut
+	products = append(products,
+		Product{ID: 6001, Title: "Áo khoác", Price: 100000, Status: statusPublished, CategoryID: 2, Position: 9000},
+		Product{ID: 6002, Title: "Áo khoác nháp", Price: 100000, Status: statusDraft, CategoryID: 3, Position: 9001},
+	)
+	useDevOrders(t, products, nil)
+
+	tests := []struct {
+		name      string
+		query     string
+		filter    productFilter
+		offset    int
+		limit     int
+		wantIDs   []int64
+		wantTotal int
+	}{
+		{"beyond 5000 products", "khoac", productFilter{publicOnly: true}, 0, 10, []int64{6001}, 1},
+		{"admin sees drafts", "khoac", productFilter{}, 0, 10, []int64{6002, 6001}, 2},
+		{"category", "khoac", productFilter{categoryIDs: map[int64]bool{3: true}}, 0, 10, []int64{6002}, 1},
+		{"page", "jean", productFilter{publicOnly: true}, 0, 2, []int64{6000, 5999}, 6000},
+		{"offset", "jean", productFilter{publicOnly: true}, 5999, 2, []int64{1}, 6000},
+		{"offset past the end", "jean", productFilter{publicOnly: true}, 7000, 2, nil, 6000},
+		{"no match", "giay", productFilter{}, 0, 10, nil, 0},
+	}
+	for _, tt := range tests {
+		got, total, err := searchCatalog(nil, defaultShopID, tt.query, tt.filter, tt.offset, tt.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []int64
+		for _, r := range got {
+			ids = append(ids, r.ID)
+		}
+		if total != tt.wantTotal || len(ids) != len(tt.wantIDs) {
+			t.Errorf("%s: ids %v total %d, want %v total %d", tt.name, ids, total, tt.wantIDs, tt.wantTotal)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != tt.wantIDs[i] {
+				t.Errorf("%s: ids %v, want %v", tt.name, ids, tt.wantIDs)
+				break
+			}
+		}
+	}
+}
+
+func TestSearchCatalogFreshPage(t *testing.T) {
+	useDevOrders(t, []Product{{ID: 1, Title: "Áo khoác", Price: 100000, Status: statusPublished}}, nil)
+	if _, total, err := searchCatalog(nil, defaultShopID, "khoac", productFilter{publicOnly: true}, 0, 10); err != nil || total != 1 {
+		t.Fatalf("total = %d, %v", total, err)
+	}
+
+	// a change the index has not seen yet: the page is read again
+	devMu.Lock()
+	devProducts[0].Price, devProducts[0].Status = 90000, statusDraft
+	devMu.Unlock()
+	if got, _, _ := searchCatalog(nil, defaultShopID, "khoac", productFilter{publicOnly: true}, 0, 10); len(got) != 0 {
+		t.Errorf("draft still listed: %+v", got)
+	}
+	if got, _, _ := searchCatalog(nil, defaultShopID, "khoac", productFilter{}, 0, 10); len(got) != 1 || got[0].Price != 90000 {
+		t.Errorf("results = %+v, want the current price", got)
+	}
+
+	// a new product appears once the catalog cache is invalidated
+	devMu.Lock()
+	devProducts = append(devProducts, Product{ID: 2, Title: "Khoác gió", Price: 50000, Status: statusPublished})
+	devMu.Unlock()
+	invalidateCatalogCache()
+	if _, total, _ := searchCatalog(nil, defaultShopID, "khoac", productFilter{}, 0, 10); total != 2 {
+		t.Errorf("total after invalidation = %d, want 2", total)
+	}
+}
+
+func TestSearchHandler(t *testing.T) {
+	useDevOrders(t, []Product{
+		{ID: 1, Title: "Áo khoác", Price: 100000, Status: statusPublished},
+		{ID: 2, Title: "Áo khoác da", Price: 100000, Status: statusPublished},
+	}, nil)
+	tests := []struct {
+		url       string
+		wantCode  int
+		wantTotal string
+		wantLen   int
+	}{
+		{"/api/search?q=khoac&limit=1", 200, "2", 1},
+		{"/api/search?q=", 200, "0", 0},
+		{"/api/search?q=khoac&category=nope", 404, "", 0},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		searchHandler(nil)(w, httptest.NewRequest(http.MethodGet, tt.url, nil))
+		if w.Code != tt.wantCode {
+			t.Errorf("%s = %d, want %d", tt.url, w.Code, tt.wantCode)
+			continue
+		}
+		if tt.wantCode != 200 {
+			continue
+		}
+		var got []SearchResult
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != tt.wantLen || w.Header().Get("X-Total-Count") != tt.wantTotal {
+			t.Errorf("%s = %d results, total %q; want %d, %q", tt.url, len(got), w.Header().Get("X-Total-Count"), tt.wantLen, tt.wantTotal)
+		}
+	}
+}
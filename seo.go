@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// catalogCacheMaxAge bounds how long a cached document is served even without
+// an invalidation, e.g. when another instance changed the catalog.
+const catalogCacheMaxAge = time.Hour
+
+// catalogCache memoizes documents generated from the catalog (sitemap, feeds)
+// until the catalog changes. Documents are built outside mu, and concurrent
+// requests for a missing document wait for one build instead of each querying.
+type catalogCache struct {
+	mu       sync.Mutex
+	entries  map[string]cachedDoc
+	building map[string]*catalogBuild
+	gen      uint64 // bumped by invalidate; a build started before it is not stored
+}
+
+type cachedDoc struct {
+	body    []byte
+	builtAt time.Time
+}
+
+// catalogBuild is a build in progress; done is closed once body and err are set.
+type catalogBuild struct {
+	done chan struct{}
+	body []byte
+	err  error
+}
+
+var renderedCatalog = newCatalogCache()
+
+func newCatalogCache() *catalogCache {
+	return &catalogCache{entries: map[string]cachedDoc{}, building: map[string]*catalogBuild{}}
+}
+
+// get returns the cached document for key, calling build when it is missing or
+// stale, or waiting for the build another request already started.
+func (c *catalogCache) get(key string, build func() ([]byte, error)) ([]byte, error) {
+	c.mu.Lock()
+	if d, ok := c.entries[key]; ok && time.Since(d.builtAt) < catalogCacheMaxAge {
+		c.mu.Unlock()
+		return d.body, nil
+	}
+	if b, ok := c.building[key]; ok {
+		c.mu.Unlock()
+		<-b.done
+		return b.body, b.err
+	}
+	b := &catalogBuild{done: make(chan struct{})}
+	c.building[key] = b
+	gen := c.gen
+	c.mu.Unlock()
+
+	b.body, b.err = build()
+
+	c.mu.Lock()
+	if c.building[key] == b {
+		delete(c.building, key)
+	}
+	if b.err == nil && c.gen == gen {
+		c.entries[key] = cachedDoc{body: b.body, builtAt: time.Now()}
+	}
+	c.mu.Unlock()
+	close(b.done)
+	return b.body, b.err
+}
+
+// invalidate drops every cached document. Builds in progress still answer the
+// requests waiting for them, but are not stored, and later requests start anew.
+func (c *catalogCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = map[string]cachedDoc{}
+	c.building = map[string]*catalogBuild{}
+	c.gen++
+}
+
+// cached is get keyed by doc within r's shop. Documents embed absolute links, so
+// they are only cached when PUBLIC_BASE_URL pins those links; otherwise they would
+// follow the client's Host header, and every spoofed host would add an entry.
+func (c *catalogCache) cached(r *http.Request, doc string, build func() ([]byte, error)) ([]byte, error) {
+	public := strings.TrimRight(os.Getenv("PUBLIC_BASE_URL"), "/")
+	if public == "" {
+		return build()
+	}
+	return c.get(fmt.Sprintf("shop %d %s%s %s", requestShop(r).ID, public, shopBase(r), doc), build)
+}
+
+// invalidateCatalogCache drops every cached catalog document and search index.
+func invalidateCatalogCache() {
+	renderedCatalog.invalidate()
+	searchIndexes.invalidate()
+}
+
+// statusRecorder remembers the status code a handler responded with.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+// invalidatesCatalog wraps an admin handler whose writes change the public
+// catalog so that cached documents are rebuilt after a successful non-GET
+// request. Rejected and anonymous requests leave the cache alone.
+func invalidatesCatalog(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			h(w, r)
+			return
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+		h(rec, r)
+		if rec.status < 400 && isAdmin(r) {
+			invalidateCatalogCache()
+		}
+	}
+}
+
+// parseProductTime parses a CreatedAt/UpdatedAt string; zero when empty or invalid.
+func parseProductTime(s string) time.Time {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04:05", s, time.Local); err == nil {
+		return t
+	}
+	return time.Time{}
+}
+
+// productLastModified is the product's UpdatedAt (or CreatedAt) as a time; zero when unknown.
+func productLastModified(p Product) time.Time {
+	if t := parseProductTime(p.UpdatedAt); !t.IsZero() {
+		return t
+	}
+	return parseProductTime(p.CreatedAt)
+}
+
+// encodeXMLDoc renders v as an indented XML document with a header.
+func encodeXMLDoc(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+// sitemapLastMod formats t for <lastmod>; zero times are left out.
+func sitemapLastMod(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// buildSitemap lists the home page, category pages (/?category=slug) and the
+// pages of all public products. Category and home lastmod is the newest change
+// of a product they show.
+func buildSitemap(db *sql.DB, base string) ([]byte, error) {
+	products, err := fetchProducts(db)
+	if err != nil {
+		return nil, err
+	}
+	cats, err := fetchCategories(db)
+	if err != nil {
+		return nil, err
+	}
+	var newest time.Time
+	newestByCat := map[int64]time.Time{}
+	var productURLs []sitemapURL
+	for _, p := range products {
+		if p.Status == statusDraft {
+			continue
+		}
+		mod := productLastModified(p)
+		if mod.After(newest) {
+			newest = mod
+		}
+		if mod.After(newestByCat[p.CategoryID]) {
+			newestByCat[p.CategoryID] = mod
+		}
+		productURLs = append(productURLs, sitemapURL{Loc: base + p.PageURL, LastMod: sitemapLastMod(mod)})
+	}
+	set := sitemapURLSet{XMLNS: "http://www.sitemaps.org/schemas/sitemap/0.9"}
+	set.URLs = append(set.URLs, sitemapURL{Loc: base + "/", LastMod: sitemapLastMod(newest)})
+	for _, c := range cats {
+		if c.Slug == "" {
+			continue
+		}
+		var mod time.Time
+		for id := range categoryDescendants(cats, c.ID) {
+			if newestByCat[id].After(mod) {
+				mod = newestByCat[id]
+			}
+		}
+		if mod.IsZero() {
+			continue // empty categories are not worth crawling
+		}
+		set.URLs = append(set.URLs, sitemapURL{Loc: base + "/?category=" + url.QueryEscape(c.Slug), LastMod: sitemapLastMod(mod)})
+	}
+	set.URLs = append(set.URLs, productURLs...)
+	return encodeXMLDoc(set)
+}
+
+// sitemapHandler serves GET /sitemap.xml from the catalog cache.
+func sitemapHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		base := siteURL(r)
+		body, err := renderedCatalog.cached(r, "sitemap", func() ([]byte, error) { return buildSitemap(db, base) })
+		if err != nil {
+			log.Println("sitemap error:", err)
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		_, _ = w.Write(body)
+	}
+}
+
+// robotsRules returns the robots.txt body without the Sitemap line:
+// ROBOTS_TXT_FILE verbatim when set, everything blocked when ROBOTS_NOINDEX is
+// true (staging), otherwise the storefront open and admin/API/redirect paths closed.
+func robotsRules() (string, error) {
+	if path := os.Getenv("ROBOTS_TXT_FILE"); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+	if strings.EqualFold(os.Getenv("ROBOTS_NOINDEX"), "true") {
+		return "User-agent: *\nDisallow: /\n", nil
+	}
+	return "User-agent: *\nDisallow: /admin\nDisallow: /api/\nDisallow: /go/\nAllow: /\n", nil
+}
+
+// robotsHandler serves GET /robots.txt, pointing crawlers at the sitemap unless
+// the configured rules already name one.
+func robotsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		rules, err := robotsRules()
+		if err != nil {
+			log.Println("robots.txt error:", err)
+			http.Error(w, "robots.txt unavailable", http.StatusInternalServerError)
+			return
+		}
+		hasSitemap := false
+		sc := bufio.NewScanner(strings.NewReader(rules))
+		for sc.Scan() {
+			if strings.HasPrefix(strings.ToLower(strings.TrimSpace(sc.Text())), "sitemap:") {
+				hasSitemap = true
+			}
+		}
+		if !strings.HasSuffix(rules, "\n") {
+			rules += "\n"
+		}
+		if !hasSitemap {
+			rules += "\nSitemap: " + siteURL(r) + "/sitemap.xml\n"
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		_, _ = w.Write([]byte(rules))
+	}
+}
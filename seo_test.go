@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCatalogCacheBuildsOnce(t *testing.T) {
+	c := newCatalogCache()
+	var builds int32
+	release := make(chan struct{})
+	build := func() ([]byte, error) {
+		atomic.AddInt32(&builds, 1)
+		<-release
+		return []byte("sitemap"), nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if body, err := c.get("k", build); err != nil || string(body) != "sitemap" {
+				t.Errorf("get = %q, %v", body, err)
+			}
+		}()
+	}
+	// another key is served while "k" is still building
+	done := make(chan struct{})
+	go func() {
+		_, _ = c.get("other", func() ([]byte, error) { return []byte("feed"), nil })
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("a build of one key blocked another key")
+	}
+	close(release)
+	wg.Wait()
+	if n := atomic.LoadInt32(&builds); n != 1 {
+		t.Errorf("builds = %d, want 1", n)
+	}
+	if _, err := c.get("k", func() ([]byte, error) { return nil, errors.New("not cached") }); err != nil {
+		t.Errorf("second get rebuilt: %v", err)
+	}
+}
+
+func TestCatalogCacheErrorsAndInvalidation(t *testing.T) {
+	c := newCatalogCache()
+	if _, err := c.get("k", func() ([]byte, error) { return nil, errors.New("db down") }); err == nil {
+		t.Fatal("build error was not returned")
+	}
+	if body, err := c.get("k", func() ([]byte, error) { return []byte("v1"), nil }); err != nil || string(body) != "v1" {
+		t.Errorf("after a failed build get = %q, %v; want a rebuild", body, err)
+	}
+
+	// a build that started before an invalidation is not stored
+	started, release, finished := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(finished)
+		_, _ = c.get("stale", func() ([]byte, error) {
+			close(started)
+			<-release
+			return []byte("old"), nil
+		})
+	}()
+	<-started
+	c.invalidate()
+	close(release)
+	<-finished
+	if body, _ := c.get("stale", func() ([]byte, error) { return []byte("new"), nil }); string(body) != "new" {
+		t.Errorf("get after invalidate = %q, want a fresh build", body)
+	}
+	if body, _ := c.get("k", func() ([]byte, error) { return []byte("v2"), nil }); string(body) != "v2" {
+		t.Errorf("get after invalidate = %q, want v2", body)
+	}
+}
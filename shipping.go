@@ -0,0 +1,464 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Shipping zone rate kinds.
+const (
+	shippingFlat   = "flat"   // BaseFee per order
+	shippingWeight = "weight" // BaseFee covers IncludedGrams, then PerKgFee per started kg
+)
+
+// defaultItemWeightGrams is assumed for products without a weight; quotes that
+// rely on it report weight_estimated.
+const defaultItemWeightGrams = 500
+
+// provinceAliases maps common short names to the folded province name.
+var provinceAliases = map[string]string{
+	"hcm":     "ho chi minh",
+	"tphcm":   "ho chi minh",
+	"sai gon": "ho chi minh",
+	"saigon":  "ho chi minh",
+	"hn":      "ha noi",
+	"hanoi":   "ha noi",
+	"danang":  "da nang",
+	"hue":     "thua thien hue",
+}
+
+// normalizeProvince folds a province or city name for matching, so that
+// "TP. Hồ Chí Minh", "Thành phố Hồ Chí Minh" and "hcm" all become "ho chi minh".
+func normalizeProvince(s string) string {
+	s = strings.Join(strings.Fields(foldVietnamese(s)), " ")
+	for _, prefix := range []string{"thanh pho ", "tinh ", "tp. ", "tp.", "tp "} {
+		if strings.HasPrefix(s, prefix) {
+			s = strings.TrimSpace(strings.TrimPrefix(s, prefix))
+			break
+		}
+	}
+	if a, ok := provinceAliases[s]; ok {
+		return a
+	}
+	return s
+}
+
+// fee returns the shipping fee for an order worth subtotal weighing grams.
+func (z ShippingZone) fee(subtotal Money, grams int) Money {
+	if z.FreeOver > 0 && subtotal >= z.FreeOver {
+		return 0
+	}
+	fee := z.BaseFee
+	if z.Kind == shippingWeight && grams > z.IncludedGrams {
+		kg := (grams - z.IncludedGrams + 999) / 1000
+		fee += z.PerKgFee * Money(kg)
+	}
+	return fee
+}
+
+// matchZone picks the zone that lists province, falling back to the first zone
+// without provinces. Zones are expected in position order.
+func matchZone(zones []ShippingZone, province string) (ShippingZone, bool) {
+	key := normalizeProvince(province)
+	for _, z := range zones {
+		for _, p := range z.Provinces {
+			if normalizeProvince(p) == key {
+				return z, true
+			}
+		}
+	}
+	for _, z := range zones {
+		if len(z.Provinces) == 0 {
+			return z, true
+		}
+	}
+	return ShippingZone{}, false
+}
+
+// sortZones orders zones by position, then id.
+func sortZones(zones []ShippingZone) {
+	sort.SliceStable(zones, func(i, j int) bool {
+		if zones[i].Position != zones[j].Position {
+			return zones[i].Position < zones[j].Position
+		}
+		return zones[i].ID < zones[j].ID
+	})
+}
+
+// fetchShippingZones returns all zones in position order.
+func fetchShippingZones(db *sql.DB) ([]ShippingZone, error) {
+	if db == nil {
+		zones := DevGetShippingZones()
+		sortZones(zones)
+		return zones, nil
+	}
+	rows, err := db.Query("SELECT id, name, IFNULL(provinces,''), kind, base_fee_minor, per_kg_fee_minor, included_grams, IFNULL(free_over_minor,0), position FROM shipping_zones WHERE shop_id = @shop_id ORDER BY position, id")
+	if err != nil {
+		return nil, fmt.Errorf("query shipping zones: %w", err)
+	}
+	defer rows.Close()
+	var out []ShippingZone
+	for rows.Next() {
+		var z ShippingZone
+		var provinces string
+		if err := rows.Scan(&z.ID, &z.Name, &provinces, &z.Kind, &z.BaseFee, &z.PerKgFee, &z.IncludedGrams, &z.FreeOver, &z.Position); err != nil {
+			return nil, fmt.Errorf("scan shipping zone: %w", err)
+		}
+		if provinces != "" {
+			if err := json.Unmarshal([]byte(provinces), &z.Provinces); err != nil {
+				log.Printf("shipping zone %d: bad provinces: %v", z.ID, err)
+			}
+		}
+		if z.Provinces == nil {
+			z.Provinces = []string{}
+		}
+		out = append(out, z)
+	}
+	return out, rows.Err()
+}
+
+// shippingZonePayload is the admin JSON body for creating or updating a zone.
+// Amounts are in minor units.
+type shippingZonePayload struct {
+	Name          *string   `json:"name"`
+	Provinces     *[]string `json:"provinces"`
+	Kind          *string   `json:"kind"`
+	BaseFee       *Money    `json:"base_fee"`
+	PerKgFee      *Money    `json:"per_kg_fee"`
+	IncludedGrams *int      `json:"included_grams"`
+	FreeOver      *Money    `json:"free_over"`
+	Position      *int      `json:"position"`
+}
+
+// apply copies the provided fields onto z and validates the result.
+func (p shippingZonePayload) apply(z *ShippingZone) error {
+	if p.Name != nil {
+		z.Name = strings.TrimSpace(*p.Name)
+	}
+	if p.Provinces != nil {
+		z.Provinces = []string{}
+		seen := make(map[string]bool)
+		for _, prov := range *p.Provinces {
+			prov = strings.TrimSpace(prov)
+			if prov == "" || seen[normalizeProvince(prov)] {
+				continue
+			}
+			seen[normalizeProvince(prov)] = true
+			z.Provinces = append(z.Provinces, prov)
+		}
+	}
+	if p.Kind != nil {
+		z.Kind = strings.ToLower(strings.TrimSpace(*p.Kind))
+	}
+	if p.BaseFee != nil {
+		z.BaseFee = *p.BaseFee
+	}
+	if p.PerKgFee != nil {
+		z.PerKgFee = *p.PerKgFee
+	}
+	if p.IncludedGrams != nil {
+		z.IncludedGrams = *p.IncludedGrams
+	}
+	if p.FreeOver != nil {
+		z.FreeOver = *p.FreeOver
+	}
+	if p.Position != nil {
+		z.Position = *p.Position
+	}
+	if z.Name == "" || len(z.Name) > 255 {
+		return errors.New("name required (at most 255 characters)")
+	}
+	switch z.Kind {
+	case shippingFlat:
+		z.PerKgFee, z.IncludedGrams = 0, 0
+	case shippingWeight:
+	default:
+		return errors.New("kind must be flat or weight")
+	}
+	for _, m := range []Money{z.BaseFee, z.PerKgFee, z.FreeOver} {
+		if m < 0 || m > maxMoney {
+			return errors.New("fees must not be negative")
+		}
+	}
+	if z.IncludedGrams < 0 {
+		return errors.New("included_grams must not be negative")
+	}
+	return nil
+}
+
+// saveShippingZone inserts (ID 0) or updates a zone and returns it.
+func saveShippingZone(db *sql.DB, z ShippingZone) (ShippingZone, error) {
+	if db == nil {
+		if z.ID == 0 {
+			return DevAddShippingZone(z), nil
+		}
+		if !DevUpdateShippingZone(z) {
+			return z, sql.ErrNoRows
+		}
+		return z, nil
+	}
+	provinces, err := json.Marshal(z.Provinces)
+	if err != nil {
+		return z, err
+	}
+	if z.ID == 0 {
+		res, err := db.Exec("INSERT INTO shipping_zones (shop_id, name, provinces, kind, base_fee_minor, per_kg_fee_minor, included_grams, free_over_minor, position) VALUES (@shop_id, ?, ?, ?, ?, ?, ?, ?, ?)",
+			z.Name, string(provinces), z.Kind, int64(z.BaseFee), int64(z.PerKgFee), z.IncludedGrams, sqlNullMoney(z.FreeOver), z.Position)
+		if err != nil {
+			return z, err
+		}
+		z.ID, _ = res.LastInsertId()
+		return z, nil
+	}
+	_, err = db.Exec("UPDATE shipping_zones SET name=?, provinces=?, kind=?, base_fee_minor=?, per_kg_fee_minor=?, included_grams=?, free_over_minor=?, position=? WHERE id=? AND shop_id = @shop_id",
+		z.Name, string(provinces), z.Kind, int64(z.BaseFee), int64(z.PerKgFee), z.IncludedGrams, sqlNullMoney(z.FreeOver), z.Position, z.ID)
+	return z, err
+}
+
+// shippingZonesHandler serves admin GET (list) and POST (create) on /api/shipping/zones.
+func shippingZonesHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			zones, err := fetchShippingZones(db)
+			if err != nil {
+				log.Println("fetchShippingZones error:", err)
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
+			if zones == nil {
+				zones = []ShippingZone{}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(zones)
+
+		case http.MethodPost:
+			var payload shippingZonePayload
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+				http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			z := ShippingZone{Kind: shippingFlat, Provinces: []string{}}
+			if err := payload.apply(&z); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			z, err := saveShippingZone(db, z)
+			if err != nil {
+				log.Println("create shipping zone error:", err)
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(z)
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// shippingZoneItemHandler serves admin GET, PUT and DELETE on /api/shipping/zones/{id}.
+func shippingZoneItemHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/api/shipping/zones/"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		zones, err := fetchShippingZones(db)
+		if err != nil {
+			log.Println("fetchShippingZones error:", err)
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		var cur ShippingZone
+		found := false
+		for _, z := range zones {
+			if z.ID == id {
+				cur, found = z, true
+			}
+		}
+		if !found {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(cur)
+
+		case http.MethodPut:
+			var payload shippingZonePayload
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+				http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := payload.apply(&cur); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if _, err := saveShippingZone(db, cur); err != nil {
+				log.Println("update shipping zone error:", err)
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(cur)
+
+		case http.MethodDelete:
+			if db == nil {
+				DevDeleteShippingZone(id)
+			} else if _, err := db.Exec("DELETE FROM shipping_zones WHERE id = ? AND shop_id = @shop_id", id); err != nil {
+				log.Println("delete shipping zone error:", err)
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// shippingQuoteInput is the public quote request: the same items as an order
+// request (or none, to quote the cart) plus the destination province.
+type shippingQuoteInput struct {
+	orderInput
+	Province string `json:"province"`
+}
+
+// ShippingQuote is the shipping fee for a set of items to one destination.
+type ShippingQuote struct {
+	Province        string `json:"province"`
+	ZoneID          int64  `json:"zone_id"`
+	Zone            string `json:"zone"`
+	Currency        string `json:"currency"`
+	Subtotal        Money  `json:"subtotal"`
+	Discount        Money  `json:"discount"`
+	WeightGrams     int    `json:"weight_grams"`
+	WeightEstimated bool   `json:"weight_estimated"` // some products have no weight set
+	Fee             Money  `json:"fee"`
+	FeeFormatted    string `json:"fee_formatted"`
+	FreeShipping    bool   `json:"free_shipping"`
+	FreeOver        Money  `json:"free_over,omitempty"`
+	FreeRemaining   Money  `json:"free_remaining,omitempty"` // spend this much more for free shipping
+}
+
+// quoteShipping prices in for delivery to in.Province. The free-shipping threshold
+// applies to the subtotal after the coupon discount.
+func quoteShipping(db *sql.DB, in shippingQuoteInput) (ShippingQuote, error) {
+	q := ShippingQuote{Province: strings.TrimSpace(in.Province)}
+	if q.Province == "" {
+		return q, orderError{"province required"}
+	}
+	items, err := orderItems(db, in.orderInput)
+	if err != nil {
+		return q, err
+	}
+	for _, it := range items {
+		q.Subtotal += it.UnitPrice * Money(it.Quantity)
+		p, _, err := fetchProduct(db, it.ProductID)
+		if err != nil {
+			return q, err
+		}
+		weight := defaultItemWeightGrams
+		if p.WeightGrams != nil {
+			weight = *p.WeightGrams
+		} else {
+			q.WeightEstimated = true
+		}
+		q.WeightGrams += weight * it.Quantity
+	}
+	_, q.Discount, err = couponDiscount(db, in.CouponCode, q.Subtotal)
+	if err != nil {
+		return q, err
+	}
+	zones, err := fetchShippingZones(db)
+	if err != nil {
+		return q, err
+	}
+	z, ok := matchZone(zones, q.Province)
+	if !ok {
+		return q, orderError{fmt.Sprintf("chưa hỗ trợ giao hàng tới %s", q.Province)}
+	}
+	cur := shopCurrency(db)
+	goods := q.Subtotal - q.Discount
+	q.ZoneID, q.Zone, q.Currency = z.ID, z.Name, cur.Code
+	q.Fee = z.fee(goods, q.WeightGrams)
+	q.FeeFormatted = cur.Format(q.Fee)
+	q.FreeOver = z.FreeOver
+	q.FreeShipping = z.FreeOver > 0 && goods >= z.FreeOver
+	if z.FreeOver > 0 && !q.FreeShipping {
+		q.FreeRemaining = z.FreeOver - goods
+	}
+	return q, nil
+}
+
+// shippingQuoteHandler serves the public POST /api/shipping/quote. Without items
+// in the body it quotes the visitor's cart, including the cart's coupon.
+func shippingQuoteHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var in shippingQuoteInput
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&in); err != nil {
+			http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(in.Items) == 0 && in.ProductID == 0 {
+			if ck, err := r.Cookie(cartCookie); err == nil && ck.Value != "" {
+				lines, _, ok, err := loadCartLines(db, ck.Value)
+				if err != nil {
+					log.Println("cart load error:", err)
+					http.Error(w, "db error", http.StatusInternalServerError)
+					return
+				}
+				if ok {
+					for _, l := range lines {
+						in.Items = append(in.Items, orderItemInput{ProductID: l.ProductID, Variant: l.Variant, Quantity: l.Quantity})
+					}
+					if in.CouponCode == "" {
+						if in.CouponCode, err = cartCoupon(db, ck.Value); err != nil {
+							log.Println("cart load error:", err)
+							http.Error(w, "db error", http.StatusInternalServerError)
+							return
+						}
+					}
+				}
+			}
+		}
+		q, err := quoteShipping(db, in)
+		var oe orderError
+		if errors.As(err, &oe) {
+			http.Error(w, oe.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Println("quoteShipping error:", err)
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(q)
+	}
+}
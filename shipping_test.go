@@ -0,0 +1,64 @@
+package main
+
+import "testing"
+
+func TestShippingZoneFee(t *testing.T) {
+	flat := ShippingZone{Kind: shippingFlat, BaseFee: 30000, FreeOver: 500000}
+	weight := ShippingZone{Kind: shippingWeight, BaseFee: 25000, PerKgFee: 5000, IncludedGrams: 1000, FreeOver: 500000}
+	never := ShippingZone{Kind: shippingWeight, BaseFee: 25000, PerKgFee: 5000, IncludedGrams: 1000}
+	tests := []struct {
+		name     string
+		zone     ShippingZone
+		subtotal Money
+		grams    int
+		want     Money
+	}{
+		{"flat ignores weight", flat, 100000, 9000, 30000},
+		{"flat under threshold", flat, 499999, 0, 30000},
+		{"flat at threshold", flat, 500000, 0, 0},
+		{"within included weight", weight, 100000, 1000, 25000},
+		{"one gram over starts a kg", weight, 100000, 1001, 30000},
+		{"exactly one kg over", weight, 100000, 2000, 30000},
+		{"just over two kg over", weight, 100000, 3001, 40000},
+		{"weight at threshold", weight, 500000, 9000, 0},
+		{"weight above threshold", weight, 750000, 9000, 0},
+		{"zero free_over never free", never, 10000000, 500, 25000},
+	}
+	for _, tt := range tests {
+		if got := tt.zone.fee(tt.subtotal, tt.grams); got != tt.want {
+			t.Errorf("%s: fee(%d, %d) = %d, want %d", tt.name, tt.subtotal, tt.grams, got, tt.want)
+		}
+	}
+}
+
+func TestMatchZone(t *testing.T) {
+	zones := []ShippingZone{
+		{ID: 1, Name: "Nội thành", Provinces: []string{"TP. Hồ Chí Minh"}},
+		{ID: 2, Name: "Toàn quốc"},
+		{ID: 3, Name: "Miền Bắc", Provinces: []string{"Hà Nội", "Hải Phòng"}},
+		{ID: 4, Name: "Khác"},
+	}
+	tests := []struct {
+		province string
+		want     int64
+	}{
+		{"Thành phố Hồ Chí Minh", 1},
+		{"hcm", 1},
+		{"  ho   chi minh ", 1},
+		{"Tỉnh Hà Nội", 3}, // a listed province wins over an earlier fallback zone
+		{"hanoi", 3},
+		{"Hải Phòng", 3},
+		{"Cà Mau", 2}, // first zone without provinces
+		{"", 2},
+	}
+	for _, tt := range tests {
+		z, ok := matchZone(zones, tt.province)
+		if !ok || z.ID != tt.want {
+			t.Errorf("matchZone(%q) = %d, %v; want %d", tt.province, z.ID, ok, tt.want)
+		}
+	}
+
+	if z, ok := matchZone(zones[:1], "Cà Mau"); ok {
+		t.Errorf("matchZone without a fallback zone = %d, want no match", z.ID)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ShopeeLink identifies a Shopee listing.
+type ShopeeLink struct {
+	ShopID int64
+	ItemID int64
+}
+
+// Canonical is the listing URL without tracking or affiliate parameters.
+func (l ShopeeLink) Canonical() string {
+	return fmt.Sprintf("https://shopee.vn/product/%d/%d", l.ShopID, l.ItemID)
+}
+
+var (
+	// "/Ao-khoac-jean-i.123456.7891011" (product slug pages)
+	shopeeSlugPath = regexp.MustCompile(`-i\.(\d+)\.(\d+)$`)
+	// "/product/123456/7891011" and "/universal-link/product/123456/7891011"
+	shopeeProductPath = regexp.MustCompile(`^/(?:universal-link/)?product/(\d+)/(\d+)$`)
+)
+
+// shopeeShortHosts serve short links that redirect to a listing.
+var shopeeShortHosts = map[string]bool{"shp.ee": true, "vn.shp.ee": true, "s.shopee.vn": true}
+
+var errNotShopeeURL = errors.New("external_url must be a Shopee product link (shopee.vn/...-i.<shop>.<item>, shopee.vn/product/<shop>/<item> or shp.ee/...)")
+
+// isShopeeHost reports whether host is shopee.vn or one of its subdomains.
+func isShopeeHost(host string) bool {
+	host = strings.ToLower(host)
+	return host == "shopee.vn" || strings.HasSuffix(host, ".shopee.vn")
+}
+
+// parseShopeeURL extracts shop and item IDs from a full Shopee product URL.
+// Short links must be resolved first, see resolveShopeeURL.
+func parseShopeeURL(raw string) (ShopeeLink, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || !isShopeeHost(u.Hostname()) {
+		return ShopeeLink{}, errNotShopeeURL
+	}
+	path := strings.TrimSuffix(u.EscapedPath(), "/")
+	m := shopeeProductPath.FindStringSubmatch(path)
+	if m == nil {
+		m = shopeeSlugPath.FindStringSubmatch(path)
+	}
+	if m == nil {
+		return ShopeeLink{}, errNotShopeeURL
+	}
+	shop, err1 := strconv.ParseInt(m[1], 10, 64)
+	item, err2 := strconv.ParseInt(m[2], 10, 64)
+	if err1 != nil || err2 != nil || shop <= 0 || item <= 0 {
+		return ShopeeLink{}, errNotShopeeURL
+	}
+	return ShopeeLink{ShopID: shop, ItemID: item}, nil
+}
+
+// shopeeResolveTimeout bounds resolving a short link, across all hops, so a
+// slow shp.ee cannot hold a product save open.
+const shopeeResolveTimeout = 3 * time.Second
+
+// shopeeRedirectClient follows no redirects so short links can be resolved hop by hop.
+var shopeeRedirectClient = &http.Client{
+	Timeout: shopeeResolveTimeout,
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// isShopeeShortLink reports whether raw is a short link that needs resolving over
+// the network.
+func isShopeeShortLink(raw string) bool {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && shopeeShortHosts[strings.ToLower(u.Hostname())]
+}
+
+// resolveShopeeURL parses raw, following short-link redirects (shp.ee) until a
+// product URL appears.
+func resolveShopeeURL(raw string) (ShopeeLink, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), shopeeResolveTimeout)
+	defer cancel()
+	next := strings.TrimSpace(raw)
+	for hop := 0; hop < 5; hop++ {
+		u, err := url.Parse(next)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return ShopeeLink{}, errNotShopeeURL
+		}
+		if !shopeeShortHosts[strings.ToLower(u.Hostname())] {
+			return parseShopeeURL(next)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return ShopeeLink{}, errNotShopeeURL
+		}
+		resp, err := shopeeRedirectClient.Do(req)
+		if err != nil {
+			return ShopeeLink{}, fmt.Errorf("could not resolve Shopee short link, paste the full product URL instead: %w", err)
+		}
+		resp.Body.Close()
+		loc, err := resp.Location()
+		if err != nil {
+			return ShopeeLink{}, errors.New("Shopee short link does not point to a product")
+		}
+		next = loc.String()
+	}
+	return ShopeeLink{}, errors.New("Shopee short link redirects too often")
+}
+
+// normalizeShopeeURL turns any accepted Shopee link into its canonical form.
+// An empty string stays empty.
+func normalizeShopeeURL(raw string) (string, error) {
+	if strings.TrimSpace(raw) == "" {
+		return "", nil
+	}
+	l, err := resolveShopeeURL(raw)
+	if err != nil {
+		return "", err
+	}
+	return l.Canonical(), nil
+}
+
+// validateAffiliateParams checks the shop's affiliate query string, e.g.
+// "utm_source=an_17312345678&utm_medium=affiliates", and returns it re-encoded.
+func validateAffiliateParams(s string) (string, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "?")
+	if s == "" {
+		return "", nil
+	}
+	if len(s) > 512 {
+		return "", errors.New("shopee_affiliate_params too long")
+	}
+	q, err := url.ParseQuery(s)
+	if err != nil {
+		return "", errors.New("shopee_affiliate_params must be a query string like utm_source=an_123")
+	}
+	return q.Encode(), nil
+}
+
+// withAffiliateParams adds the shop's affiliate parameters to a Shopee link.
+// Links that are not on shopee.vn are returned unchanged.
+func withAffiliateParams(link, params string) string {
+	if params == "" {
+		return link
+	}
+	u, err := url.Parse(link)
+	if err != nil || !isShopeeHost(u.Hostname()) {
+		return link
+	}
+	extra, err := url.ParseQuery(params)
+	if err != nil {
+		return link
+	}
+	q := u.Query()
+	for k, vs := range extra {
+		q[k] = vs
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
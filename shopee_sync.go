@@ -0,0 +1,476 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ShopeeMetadata is what a fetcher reports about a listing. Price is in đồng.
+type ShopeeMetadata struct {
+	Title     string `json:"title"`
+	Price     Money  `json:"price"`
+	ImageURL  string `json:"image_url"`
+	Available bool   `json:"available"`
+}
+
+// ShopeeFetcher looks up a listing's current metadata. It returns
+// errShopeeListingGone when the listing no longer exists.
+type ShopeeFetcher interface {
+	Fetch(ctx context.Context, link ShopeeLink) (ShopeeMetadata, error)
+}
+
+var errShopeeListingGone = errors.New("shopee listing not found")
+
+// httpShopeeFetcher reads Shopee's public item API.
+type httpShopeeFetcher struct {
+	client  *http.Client
+	baseURL string // https://shopee.vn
+}
+
+func newHTTPShopeeFetcher() *httpShopeeFetcher {
+	return &httpShopeeFetcher{client: &http.Client{Timeout: 15 * time.Second}, baseURL: "https://shopee.vn"}
+}
+
+// shopeePriceScale: the item API reports prices multiplied by 100000.
+const shopeePriceScale = 100000
+
+func (f *httpShopeeFetcher) Fetch(ctx context.Context, link ShopeeLink) (ShopeeMetadata, error) {
+	u := fmt.Sprintf("%s/api/v4/item/get?itemid=%d&shopid=%d", f.baseURL, link.ItemID, link.ShopID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return ShopeeMetadata{}, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; tram-shop-sync/1.0)")
+	req.Header.Set("Referer", link.Canonical())
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return ShopeeMetadata{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return ShopeeMetadata{}, errShopeeListingGone
+	}
+	if resp.StatusCode != http.StatusOK {
+		return ShopeeMetadata{}, fmt.Errorf("shopee item api: %s", resp.Status)
+	}
+	var body struct {
+		Data *struct {
+			Name       string `json:"name"`
+			Price      int64  `json:"price"`
+			Image      string `json:"image"`
+			Stock      int    `json:"stock"`
+			ItemStatus string `json:"item_status"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 4<<20)).Decode(&body); err != nil {
+		return ShopeeMetadata{}, fmt.Errorf("decode shopee item: %w", err)
+	}
+	if body.Data == nil {
+		return ShopeeMetadata{}, errShopeeListingGone
+	}
+	d := body.Data
+	m := ShopeeMetadata{
+		Title:     strings.TrimSpace(d.Name),
+		Price:     Money(d.Price / shopeePriceScale),
+		Available: d.Stock > 0 && (d.ItemStatus == "" || d.ItemStatus == "normal"),
+	}
+	if d.Image != "" {
+		m.ImageURL = "https://down-vn.img.susercontent.com/file/" + d.Image
+	}
+	return m, nil
+}
+
+// stubShopeeFetcher answers from a fixed map keyed by canonical URL; listings not
+// in the map are reported gone. It keeps the sync usable offline and in tests.
+type stubShopeeFetcher struct {
+	Items map[string]ShopeeMetadata
+}
+
+func (f *stubShopeeFetcher) Fetch(_ context.Context, link ShopeeLink) (ShopeeMetadata, error) {
+	m, ok := f.Items[link.Canonical()]
+	if !ok {
+		return ShopeeMetadata{}, errShopeeListingGone
+	}
+	return m, nil
+}
+
+// newShopeeFetcher picks the fetcher from SHOPEE_FETCHER: "http" (default), "stub"
+// (reads SHOPEE_STUB_FILE, a JSON object of canonical URL to metadata) or "off".
+func newShopeeFetcher() (ShopeeFetcher, error) {
+	switch strings.ToLower(os.Getenv("SHOPEE_FETCHER")) {
+	case "", "http":
+		return newHTTPShopeeFetcher(), nil
+	case "stub":
+		stub := &stubShopeeFetcher{Items: map[string]ShopeeMetadata{}}
+		if path := os.Getenv("SHOPEE_STUB_FILE"); path != "" {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			if err := json.Unmarshal(data, &stub.Items); err != nil {
+				return nil, fmt.Errorf("parse %s: %w", path, err)
+			}
+		}
+		return stub, nil
+	case "off":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown SHOPEE_FETCHER %q", os.Getenv("SHOPEE_FETCHER"))
+	}
+}
+
+// Shopee sync flags that need the admin's attention until acknowledged.
+const (
+	syncFlagPriceChanged = "price_changed"
+	syncFlagGone         = "gone"
+)
+
+// ShopeeSyncState is the last sync result for one product.
+type ShopeeSyncState struct {
+	ProductID     int64      `json:"product_id"`
+	Title         string     `json:"title,omitempty"`
+	SyncedAt      *time.Time `json:"synced_at"`
+	UpstreamPrice Money      `json:"upstream_price"`
+	PreviousPrice Money      `json:"previous_price,omitempty"` // our price before the last change
+	Available     bool       `json:"available"`
+	Flag          string     `json:"flag"` // "", price_changed or gone
+	Error         string     `json:"error,omitempty"`
+}
+
+// fetchSyncStates returns the stored sync states keyed by product id.
+func fetchSyncStates(db *sql.DB) (map[int64]ShopeeSyncState, error) {
+	if db == nil {
+		return DevGetSyncStates(), nil
+	}
+	rows, err := db.Query("SELECT product_id, synced_at, IFNULL(upstream_price_minor,0), IFNULL(previous_price_minor,0), available, IFNULL(flag,''), IFNULL(error,'') FROM shopee_sync WHERE shop_id = @shop_id")
+	if err != nil {
+		return nil, fmt.Errorf("query shopee sync: %w", err)
+	}
+	defer rows.Close()
+	out := make(map[int64]ShopeeSyncState)
+	for rows.Next() {
+		var s ShopeeSyncState
+		var synced interface{}
+		if err := rows.Scan(&s.ProductID, &synced, &s.UpstreamPrice, &s.PreviousPrice, &s.Available, &s.Flag, &s.Error); err != nil {
+			return nil, fmt.Errorf("scan shopee sync: %w", err)
+		}
+		s.SyncedAt = parseDBTime(synced)
+		out[s.ProductID] = s
+	}
+	return out, rows.Err()
+}
+
+// saveSyncState upserts the sync state of one product.
+func saveSyncState(db *sql.DB, s ShopeeSyncState) error {
+	if db == nil {
+		DevSetSyncState(s)
+		return nil
+	}
+	_, err := db.Exec(`INSERT INTO shopee_sync (shop_id, product_id, synced_at, upstream_price_minor, previous_price_minor, available, flag, error) VALUES (@shop_id, ?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE synced_at=VALUES(synced_at), upstream_price_minor=VALUES(upstream_price_minor), previous_price_minor=VALUES(previous_price_minor), available=VALUES(available), flag=VALUES(flag), error=VALUES(error)`,
+		s.ProductID, sqlNullTime(s.SyncedAt), int64(s.UpstreamPrice), sqlNullMoney(s.PreviousPrice), s.Available, sqlNullString(s.Flag), sqlNullString(s.Error))
+	return err
+}
+
+// syncShopeeProduct fetches p's listing and applies it: title, price (VND shops
+// only, since Shopee.vn prices are in đồng), image unless the admin uploaded one,
+// and sold-out status. A changed price or a vanished listing is flagged; flags
+// stay until acknowledged.
+func syncShopeeProduct(ctx context.Context, db *sql.DB, f ShopeeFetcher, p Product, prev ShopeeSyncState) (ShopeeSyncState, error) {
+	now := time.Now()
+	s := prev
+	s.ProductID, s.SyncedAt, s.Error = p.ID, &now, ""
+	link, err := parseShopeeURL(p.ExternalURL)
+	if err != nil {
+		s.Error = err.Error()
+		return s, saveSyncState(db, s)
+	}
+	m, err := f.Fetch(ctx, link)
+	if errors.Is(err, errShopeeListingGone) {
+		s.Flag, s.Available = syncFlagGone, false
+		if p.Status == statusPublished {
+			if err := setProductSyncFields(db, p.ID, p.Title, p.Price, p.ImageURL, statusSoldOut); err != nil {
+				return s, err
+			}
+		}
+		return s, saveSyncState(db, s)
+	}
+	if err != nil {
+		s.Error = err.Error()
+		return s, saveSyncState(db, s)
+	}
+
+	title, price, image, status := p.Title, p.Price, p.ImageURL, p.Status
+	if m.Title != "" {
+		title = m.Title
+	}
+	if m.ImageURL != "" && p.ImagePublicID == "" {
+		image = m.ImageURL
+	}
+	if shopCurrency(db).Code == defaultCurrency && m.Price > 0 && m.Price != p.Price {
+		s.Flag, s.PreviousPrice = syncFlagPriceChanged, p.Price
+		price = m.Price
+	}
+	switch {
+	case !m.Available && status == statusPublished:
+		status = statusSoldOut
+	case m.Available && status == statusSoldOut && !prev.Available && prev.SyncedAt != nil:
+		// back in stock after the sync marked it sold out
+		status = statusPublished
+	}
+	if s.Flag == syncFlagGone {
+		s.Flag = ""
+	}
+	s.UpstreamPrice, s.Available = m.Price, m.Available
+	if title != p.Title || price != p.Price || image != p.ImageURL || status != p.Status {
+		if err := setProductSyncFields(db, p.ID, title, price, image, status); err != nil {
+			return s, err
+		}
+		if err := recordPriceChange(db, nil, p.ID, p.Price, price, priceSourceShopee); err != nil {
+			return s, err
+		}
+	}
+	return s, saveSyncState(db, s)
+}
+
+// setProductSyncFields stores the fields the Shopee sync maintains.
+func setProductSyncFields(db *sql.DB, id int64, title string, price Money, imageURL, status string) error {
+	if db == nil {
+		DevModifyProduct(id, func(p *Product) {
+			p.Title, p.Price, p.ImageURL, p.Status = title, price, imageURL, status
+		})
+		return nil
+	}
+	_, err := db.Exec("UPDATE products SET title=?, price_minor=?, image_url=?, status=? WHERE id=? AND shop_id = @shop_id", title, int64(price), imageURL, status, id)
+	return err
+}
+
+// shopeeSyncer runs syncs one at a time, from the schedule or the admin endpoint.
+type shopeeSyncer struct {
+	db      *sql.DB
+	fetcher ShopeeFetcher
+	delay   time.Duration // pause between listings to stay polite
+
+	runMu sync.Mutex // held for the whole of a run
+
+	mu      sync.Mutex // guards the fields below
+	running bool
+	ranAt   time.Time
+	lastErr string
+}
+
+// ShopeeSyncResult summarizes one sync run.
+type ShopeeSyncResult struct {
+	Checked int               `json:"checked"`
+	States  []ShopeeSyncState `json:"states"`
+}
+
+// run syncs the Shopee products with the given ids, or all of them when ids is
+// empty, waiting for a run in progress to finish first.
+func (s *shopeeSyncer) run(ctx context.Context, ids []int64) (ShopeeSyncResult, error) {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+	return s.runLocked(ctx, ids)
+}
+
+// tryStart begins a run in the background and returns false when one is
+// already in progress.
+func (s *shopeeSyncer) tryStart(ctx context.Context, ids []int64, who string) bool {
+	if !s.runMu.TryLock() {
+		return false
+	}
+	go func() {
+		defer s.runMu.Unlock()
+		res, err := s.runLocked(ctx, ids)
+		if err != nil {
+			log.Printf("shopee sync (%s) error: %v", who, err)
+			return
+		}
+		log.Printf("shopee sync (%s) checked=%d", who, res.Checked)
+	}()
+	return true
+}
+
+// runLocked is run for a caller holding runMu.
+func (s *shopeeSyncer) runLocked(ctx context.Context, ids []int64) (res ShopeeSyncResult, err error) {
+	s.mu.Lock()
+	s.running = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.running, s.ranAt, s.lastErr = false, time.Now(), ""
+		if err != nil {
+			s.lastErr = err.Error()
+		}
+		s.mu.Unlock()
+	}()
+	res = ShopeeSyncResult{States: []ShopeeSyncState{}}
+	products, err := fetchProducts(s.db)
+	if err != nil {
+		return res, err
+	}
+	states, err := fetchSyncStates(s.db)
+	if err != nil {
+		return res, err
+	}
+	want := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		want[id] = true
+	}
+	for _, p := range products {
+		if p.Source != sourceShopee || p.ExternalURL == "" || (len(ids) > 0 && !want[p.ID]) {
+			continue
+		}
+		if res.Checked > 0 && s.delay > 0 {
+			select {
+			case <-ctx.Done():
+				return res, ctx.Err()
+			case <-time.After(s.delay):
+			}
+		}
+		st, err := syncShopeeProduct(ctx, s.db, s.fetcher, p, states[p.ID])
+		if err != nil {
+			return res, fmt.Errorf("sync product %d: %w", p.ID, err)
+		}
+		st.Title = p.Title
+		res.Checked++
+		res.States = append(res.States, st)
+	}
+	if res.Checked > 0 {
+		invalidateCatalogCache()
+	}
+	return res, nil
+}
+
+// start runs the sync every interval until ctx is done.
+func (s *shopeeSyncer) start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		log.Printf("shopee sync enabled, every %s", interval)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// a run started by the admin counts for this tick
+				if !s.tryStart(ctx, nil, "scheduled") {
+					log.Println("shopee sync: previous run still in progress, skipping")
+				}
+			}
+		}
+	}()
+}
+
+// adminShopeeSync serves /api/admin/shopee-sync:
+//
+//	GET  lists products with a sync flag or error, and whether a run is going on
+//	POST {"ids": [..]} starts a sync in the background (all Shopee products when
+//	     ids is empty): 202, or 409 while another run is in progress
+//	POST {"ack": [..]} clears the flags of the given products
+func adminShopeeSync(s *shopeeSyncer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			states, err := fetchSyncStates(s.db)
+			if err != nil {
+				log.Println("fetchSyncStates error:", err)
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
+			products, err := fetchProducts(s.db)
+			if err != nil {
+				log.Println("fetchProducts error:", err)
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
+			out := []ShopeeSyncState{}
+			for _, p := range products {
+				if st, ok := states[p.ID]; ok && (st.Flag != "" || st.Error != "") {
+					st.Title = p.Title
+					out = append(out, st)
+				}
+			}
+			resp := map[string]interface{}{"enabled": s.fetcher != nil, "flagged": out}
+			s.mu.Lock()
+			resp["running"] = s.running
+			if !s.ranAt.IsZero() {
+				resp["ran_at"] = s.ranAt.Format(time.RFC3339)
+			}
+			if s.lastErr != "" {
+				resp["last_error"] = s.lastErr
+			}
+			s.mu.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(resp)
+
+		case http.MethodPost:
+			var req struct {
+				IDs []int64 `json:"ids"`
+				Ack []int64 `json:"ack"`
+			}
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil && err != io.EOF {
+				http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if len(req.Ack) > 0 {
+				if err := ackSyncFlags(s.db, req.Ack); err != nil {
+					log.Println("ackSyncFlags error:", err)
+					http.Error(w, "db error", http.StatusInternalServerError)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			if s.fetcher == nil {
+				http.Error(w, "shopee sync is disabled (SHOPEE_FETCHER=off)", http.StatusConflict)
+				return
+			}
+			// the request context ends with the response; the run outlives it
+			if !s.tryStart(context.Background(), req.IDs, "admin "+r.RemoteAddr) {
+				http.Error(w, "a sync is already running", http.StatusConflict)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusAccepted)
+			_ = json.NewEncoder(w).Encode(map[string]bool{"started": true})
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// ackSyncFlags clears the sync flags of the given products.
+func ackSyncFlags(db *sql.DB, ids []int64) error {
+	states, err := fetchSyncStates(db)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		st, ok := states[id]
+		if !ok || st.Flag == "" {
+			continue
+		}
+		st.Flag, st.PreviousPrice = "", 0
+		if err := saveSyncState(db, st); err != nil {
+			return err
+		}
+	}
+	return nil
+}
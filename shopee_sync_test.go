@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// useDevSync gives one test an empty in-memory sync state and price history
+// besides the products.
+func useDevSync(t *testing.T, products []Product) {
+	t.Helper()
+	useDevOrders(t, products, nil)
+	devMu.Lock()
+	savedStates, savedHistory := devSyncStates, devPriceHistory
+	devSyncStates, devPriceHistory = map[int64]ShopeeSyncState{}, nil
+	devMu.Unlock()
+	t.Cleanup(func() {
+		devMu.Lock()
+		devSyncStates, devPriceHistory = savedStates, savedHistory
+		devMu.Unlock()
+	})
+}
+
+func TestSyncShopeeProduct(t *testing.T) {
+	const link = "https://shopee.vn/product/11/22"
+	synced := time.Now().Add(-time.Hour)
+	listing := func(price Money, available bool) map[string]ShopeeMetadata {
+		return map[string]ShopeeMetadata{link: {Title: "Áo khoác", Price: price, Available: available}}
+	}
+	tests := []struct {
+		name      string
+		status    string
+		prev      ShopeeSyncState
+		items     map[string]ShopeeMetadata
+		wantState string
+		wantPrice Money
+		wantFlag  string
+		wantPrev  Money
+	}{
+		{"gone marks sold out", statusPublished, ShopeeSyncState{}, nil, statusSoldOut, 100000, syncFlagGone, 0},
+		{"gone leaves a draft alone", statusDraft, ShopeeSyncState{}, nil, statusDraft, 100000, syncFlagGone, 0},
+		{"unchanged", statusPublished, ShopeeSyncState{}, listing(100000, true), statusPublished, 100000, "", 0},
+		{"price change is applied and flagged", statusPublished, ShopeeSyncState{}, listing(120000, true), statusPublished, 120000, syncFlagPriceChanged, 100000},
+		{"out of stock marks sold out", statusPublished, ShopeeSyncState{}, listing(100000, false), statusSoldOut, 100000, "", 0},
+		{"back in stock republishes", statusSoldOut, ShopeeSyncState{Available: false, SyncedAt: &synced}, listing(100000, true), statusPublished, 100000, "", 0},
+		{"admin sold out stays sold out", statusSoldOut, ShopeeSyncState{}, listing(100000, true), statusSoldOut, 100000, "", 0},
+		{"price flag persists until ack", statusPublished, ShopeeSyncState{Flag: syncFlagPriceChanged, PreviousPrice: 90000, Available: true, SyncedAt: &synced}, listing(100000, true), statusPublished, 100000, syncFlagPriceChanged, 90000},
+		{"gone flag clears when the listing returns", statusSoldOut, ShopeeSyncState{Flag: syncFlagGone, Available: false, SyncedAt: &synced}, listing(100000, true), statusPublished, 100000, "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := Product{ID: 1, Title: "Áo khoác", Price: 100000, Status: tt.status, Source: sourceShopee, ExternalURL: link}
+			useDevSync(t, []Product{p})
+			f := &stubShopeeFetcher{Items: tt.items}
+			st, err := syncShopeeProduct(context.Background(), nil, f, p, tt.prev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := DevGetProducts()[0]
+			if got.Status != tt.wantState || got.Price != tt.wantPrice {
+				t.Errorf("product = %s %d, want %s %d", got.Status, got.Price, tt.wantState, tt.wantPrice)
+			}
+			if st.Flag != tt.wantFlag || st.PreviousPrice != tt.wantPrev {
+				t.Errorf("state flag = %q previous_price = %d, want %q %d", st.Flag, st.PreviousPrice, tt.wantFlag, tt.wantPrev)
+			}
+			if saved := DevGetSyncStates()[1]; saved.Flag != st.Flag || saved.SyncedAt == nil {
+				t.Errorf("saved state = %+v, want flag %q and a sync time", saved, st.Flag)
+			}
+		})
+	}
+}
+
+func TestShopeeSyncFlagsUntilAck(t *testing.T) {
+	const link = "https://shopee.vn/product/11/22"
+	useDevSync(t, []Product{{ID: 1, Title: "Áo", Price: 100000, Status: statusPublished, Source: sourceShopee, ExternalURL: link}})
+	s := &shopeeSyncer{fetcher: &stubShopeeFetcher{Items: map[string]ShopeeMetadata{link: {Price: 80000, Available: true}}}}
+
+	for i := 0; i < 2; i++ {
+		if _, err := s.run(context.Background(), nil); err != nil {
+			t.Fatal(err)
+		}
+		st := DevGetSyncStates()[1]
+		if st.Flag != syncFlagPriceChanged || st.PreviousPrice != 100000 {
+			t.Fatalf("run %d: state = %+v, want price_changed from 100000", i+1, st)
+		}
+	}
+	if err := ackSyncFlags(nil, []int64{1}); err != nil {
+		t.Fatal(err)
+	}
+	if st := DevGetSyncStates()[1]; st.Flag != "" || st.PreviousPrice != 0 {
+		t.Errorf("after ack: state = %+v, want no flag", st)
+	}
+	if _, err := s.run(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if st := DevGetSyncStates()[1]; st.Flag != "" {
+		t.Errorf("unchanged price flagged again: %+v", st)
+	}
+}
+
+func TestShopeeSyncerTryStart(t *testing.T) {
+	useDevSync(t, nil)
+	s := &shopeeSyncer{fetcher: &stubShopeeFetcher{}}
+	s.runMu.Lock()
+	if s.tryStart(context.Background(), nil, "test") {
+		t.Error("tryStart started a second run")
+	}
+	s.runMu.Unlock()
+	if !s.tryStart(context.Background(), nil, "test") {
+		t.Fatal("tryStart did not start")
+	}
+	s.runMu.Lock() // wait for the background run
+	s.runMu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running || s.ranAt.IsZero() {
+		t.Errorf("after run: running=%v ranAt=%v", s.running, s.ranAt)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestParseShopeeURL(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    ShopeeLink
+		wantErr bool
+	}{
+		{"https://shopee.vn/Ao-khoac-jean-i.123456.7891011", ShopeeLink{123456, 7891011}, false},
+		{"https://shopee.vn/%C3%81o-kho%C3%A1c-i.1.2?sp_atk=x&xptdk=y", ShopeeLink{1, 2}, false},
+		{"https://shopee.vn/product/123456/7891011", ShopeeLink{123456, 7891011}, false},
+		{"https://shopee.vn/product/123456/7891011/", ShopeeLink{123456, 7891011}, false},
+		{"http://shopee.vn/product/1/2?utm_source=an_1", ShopeeLink{1, 2}, false},
+		{"https://shopee.vn/universal-link/product/123456/7891011", ShopeeLink{123456, 7891011}, false},
+		{"https://m.shopee.vn/product/1/2", ShopeeLink{1, 2}, false},
+		{"  https://shopee.vn/product/1/2  ", ShopeeLink{1, 2}, false},
+		{"https://shopee.vn/product/0/2", ShopeeLink{}, true},
+		{"https://shopee.vn/Ao-i.5.0", ShopeeLink{}, true},
+		{"https://shopee.vn/product/1", ShopeeLink{}, true},
+		{"https://shopee.vn/shop/123456", ShopeeLink{}, true},
+		{"https://shopee.vn/Ao-i.1.2/extra", ShopeeLink{}, true},
+		{"https://shopee.co.th/product/1/2", ShopeeLink{}, true},
+		{"https://notshopee.vn/product/1/2", ShopeeLink{}, true},
+		{"https://shopee.vn.evil.com/product/1/2", ShopeeLink{}, true},
+		{"https://lazada.vn/Ao-i.1.2", ShopeeLink{}, true},
+		{"ftp://shopee.vn/product/1/2", ShopeeLink{}, true},
+		{"shopee.vn/product/1/2", ShopeeLink{}, true},
+		{"https://shp.ee/abc", ShopeeLink{}, true}, // short links must be resolved first
+		{"", ShopeeLink{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseShopeeURL(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseShopeeURL(%q) = %+v, want error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseShopeeURL(%q) = %+v, %v; want %+v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestResolveShopeeURL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/hop":
+			http.Redirect(w, r, "/final", http.StatusFound)
+		case "/final":
+			http.Redirect(w, r, "https://shopee.vn/Ao-i.7.8?sp_atk=1", http.StatusFound)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		case "/shop":
+			http.Redirect(w, r, "https://shopee.vn/shop/7", http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusOK) // no Location
+		}
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+	shopeeShortHosts[u.Hostname()] = true
+	defer delete(shopeeShortHosts, u.Hostname())
+
+	tests := []struct {
+		in      string
+		want    ShopeeLink
+		wantErr bool
+	}{
+		{"https://shopee.vn/product/1/2", ShopeeLink{1, 2}, false}, // no network
+		{srv.URL + "/hop", ShopeeLink{7, 8}, false},
+		{srv.URL + "/loop", ShopeeLink{}, true},
+		{srv.URL + "/shop", ShopeeLink{}, true},
+		{srv.URL + "/none", ShopeeLink{}, true},
+		{"https://example.com/product/1/2", ShopeeLink{}, true},
+	}
+	for _, tt := range tests {
+		got, err := resolveShopeeURL(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("resolveShopeeURL(%q) = %+v, want error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("resolveShopeeURL(%q) = %+v, %v; want %+v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestIsShopeeShortLink(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"https://shp.ee/abc", true},
+		{"https://vn.shp.ee/abc", true},
+		{"http://s.shopee.vn/xyz", true},
+		{"https://shopee.vn/product/1/2", false},
+		{"shp.ee/abc", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := isShopeeShortLink(tt.in); got != tt.want {
+			t.Errorf("isShopeeShortLink(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
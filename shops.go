@@ -0,0 +1,549 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	mysql "github.com/go-sql-driver/mysql"
+)
+
+// defaultShopID is the shop that existed before multi-shop support. It answers
+// requests that do not name a shop and keeps accepting ADMIN_TOKEN.
+const defaultShopID int64 = 1
+
+// shopUsernamePattern: lowercase letters, digits and inner dashes, so that a
+// username also works as a subdomain.
+var shopUsernamePattern = regexp.MustCompile(`^[a-z0-9](?:[a-z0-9-]{0,30}[a-z0-9])?$`)
+
+// reservedShopUsernames would clash with common host names.
+var reservedShopUsernames = map[string]bool{"www": true, "api": true, "admin": true, "static": true, "mail": true}
+
+// defaultShopUsername is the default shop's username (DEFAULT_SHOP_USERNAME, else "main").
+func defaultShopUsername() string {
+	if v := os.Getenv("DEFAULT_SHOP_USERNAME"); v != "" {
+		return v
+	}
+	return "main"
+}
+
+// openShopDB opens a connection pool scoped to one shop: every connection sets
+// the session variable @shop_id, which all queries filter and insert with.
+// cfg is nil in dev mode, where there is no database.
+func openShopDB(cfg *mysql.Config, shopID int64) (*sql.DB, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	connector, err := mysql.NewConnector(shopDBConfig(cfg, shopID))
+	if err != nil {
+		return nil, err
+	}
+	return sql.OpenDB(connector), nil
+}
+
+// shopDBConfig copies cfg with the session variable @shop_id set to shopID;
+// cfg itself, shared by all shops, is left alone.
+func shopDBConfig(cfg *mysql.Config, shopID int64) *mysql.Config {
+	c := cfg.Clone()
+	params := make(map[string]string, len(c.Params)+1)
+	for k, v := range c.Params {
+		params[k] = v
+	}
+	params["@shop_id"] = strconv.FormatInt(shopID, 10)
+	c.Params = params
+	return c
+}
+
+// hashAdminToken is how shop admin tokens are stored.
+func hashAdminToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newAdminToken returns a random shop admin token.
+func newAdminToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// sessionSecret keys admin session cookies: SESSION_SECRET when set (needed for
+// sessions to survive restarts or span several instances), otherwise random per
+// process.
+var sessionSecret = func() []byte {
+	if v := os.Getenv("SESSION_SECRET"); v != "" {
+		return []byte(v)
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatalf("session secret: %v", err)
+	}
+	return b
+}()
+
+// shopSite is an opened shop: its scoped database and its routes.
+type shopSite struct {
+	Shop
+	db      *sql.DB
+	handler http.Handler
+
+	mu        sync.Mutex
+	tokenHash string // hashAdminToken of the shop's admin token; "" when none was issued
+}
+
+// checkAdminToken reports whether token grants admin access to the shop: the
+// shop's own token, ADMIN_TOKEN for the default shop, or SUPER_ADMIN_TOKEN.
+func (s *shopSite) checkAdminToken(token string) bool {
+	if token == "" {
+		return false
+	}
+	if super := os.Getenv("SUPER_ADMIN_TOKEN"); super != "" && subtle.ConstantTimeCompare([]byte(token), []byte(super)) == 1 {
+		return true
+	}
+	if s.ID == defaultShopID {
+		if env := os.Getenv("ADMIN_TOKEN"); env != "" && subtle.ConstantTimeCompare([]byte(token), []byte(env)) == 1 {
+			return true
+		}
+	}
+	s.mu.Lock()
+	hash := s.tokenHash
+	s.mu.Unlock()
+	return hash != "" && subtle.ConstantTimeCompare([]byte(hashAdminToken(token)), []byte(hash)) == 1
+}
+
+// sessionValue is the shop's admin session cookie value: an HMAC under
+// sessionSecret of the shop id and its tokens, so a new token signs out existing
+// sessions, a session of one shop is worthless in another, and nobody can compute
+// one without the secret.
+func (s *shopSite) sessionValue() string {
+	s.mu.Lock()
+	tokens := s.tokenHash
+	s.mu.Unlock()
+	if s.ID == defaultShopID {
+		tokens += ":" + hashAdminToken(os.Getenv("ADMIN_TOKEN"))
+	}
+	mac := hmac.New(sha256.New, sessionSecret)
+	mac.Write([]byte("session:" + strconv.FormatInt(s.ID, 10) + ":" + tokens))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// shopFeedToken is the ?token= that the catalog feeds of the shop require, or
+// "" when FEED_TOKEN is unset and feeds are public. The default shop keeps
+// FEED_TOKEN itself; every other shop gets an HMAC of its id under FEED_TOKEN,
+// so a feed URL of one shop does not open the feeds of another.
+func shopFeedToken(shopID int64) string {
+	secret := os.Getenv("FEED_TOKEN")
+	if secret == "" || shopID == defaultShopID {
+		return secret
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("feed:" + strconv.FormatInt(shopID, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// shopRequest is what the registry records about a request's shop.
+type shopRequest struct {
+	site      *shopSite
+	base      string // "/@username" when the shop was addressed by path prefix
+	subdomain bool   // the shop was addressed as <username>.SHOP_DOMAIN
+}
+
+type shopContextKey struct{}
+
+// requestShop returns the shop serving r. Requests that did not pass through
+// the registry belong to the default shop.
+func requestShop(r *http.Request) *shopSite {
+	if sr, ok := r.Context().Value(shopContextKey{}).(shopRequest); ok {
+		return sr.site
+	}
+	return &shopSite{Shop: Shop{ID: defaultShopID, Username: defaultShopUsername()}}
+}
+
+// shopBase is the path prefix of the shop serving r ("/@username"), or "" when
+// it is served from the root of its host. Links rendered for the visitor start with it.
+func shopBase(r *http.Request) string {
+	sr, _ := r.Context().Value(shopContextKey{}).(shopRequest)
+	return sr.base
+}
+
+// shopCookiePath keeps cookies (cart, admin session) of shops that share a host apart.
+func shopCookiePath(r *http.Request) string {
+	return shopBase(r) + "/"
+}
+
+// fetchShopSites reads shops with their token hashes, all of them or only the
+// one named username. In dev mode there is just the default shop.
+func fetchShopSites(db *sql.DB, username string) ([]*shopSite, error) {
+	if db == nil {
+		name := defaultShopUsername()
+		if username != "" && username != name {
+			return nil, nil
+		}
+		return []*shopSite{{Shop: Shop{ID: defaultShopID, Username: name, Name: name}}}, nil
+	}
+	q := "SELECT id, username, name, IFNULL(admin_token_hash,''), created_at FROM shops"
+	var args []interface{}
+	if username != "" {
+		q += " WHERE username = ?"
+		args = append(args, username)
+	}
+	rows, err := db.Query(q+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []*shopSite
+	for rows.Next() {
+		s := &shopSite{}
+		if err := rows.Scan(&s.ID, &s.Username, &s.Name, &s.tokenHash, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// createShop stores a new shop with an empty profile and returns it.
+func createShop(db *sql.DB, username, name, tokenHash string) (Shop, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return Shop{}, err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("INSERT INTO shops (username, name, admin_token_hash) VALUES (?, ?, ?)", username, name, tokenHash)
+	if err != nil {
+		return Shop{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Shop{}, err
+	}
+	// the profile row of a shop has id = shop id (see ensureShopColumns)
+	if _, err := tx.Exec(`INSERT INTO profile (id, shop_id, display_name, username, bio, highlight, avatar_url, currency)
+		VALUES (?, ?, ?, ?, '', '', '', 'VND')`, id, id, name, "@"+username); err != nil {
+		return Shop{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Shop{}, err
+	}
+	return Shop{ID: id, Username: username, Name: name}, nil
+}
+
+// shopRegistry routes each request to its shop, chosen by subdomain
+// (<username>.SHOP_DOMAIN) or path prefix (/@username/...), and keeps the
+// shops opened so far. Requests naming no shop go to the default shop.
+type shopRegistry struct {
+	root   *sql.DB       // the default shop's pool; also used for the shops table
+	cfg    *mysql.Config // opens the other shops' pools; nil in dev mode
+	domain string        // SHOP_DOMAIN, "" to disable subdomain routing
+	build  func(db *sql.DB) http.Handler
+
+	mu     sync.Mutex
+	byID   map[int64]*shopSite
+	byName map[string]*shopSite
+}
+
+func newShopRegistry(root *sql.DB, cfg *mysql.Config, build func(db *sql.DB) http.Handler) *shopRegistry {
+	return &shopRegistry{
+		root:   root,
+		cfg:    cfg,
+		domain: strings.ToLower(strings.Trim(os.Getenv("SHOP_DOMAIN"), ".")),
+		build:  build,
+		byID:   map[int64]*shopSite{},
+		byName: map[string]*shopSite{},
+	}
+}
+
+// load opens every shop, which also starts their background jobs.
+func (reg *shopRegistry) load() error {
+	sites, err := fetchShopSites(reg.root, "")
+	if err != nil {
+		return err
+	}
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	for _, s := range sites {
+		if err := reg.add(s); err != nil {
+			return err
+		}
+	}
+	if reg.byID[defaultShopID] == nil {
+		return errors.New("default shop is missing from the shops table")
+	}
+	return nil
+}
+
+// add opens the shop's pool and routes; reg.mu must be held.
+func (reg *shopRegistry) add(s *shopSite) error {
+	if s.ID == defaultShopID {
+		s.db = reg.root
+	} else {
+		db, err := openShopDB(reg.cfg, s.ID)
+		if err != nil {
+			return err
+		}
+		s.db = db
+	}
+	s.handler = reg.build(s.db)
+	reg.byID[s.ID] = s
+	reg.byName[s.Username] = s
+	return nil
+}
+
+// lookup returns the shop with username ("" for the default shop), or nil.
+// Shops created by another instance are picked up from the database.
+func (reg *shopRegistry) lookup(username string) (*shopSite, error) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if username == "" {
+		return reg.byID[defaultShopID], nil
+	}
+	if s, ok := reg.byName[username]; ok {
+		return s, nil
+	}
+	if !shopUsernamePattern.MatchString(username) {
+		return nil, nil
+	}
+	sites, err := fetchShopSites(reg.root, username)
+	if err != nil || len(sites) == 0 {
+		return nil, err
+	}
+	if err := reg.add(sites[0]); err != nil {
+		return nil, err
+	}
+	return sites[0], nil
+}
+
+// dbs returns the pools of all opened shops by username, for jobs that run per shop.
+func (reg *shopRegistry) dbs() map[string]*sql.DB {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	out := make(map[string]*sql.DB, len(reg.byID))
+	for _, s := range reg.byID {
+		out[s.Username] = s.db
+	}
+	return out
+}
+
+// subdomainShop returns the username in host <username>.SHOP_DOMAIN, or "".
+func (reg *shopRegistry) subdomainShop(host string) string {
+	if reg.domain == "" {
+		return ""
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	sub := strings.TrimSuffix(strings.ToLower(host), "."+reg.domain)
+	if sub == host || sub == "www" || strings.Contains(sub, ".") {
+		return ""
+	}
+	return sub
+}
+
+func (reg *shopRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/api/shops" || strings.HasPrefix(r.URL.Path, "/api/shops/") {
+		reg.shopsHandler(w, r)
+		return
+	}
+	var sr shopRequest
+	username, path := "", r.URL.Path
+	if strings.HasPrefix(path, "/@") {
+		rest := path[2:]
+		i := strings.IndexByte(rest, '/')
+		if i < 0 {
+			// "/@username" -> "/@username/" so that relative links resolve inside the shop
+			target := path + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+		username, path = rest[:i], rest[i:]
+		sr.base = "/@" + username
+	} else if sub := reg.subdomainShop(r.Host); sub != "" {
+		username, sr.subdomain = sub, true
+	}
+	site, err := reg.lookup(username)
+	if err != nil {
+		log.Println("shop lookup error:", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if site == nil {
+		http.Error(w, "shop not found", http.StatusNotFound)
+		return
+	}
+	sr.site = site
+	r2 := r.WithContext(context.WithValue(r.Context(), shopContextKey{}, sr))
+	if sr.base != "" {
+		u := *r.URL
+		u.Path, u.RawPath = path, ""
+		r2.URL = &u
+	}
+	site.handler.ServeHTTP(w, r2)
+}
+
+// shopResponse is a shop as returned by the super-admin API.
+type shopResponse struct {
+	Shop
+	Path       string `json:"path"`                  // path prefix the shop is served under
+	AdminToken string `json:"admin_token,omitempty"` // only when a token was just issued
+	FeedToken  string `json:"feed_token,omitempty"`  // ?token= of the shop's catalog feeds, when FEED_TOKEN is set
+}
+
+func newShopResponse(s Shop, token string) shopResponse {
+	return shopResponse{Shop: s, Path: "/@" + s.Username + "/", AdminToken: token, FeedToken: shopFeedToken(s.ID)}
+}
+
+// shopsHandler serves the super-admin API, authorized by the
+// X-Super-Admin-Token header (SUPER_ADMIN_TOKEN):
+//
+//	GET  /api/shops                        lists shops
+//	POST /api/shops {"username", "name"}   creates a shop and returns its admin token
+//	POST /api/shops/{id}/token             issues a new admin token for the shop
+func (reg *shopRegistry) shopsHandler(w http.ResponseWriter, r *http.Request) {
+	super := os.Getenv("SUPER_ADMIN_TOKEN")
+	got := r.Header.Get("X-Super-Admin-Token")
+	if super == "" || subtle.ConstantTimeCompare([]byte(got), []byte(super)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/shops"), "/")
+	switch {
+	case rest == "" && r.Method == http.MethodGet:
+		sites, err := fetchShopSites(reg.root, "")
+		if err != nil {
+			log.Println("fetchShopSites error:", err)
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		out := []shopResponse{}
+		for _, s := range sites {
+			out = append(out, newShopResponse(s.Shop, ""))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+
+	case rest == "" && r.Method == http.MethodPost:
+		if reg.root == nil {
+			http.Error(w, "creating shops needs MySQL; DEV_MODE serves the default shop only", http.StatusNotImplemented)
+			return
+		}
+		if os.Getenv("ADMIN_TOKEN") == "" {
+			// the default shop must have its own credential before others are added
+			http.Error(w, "set ADMIN_TOKEN before creating shops", http.StatusConflict)
+			return
+		}
+		var payload struct {
+			Username string `json:"username"`
+			Name     string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		username := strings.ToLower(strings.TrimSpace(payload.Username))
+		name := strings.TrimSpace(payload.Name)
+		if !shopUsernamePattern.MatchString(username) || reservedShopUsernames[username] {
+			http.Error(w, "username must be 1-32 lowercase letters, digits or dashes and not reserved", http.StatusBadRequest)
+			return
+		}
+		if name == "" {
+			name = username
+		}
+		if len(name) > 255 {
+			http.Error(w, "name too long", http.StatusBadRequest)
+			return
+		}
+		existing, err := reg.lookup(username)
+		if err != nil {
+			log.Println("shop lookup error:", err)
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		if existing != nil {
+			http.Error(w, "username already taken", http.StatusConflict)
+			return
+		}
+		token, err := newAdminToken()
+		if err != nil {
+			log.Println("admin token error:", err)
+			http.Error(w, "could not create token", http.StatusInternalServerError)
+			return
+		}
+		shop, err := createShop(reg.root, username, name, hashAdminToken(token))
+		if err != nil {
+			log.Println("createShop error:", err)
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		site := &shopSite{Shop: shop, tokenHash: hashAdminToken(token)}
+		reg.mu.Lock()
+		err = reg.add(site)
+		reg.mu.Unlock()
+		if err != nil {
+			log.Println("open shop error:", err)
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("shop created id=%d username=%s remote=%s", shop.ID, shop.Username, r.RemoteAddr)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(newShopResponse(shop, token))
+
+	case strings.HasSuffix(rest, "/token") && r.Method == http.MethodPost:
+		id, err := strconv.ParseInt(strings.TrimSuffix(rest, "/token"), 10, 64)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if reg.root == nil {
+			http.Error(w, "shop tokens need MySQL; DEV_MODE uses ADMIN_TOKEN", http.StatusNotImplemented)
+			return
+		}
+		reg.mu.Lock()
+		site := reg.byID[id]
+		reg.mu.Unlock()
+		if site == nil {
+			http.NotFound(w, r)
+			return
+		}
+		token, err := newAdminToken()
+		if err != nil {
+			log.Println("admin token error:", err)
+			http.Error(w, "could not create token", http.StatusInternalServerError)
+			return
+		}
+		if _, err := reg.root.Exec("UPDATE shops SET admin_token_hash = ? WHERE id = ?", hashAdminToken(token), id); err != nil {
+			log.Println("update shop token error:", err)
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		site.mu.Lock()
+		site.tokenHash = hashAdminToken(token)
+		site.mu.Unlock()
+		log.Printf("shop token reissued id=%d remote=%s", id, r.RemoteAddr)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(newShopResponse(site.Shop, token))
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	mysql "github.com/go-sql-driver/mysql"
+)
+
+// echoShop answers with the shop, path and base the registry handed it.
+var echoShop = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	io.WriteString(w, requestShop(r).Username+" "+r.URL.Path+" "+shopBase(r))
+})
+
+// testRegistry is a dev-mode registry with the default shop and anna (id 2).
+func testRegistry(t *testing.T, domain string) *shopRegistry {
+	t.Helper()
+	t.Setenv("SHOP_DOMAIN", domain)
+	reg := newShopRegistry(nil, nil, func(*sql.DB) http.Handler { return echoShop })
+	if err := reg.load(); err != nil {
+		t.Fatal(err)
+	}
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if err := reg.add(&shopSite{Shop: Shop{ID: 2, Username: "anna"}, tokenHash: hashAdminToken("anna-token")}); err != nil {
+		t.Fatal(err)
+	}
+	return reg
+}
+
+func TestShopRegistryRouting(t *testing.T) {
+	reg := testRegistry(t, "shop.test")
+	main := defaultShopUsername()
+	tests := []struct {
+		host, path string
+		wantCode   int
+		wantBody   string
+	}{
+		{"shop.test", "/", 200, main + " / "},
+		{"shop.test", "/api/products", 200, main + " /api/products "},
+		{"shop.test", "/@anna/", 200, "anna / /@anna"},
+		{"shop.test", "/@anna/api/products?q=x", 200, "anna /api/products /@anna"},
+		{"shop.test", "/@" + main + "/p/1", 200, main + " /p/1 /@" + main},
+		{"shop.test", "/@nobody/", 404, ""},
+		{"shop.test", "/@Bad_Name/", 404, ""},
+		{"anna.shop.test", "/cart", 200, "anna /cart "},
+		{"ANNA.shop.test:8000", "/", 200, "anna / "},
+		{"www.shop.test", "/", 200, main + " / "},
+		{"nobody.shop.test", "/", 404, ""},
+		{"a.b.shop.test", "/", 200, main + " / "},
+		{"anna.other.test", "/", 200, main + " / "},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		r.Host = tt.host
+		w := httptest.NewRecorder()
+		reg.ServeHTTP(w, r)
+		if w.Code != tt.wantCode || (tt.wantBody != "" && w.Body.String() != tt.wantBody) {
+			t.Errorf("%s%s = %d %q, want %d %q", tt.host, tt.path, w.Code, w.Body.String(), tt.wantCode, tt.wantBody)
+		}
+	}
+}
+
+func TestShopRegistryRedirectsBarePrefix(t *testing.T) {
+	reg := testRegistry(t, "")
+	w := httptest.NewRecorder()
+	reg.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/@anna?ref=ig", nil))
+	if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/@anna/?ref=ig" {
+		t.Errorf("redirect = %d %q", w.Code, w.Header().Get("Location"))
+	}
+
+	// without SHOP_DOMAIN a subdomain does not pick a shop
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Host = "anna.shop.test"
+	reg.ServeHTTP(w, r)
+	if want := defaultShopUsername() + " / "; w.Body.String() != want {
+		t.Errorf("body = %q, want %q", w.Body.String(), want)
+	}
+}
+
+func TestCheckAdminToken(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "env-token")
+	t.Setenv("SUPER_ADMIN_TOKEN", "super-token")
+	main := &shopSite{Shop: Shop{ID: defaultShopID}}
+	anna := &shopSite{Shop: Shop{ID: 2}, tokenHash: hashAdminToken("anna-token")}
+	bob := &shopSite{Shop: Shop{ID: 3}, tokenHash: hashAdminToken("bob-token")}
+	tests := []struct {
+		site  *shopSite
+		token string
+		want  bool
+	}{
+		{main, "env-token", true},
+		{main, "super-token", true},
+		{main, "anna-token", false},
+		{main, "", false},
+		{anna, "anna-token", true},
+		{anna, "super-token", true},
+		{anna, "env-token", false}, // ADMIN_TOKEN only manages the default shop
+		{anna, "bob-token", false},
+		{anna, "anna-token ", false},
+		{anna, hashAdminToken("anna-token"), false},
+		{bob, "bob-token", true},
+		{bob, "anna-token", false},
+		{&shopSite{Shop: Shop{ID: 4}}, "", false}, // no token issued
+	}
+	for _, tt := range tests {
+		if got := tt.site.checkAdminToken(tt.token); got != tt.want {
+			t.Errorf("shop %d checkAdminToken(%q) = %v, want %v", tt.site.ID, tt.token, got, tt.want)
+		}
+	}
+
+	t.Setenv("SUPER_ADMIN_TOKEN", "")
+	if anna.checkAdminToken("super-token") {
+		t.Error("unset SUPER_ADMIN_TOKEN still accepted")
+	}
+}
+
+func TestSessionValue(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "env-token")
+	main := &shopSite{Shop: Shop{ID: defaultShopID}}
+	anna := &shopSite{Shop: Shop{ID: 2}, tokenHash: hashAdminToken("t1")}
+	bob := &shopSite{Shop: Shop{ID: 3}, tokenHash: hashAdminToken("t1")}
+
+	session := anna.sessionValue()
+	if session != anna.sessionValue() {
+		t.Error("sessionValue is not stable")
+	}
+	if session == bob.sessionValue() {
+		t.Error("shops with the same token share a session value")
+	}
+	if strings.Contains(session, anna.tokenHash) {
+		t.Error("session value exposes the token hash")
+	}
+	anna.tokenHash = hashAdminToken("t2")
+	if anna.sessionValue() == session {
+		t.Error("a new admin token kept the old session valid")
+	}
+
+	before := main.sessionValue()
+	t.Setenv("ADMIN_TOKEN", "rotated")
+	if main.sessionValue() == before {
+		t.Error("changing ADMIN_TOKEN kept the default shop's session valid")
+	}
+}
+
+func TestTenantIsolation(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "env-token")
+	t.Setenv("SUPER_ADMIN_TOKEN", "")
+	reg := testRegistry(t, "")
+	reg.mu.Lock()
+	reg.byID[2].handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		}
+	})
+	reg.byID[defaultShopID].handler = reg.byID[2].handler
+	annaSession := reg.byID[2].sessionValue()
+	mainSession := reg.byID[defaultShopID].sessionValue()
+	reg.mu.Unlock()
+
+	tests := []struct {
+		name, path, header, cookie string
+		want                       int
+	}{
+		{"own token", "/@anna/api/admin/products", "anna-token", "", 200},
+		{"own session", "/@anna/api/admin/products", "", annaSession, 200},
+		{"default token on anna", "/@anna/api/admin/products", "env-token", "", 401},
+		{"default session on anna", "/@anna/api/admin/products", "", mainSession, 401},
+		{"anna token on default", "/api/admin/products", "anna-token", "", 401},
+		{"anna session on default", "/api/admin/products", "", annaSession, 401},
+		{"default token", "/api/admin/products", "env-token", "", 200},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.header != "" {
+			r.Header.Set("X-Admin-Token", tt.header)
+		}
+		if tt.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: "session", Value: tt.cookie})
+		}
+		w := httptest.NewRecorder()
+		reg.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
+
+func TestShopDBConfig(t *testing.T) {
+	cfg := mysql.NewConfig()
+	cfg.Params = map[string]string{"time_zone": "'+07:00'"}
+	c := shopDBConfig(cfg, 42)
+	if c.Params["@shop_id"] != "42" || c.Params["time_zone"] != "'+07:00'" {
+		t.Errorf("params = %v", c.Params)
+	}
+	if _, ok := cfg.Params["@shop_id"]; ok {
+		t.Error("shopDBConfig changed the shared config")
+	}
+}
+
+func TestShopFeedToken(t *testing.T) {
+	t.Setenv("FEED_TOKEN", "")
+	if got := shopFeedToken(2); got != "" {
+		t.Errorf("without FEED_TOKEN = %q, want public feeds", got)
+	}
+	t.Setenv("FEED_TOKEN", "feed-secret")
+	if got := shopFeedToken(defaultShopID); got != "feed-secret" {
+		t.Errorf("default shop = %q, want FEED_TOKEN", got)
+	}
+	anna, bob := shopFeedToken(2), shopFeedToken(3)
+	if anna == "" || anna == bob || anna == "feed-secret" || anna != shopFeedToken(2) {
+		t.Errorf("shop tokens = %q, %q", anna, bob)
+	}
+
+	reg := testRegistry(t, "")
+	reg.mu.Lock()
+	for _, s := range reg.byID {
+		s.handler = catalogFeedHandler(nil)
+	}
+	reg.mu.Unlock()
+	useDevOrders(t, nil, nil)
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/@anna/feeds/google.xml?token=" + anna, 200},
+		{"/@anna/feeds/google.xml?token=feed-secret", 401},
+		{"/@anna/feeds/google.xml?token=" + bob, 401},
+		{"/@anna/feeds/google.xml", 401},
+		{"/feeds/google.xml?token=feed-secret", 200},
+		{"/feeds/google.xml?token=" + anna, 401},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		reg.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if w.Code != tt.want {
+			t.Errorf("%s = %d, want %d", tt.path, w.Code, tt.want)
+		}
+	}
+}
+
+// recordDriver is a database/sql driver that records every statement and
+// answers queries with the rows registered for their prefix, so that SQL
+// written for one shop can be checked without a database.
+type recordDriver struct {
+	mu     sync.Mutex
+	stmts  []string
+	args   [][]driver.Value
+	rows   map[string][][]driver.Value // query prefix -> rows of (id, title)
+	nextID int64
+}
+
+func (d *recordDriver) Open(string) (driver.Conn, error) { return recordConn{d}, nil }
+
+type recordConn struct{ d *recordDriver }
+
+func (c recordConn) Prepare(query string) (driver.Stmt, error) { return recordStmt{c.d, query}, nil }
+func (c recordConn) Close() error                              { return nil }
+func (c recordConn) Begin() (driver.Tx, error)                 { return recordTx{}, nil }
+
+type recordTx struct{}
+
+func (recordTx) Commit() error   { return nil }
+func (recordTx) Rollback() error { return nil }
+
+type recordStmt struct {
+	d     *recordDriver
+	query string
+}
+
+func (s recordStmt) Close() error  { return nil }
+func (s recordStmt) NumInput() int { return -1 }
+
+func (s recordStmt) record(args []driver.Value) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	s.d.stmts = append(s.d.stmts, s.query)
+	s.d.args = append(s.d.args, args)
+}
+
+func (s recordStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.record(args)
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	s.d.nextID++
+	return recordResult{s.d.nextID}, nil
+}
+
+func (s recordStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.record(args)
+	for prefix, rows := range s.d.rows {
+		if strings.HasPrefix(s.query, prefix) {
+			return &recordRows{rows: rows}, nil
+		}
+	}
+	return &recordRows{}, nil
+}
+
+type recordRows struct{ rows [][]driver.Value }
+
+func (r *recordRows) Columns() []string { return []string{"id", "title"} }
+func (r *recordRows) Close() error      { return nil }
+func (r *recordRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// recordResult gives every statement a new insert id.
+type recordResult struct{ id int64 }
+
+func (r recordResult) LastInsertId() (int64, error) { return r.id, nil }
+func (r recordResult) RowsAffected() (int64, error) { return 1, nil }
+
+func TestRestoreSnapshotIsShopScoped(t *testing.T) {
+	d := &recordDriver{rows: map[string][][]driver.Value{
+		"SELECT id, title FROM products": {{int64(7), "Áo"}},
+	}}
+	db := sql.OpenDB(recordConnector{d})
+	defer db.Close()
+
+	snap := ShopSnapshot{
+		Socials:       []Social{{Name: "ig", URL: "https://instagram.com/x"}},
+		Categories:    []Category{{ID: 1, Name: "Áo"}, {ID: 2, Name: "Khoác", ParentID: 1}},
+		Collections:   []Collection{{ID: 1, Name: "Hè", Slug: "he"}},
+		Products:      []Product{{ID: 7, Title: "Áo", Price: 100000, CategoryID: 2, Collections: []Collection{{ID: 1}}}, {ID: 8, Title: "Quần"}},
+		Orders:        []Order{{ID: 1, Items: []OrderItem{{ProductID: 7, Quantity: 1}}}},
+		Coupons:       []Coupon{{Code: "SALE", Kind: "percent", Percent: 10}},
+		ShippingZones: []ShippingZone{{Name: "HN"}},
+		ProfileBlocks: []ProfileBlock{{Type: "product", ProductID: 7}},
+	}
+	if err := restoreSnapshot(db, snap); err != nil {
+		t.Fatal(err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.stmts) == 0 {
+		t.Fatal("no statements recorded")
+	}
+	tables := make(map[string]bool)
+	for _, table := range shopTables {
+		tables[table] = true
+	}
+	remapped := 0
+	for i, q := range d.stmts {
+		fields := strings.Fields(q)
+		var table string
+		switch strings.ToUpper(fields[0]) {
+		case "INSERT":
+			table = strings.TrimSuffix(fields[2], "(")
+			if !strings.Contains(q, "(shop_id,") || !strings.Contains(q, "VALUES (@shop_id,") {
+				t.Errorf("insert without @shop_id: %s", q)
+			}
+		case "UPDATE":
+			table = fields[1]
+			if fields[3] == "product_id" || fields[3] == "target_id" {
+				if len(d.args[i]) == 2 && d.args[i][1] == int64(7) {
+					remapped++
+				}
+			}
+		case "DELETE":
+			table = fields[2]
+		case "SELECT":
+			table = fields[4]
+		default:
+			t.Errorf("unexpected statement: %s", q)
+			continue
+		}
+		if !tables[table] {
+			t.Errorf("statement on a table that is not shop-owned (%s): %s", table, q)
+		}
+		if fields[0] != "INSERT" && !strings.Contains(q, "shop_id = @shop_id") {
+			t.Errorf("statement not scoped to the shop: %s", q)
+		}
+	}
+	if remapped == 0 {
+		t.Error("references of the product restored into its own shop were not remapped")
+	}
+}
+
+type recordConnector struct{ d *recordDriver }
+
+func (c recordConnector) Connect(context.Context) (driver.Conn, error) { return c.d.Open("") }
+func (c recordConnector) Driver() driver.Driver                        { return c.d }
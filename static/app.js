@@ -1,14 +1,33 @@
 // Minimal frontend JS cho trang linktree & admin
-const currencyFormatter = new Intl.NumberFormat('vi-VN',{style:'currency',currency:'VND'});
-const tokenKey = 'admin_token';
+// amounts from the API are integer minor units of the shop currency (set from the profile)
+let currencyFormatter = new Intl.NumberFormat('vi-VN',{style:'currency',currency:'VND'});
+let currencyDigits = 0;
+function setShopCurrency(code){
+  try{
+    currencyFormatter = new Intl.NumberFormat('vi-VN',{style:'currency',currency: code || 'VND'});
+    currencyDigits = currencyFormatter.resolvedOptions().maximumFractionDigits;
+  }catch(err){ /* keep VND */ }
+}
+// toMajor / toMinor convert between API amounts and what is typed into forms
+function toMajor(minor){ return Number(minor) / 10 ** currencyDigits; }
+function toMinor(major){ return Math.round(Number(major) * 10 ** currencyDigits); }
+// shops hosted under /@username/ prefix every API call and page link with it
+const shopBase = (window.location.pathname.match(/^\/@[a-z0-9-]+/) || [''])[0];
+function shopURL(path){ return path && path.startsWith('/') ? shopBase + path : path; }
+const tokenKey = 'admin_token' + shopBase;
 const tokenFromURL = new URLSearchParams(window.location.search).get('token');
 if(tokenFromURL){ sessionStorage.setItem(tokenKey, tokenFromURL); }
 const adminToken = sessionStorage.getItem(tokenKey) || '';
 
+// the server-rendered storefront accepts ?tab=shopee and ?q=; start from the same filters
+const pageParams = new URLSearchParams(window.location.search);
 let allProducts = [];
-let filterText = '';
-let filterTab = 'my'; // 'my' = My Choice (no external link), 'shopee' = items with external_url
+let filterText = pageParams.get('q') || '';
+let filterTab = pageParams.get('tab') === 'shopee' ? 'shopee' : 'my'; // 'my' = My Choice (no external link), 'shopee' = items with external_url
 let filterCategory = 0; // 0 = all
+let allCategories = []; // flat list from /api/categories (position order)
+let searchRank = null; // Map product id -> rank from /api/search, null = no server results
+let searchTimer = null;
 
 function authedFetch(url, options={}){
   const opts = {...options};
@@ -85,15 +104,38 @@ function showPrompt(msg, defaultValue=''){ return showActionModal({message: msg,
 function formatPrice(value){
   const num = Number(value);
   if(Number.isNaN(num) || num <= 0) return 'Liên hệ';
-  return currencyFormatter.format(num);
+  return currencyFormatter.format(toMajor(num));
+}
+
+// priceHTML shows the current price, with the regular price struck through during a sale
+// and a "price dropped" badge when it is below the recent high
+function priceHTML(p){
+  const regular = p.price > 0 ? (p.price_formatted || formatPrice(p.price)) : formatPrice(0);
+  if(!p.on_sale){
+    return p.price_dropped ? `${regular} <span class="price-drop" title="Trước đây ${formatPrice(p.recent_max_price)}">Giảm giá</span>` : regular;
+  }
+  return `<s class="muted">${regular}</s> <span class="sale-price">${p.effective_price_formatted || formatPrice(p.effective_price)}</span>`;
 }
 
+// toLocalInput converts an ISO time to a datetime-local input value
+function toLocalInput(iso){
+  if(!iso) return '';
+  const d = new Date(iso);
+  if(Number.isNaN(d.getTime())) return '';
+  const pad = n => String(n).padStart(2, '0');
+  return `${d.getFullYear()}-${pad(d.getMonth()+1)}-${pad(d.getDate())}T${pad(d.getHours())}:${pad(d.getMinutes())}`;
+}
+
+// socialLink prefers the click-tracking redirect over the raw social URL
+function socialLink(s){ return s ? (shopURL(s.tracked_url) || s.url) : ''; }
+
 async function loadProfile(populateForm=false){
   try{
     const fetchFn = populateForm ? authedFetch : fetch;
-    const res = await fetchFn('/api/profile');
+    const res = await fetchFn(shopBase+'/api/profile');
     if(!res.ok) return;
     const data = await res.json();
+    setShopCurrency(data.currency);
     const nameEl = document.getElementById('profile-name');
     const handleEl = document.getElementById('profile-username');
     const highlightEl = document.getElementById('profile-highlight');
@@ -104,6 +146,7 @@ async function loadProfile(populateForm=false){
   if(highlightEl) highlightEl.textContent = data.highlight || '';
   if(bioEl) bioEl.innerHTML = linkifyText(data.bio || '');
   if(avatarEl && data.avatar_url) avatarEl.src = data.avatar_url;
+  renderProfileBlocks(data.blocks || []);
   // Render fixed social icons: Instagram (use profile username if present), Facebook, TikTok.
   const socialContainer = document.getElementById('social-icons');
   if (socialContainer) {
@@ -112,9 +155,9 @@ async function loadProfile(populateForm=false){
     // try to pick URLs from data.socials when available
     const socialsMap = {};
     (data.socials || []).forEach(s => { if (s && s.name) socialsMap[s.name.toLowerCase()] = s; });
-    const instaURL = socialsMap['instagram']?.url || (uname ? 'https://www.instagram.com/_huientram?igsh=NWVxb3NpbWRheTl2&utm_source=qr' + uname : 'https://www.instagram.com');
-    const fbURL = socialsMap['facebook']?.url || 'https://www.facebook.com/ty.tung.180?mibextid=wwXIfr&rdid=06MPlWdqSOX9lOCp&share_url=https%3A%2F%2Fwww.facebook.com%2Fshare%2F1ZS1NpLBL3%2F%3Fmibextid%3DwwXIfr';
-    const ttURL = socialsMap['tiktok']?.url || 'https://www.tiktok.com/@huyentram0206?_r=1&_t=ZS-91kThaRWClJ';
+    const instaURL = socialLink(socialsMap['instagram']) || (uname ? 'https://www.instagram.com/_huientram?igsh=NWVxb3NpbWRheTl2&utm_source=qr' + uname : 'https://www.instagram.com');
+    const fbURL = socialLink(socialsMap['facebook']) || 'https://www.facebook.com/ty.tung.180?mibextid=wwXIfr&rdid=06MPlWdqSOX9lOCp&share_url=https%3A%2F%2Fwww.facebook.com%2Fshare%2F1ZS1NpLBL3%2F%3Fmibextid%3DwwXIfr';
+    const ttURL = socialLink(socialsMap['tiktok']) || 'https://www.tiktok.com/@huyentram0206?_r=1&_t=ZS-91kThaRWClJ';
 
     const fixed = [
       { name: 'Instagram', url: instaURL, icon: 'instagram.svg' },
@@ -146,6 +189,9 @@ async function loadProfile(populateForm=false){
         profileForm.querySelector('[name="username"]').value = data.username || '';
         profileForm.querySelector('[name="bio"]').value = data.bio || '';
         profileForm.querySelector('[name="highlight"]').value = data.highlight || '';
+        profileForm.querySelector('[name="shopee_affiliate_params"]').value = data.shopee_affiliate_params || '';
+        const currencySel = profileForm.querySelector('[name="currency"]');
+        if(currencySel) currencySel.value = data.currency || 'VND';
       }
     }
   }catch(err){
@@ -153,10 +199,36 @@ async function loadProfile(populateForm=false){
   }
 }
 
+// renderProfileBlocks draws the profile's link, header, text, video and product blocks
+// (same markup as the server-rendered storefront)
+function renderProfileBlocks(blocks){
+  const el = document.getElementById('profile-blocks');
+  if(!el) return;
+  el.innerHTML = blocks.map(b=>{
+    switch(b.type){
+      case 'link': return `<a class="btn block-link" href="${escapeHtml(b.url)}" target="_blank" rel="noreferrer">${escapeHtml(b.title)}</a>`;
+      case 'header': return `<h2 class="block-header">${escapeHtml(b.title)}</h2>`;
+      case 'text': return `<p class="block-text">${escapeHtml(b.body || '')}</p>`;
+      case 'video': return b.embed_url ? `<div class="block-video"><iframe src="${escapeHtml(b.embed_url)}" title="${escapeHtml(b.title || '')}" loading="lazy" allowfullscreen allow="encrypted-media; picture-in-picture"></iframe></div>` : '';
+      case 'product': {
+        const p = b.product;
+        if(!p) return '';
+        return `<a class="block-product" href="${escapeHtml(shopURL(p.page_url))}" data-id="${p.id}">${p.image_url ? `<img src="${escapeHtml(p.image_url)}" alt="${escapeHtml(p.title)}" loading="lazy">` : ''}<span class="title">${escapeHtml(p.title)}</span><span class="price">${p.price > 0 ? escapeHtml(p.effective_price_formatted) : 'Liên hệ'}</span></a>`;
+      }
+    }
+    return '';
+  }).join('');
+  // featured products open the product modal when the storefront has them loaded
+  el.querySelectorAll('.block-product').forEach(a=>a.addEventListener('click', e=>{
+    const p = allProducts.find(x => x.id === Number(a.dataset.id));
+    if(p){ e.preventDefault(); showProductModal(p); }
+  }));
+}
+
 // ---------------- Socials admin helpers ----------------
 async function loadAdminSocials(){
   try{
-    const res = await authedFetch('/api/socials');
+    const res = await authedFetch(shopBase+'/api/socials');
     if(!res.ok) return [];
     const data = await res.json();
     renderAdminSocials(data);
@@ -192,7 +264,7 @@ function renderAdminSocials(socs){
       const id = ev.currentTarget.dataset.id;
       const ok = await showConfirm('Xóa social này?');
       if(!ok) return;
-      const res = await authedFetch('/api/socials/'+id, {method:'DELETE'});
+      const res = await authedFetch(shopBase+'/api/socials/'+id, {method:'DELETE'});
       if(res.ok){ loadAdminSocials(); loadProfile(true); }
       else{ const txt = await res.text().catch(()=>'<no body>'); alert('Xóa thất bại: '+txt); }
     });
@@ -201,7 +273,7 @@ function renderAdminSocials(socs){
   list.querySelectorAll('.btn-edit-social').forEach(b=>{
     b.addEventListener('click', async (ev)=>{
       const id = ev.currentTarget.dataset.id;
-      const res = await authedFetch('/api/socials');
+      const res = await authedFetch(shopBase+'/api/socials');
       if(!res.ok) return;
       const data = await res.json();
       const s = data.find(x=>String(x.id) === String(id));
@@ -218,7 +290,7 @@ function renderAdminSocials(socs){
 
 async function loadStaticImgs(){
   try{
-    const res = await authedFetch('/api/static-imgs');
+    const res = await authedFetch(shopBase+'/api/static-imgs');
     if(!res.ok) return [];
     const arr = await res.json();
     const sel = document.getElementById('social-icon-select');
@@ -232,7 +304,7 @@ async function loadStaticImgs(){
 // Category UI removed: public/categories are managed directly in the DB now.
 
 async function listProducts(){
-  const res = await fetch('/api/products');
+  const res = await fetch(shopBase+'/api/products');
   if(!res.ok){
     const txt = await res.text().catch(()=>'<no body>');
     console.error('listProducts failed', res.status, txt);
@@ -247,26 +319,58 @@ async function listProducts(){
     category: p.category || ''
   }));
   renderProducts();
+  // /?product=ID (linked from the /p/ pages) opens that product directly
+  const wanted = Number(new URLSearchParams(window.location.search).get('product'));
+  const linked = wanted && allProducts.find(p => p.id === wanted);
+  if(linked) showProductModal(linked);
+}
+
+// runSearch asks the server for ranked, diacritic-insensitive matches (debounced)
+function runSearch(){
+  clearTimeout(searchTimer);
+  const q = filterText.trim();
+  if(!q){ searchRank = null; renderProducts(); return; }
+  searchTimer = setTimeout(async ()=>{
+    try{
+      const res = await fetch(shopBase+'/api/search?q='+encodeURIComponent(q));
+      if(!res.ok) throw new Error('status '+res.status);
+      const data = await res.json();
+      if(filterText.trim() !== q) return; // stale response
+      searchRank = new Map(data.map((r,i)=>[r.id, i]));
+    }catch(err){
+      console.error('search failed, falling back to local filter', err);
+      searchRank = null;
+    }
+    renderProducts();
+  }, 200);
 }
 
 function renderProducts(){
   const el = document.getElementById('products');
   if(!el) return;
+  // the grid now holds every match, so the server-rendered page links no longer apply
+  const pager = document.getElementById('products-pager');
+  if(pager) pager.remove();
   const normalized = filterText.trim().toLowerCase();
   const filtered = allProducts.filter(p=>{
-    const matchText = !normalized || (p.title && p.title.toLowerCase().includes(normalized)) || (p.description && p.description.toLowerCase().includes(normalized));
+    const matchText = !normalized || (searchRank
+      ? searchRank.has(p.id)
+      : (p.title && p.title.toLowerCase().includes(normalized)) || (p.description && p.description.toLowerCase().includes(normalized)));
     let matchTab = true;
     if(filterTab === 'my'){
-      matchTab = (p.tag || 'mychoice') === 'mychoice';
+      matchTab = (p.source || 'mychoice') === 'mychoice';
     } else if(filterTab === 'shopee'){
-      matchTab = (p.tag || '') === 'shopee';
+      matchTab = (p.source || '') === 'shopee';
     }
     let matchCategory = true;
-    if(filterCategory && Number(p.category_id || 0) !== Number(filterCategory)){
+    if(filterCategory && !categoryScope(filterCategory).has(Number(p.category_id || 0))){
       matchCategory = false;
     }
     return matchText && matchTab && matchCategory;
   });
+  if(normalized && searchRank){
+    filtered.sort((a,b)=> searchRank.get(a.id) - searchRank.get(b.id));
+  }
   el.innerHTML = '';
   if(!filtered.length){
     el.innerHTML = `<div class="empty-state">Không tìm thấy sản phẩm phù hợp.</div>`;
@@ -285,14 +389,21 @@ function renderProducts(){
       <div class="info">
         <p class="title">${p.title}</p>
         <p class="desc">${p.description || 'Đang cập nhật mô tả chi tiết.'}</p>
-        <span class="price">${formatPrice(p.price)}${p.category ? ` • ${p.category}` : ''}</span>
-  ${p.tag === 'shopee' && p.external_url ? `<div style="margin-top:0.6rem"><a class="btn ghost" href="${p.external_url}" target="_blank" rel="noreferrer">Mua trên Shopee</a></div>` : ''}
+        <span class="price">${priceHTML(p)}${p.category ? ` • ${p.category}` : ''}</span>
+  ${p.source === 'shopee' && p.external_url ? `<div style="margin-top:0.6rem"><a class="btn ghost" href="${escapeHtml(shopURL(p.tracked_url) || p.external_url)}" target="_blank" rel="noreferrer">Mua trên Shopee</a></div>` : ''}
       </div>`;
     card.addEventListener('click', ()=> showProductModal(p));
     el.appendChild(card);
   });
 }
 
+// recordProductView reports a product detail view for the shop analytics
+function recordProductView(p){
+  const body = JSON.stringify({product_id: p.id, referrer: document.referrer});
+  if(navigator.sendBeacon) navigator.sendBeacon(shopBase+'/api/events/view', body);
+  else fetch(shopBase+'/api/events/view', {method:'POST', body, keepalive:true}).catch(()=>{});
+}
+
 function showProductModal(p){
   const modal = document.getElementById('product-modal');
   const body = document.getElementById('modal-body');
@@ -303,25 +414,434 @@ function showProductModal(p){
       : `<div class="thumb-placeholder" style="height:280px;border-radius:16px">No image</div>`}
     <h3>${p.title}</h3>
     <p>${p.description||'Đang cập nhật mô tả chi tiết.'}</p>
-    <p class="price" style="margin-top:1rem;font-size:1.2rem">${formatPrice(p.price)}</p>
+    <p class="price" style="margin-top:1rem;font-size:1.2rem">${priceHTML(p)}</p>
     ${p.category ? `<p style="color:#7b8191">Danh mục: ${p.category}</p>` : ''}
-  ${p.tag === 'shopee' && p.external_url ? `<div style="margin-top:0.8rem"><a class="btn primary" href="${p.external_url}" target="_blank" rel="noreferrer">Mua trên Shopee</a></div>` : (p.tag !== 'shopee' ? `<div style="margin-top:1.2rem"><a class="btn primary" href="https://www.instagram.com/${(document.getElementById('profile-username')?.textContent||'').replace(/^@/,'')}" target="_blank" rel="noreferrer">Nhắn Instagram để chốt</a></div>` : '')}
+    ${p.page_url ? `<p><a class="muted" href="${escapeHtml(shopURL(p.page_url))}">Link chia sẻ sản phẩm</a></p>` : ''}
+  ${p.source === 'shopee' && p.external_url ? `<div style="margin-top:0.8rem"><a class="btn primary" href="${escapeHtml(shopURL(p.tracked_url) || p.external_url)}" target="_blank" rel="noreferrer">Mua trên Shopee</a></div>` : (p.source !== 'shopee' ? orderFormHTML(p) : '')}
   `;
+  wireOrderForm(p);
+  recordProductView(p);
+  modal.classList.remove('hidden');
+  modal.classList.add('open');
+}
+
+const orderStatusLabels = {new:'Mới', confirmed:'Đã xác nhận', shipped:'Đang giao', done:'Hoàn tất', cancelled:'Đã hủy'};
+
+// orderFormHTML renders the order request form shown in the product modal
+function orderFormHTML(p){
+  const insta = `https://www.instagram.com/${(document.getElementById('profile-username')?.textContent||'').replace(/^@/,'')}`;
+  if(p.status === 'sold_out'){
+    return `<p class="muted" style="margin-top:1.2rem">Sản phẩm đã hết hàng. <a href="${insta}" target="_blank" rel="noreferrer">Nhắn Instagram</a> để hỏi hàng về.</p>`;
+  }
+  const max = p.stock == null ? 99 : Math.min(99, p.stock);
+  return `
+    <form id="order-form" class="product-form" style="margin-top:1.2rem">
+      <div class="row">
+        <label>Họ tên<input name="customer_name" required></label>
+        <label>Số điện thoại<input name="phone" type="tel" required placeholder="09xx xxx xxx"></label>
+      </div>
+      <div class="row"><label>Địa chỉ nhận hàng<input name="address" required></label></div>
+      <div class="row">
+        <label>Size / màu<input name="variant" placeholder="Ví dụ: size M, màu đen"></label>
+        <label>Số lượng<input name="quantity" type="number" min="1" max="${max}" value="1"></label>
+      </div>
+      <div class="row"><label>Ghi chú<textarea name="note"></textarea></label></div>
+      <div class="form-actions">
+        <button type="submit" class="btn primary">Đặt hàng</button>
+        <button type="button" class="btn" id="add-to-cart">Thêm vào giỏ</button>
+        <a class="btn ghost" href="${insta}" target="_blank" rel="noreferrer">Nhắn Instagram</a>
+      </div>
+      <p id="order-result" class="muted"></p>
+    </form>`;
+}
+
+function wireOrderForm(p){
+  const form = document.getElementById('order-form');
+  if(!form) return;
+  document.getElementById('add-to-cart')?.addEventListener('click', async ()=>{
+    const variant = form.querySelector('[name="variant"]').value.trim();
+    const quantity = Number(form.querySelector('[name="quantity"]').value) || 1;
+    const res = await fetch(shopBase+'/api/cart/items', {method:'POST', headers:{'Content-Type':'application/json'}, body: JSON.stringify({product_id: p.id, variant, quantity})});
+    const result = document.getElementById('order-result');
+    if(!res.ok){ result.textContent = 'Chưa thêm được: ' + (await res.text()); return; }
+    renderCartButton(await res.json());
+    result.textContent = 'Đã thêm vào giỏ hàng.';
+  });
+  form.addEventListener('submit', async (e)=>{
+    e.preventDefault();
+    const val = name => form.querySelector(`[name="${name}"]`).value.trim();
+    const payload = {
+      customer_name: val('customer_name'), phone: val('phone'), address: val('address'), note: val('note'),
+      items: [{product_id: p.id, variant: val('variant'), quantity: Number(val('quantity')) || 1}],
+    };
+    const result = document.getElementById('order-result');
+    const btn = form.querySelector('button[type="submit"]');
+    btn.disabled = true;
+    try{
+      const res = await fetch(shopBase+'/api/orders', {method:'POST', headers:{'Content-Type':'application/json'}, body: JSON.stringify(payload)});
+      if(!res.ok){ result.textContent = 'Chưa gửi được: ' + (await res.text()); return; }
+      const order = await res.json();
+      form.innerHTML = `<p>Đã nhận đơn #${order.id} (${formatPrice(order.total)}). Shop sẽ gọi lại để xác nhận nhé!</p>`;
+    }catch(err){
+      result.textContent = 'Lỗi mạng, vui lòng thử lại.';
+    }finally{
+      btn.disabled = false;
+    }
+  });
+}
+
+// renderCartButton shows the floating cart button with the item count
+function renderCartButton(cart){
+  const btn = document.getElementById('cart-button');
+  if(!btn) return;
+  document.getElementById('cart-count').textContent = cart.count || 0;
+  btn.classList.toggle('hidden', !(cart.items||[]).length);
+}
+
+async function refreshCart(){
+  try{
+    const res = await fetch(shopBase+'/api/cart');
+    if(res.ok) renderCartButton(await res.json());
+  }catch(err){ /* cart is optional */ }
+}
+
+// showCart renders the cart and checkout form in the product modal
+async function showCart(){
+  const modal = document.getElementById('product-modal');
+  const body = document.getElementById('modal-body');
+  if(!modal || !body) return;
+  const res = await fetch(shopBase+'/api/cart');
+  if(!res.ok) return;
+  const cart = await res.json();
+  renderCartButton(cart);
+  if(!cart.items.length){
+    body.innerHTML = '<h3>Giỏ hàng</h3><p class="muted">Giỏ hàng trống.</p>';
+  }else{
+    body.innerHTML = `
+      <h3>Giỏ hàng</h3>
+      ${cart.items.map(it=>`
+        <div class="cart-line" data-line="${it.id}">
+          ${it.image_url ? `<img src="${it.image_url}" alt="">` : ''}
+          <div style="flex:1">
+            <strong>${escapeHtml(it.title || ('#' + it.product_id))}</strong>${it.variant ? ` <span class="muted">(${escapeHtml(it.variant)})</span>` : ''}
+            <div class="muted">${formatPrice(it.unit_price)}${it.price_changed ? ` <small>(giá cũ ${formatPrice(it.added_price)})</small>` : ''}</div>
+            ${it.error ? `<div class="muted" style="color:#c0392b">${escapeHtml(it.error)}</div>` : ''}
+          </div>
+          <input type="number" class="cart-qty" min="0" max="99" value="${it.quantity}" aria-label="Số lượng">
+          <button type="button" class="btn-ghost cart-remove">Xóa</button>
+        </div>`).join('')}
+      <form id="coupon-apply-form" class="row" style="margin-top:1rem;gap:8px">
+        <input name="code" placeholder="Mã giảm giá" value="${escapeHtml(cart.coupon_code || '')}">
+        <button type="submit" class="btn ghost">Áp dụng</button>
+        ${cart.coupon_code ? '<button type="button" class="btn-ghost" id="coupon-remove">Bỏ mã</button>' : ''}
+      </form>
+      ${cart.coupon_error ? `<p class="muted" style="color:#c0392b">${escapeHtml(cart.coupon_error)}</p>` : ''}
+      ${cart.discount > 0 ? `<p class="muted">Tạm tính: ${formatPrice(cart.subtotal)} • Giảm (${escapeHtml(cart.coupon_code)}): −${formatPrice(cart.discount)}</p>` : ''}
+      <p class="price" style="margin-top:1rem">Tổng: ${formatPrice(cart.total)}</p>
+      <form id="shipping-quote-form" class="row" style="gap:8px">
+        <input name="province" placeholder="Tỉnh/thành nhận hàng" value="${escapeHtml(localStorage.getItem('shipping_province') || '')}">
+        <button type="submit" class="btn ghost">Tính phí ship</button>
+      </form>
+      <p id="shipping-quote" class="muted"></p>
+      <form id="checkout-form" class="product-form">
+        <div class="row">
+          <label>Họ tên<input name="customer_name" required></label>
+          <label>Số điện thoại<input name="phone" type="tel" required placeholder="09xx xxx xxx"></label>
+        </div>
+        <div class="row"><label>Địa chỉ nhận hàng<input name="address" required></label></div>
+        <div class="row"><label>Ghi chú<textarea name="note"></textarea></label></div>
+        <div class="form-actions"><button type="submit" class="btn primary">Đặt hàng</button></div>
+        <p id="checkout-result" class="muted"></p>
+      </form>`;
+    const update = async (lineID, quantity)=>{
+      const res = await fetch(shopBase+`/api/cart/items/${lineID}`, quantity > 0
+        ? {method:'PUT', headers:{'Content-Type':'application/json'}, body: JSON.stringify({quantity})}
+        : {method:'DELETE'});
+      if(!res.ok) alert('Không cập nhật được giỏ hàng: ' + await res.text());
+      showCart();
+    };
+    body.querySelectorAll('.cart-line').forEach(line=>{
+      const id = line.dataset.line;
+      line.querySelector('.cart-qty').addEventListener('change', e=>update(id, Number(e.target.value) || 0));
+      line.querySelector('.cart-remove').addEventListener('click', ()=>update(id, 0));
+    });
+    const couponForm = document.getElementById('coupon-apply-form');
+    couponForm.addEventListener('submit', async (e)=>{
+      e.preventDefault();
+      const code = couponForm.querySelector('[name="code"]').value.trim();
+      const res = await fetch(shopBase+'/api/cart/coupon', code
+        ? {method:'POST', headers:{'Content-Type':'application/json'}, body: JSON.stringify({code})}
+        : {method:'DELETE'});
+      if(!res.ok){ alert('Không áp dụng được mã: ' + await res.text()); return; }
+      showCart();
+    });
+    document.getElementById('coupon-remove')?.addEventListener('click', async ()=>{
+      await fetch(shopBase+'/api/cart/coupon', {method:'DELETE'});
+      showCart();
+    });
+    const quoteForm = document.getElementById('shipping-quote-form');
+    const quoteShipping = async ()=>{
+      const province = quoteForm.querySelector('[name="province"]').value.trim();
+      const out = document.getElementById('shipping-quote');
+      if(!province){ out.textContent = ''; return; }
+      localStorage.setItem('shipping_province', province);
+      const res = await fetch(shopBase+'/api/shipping/quote', {method:'POST', headers:{'Content-Type':'application/json'}, body: JSON.stringify({province})});
+      if(!res.ok){ out.textContent = await res.text(); return; }
+      const q = await res.json();
+      out.textContent = q.free_shipping
+        ? `Miễn phí giao hàng (${q.zone})`
+        : `Phí ship ${q.zone}: ${formatPrice(q.fee)}${q.free_remaining ? ` • Mua thêm ${formatPrice(q.free_remaining)} để được miễn phí` : ''}${q.weight_estimated ? ' (ước tính)' : ''}`;
+    };
+    quoteForm.addEventListener('submit', e=>{ e.preventDefault(); quoteShipping(); });
+    quoteShipping();
+    const form = document.getElementById('checkout-form');
+    form.addEventListener('submit', async (e)=>{
+      e.preventDefault();
+      const val = name => form.querySelector(`[name="${name}"]`).value.trim();
+      const payload = {customer_name: val('customer_name'), phone: val('phone'), address: val('address'), note: val('note')};
+      const res = await fetch(shopBase+'/api/cart/checkout', {method:'POST', headers:{'Content-Type':'application/json'}, body: JSON.stringify(payload)});
+      if(!res.ok){ document.getElementById('checkout-result').textContent = 'Chưa gửi được: ' + (await res.text()); return; }
+      const order = await res.json();
+      body.innerHTML = `<h3>Cảm ơn bạn!</h3><p>Đã nhận đơn #${order.id} (${formatPrice(order.total)}). Shop sẽ gọi lại để xác nhận nhé!</p>`;
+      renderCartButton({count:0, items:[]});
+    });
+  }
   modal.classList.remove('hidden');
   modal.classList.add('open');
 }
 
+// adminLoadOrders renders the order list with status controls
+async function adminLoadOrders(){
+  const el = document.getElementById('admin-orders');
+  if(!el) return;
+  const status = document.getElementById('order-status-filter')?.value || '';
+  const res = await authedFetch(shopBase+'/api/orders' + (status ? `?status=${status}` : ''));
+  if(!res.ok){ el.innerHTML = '<p class="muted">Không tải được đơn hàng</p>'; return; }
+  const orders = await res.json();
+  if(!orders.length){ el.innerHTML = '<p class="muted">Chưa có đơn nào</p>'; return; }
+  el.innerHTML = orders.map(o=>`
+    <div class="card" data-order="${o.id}" style="padding:8px;display:flex;gap:12px;align-items:flex-start">
+      <div style="flex:1">
+        <strong>#${o.id} • ${escapeHtml(o.customer_name)} • ${escapeHtml(o.phone)}</strong>
+        <div class="muted">${escapeHtml(o.address)} • ${new Date(o.created_at).toLocaleString('vi-VN')}</div>
+        <ul>${(o.items||[]).map(it=>`<li>${escapeHtml(it.title)}${it.variant ? ` (${escapeHtml(it.variant)})` : ''} × ${it.quantity} — ${formatPrice(it.unit_price)}</li>`).join('')}</ul>
+        ${o.note ? `<div class="muted">Ghi chú: ${escapeHtml(o.note)}</div>` : ''}
+        ${o.discount > 0 ? `<div class="muted">Tạm tính ${formatPrice(o.subtotal)} • Mã ${escapeHtml(o.coupon_code || '')}: −${formatPrice(o.discount)}</div>` : ''}
+        <div><strong>${formatPrice(o.total)}</strong></div>
+      </div>
+      <select class="order-status">
+        ${Object.entries(orderStatusLabels).map(([k,v])=>`<option value="${k}"${k===o.status?' selected':''}>${v}</option>`).join('')}
+      </select>
+    </div>`).join('');
+  el.querySelectorAll('.order-status').forEach(sel=>{
+    sel.addEventListener('change', async ()=>{
+      const id = sel.closest('[data-order]').dataset.order;
+      const res = await authedFetch(shopBase+`/api/orders/${id}`, {method:'PUT', headers:{'Content-Type':'application/json'}, body: JSON.stringify({status: sel.value})});
+      if(!res.ok){ alert('Không đổi được trạng thái: ' + await res.text()); }
+      adminLoadOrders(); adminLoadProducts();
+    });
+  });
+}
+
+// adminLoadAnalytics renders totals, top products, socials and referrers for the chosen range
+async function adminLoadAnalytics(refresh=false){
+  const el = document.getElementById('admin-analytics');
+  const form = document.getElementById('analytics-form');
+  if(!el || !form) return;
+  const params = new URLSearchParams();
+  ['from','to'].forEach(name=>{ const v = form.querySelector(`[name="${name}"]`).value; if(v) params.set(name, v); });
+  if(form.querySelector('[name="bots"]').checked) params.set('bots', '1');
+  if(refresh) params.set('refresh', '1');
+  const res = await authedFetch(shopBase+'/api/admin/analytics?' + params);
+  if(!res.ok){ el.innerHTML = `<p class="muted">Không tải được thống kê: ${escapeHtml(await res.text())}</p>`; return; }
+  const a = await res.json();
+  const maxDay = Math.max(1, ...a.daily.map(d=>d.views + d.product_clicks + d.social_clicks));
+  el.innerHTML = `
+    <p><strong>${a.totals.views}</strong> lượt xem • <strong>${a.totals.product_clicks}</strong> lượt bấm Shopee • <strong>${a.totals.social_clicks}</strong> lượt bấm mạng xã hội
+      <span class="muted">(${a.from} → ${a.to}${a.rolled_up_at ? ', tổng hợp lúc ' + new Date(a.rolled_up_at).toLocaleTimeString('vi-VN') : ''})</span></p>
+    <div style="display:flex;gap:2px;align-items:flex-end;height:60px;margin:0.6rem 0">
+      ${a.daily.map(d=>{ const n = d.views + d.product_clicks + d.social_clicks; return `<div title="${d.day}: ${d.views} xem, ${d.product_clicks + d.social_clicks} bấm" style="flex:1;background:#c0392b;opacity:.7;height:${Math.round(n / maxDay * 100)}%"></div>`; }).join('')}
+    </div>
+    <h4>Sản phẩm nổi bật</h4>
+    ${a.top_products.length ? a.top_products.map(p=>`<div class="muted">${escapeHtml(p.title || ('#' + p.product_id))}: ${p.views} xem • ${p.clicks} bấm${p.views ? ` • CTR ${(p.ctr * 100).toFixed(1)}%` : ''}</div>`).join('') : '<p class="muted">Chưa có dữ liệu</p>'}
+    <h4>Mạng xã hội</h4>
+    ${a.socials.length ? a.socials.map(s=>`<div class="muted">${escapeHtml(s.name || ('#' + s.social_id))}: ${s.clicks} bấm</div>`).join('') : '<p class="muted">Chưa có dữ liệu</p>'}
+    <h4>Nguồn truy cập</h4>
+    ${a.referrers.length ? a.referrers.map(r=>`<div class="muted">${escapeHtml(r.host || '(trực tiếp)')}: ${r.views} xem • ${r.product_clicks + r.social_clicks} bấm</div>`).join('') : '<p class="muted">Chưa có dữ liệu</p>'}`;
+}
+
+// adminLoadShopeeSync lists Shopee products whose price changed or listing disappeared
+async function adminLoadShopeeSync(){
+  const el = document.getElementById('admin-shopee-sync');
+  if(!el) return;
+  const res = await authedFetch(shopBase+'/api/admin/shopee-sync');
+  if(!res.ok){ el.innerHTML = '<p class="muted">Không tải được trạng thái đồng bộ</p>'; return; }
+  const data = await res.json();
+  if(!data.enabled){ el.innerHTML = '<p class="muted">Đồng bộ Shopee đang tắt</p>'; return; }
+  if(!data.flagged.length){ el.innerHTML = `<p class="muted">Không có thay đổi cần xem${data.ran_at ? ' • lần chạy gần nhất ' + new Date(data.ran_at).toLocaleString('vi-VN') : ''}</p>`; return; }
+  el.innerHTML = data.flagged.map(s=>`
+    <div class="card" data-sync="${s.product_id}" style="padding:8px;display:flex;gap:12px;align-items:center">
+      <div style="flex:1">
+        <strong>${escapeHtml(s.title || ('#' + s.product_id))}</strong>
+        <div class="muted">${s.flag === 'gone' ? 'Sản phẩm không còn trên Shopee' : s.flag === 'price_changed' ? `Giá đổi ${formatPrice(s.previous_price)} → ${formatPrice(s.upstream_price)}` : ''}${s.error ? ' Lỗi: ' + escapeHtml(s.error) : ''}</div>
+      </div>
+      ${s.flag ? '<button type="button" class="btn-ghost sync-ack">Đã xem</button>' : ''}
+    </div>`).join('');
+  el.querySelectorAll('.sync-ack').forEach(btn=>btn.addEventListener('click', async ()=>{
+    const id = Number(btn.closest('[data-sync]').dataset.sync);
+    await authedFetch(shopBase+'/api/admin/shopee-sync', {method:'POST', headers:{'Content-Type':'application/json'}, body: JSON.stringify({ack:[id]})});
+    adminLoadShopeeSync();
+  }));
+}
+
+// adminLoadShippingZones renders the shipping zones with delete controls
+async function adminLoadShippingZones(){
+  const el = document.getElementById('admin-shipping-zones');
+  if(!el) return;
+  const res = await authedFetch(shopBase+'/api/shipping/zones');
+  if(!res.ok){ el.innerHTML = '<p class="muted">Không tải được vùng giao hàng</p>'; return; }
+  const zones = await res.json();
+  if(!zones.length){ el.innerHTML = '<p class="muted">Chưa có vùng nào — khách chưa tính được phí ship</p>'; return; }
+  el.innerHTML = zones.map(z=>`
+    <div class="card" data-zone="${z.id}" style="padding:8px;display:flex;gap:12px;align-items:center">
+      <div style="flex:1">
+        <strong>${escapeHtml(z.name)}</strong> — ${formatPrice(z.base_fee)}${z.kind === 'weight' ? ` cho ${z.included_grams}g đầu, +${formatPrice(z.per_kg_fee)}/kg` : ''}
+        <div class="muted">${z.provinces.length ? z.provinces.map(escapeHtml).join(', ') : 'Các nơi còn lại'}${z.free_over > 0 ? ' • Miễn phí từ ' + formatPrice(z.free_over) : ''}</div>
+      </div>
+      <button type="button" class="btn-ghost zone-delete">Xóa</button>
+    </div>`).join('');
+  el.querySelectorAll('[data-zone]').forEach(row=>{
+    row.querySelector('.zone-delete').addEventListener('click', async ()=>{
+      if(!await showConfirm('Xóa vùng giao hàng này?')) return;
+      await authedFetch(shopBase+`/api/shipping/zones/${row.dataset.zone}`, {method:'DELETE'});
+      adminLoadShippingZones();
+    });
+  });
+}
+
+// adminLoadProfileBlocks renders the profile blocks with show/hide, move and delete controls
+async function adminLoadProfileBlocks(){
+  const el = document.getElementById('admin-profile-blocks');
+  if(!el) return;
+  const res = await authedFetch(shopBase+'/api/profile/blocks');
+  if(!res.ok){ el.innerHTML = '<p class="muted">Không tải được khối nội dung</p>'; return; }
+  const blocks = await res.json();
+  if(!blocks.length){ el.innerHTML = '<p class="muted">Chưa có khối nào</p>'; return; }
+  const labels = {link:'Liên kết', header:'Tiêu đề', text:'Đoạn văn', video:'Video', product:'Sản phẩm'};
+  const when = b => [b.starts_at ? 'từ ' + new Date(b.starts_at).toLocaleString('vi-VN') : '', b.ends_at ? 'đến ' + new Date(b.ends_at).toLocaleString('vi-VN') : ''].filter(Boolean).join(' ');
+  el.innerHTML = blocks.map(b=>`
+    <div class="card" data-block="${b.id}" style="padding:8px;display:flex;gap:12px;align-items:center">
+      <div style="flex:1">
+        <strong>${labels[b.type] || escapeHtml(b.type)}</strong> — ${escapeHtml(b.title || b.body || b.url || (b.product_id ? '#' + b.product_id : ''))}
+        <div class="muted">${b.visible ? 'Đang hiện' : 'Đang ẩn'}${when(b) ? ' • ' + escapeHtml(when(b)) : ''}</div>
+      </div>
+      <button type="button" class="btn-ghost block-up" title="Lên">↑</button>
+      <button type="button" class="btn-ghost block-down" title="Xuống">↓</button>
+      <button type="button" class="btn-ghost block-toggle">${b.visible ? 'Ẩn' : 'Hiện'}</button>
+      <button type="button" class="btn-ghost block-delete">Xóa</button>
+    </div>`).join('');
+  const ids = blocks.map(b=>b.id);
+  const move = async (i, j) => {
+    if(j < 0 || j >= ids.length) return;
+    [ids[i], ids[j]] = [ids[j], ids[i]];
+    await authedFetch(shopBase+'/api/profile/blocks/order', {method:'PUT', headers:{'Content-Type':'application/json'}, body: JSON.stringify({ids})});
+    adminLoadProfileBlocks();
+  };
+  el.querySelectorAll('[data-block]').forEach((row, i)=>{
+    const b = blocks[i];
+    row.querySelector('.block-up').addEventListener('click', ()=>move(i, i-1));
+    row.querySelector('.block-down').addEventListener('click', ()=>move(i, i+1));
+    row.querySelector('.block-toggle').addEventListener('click', async ()=>{
+      await authedFetch(shopBase+`/api/profile/blocks/${b.id}`, {method:'PUT', headers:{'Content-Type':'application/json'}, body: JSON.stringify({visible: !b.visible})});
+      adminLoadProfileBlocks();
+    });
+    row.querySelector('.block-delete').addEventListener('click', async ()=>{
+      if(!await showConfirm('Xóa khối này?')) return;
+      await authedFetch(shopBase+`/api/profile/blocks/${b.id}`, {method:'DELETE'});
+      adminLoadProfileBlocks();
+    });
+  });
+}
+
+// adminLoadCoupons renders the coupon list with enable/disable and delete controls
+async function adminLoadCoupons(){
+  const el = document.getElementById('admin-coupons');
+  if(!el) return;
+  const res = await authedFetch(shopBase+'/api/coupons');
+  if(!res.ok){ el.innerHTML = '<p class="muted">Không tải được mã giảm giá</p>'; return; }
+  const coupons = await res.json();
+  if(!coupons.length){ el.innerHTML = '<p class="muted">Chưa có mã nào</p>'; return; }
+  el.innerHTML = coupons.map(c=>`
+    <div class="card" data-coupon="${c.id}" style="padding:8px;display:flex;gap:12px;align-items:center">
+      <div style="flex:1">
+        <strong>${escapeHtml(c.code)}</strong> — ${c.kind === 'percent' ? c.percent + '%' : formatPrice(c.amount)}
+        <div class="muted">${c.min_order > 0 ? 'Đơn từ ' + formatPrice(c.min_order) + ' • ' : ''}Đã dùng ${c.used_count}${c.max_uses ? '/' + c.max_uses : ''}${c.expires_at ? ' • Hết hạn ' + new Date(c.expires_at).toLocaleString('vi-VN') : ''}</div>
+      </div>
+      <label style="display:flex;gap:4px;align-items:center"><input type="checkbox" class="coupon-active"${c.active ? ' checked' : ''}> Bật</label>
+      <button type="button" class="btn-ghost coupon-delete">Xóa</button>
+    </div>`).join('');
+  el.querySelectorAll('[data-coupon]').forEach(row=>{
+    const id = row.dataset.coupon;
+    row.querySelector('.coupon-active').addEventListener('change', async e=>{
+      const res = await authedFetch(shopBase+`/api/coupons/${id}`, {method:'PUT', headers:{'Content-Type':'application/json'}, body: JSON.stringify({active: e.target.checked})});
+      if(!res.ok) alert('Không cập nhật được mã: ' + await res.text());
+      adminLoadCoupons();
+    });
+    row.querySelector('.coupon-delete').addEventListener('click', async ()=>{
+      if(!await showConfirm('Xóa mã giảm giá này?')) return;
+      await authedFetch(shopBase+`/api/coupons/${id}`, {method:'DELETE'});
+      adminLoadCoupons();
+    });
+  });
+}
+
+// categoryScope returns the ids of a category and all of its descendants
+function categoryScope(id){
+  const scope = new Set([Number(id)]);
+  let grew = true;
+  while(grew){
+    grew = false;
+    allCategories.forEach(c=>{
+      if(scope.has(Number(c.parent_id || 0)) && !scope.has(Number(c.id))){ scope.add(Number(c.id)); grew = true; }
+    });
+  }
+  return scope;
+}
+
+// categoriesInTreeOrder returns [{cat, depth}] walking the tree depth first
+function categoriesInTreeOrder(cats){
+  const out = [];
+  const walk = (parent, depth)=>{
+    cats.filter(c=>Number(c.parent_id || 0) === parent).forEach(c=>{
+      out.push({cat: c, depth});
+      walk(Number(c.id), depth+1);
+    });
+  };
+  walk(0, 0);
+  return out;
+}
+
+// categoryOptions renders <option>s in tree order, indenting children
+function categoryOptions(cats){
+  return categoriesInTreeOrder(cats).map(({cat, depth})=>`<option value="${cat.id}">${'— '.repeat(depth)}${escapeHtml(cat.name)}</option>`).join('');
+}
+
 // ----- Category admin helpers -----
 async function loadCategories(){
   try{
-    const res = await authedFetch('/api/categories');
+    const res = await authedFetch(shopBase+'/api/categories');
     if(!res.ok) return [];
-    const cats = await res.json();
+    const cats = (await res.json()) || [];
+    allCategories = cats;
     renderCategories(cats);
     // populate product category select
     const sel = document.getElementById('product-category');
     if(sel){
-      sel.innerHTML = '<option value="0">— Chọn danh mục —</option>' + cats.map(c=>`<option value="${c.id}">${c.name}</option>`).join('');
+      sel.innerHTML = '<option value="0">— Chọn danh mục —</option>' + categoryOptions(cats);
+    }
+    const parentSel = document.getElementById('category-parent');
+    if(parentSel){
+      parentSel.innerHTML = '<option value="0">— Danh mục gốc —</option>' + categoryOptions(cats);
     }
     return cats;
   }catch(err){ console.error('loadCategories error', err); return []; }
@@ -330,18 +850,24 @@ async function loadCategories(){
 // Public-facing category chips (visible on index.html)
 async function loadPublicCategories(){
   try{
-    const res = await fetch('/api/categories');
+    const res = await fetch(shopBase+'/api/categories');
     if(!res.ok) return [];
-    const cats = await res.json();
+    const cats = (await res.json()) || [];
+    allCategories = cats;
     const chips = document.getElementById('category-chips');
     if(!chips) return cats;
+    // /?category=slug (category pages listed in the sitemap) preselects that category
+    const wantedSlug = new URLSearchParams(window.location.search).get('category');
+    const wantedCat = wantedSlug && cats.find(c => c.slug === wantedSlug);
+    if(wantedCat){ filterCategory = Number(wantedCat.id); renderProducts(); }
     chips.innerHTML = '';
     const allBtn = document.createElement('button');
     allBtn.className = 'chip' + (filterCategory ? '' : ' active');
     allBtn.textContent = 'Tất cả';
     allBtn.addEventListener('click', ()=>{ filterCategory = 0; document.querySelectorAll('#category-chips .chip').forEach(x=>x.classList.remove('active')); allBtn.classList.add('active'); renderProducts(); });
     chips.appendChild(allBtn);
-    cats.forEach(c=>{
+    // only top-level chips; selecting one also shows products of its subcategories
+    cats.filter(c=>!Number(c.parent_id || 0)).forEach(c=>{
       const b = document.createElement('button');
       b.className = 'chip' + (Number(filterCategory) === Number(c.id) ? ' active' : '');
       b.textContent = c.name;
@@ -366,11 +892,11 @@ function renderCategories(cats){
     list.innerHTML = '<div class="muted">Chưa có danh mục nào.</div>';
     return;
   }
-  cats.forEach(c=>{
+  categoriesInTreeOrder(cats).forEach(({cat: c, depth})=>{
     const row = document.createElement('div');
     row.className = 'cat-row';
     row.innerHTML = `
-      <div>${c.name}</div>
+      <div style="padding-left:${depth*1.2}rem">${escapeHtml(c.name)} <span class="muted">/${escapeHtml(c.slug || '')}</span></div>
       <div class="actions">
         <button class="btn-edit" data-id="${c.id}" data-name="${escapeHtml(c.name)}">Sửa</button>
         <button class="btn-delete" data-id="${c.id}">Xóa</button>
@@ -383,7 +909,7 @@ function renderCategories(cats){
       const id = ev.currentTarget.dataset.id;
       const ok = await showConfirm('Xóa danh mục? Sản phẩm liên quan sẽ mất danh mục.');
       if(!ok) return;
-      const res = await authedFetch('/api/admin/delete-category',{method:'POST',headers:{'Content-Type':'application/json'},body:JSON.stringify({id: Number(id)})});
+      const res = await authedFetch(shopBase+'/api/admin/delete-category',{method:'POST',headers:{'Content-Type':'application/json'},body:JSON.stringify({id: Number(id)})});
       if(res.ok){ loadCategories(); adminLoadProducts(); listProducts(); }
       else { const txt = await res.text().catch(()=>'<no body>'); alert('Xóa thất bại: '+txt); }
     });
@@ -394,14 +920,54 @@ function renderCategories(cats){
       const name = ev.currentTarget.dataset.name;
       const newName = await showPrompt('Chỉnh tên danh mục', name);
       if(!newName) return;
-      const res = await authedFetch('/api/categories/'+id, {method:'PUT', headers:{'Content-Type':'application/json'}, body: JSON.stringify({name: newName})});
+      const res = await authedFetch(shopBase+'/api/categories/'+id, {method:'PUT', headers:{'Content-Type':'application/json'}, body: JSON.stringify({name: newName})});
       if(res.ok){ loadCategories(); adminLoadProducts(); listProducts(); }
       else{ const txt = await res.text().catch(()=>'<no body>'); alert('Cập nhật thất bại: '+txt); }
     });
   });
 }
 
-// Toggle external_url field visibility depending on selected tag
+// ----- Collection admin helpers -----
+async function loadCollections(){
+  try{
+    const res = await authedFetch(shopBase+'/api/collections');
+    if(!res.ok) return [];
+    const cols = (await res.json()) || [];
+    const opts = cols.map(c=>`<option value="${c.id}">${escapeHtml(c.name)}</option>`).join('');
+    ['product-collections','edit-product-collections'].forEach(id=>{
+      const sel = document.getElementById(id);
+      if(!sel) return;
+      const selected = new Set(Array.from(sel.selectedOptions).map(o=>o.value));
+      sel.innerHTML = opts;
+      Array.from(sel.options).forEach(o=>{ o.selected = selected.has(o.value); });
+    });
+    const list = document.getElementById('collection-list');
+    if(list){
+      list.innerHTML = cols.length ? '' : '<div class="muted">Chưa có bộ sưu tập nào.</div>';
+      cols.forEach(c=>{
+        const row = document.createElement('div');
+        row.className = 'cat-row';
+        row.innerHTML = `
+          <div>${escapeHtml(c.name)} <span class="muted">(${c.product_count || 0} sp)</span></div>
+          <div class="actions"><button class="btn-delete-collection" data-id="${c.id}">Xóa</button></div>`;
+        list.appendChild(row);
+      });
+      list.querySelectorAll('.btn-delete-collection').forEach(b=>{
+        b.addEventListener('click', async (ev)=>{
+          const id = ev.currentTarget.dataset.id;
+          const ok = await showConfirm('Xóa bộ sưu tập? Sản phẩm vẫn được giữ lại.');
+          if(!ok) return;
+          const res = await authedFetch(shopBase+'/api/collections/'+id, {method:'DELETE'});
+          if(res.ok){ loadCollections(); adminLoadProducts(); }
+          else{ const txt = await res.text().catch(()=>'<no body>'); alert('Xóa thất bại: '+txt); }
+        });
+      });
+    }
+    return cols;
+  }catch(err){ console.error('loadCollections error', err); return []; }
+}
+
+// Toggle external_url field visibility depending on selected source
 function toggleExternalField(){
   const tagSel = document.getElementById('product-tag');
   const extWrap = document.querySelector('.external-field');
@@ -443,7 +1009,7 @@ document.addEventListener('keydown', (e)=>{
 async function adminLoadProducts(){
   const container = document.getElementById('admin-products');
   if(!container) return;
-  const res = await authedFetch('/api/products');
+  const res = await authedFetch(shopBase+'/api/products');
   if(!res.ok){
     const txt = await res.text().catch(()=>'<no body>');
     console.error('adminLoadProducts failed', res.status, txt);
@@ -456,29 +1022,46 @@ async function adminLoadProducts(){
     const row = document.createElement('div');
     row.className = 'card product';
     row.style.padding = '8px';
+    row.draggable = true;
+    row.dataset.id = p.id;
     row.innerHTML = `
       <div style="display:flex;gap:12px;align-items:center">
+        <input type="checkbox" class="bulk-select" value="${p.id}" aria-label="Chọn sản phẩm">
+        <span class="drag-handle" title="Kéo để sắp xếp" style="cursor:grab">⠿</span>
         ${p.image_url?`<img src="${p.image_url}" style="width:120px;height:80px;object-fit:cover">`:`<div style="width:120px;height:80px;background:#eee"></div>`}
         <div style="flex:1">
           <strong>${p.title}</strong>
           <div style="color:#666">${p.description||''}</div>
           ${p.category ? `<div style="color:#999;font-size:.85rem">${p.category}</div>`:''}
-          <div style="color:#999;font-size:.85rem">Tag: ${p.tag || 'mychoice'}</div>
+          <div style="color:#999;font-size:.85rem">Trạng thái: ${p.status || 'published'} • Nguồn: ${p.source || 'mychoice'}${p.stock != null ? ' • Tồn: ' + p.stock : ''}${(p.collections||[]).length ? ' • ' + p.collections.map(c=>escapeHtml(c.name)).join(', ') : ''}</div>
         </div>
         <div style="display:flex;gap:8px">
+          <button class="btn btn-pin" data-id="${p.id}" data-pinned="${p.pinned ? 1 : 0}">${p.pinned ? 'Bỏ ghim' : 'Ghim'}</button>
           <button class="btn btn-edit" data-id="${p.id}">Edit</button>
           <button class="btn btn-delete" data-id="${p.id}">Delete</button>
         </div>
       </div>`;
     container.appendChild(row);
   }
+  wireProductReorder(container);
+  container.querySelectorAll('.btn-pin').forEach(b=>{
+    b.addEventListener('click', async (ev)=>{
+      ev.stopPropagation();
+      const id = ev.currentTarget.dataset.id;
+      const fd = new FormData();
+      fd.append('pinned', ev.currentTarget.dataset.pinned === '1' ? '0' : '1');
+      const res = await authedFetch(shopBase+'/api/products/'+id, {method:'PUT', body: fd});
+      if(res.ok){ adminLoadProducts(); listProducts(); }
+      else{ const txt = await res.text().catch(()=>'<no body>'); alert('Ghim thất bại: '+txt); }
+    });
+  });
   container.querySelectorAll('.btn-delete').forEach(b=>{
     b.addEventListener('click', async (ev)=>{
       const id = ev.currentTarget.dataset.id;
       const ok = await showConfirm('Delete product?');
       if(!ok) return;
       // Use POST JSON admin endpoint to avoid DELETE/cors/cookie issues
-      const res = await authedFetch('/api/admin/delete-product', {
+      const res = await authedFetch(shopBase+'/api/admin/delete-product', {
         method: 'POST',
         headers: {'Content-Type': 'application/json'},
         body: JSON.stringify({id: Number(id)})
@@ -497,7 +1080,7 @@ async function adminLoadProducts(){
     b.addEventListener('click', async (ev)=>{
       const id = ev.currentTarget.dataset.id;
       // open dedicated edit modal populated with product data
-      const res = await authedFetch('/api/products/'+id);
+      const res = await authedFetch(shopBase+'/api/products/'+id);
       if(!res.ok){ alert('Product not found'); return; }
       const p = await res.json();
       showProductEditModal(p);
@@ -505,6 +1088,29 @@ async function adminLoadProducts(){
   });
 }
 
+// wireProductReorder enables drag & drop on the admin list and saves the new order
+function wireProductReorder(container){
+  let dragged = null;
+  container.querySelectorAll('.card.product[draggable="true"]').forEach(row=>{
+    row.addEventListener('dragstart', ()=>{ dragged = row; row.style.opacity = '0.5'; });
+    row.addEventListener('dragend', ()=>{ row.style.opacity = ''; dragged = null; });
+    row.addEventListener('dragover', (e)=>{
+      e.preventDefault();
+      if(!dragged || dragged === row) return;
+      const rect = row.getBoundingClientRect();
+      const after = (e.clientY - rect.top) > rect.height / 2;
+      container.insertBefore(dragged, after ? row.nextSibling : row);
+    });
+    row.addEventListener('drop', async (e)=>{
+      e.preventDefault();
+      const ids = Array.from(container.querySelectorAll('.card.product')).map(r=>Number(r.dataset.id));
+      const res = await authedFetch(shopBase+'/api/admin/reorder-products', {method:'POST', headers:{'Content-Type':'application/json'}, body: JSON.stringify({ids})});
+      if(res.ok){ listProducts(); }
+      else{ const txt = await res.text().catch(()=>'<no body>'); alert('Sắp xếp thất bại: '+txt); adminLoadProducts(); }
+    });
+  });
+}
+
 // showProductEditModal opens the edit modal and populates fields
 function showProductEditModal(p){
   const modal = document.getElementById('product-edit-modal');
@@ -513,11 +1119,21 @@ function showProductEditModal(p){
   form.querySelector('[name="id"]').value = p.id || '';
   form.querySelector('[name="title"]').value = p.title || '';
   form.querySelector('[name="description"]').value = p.description || '';
-  form.querySelector('[name="price"]').value = p.price || '';
+  form.querySelector('[name="price"]').value = p.price ? toMajor(p.price) : '';
+  form.querySelector('[name="stock"]').value = p.stock == null ? '' : p.stock;
+  form.querySelector('[name="weight_grams"]').value = p.weight_grams == null ? '' : p.weight_grams;
+  form.querySelector('[name="sale_price"]').value = p.sale_price == null ? '' : toMajor(p.sale_price);
+  form.querySelector('[name="sale_starts_at"]').value = toLocalInput(p.sale_starts_at);
+  form.querySelector('[name="sale_ends_at"]').value = toLocalInput(p.sale_ends_at);
   const catSel = document.getElementById('edit-product-category');
   if(catSel) catSel.value = p.category_id || 0;
   const tagSel = document.getElementById('edit-product-tag');
-  if(tagSel) tagSel.value = p.tag || 'mychoice';
+  if(tagSel) tagSel.value = p.source || 'mychoice';
+  const colSel = document.getElementById('edit-product-collections');
+  if(colSel){
+    const ids = new Set((p.collections||[]).map(c=>String(c.id)));
+    Array.from(colSel.options).forEach(o=>{ o.selected = ids.has(o.value); });
+  }
   const ext = form.querySelector('[name="external_url"]');
   if(ext) ext.value = p.external_url || '';
   // reset file chooser display
@@ -531,7 +1147,7 @@ function showProductEditModal(p){
   loadCategories().then(cats=>{
     const sel = document.getElementById('edit-product-category');
     if(sel){
-      sel.innerHTML = '<option value="0">— Chọn danh mục —</option>' + cats.map(c=>`<option value="${c.id}">${c.name}</option>`).join('');
+      sel.innerHTML = '<option value="0">— Chọn danh mục —</option>' + categoryOptions(cats);
       sel.value = p.category_id || 0;
     }
   }).catch(()=>{});
@@ -560,7 +1176,7 @@ document.addEventListener('DOMContentLoaded', ()=>{
       const id = editForm.querySelector('[name="id"]').value;
       const fd = new FormData(editForm);
       try{
-        const res = await authedFetch('/api/products/'+id, {method:'PUT', body: fd});
+        const res = await authedFetch(shopBase+'/api/products/'+id, {method:'PUT', body: fd});
         if(res.ok){
           alert('Product updated');
           editModal.classList.add('hidden');
@@ -579,6 +1195,10 @@ document.addEventListener('DOMContentLoaded', ()=>{
 document.addEventListener('DOMContentLoaded', ()=>{
   loadProfile();
   listProducts();
+  if(document.getElementById('cart-button')){
+    refreshCart();
+    document.getElementById('cart-button').addEventListener('click', showCart);
+  }
   const productForm = document.getElementById('product-form');
   const adminPanel = document.getElementById('admin-panel');
   const profileForm = document.getElementById('profile-form');
@@ -588,14 +1208,86 @@ document.addEventListener('DOMContentLoaded', ()=>{
   if(searchInput){
     searchInput.addEventListener('input', (e)=>{
       filterText = e.target.value;
-      renderProducts();
+      runSearch();
     });
+    // the form only submits without JS; filter in place instead
+    searchInput.form?.addEventListener('submit', e=> e.preventDefault());
+    if(filterText) runSearch();
   }
 
   if(adminPanel){
     adminLoadProducts();
     loadProfile(true);
     loadCategories();
+    loadCollections();
+    adminLoadOrders();
+    document.getElementById('order-status-filter')?.addEventListener('change', adminLoadOrders);
+    adminLoadCoupons();
+    const couponForm = document.getElementById('coupon-form');
+    if(couponForm){
+      couponForm.addEventListener('submit', async (e)=>{
+        e.preventDefault();
+        const val = name => couponForm.querySelector(`[name="${name}"]`).value.trim();
+        const payload = {code: val('code'), kind: val('kind'), min_order: toMinor(val('min_order') || 0), max_uses: Number(val('max_uses')) || 0};
+        if(payload.kind === 'percent') payload.percent = Number(val('value'));
+        else payload.amount = toMinor(val('value'));
+        if(val('expires_at')) payload.expires_at = new Date(val('expires_at')).toISOString();
+        const res = await authedFetch(shopBase+'/api/coupons', {method:'POST', headers:{'Content-Type':'application/json'}, body: JSON.stringify(payload)});
+        if(!res.ok){ alert('Không tạo được mã: ' + await res.text()); return; }
+        couponForm.reset();
+        adminLoadCoupons();
+      });
+    }
+    adminLoadAnalytics();
+    document.getElementById('analytics-form')?.addEventListener('submit', e=>{ e.preventDefault(); adminLoadAnalytics(); });
+    document.getElementById('analytics-refresh')?.addEventListener('click', ()=>adminLoadAnalytics(true));
+    adminLoadShopeeSync();
+    document.getElementById('shopee-sync-now')?.addEventListener('click', async e=>{
+      e.target.disabled = true;
+      const res = await authedFetch(shopBase+'/api/admin/shopee-sync', {method:'POST', headers:{'Content-Type':'application/json'}, body: '{}'});
+      if(!res.ok){ e.target.disabled = false; alert('Không đồng bộ được: ' + await res.text()); return; }
+      // the sync runs in the background; poll until it is done
+      for(;;){
+        await new Promise(r=>setTimeout(r, 3000));
+        const st = await authedFetch(shopBase+'/api/admin/shopee-sync');
+        if(!st.ok || !(await st.json()).running) break;
+      }
+      e.target.disabled = false;
+      adminLoadShopeeSync();
+      adminLoadProducts();
+    });
+    adminLoadShippingZones();
+    const zoneForm = document.getElementById('shipping-zone-form');
+    if(zoneForm){
+      zoneForm.addEventListener('submit', async (e)=>{
+        e.preventDefault();
+        const val = name => zoneForm.querySelector(`[name="${name}"]`).value.trim();
+        const payload = {
+          name: val('name'), kind: val('kind'), position: Number(val('position')) || 0,
+          provinces: val('provinces').split('\n').map(s=>s.trim()).filter(Boolean),
+          base_fee: toMinor(val('base_fee') || 0), per_kg_fee: toMinor(val('per_kg_fee') || 0),
+          included_grams: Number(val('included_grams')) || 0, free_over: toMinor(val('free_over') || 0),
+        };
+        const res = await authedFetch(shopBase+'/api/shipping/zones', {method:'POST', headers:{'Content-Type':'application/json'}, body: JSON.stringify(payload)});
+        if(!res.ok){ alert('Không tạo được vùng: ' + await res.text()); return; }
+        zoneForm.reset();
+        adminLoadShippingZones();
+      });
+    }
+    adminLoadProfileBlocks();
+    const blockForm = document.getElementById('profile-block-form');
+    if(blockForm){
+      blockForm.addEventListener('submit', async (e)=>{
+        e.preventDefault();
+        const val = name => blockForm.querySelector(`[name="${name}"]`).value.trim();
+        const payload = {type: val('type'), title: val('title'), body: val('body'), url: val('url'), starts_at: val('starts_at'), ends_at: val('ends_at')};
+        if(val('product_id')) payload.product_id = Number(val('product_id'));
+        const res = await authedFetch(shopBase+'/api/profile/blocks', {method:'POST', headers:{'Content-Type':'application/json'}, body: JSON.stringify(payload)});
+        if(!res.ok){ alert('Không tạo được khối: ' + await res.text()); return; }
+        blockForm.reset();
+        adminLoadProfileBlocks();
+      });
+    }
     if(!adminToken){
       const warn = document.getElementById('token-warning');
       if(warn) warn.classList.remove('hidden');
@@ -610,7 +1302,7 @@ document.addEventListener('DOMContentLoaded', ()=>{
           ev.preventDefault(); ev.stopPropagation();
           const id = editBtn.dataset.id;
           if(!id) return;
-          const res = await authedFetch('/api/products/'+id);
+          const res = await authedFetch(shopBase+'/api/products/'+id);
           if(!res.ok){ alert('Product not found'); return; }
           const p = await res.json();
           showProductEditModal(p);
@@ -622,7 +1314,7 @@ document.addEventListener('DOMContentLoaded', ()=>{
           if(!id) return;
           const ok = await showConfirm('Delete product?');
           if(!ok) return;
-          const res = await authedFetch('/api/admin/delete-product', {method: 'POST', headers: {'Content-Type':'application/json'}, body: JSON.stringify({id: Number(id)})});
+          const res = await authedFetch(shopBase+'/api/admin/delete-product', {method: 'POST', headers: {'Content-Type':'application/json'}, body: JSON.stringify({id: Number(id)})});
           if(res.ok){ adminLoadProducts(); listProducts(); alert('Deleted'); }
           else{ const txt = await res.text().catch(()=>'<no body>'); alert('Delete failed: '+txt); }
           return;
@@ -636,14 +1328,15 @@ document.addEventListener('DOMContentLoaded', ()=>{
 
   // topbar removed — no copy/back handlers needed
 
-  // tabs behavior: Shopee opens external shop
+  // tabs behavior: Shopee opens external shop. Tabs are links for no-JS visitors.
   document.querySelectorAll('.tab').forEach(t=>{
     t.addEventListener('click', (ev)=>{
+      ev.preventDefault();
       const el = ev.currentTarget;
       document.querySelectorAll('.tab').forEach(x=>x.classList.remove('active'));
       el.classList.add('active');
-      const txt = el.textContent.trim().toLowerCase();
-      if(txt === 'shopee') filterTab = 'shopee';
+      const key = el.dataset.tab || el.textContent.trim().toLowerCase();
+      if(key === 'shopee') filterTab = 'shopee';
       else filterTab = 'my';
       renderProducts();
     });
@@ -654,15 +1347,15 @@ document.addEventListener('DOMContentLoaded', ()=>{
       e.preventDefault();
       const fd = new FormData(productForm);
       const editId = document.getElementById('product-id').value;
-      // client-side: ensure shopee tag includes a link
-      const tagVal = fd.get('tag') || 'mychoice';
+      // client-side: ensure shopee source includes a link
+      const tagVal = fd.get('source') || 'mychoice';
       const ext = (fd.get('external_url') || '').toString().trim();
       if(tagVal === 'shopee' && ext === ''){
-        alert('Vui lòng nhập link bán hàng khi chọn tag Shopee');
+        alert('Vui lòng nhập link bán hàng khi chọn nguồn Shopee');
         return;
       }
       if(editId){
-        const res = await authedFetch('/api/products/'+editId, {method:'PUT', body: fd});
+        const res = await authedFetch(shopBase+'/api/products/'+editId, {method:'PUT', body: fd});
         if(res.ok){
           alert('Product updated');
           productForm.reset();
@@ -674,7 +1367,7 @@ document.addEventListener('DOMContentLoaded', ()=>{
           alert('Error: '+txt);
         }
       } else {
-        const res = await authedFetch('/api/products',{method:'POST',body:fd});
+        const res = await authedFetch(shopBase+'/api/products',{method:'POST',body:fd});
         if(res.ok){
           alert('Product added');
           productForm.reset();
@@ -716,12 +1409,91 @@ document.addEventListener('DOMContentLoaded', ()=>{
     });
   }
 
+  const bulkForm = document.getElementById('bulk-form');
+  if(bulkForm){
+    bulkForm.addEventListener('submit', async (e)=>{
+      e.preventDefault();
+      const ids = Array.from(document.querySelectorAll('#admin-products .bulk-select:checked')).map(c=>Number(c.value));
+      if(!ids.length){ alert('Chưa chọn sản phẩm nào'); return; }
+      const op = bulkForm.querySelector('[name="op"]').value;
+      const value = bulkForm.querySelector('[name="value"]').value.trim();
+      const payload = {ids, op};
+      if(op === 'set_status') payload.status = value;
+      if(op === 'set_category') payload.category_id = Number(value) || 0;
+      if(op === 'set_tag') payload.tag = value;
+      if(op === 'adjust_price') payload.percent = Number(value);
+      if(op === 'delete' && !(await showConfirm('Xóa '+ids.length+' sản phẩm?'))) return;
+      const res = await authedFetch(shopBase+'/api/admin/bulk-products', {method:'POST', headers:{'Content-Type':'application/json'}, body: JSON.stringify(payload)});
+      if(!res.ok && res.status !== 409){ const txt = await res.text().catch(()=>'<no body>'); alert('Thao tác thất bại: '+txt); return; }
+      const out = await res.json();
+      const errors = out.results.filter(r=>!r.ok).map(r=>`#${r.id}: ${r.error}`);
+      alert(`Thành công: ${out.succeeded}, lỗi: ${out.failed}` + (errors.length ? '\n' + errors.join('\n') : ''));
+      adminLoadProducts(); listProducts();
+    });
+  }
+
+  const exportBtn = document.getElementById('export-csv');
+  if(exportBtn){
+    exportBtn.addEventListener('click', async ()=>{
+      const res = await authedFetch(shopBase+'/api/admin/export/products.csv');
+      if(!res.ok){ alert('Xuất CSV thất bại'); return; }
+      const url = URL.createObjectURL(await res.blob());
+      const a = document.createElement('a');
+      a.href = url; a.download = 'products.csv';
+      document.body.appendChild(a); a.click(); a.remove();
+      URL.revokeObjectURL(url);
+    });
+  }
+
+  const importForm = document.getElementById('import-form');
+  if(importForm){
+    importForm.addEventListener('submit', async (e)=>{
+      e.preventDefault();
+      const file = importForm.querySelector('[name="file"]').files[0];
+      if(!file) return;
+      const create = importForm.querySelector('[name="create_categories"]').checked ? '1' : '0';
+      const send = async (dryRun)=>{
+        const fd = new FormData();
+        fd.append('file', file);
+        const res = await authedFetch(shopBase+`/api/admin/import/products?dry_run=${dryRun}&create_categories=${create}`, {method:'POST', body: fd});
+        if(!res.ok){ const txt = await res.text().catch(()=>'<no body>'); alert('Nhập CSV thất bại: '+txt); return null; }
+        return res.json();
+      };
+      // validate first, then ask before writing anything
+      const preview = await send(1);
+      if(!preview) return;
+      const errors = preview.rows.filter(r=>r.errors && r.errors.length).map(r=>`Dòng ${r.line}: ${r.errors.join(', ')}`);
+      const summary = `Thêm mới: ${preview.created}, cập nhật: ${preview.updated}, bỏ qua: ${preview.skipped}`;
+      if(!(await showConfirm(summary + (errors.length ? '\n' + errors.join('\n') : '') + '\nTiếp tục nhập?'))) return;
+      const out = await send(0);
+      if(!out) return;
+      alert(`Đã nhập. Thêm mới: ${out.created}, cập nhật: ${out.updated}, bỏ qua: ${out.skipped}`);
+      importForm.reset();
+      loadCategories(); loadCollections(); adminLoadProducts(); listProducts();
+    });
+  }
+
+  const collectionForm = document.getElementById('collection-form');
+  if(collectionForm){
+    collectionForm.addEventListener('submit', async (e)=>{
+      e.preventDefault();
+      const name = collectionForm.querySelector('[name="name"]').value.trim();
+      if(!name) return;
+      const res = await authedFetch(shopBase+'/api/collections',{method:'POST',headers:{'Content-Type':'application/json'},body: JSON.stringify({name})});
+      if(res.ok){ collectionForm.reset(); loadCollections(); }
+      else{ const txt = await res.text().catch(()=>'<no body>'); alert('Thêm bộ sưu tập thất bại: '+txt); }
+    });
+  }
+
   if(categoryForm){
     categoryForm.addEventListener('submit', async (e)=>{
       e.preventDefault();
       const name = categoryForm.querySelector('[name="name"]').value.trim();
       if(!name) return;
-      const res = await authedFetch('/api/categories',{method:'POST',headers:{'Content-Type':'application/json'},body: JSON.stringify({name})});
+      const parentEl = categoryForm.querySelector('[name="parent_id"]');
+      const positionEl = categoryForm.querySelector('[name="position"]');
+      const payload = {name, parent_id: Number(parentEl ? parentEl.value : 0), position: Number(positionEl ? positionEl.value : 0) || 0};
+      const res = await authedFetch(shopBase+'/api/categories',{method:'POST',headers:{'Content-Type':'application/json'},body: JSON.stringify(payload)});
       if(res.ok){ categoryForm.reset(); loadCategories(); adminLoadProducts(); listProducts(); }
       else{ const txt = await res.text().catch(()=>'<no body>'); alert('Add category failed: '+txt); }
     });
@@ -735,7 +1507,7 @@ document.addEventListener('DOMContentLoaded', ()=>{
     profileForm.addEventListener('submit', async (e)=>{
       e.preventDefault();
       const fd = new FormData(profileForm);
-      const res = await authedFetch('/api/profile',{method:'PUT',body:fd});
+      const res = await authedFetch(shopBase+'/api/profile',{method:'PUT',body:fd});
       if(res.ok){
         alert('Profile updated');
         loadProfile(true);
@@ -1,7 +1,7 @@
 package main
 
 import (
-	"strconv"
+	"fmt"
 	"sync"
 	"time"
 )
@@ -11,9 +11,9 @@ var (
 	devProducts   []Product
 	devNextID     int64 = 1
 	devCategories       = []Category{
-		{ID: 1, Name: "Quần áo"},
-		{ID: 2, Name: "Đầm"},
-		{ID: 3, Name: "Giày dép"},
+		{ID: 1, Name: "Quần áo", Slug: "quan-ao", Position: 1},
+		{ID: 2, Name: "Đầm", Slug: "dam", Position: 2},
+		{ID: 3, Name: "Giày dép", Slug: "giay-dep", Position: 3},
 	}
 	devNextCatID int64 = 4
 
@@ -23,6 +23,7 @@ var (
 		Bio:         "Local curated closet • Giao nhanh trong 48h",
 		Highlight:   "Nhắn mình trên Instagram để chốt đơn nhé!",
 		AvatarURL:   "https://images.unsplash.com/photo-1534528741775-53994a69daeb?auto=format&fit=crop&w=400&q=80",
+		Currency:    defaultCurrency,
 	}
 
 	devSocials = []Social{
@@ -42,23 +43,24 @@ func DevGetProducts() []Product {
 }
 
 // DevAddProduct adds a product to the in-memory store and returns the new id.
-func DevAddProduct(title, description string, price float64, imageURL string, categoryID int64, externalURL string, tag string) int64 {
+func DevAddProduct(title, description string, price Money, imageURL string, categoryID int64, externalURL string, source string) int64 {
 	devMu.Lock()
 	defer devMu.Unlock()
 	id := devNextID
 	devNextID++
-	categoryName := ""
+	categoryName, categorySlug := "", ""
 	for _, c := range devCategories {
 		if c.ID == categoryID {
-			categoryName = c.Name
+			categoryName, categorySlug = c.Name, c.Slug
 			break
 		}
 	}
-	// default tag to mychoice when dev add (external_url may indicate shopee but admin should set tag)
-	if tag == "" {
-		tag = "mychoice"
+	// default source to mychoice when dev add (external_url may indicate shopee but admin should set source)
+	if source == "" {
+		source = sourceMyChoice
 	}
-	p := Product{ID: id, Title: title, Description: description, Price: price, ImageURL: imageURL, ExternalURL: externalURL, Tag: tag, CategoryID: categoryID, Category: categoryName, CreatedAt: time.Now().Format(time.RFC3339)}
+	p := Product{ID: id, Title: title, Description: description, Price: price, ImageURL: imageURL, ExternalURL: externalURL, Source: source, Tag: source, Status: statusPublished, CategoryID: categoryID, Category: categoryName, CategorySlug: categorySlug, CreatedAt: time.Now().Format(time.RFC3339)}
+	p.UpdatedAt = p.CreatedAt
 	devProducts = append([]Product{p}, devProducts...)
 	return id
 }
@@ -70,6 +72,7 @@ func DevDeleteProduct(id int64) bool {
 	for i, p := range devProducts {
 		if p.ID == id {
 			devProducts = append(devProducts[:i], devProducts[i+1:]...)
+			delete(devProductCollections, id)
 			return true
 		}
 	}
@@ -77,7 +80,7 @@ func DevDeleteProduct(id int64) bool {
 }
 
 // DevUpdateProduct updates a product in-memory. Returns true if found.
-func DevUpdateProduct(id int64, title, description string, price float64, imageURL string, categoryID int64, externalURL string, tag string) bool {
+func DevUpdateProduct(id int64, title, description string, price Money, imageURL string, categoryID int64, externalURL string, source string) bool {
 	devMu.Lock()
 	defer devMu.Unlock()
 	for i, p := range devProducts {
@@ -87,9 +90,11 @@ func DevUpdateProduct(id int64, title, description string, price float64, imageU
 			devProducts[i].Price = price
 			devProducts[i].CategoryID = categoryID
 			devProducts[i].Category = ""
+			devProducts[i].CategorySlug = ""
 			for _, c := range devCategories {
 				if c.ID == categoryID {
 					devProducts[i].Category = c.Name
+					devProducts[i].CategorySlug = c.Slug
 					break
 				}
 			}
@@ -98,19 +103,69 @@ func DevUpdateProduct(id int64, title, description string, price float64, imageU
 			}
 			// update external URL if provided (empty string clears it)
 			devProducts[i].ExternalURL = externalURL
-			if tag != "" {
-				devProducts[i].Tag = tag
+			if source != "" {
+				devProducts[i].Source = source
+				devProducts[i].Tag = source
 			}
 			devProducts[i].CreatedAt = time.Now().Format(time.RFC3339)
+			devProducts[i].UpdatedAt = devProducts[i].CreatedAt
 			return true
 		}
 	}
 	return false
 }
 
-// helper to format price for DB compatibility if needed
-func FormatPrice(p float64) string {
-	return strconv.FormatFloat(p, 'f', 2, 64)
+// DevModifyProduct applies fn to the product with id under the store lock; returns false if not found.
+func DevModifyProduct(id int64, fn func(p *Product)) bool {
+	devMu.Lock()
+	defer devMu.Unlock()
+	for i := range devProducts {
+		if devProducts[i].ID == id {
+			fn(&devProducts[i])
+			devProducts[i].UpdatedAt = time.Now().Format(time.RFC3339)
+			return true
+		}
+	}
+	return false
+}
+
+// DevSetProductPlacement updates pinned and/or position (nil leaves a field unchanged).
+func DevSetProductPlacement(id int64, pinned *bool, position *int) bool {
+	devMu.Lock()
+	defer devMu.Unlock()
+	for i := range devProducts {
+		if devProducts[i].ID == id {
+			if pinned != nil {
+				devProducts[i].Pinned = *pinned
+			}
+			if position != nil {
+				devProducts[i].Position = *position
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// DevReorderProducts assigns positions 1..n following ids and returns the ids not found.
+func DevReorderProducts(ids []int64) []int64 {
+	devMu.Lock()
+	defer devMu.Unlock()
+	var missing []int64
+	for pos, id := range ids {
+		found := false
+		for i := range devProducts {
+			if devProducts[i].ID == id {
+				devProducts[i].Position = pos + 1
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, id)
+		}
+	}
+	return missing
 }
 
 // DevGetProfile returns current in-memory profile.
@@ -188,25 +243,30 @@ func DevGetCategories() []Category {
 	return cp
 }
 
-func DevAddCategory(name string) Category {
+// DevAddCategory stores c with a new id and returns it.
+func DevAddCategory(c Category) Category {
 	devMu.Lock()
 	defer devMu.Unlock()
-	c := Category{ID: devNextCatID, Name: name}
+	c.ID = devNextCatID
+	c.Children = nil
 	devNextCatID++
 	devCategories = append(devCategories, c)
 	return c
 }
 
-func DevUpdateCategory(id int64, name string) bool {
+// DevUpdateCategory replaces the category with c.ID; returns true if found.
+func DevUpdateCategory(c Category) bool {
 	devMu.Lock()
 	defer devMu.Unlock()
 	for i := range devCategories {
-		if devCategories[i].ID == id {
-			devCategories[i].Name = name
+		if devCategories[i].ID == c.ID {
+			c.Children = nil
+			devCategories[i] = c
 			// update existing products using this category
 			for j := range devProducts {
-				if devProducts[j].CategoryID == id {
-					devProducts[j].Category = name
+				if devProducts[j].CategoryID == c.ID {
+					devProducts[j].Category = c.Name
+					devProducts[j].CategorySlug = c.Slug
 				}
 			}
 			return true
@@ -215,6 +275,7 @@ func DevUpdateCategory(id int64, name string) bool {
 	return false
 }
 
+// DevDeleteCategory removes a category, moving its children up to its parent.
 func DevDeleteCategory(id int64) bool {
 	devMu.Lock()
 	defer devMu.Unlock()
@@ -228,11 +289,18 @@ func DevDeleteCategory(id int64) bool {
 	if idx == -1 {
 		return false
 	}
+	parent := devCategories[idx].ParentID
 	devCategories = append(devCategories[:idx], devCategories[idx+1:]...)
+	for i := range devCategories {
+		if devCategories[i].ParentID == id {
+			devCategories[i].ParentID = parent
+		}
+	}
 	for j := range devProducts {
 		if devProducts[j].CategoryID == id {
 			devProducts[j].CategoryID = 0
 			devProducts[j].Category = ""
+			devProducts[j].CategorySlug = ""
 		}
 	}
 	return true
@@ -248,3 +316,704 @@ func DevGetCategory(id int64) (Category, bool) {
 	}
 	return Category{}, false
 }
+
+var (
+	devCollections        []Collection
+	devNextCollectionID   int64 = 1
+	devProductCollections       = map[int64][]int64{} // product id -> collection ids
+)
+
+// DevGetCollections returns a copy of in-memory collections with product counts.
+func DevGetCollections() []Collection {
+	devMu.Lock()
+	defer devMu.Unlock()
+	out := make([]Collection, len(devCollections))
+	copy(out, devCollections)
+	for i := range out {
+		out[i].ProductCount = 0
+		for _, ids := range devProductCollections {
+			for _, id := range ids {
+				if id == out[i].ID {
+					out[i].ProductCount++
+				}
+			}
+		}
+	}
+	return out
+}
+
+// DevAddCollection stores c with a new id and returns it.
+func DevAddCollection(c Collection) Collection {
+	devMu.Lock()
+	defer devMu.Unlock()
+	c.ID = devNextCollectionID
+	devNextCollectionID++
+	devCollections = append(devCollections, c)
+	return c
+}
+
+// DevUpdateCollection replaces the collection with c.ID; returns true if found.
+func DevUpdateCollection(c Collection) bool {
+	devMu.Lock()
+	defer devMu.Unlock()
+	for i := range devCollections {
+		if devCollections[i].ID == c.ID {
+			devCollections[i] = c
+			return true
+		}
+	}
+	return false
+}
+
+// DevDeleteCollection removes a collection and its product assignments.
+func DevDeleteCollection(id int64) bool {
+	devMu.Lock()
+	defer devMu.Unlock()
+	idx := -1
+	for i := range devCollections {
+		if devCollections[i].ID == id {
+			idx = i
+			break
+		}
+	}
+	if idx == -1 {
+		return false
+	}
+	devCollections = append(devCollections[:idx], devCollections[idx+1:]...)
+	for pid, ids := range devProductCollections {
+		kept := ids[:0]
+		for _, cid := range ids {
+			if cid != id {
+				kept = append(kept, cid)
+			}
+		}
+		devProductCollections[pid] = kept
+	}
+	return true
+}
+
+// DevGetProductCollections returns product id -> collection ids.
+func DevGetProductCollections() map[int64][]int64 {
+	devMu.Lock()
+	defer devMu.Unlock()
+	out := make(map[int64][]int64, len(devProductCollections))
+	for pid, ids := range devProductCollections {
+		out[pid] = append([]int64(nil), ids...)
+	}
+	return out
+}
+
+// DevSetProductCollections replaces the collections of a product.
+func DevSetProductCollections(productID int64, ids []int64) {
+	devMu.Lock()
+	defer devMu.Unlock()
+	if len(ids) == 0 {
+		delete(devProductCollections, productID)
+		return
+	}
+	devProductCollections[productID] = append([]int64(nil), ids...)
+}
+
+// DevRestoreSnapshot replaces the whole in-memory store with snap. Sections that
+// are nil in snap (missing from older files) are left unchanged.
+func DevRestoreSnapshot(snap ShopSnapshot) {
+	devMu.Lock()
+	defer devMu.Unlock()
+	byCat := make(map[int64]Category, len(snap.Categories))
+	devCategories = make([]Category, 0, len(snap.Categories))
+	devNextCatID = 1
+	for _, c := range snap.Categories {
+		c.Children = nil
+		byCat[c.ID] = c
+		devCategories = append(devCategories, c)
+		if c.ID >= devNextCatID {
+			devNextCatID = c.ID + 1
+		}
+	}
+	devCollections = make([]Collection, 0, len(snap.Collections))
+	devNextCollectionID = 1
+	for _, c := range snap.Collections {
+		c.ProductCount = 0
+		devCollections = append(devCollections, c)
+		if c.ID >= devNextCollectionID {
+			devNextCollectionID = c.ID + 1
+		}
+	}
+	devProducts = make([]Product, 0, len(snap.Products))
+	devProductCollections = map[int64][]int64{}
+	devNextID = 1
+	for _, p := range snap.Products {
+		for _, c := range p.Collections {
+			devProductCollections[p.ID] = append(devProductCollections[p.ID], c.ID)
+		}
+		p.Collections = nil
+		c := byCat[p.CategoryID]
+		p.Category, p.CategorySlug = c.Name, c.Slug
+		if p.Source == "" {
+			p.Source = sourceMyChoice
+		}
+		p.Tag = p.Source
+		if !validProductStatus(p.Status) {
+			p.Status = statusPublished
+		}
+		devProducts = append(devProducts, p)
+		if p.ID >= devNextID {
+			devNextID = p.ID + 1
+		}
+	}
+	devSocials = append([]Social(nil), snap.Socials...)
+	devNextSocialID = 1
+	for _, s := range devSocials {
+		if s.ID >= devNextSocialID {
+			devNextSocialID = s.ID + 1
+		}
+	}
+	devProfile = snap.Profile
+	devProfile.Socials = nil
+	if snap.Orders != nil {
+		devOrders = make([]Order, 0, len(snap.Orders))
+		devNextOrderID = 1
+		for _, o := range snap.Orders {
+			o.Items = append([]OrderItem(nil), o.Items...)
+			devOrders = append(devOrders, o)
+			if o.ID >= devNextOrderID {
+				devNextOrderID = o.ID + 1
+			}
+		}
+	}
+	if snap.Coupons != nil {
+		devCoupons = append([]Coupon(nil), snap.Coupons...)
+		devNextCouponID = 1
+		for _, c := range devCoupons {
+			if c.ID >= devNextCouponID {
+				devNextCouponID = c.ID + 1
+			}
+		}
+	}
+	if snap.ShippingZones != nil {
+		devShippingZones = append([]ShippingZone(nil), snap.ShippingZones...)
+		devNextShippingZoneID = 1
+		for _, z := range devShippingZones {
+			if z.ID >= devNextShippingZoneID {
+				devNextShippingZoneID = z.ID + 1
+			}
+		}
+	}
+	if snap.ProfileBlocks != nil {
+		devProfileBlocks = make([]ProfileBlock, 0, len(snap.ProfileBlocks))
+		devNextProfileBlockID = 1
+		for _, b := range snap.ProfileBlocks {
+			b.EmbedURL, b.Product = "", nil
+			devProfileBlocks = append(devProfileBlocks, b)
+			if b.ID >= devNextProfileBlockID {
+				devNextProfileBlockID = b.ID + 1
+			}
+		}
+	}
+}
+
+var (
+	devOrders      []Order
+	devNextOrderID int64 = 1
+)
+
+// DevAddOrder stores o with a new id and returns it.
+func DevAddOrder(o Order) Order {
+	devMu.Lock()
+	defer devMu.Unlock()
+	return devAddOrderLocked(o)
+}
+
+// devAddOrderLocked is DevAddOrder with devMu held.
+func devAddOrderLocked(o Order) Order {
+	o.ID = devNextOrderID
+	devNextOrderID++
+	o.Items = append([]OrderItem(nil), o.Items...)
+	devOrders = append([]Order{o}, devOrders...)
+	return o
+}
+
+// DevGetOrders returns a copy of the in-memory orders, newest first.
+func DevGetOrders() []Order {
+	devMu.Lock()
+	defer devMu.Unlock()
+	out := make([]Order, len(devOrders))
+	for i, o := range devOrders {
+		o.Items = append([]OrderItem(nil), o.Items...)
+		out[i] = o
+	}
+	return out
+}
+
+// DevTransitionOrder is the in-memory version of transitionOrder.
+func DevTransitionOrder(id int64, to string, adminNote *string) (Order, error) {
+	devMu.Lock()
+	defer devMu.Unlock()
+	idx := -1
+	for i := range devOrders {
+		if devOrders[i].ID == id {
+			idx = i
+			break
+		}
+	}
+	if idx == -1 {
+		return Order{}, errOrderNotFound
+	}
+	o := &devOrders[idx]
+	if to != o.Status {
+		if !canTransitionOrder(o.Status, to) {
+			return Order{}, orderError{fmt.Sprintf("cannot change order from %s to %s", o.Status, to)}
+		}
+		reserve, release := !stockReserved(o.Status) && stockReserved(to), stockReserved(o.Status) && !stockReserved(to)
+		if reserve || release {
+			need := make(map[int64]int)
+			for _, it := range o.Items {
+				need[it.ProductID] += it.Quantity
+			}
+			// check everything first so a failure changes nothing
+			for i := range devProducts {
+				p := &devProducts[i]
+				if n, ok := need[p.ID]; ok && reserve && p.Stock != nil && *p.Stock < n {
+					return Order{}, fmt.Errorf("%w: %s (còn %d)", errInsufficientStock, p.Title, *p.Stock)
+				}
+			}
+			for i := range devProducts {
+				p := &devProducts[i]
+				n, ok := need[p.ID]
+				if !ok || p.Stock == nil {
+					continue
+				}
+				left := *p.Stock + n
+				if reserve {
+					left = *p.Stock - n
+				}
+				p.Stock = &left
+				p.Status = stockStatus(p.Status, left)
+			}
+		}
+		if to == orderCancelled && (o.CouponID != 0 || o.CouponCode != "") {
+			for i := range devCoupons {
+				c := devCoupons[i]
+				used := c.ID == o.CouponID || (o.CouponID == 0 && c.Code == o.CouponCode)
+				if used && c.UsedCount > 0 {
+					devCoupons[i].UsedCount--
+				}
+			}
+		}
+		o.Status = to
+	}
+	if adminNote != nil {
+		o.AdminNote = *adminNote
+	}
+	o.UpdatedAt = time.Now().Format(time.RFC3339)
+	out := *o
+	out.Items = append([]OrderItem(nil), o.Items...)
+	return out, nil
+}
+
+// devCart is an in-memory cart.
+type devCart struct {
+	lines   []cartLine
+	expires time.Time
+	coupon  string
+}
+
+var (
+	devCarts                = map[string]*devCart{}
+	devNextCartLineID int64 = 1
+)
+
+// DevCreateCart stores an empty cart.
+func DevCreateCart(id string, expires time.Time) {
+	devMu.Lock()
+	defer devMu.Unlock()
+	devCarts[id] = &devCart{expires: expires}
+}
+
+// DevDeleteExpiredCarts drops carts that expired before now.
+func DevDeleteExpiredCarts(now time.Time) {
+	devMu.Lock()
+	defer devMu.Unlock()
+	for k, c := range devCarts {
+		if c.expires.Before(now) {
+			delete(devCarts, k)
+		}
+	}
+}
+
+// DevGetCart returns a copy of the lines of an unexpired cart.
+func DevGetCart(id string) ([]cartLine, time.Time, bool) {
+	devMu.Lock()
+	defer devMu.Unlock()
+	c, ok := devCarts[id]
+	if !ok || c.expires.Before(time.Now()) {
+		return nil, time.Time{}, false
+	}
+	return append([]cartLine(nil), c.lines...), c.expires, true
+}
+
+// DevTouchCart sets a new expiry on a cart.
+func DevTouchCart(id string, expires time.Time) {
+	devMu.Lock()
+	defer devMu.Unlock()
+	if c, ok := devCarts[id]; ok {
+		c.expires = expires
+	}
+}
+
+// DevAddCartLine appends a line with a new id.
+func DevAddCartLine(id string, l cartLine) {
+	devMu.Lock()
+	defer devMu.Unlock()
+	if c, ok := devCarts[id]; ok {
+		l.ID = devNextCartLineID
+		devNextCartLineID++
+		c.lines = append(c.lines, l)
+	}
+}
+
+// DevSetCartLine changes the quantity of a line (0 removes it); returns false if not found.
+func DevSetCartLine(id string, lineID int64, qty int) bool {
+	devMu.Lock()
+	defer devMu.Unlock()
+	c, ok := devCarts[id]
+	if !ok {
+		return false
+	}
+	for i := range c.lines {
+		if c.lines[i].ID == lineID {
+			if qty == 0 {
+				c.lines = append(c.lines[:i], c.lines[i+1:]...)
+			} else {
+				c.lines[i].Quantity = qty
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// DevCheckout is createOrder's transaction in memory: it counts the coupon use
+// (couponID 0 for none), removes the cart being checked out ("" for none) and
+// stores o, or does nothing when the cart is gone, e.g. checked out already.
+func DevCheckout(cartID string, couponID int64, o Order) (Order, error) {
+	devMu.Lock()
+	defer devMu.Unlock()
+	if cartID != "" {
+		if _, ok := devCarts[cartID]; !ok {
+			return o, errCartCheckedOut
+		}
+	}
+	if couponID != 0 {
+		if err := devUseCouponLocked(couponID); err != nil {
+			return o, err
+		}
+	}
+	delete(devCarts, cartID)
+	return devAddOrderLocked(o), nil
+}
+
+var (
+	devCoupons      []Coupon
+	devNextCouponID int64 = 1
+)
+
+// DevGetCoupons returns a copy of the in-memory coupons, newest first.
+func DevGetCoupons() []Coupon {
+	devMu.Lock()
+	defer devMu.Unlock()
+	return append([]Coupon(nil), devCoupons...)
+}
+
+// DevAddCoupon stores c with a new id and returns it.
+func DevAddCoupon(c Coupon) Coupon {
+	devMu.Lock()
+	defer devMu.Unlock()
+	c.ID = devNextCouponID
+	devNextCouponID++
+	c.CreatedAt = time.Now().Format(time.RFC3339)
+	devCoupons = append([]Coupon{c}, devCoupons...)
+	return c
+}
+
+// DevUpdateCoupon replaces the editable fields of a coupon; returns false if not found.
+func DevUpdateCoupon(c Coupon) bool {
+	devMu.Lock()
+	defer devMu.Unlock()
+	for i := range devCoupons {
+		if devCoupons[i].ID == c.ID {
+			c.UsedCount, c.CreatedAt = devCoupons[i].UsedCount, devCoupons[i].CreatedAt
+			devCoupons[i] = c
+			return true
+		}
+	}
+	return false
+}
+
+// DevDeleteCoupon removes a coupon.
+func DevDeleteCoupon(id int64) bool {
+	devMu.Lock()
+	defer devMu.Unlock()
+	for i := range devCoupons {
+		if devCoupons[i].ID == id {
+			devCoupons = append(devCoupons[:i], devCoupons[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// DevUseCoupon counts one use of a coupon, failing when the limit is reached.
+func DevUseCoupon(id int64) error {
+	devMu.Lock()
+	defer devMu.Unlock()
+	return devUseCouponLocked(id)
+}
+
+// devUseCouponLocked is DevUseCoupon with devMu held.
+func devUseCouponLocked(id int64) error {
+	for i := range devCoupons {
+		c := &devCoupons[i]
+		if c.ID == id {
+			if c.MaxUses > 0 && c.UsedCount >= c.MaxUses {
+				return errCouponUsedUp
+			}
+			c.UsedCount++
+			return nil
+		}
+	}
+	return orderError{"mã giảm giá không hợp lệ"}
+}
+
+// DevSetCartCoupon stores the coupon code applied to a cart ("" removes it).
+func DevSetCartCoupon(id, code string) {
+	devMu.Lock()
+	defer devMu.Unlock()
+	if c, ok := devCarts[id]; ok {
+		c.coupon = code
+	}
+}
+
+// DevGetCartCoupon returns the coupon code applied to a cart.
+func DevGetCartCoupon(id string) string {
+	devMu.Lock()
+	defer devMu.Unlock()
+	if c, ok := devCarts[id]; ok {
+		return c.coupon
+	}
+	return ""
+}
+
+var (
+	devShippingZones      []ShippingZone
+	devNextShippingZoneID int64 = 1
+)
+
+// DevGetShippingZones returns a copy of the in-memory shipping zones.
+func DevGetShippingZones() []ShippingZone {
+	devMu.Lock()
+	defer devMu.Unlock()
+	return append([]ShippingZone(nil), devShippingZones...)
+}
+
+// DevAddShippingZone stores z with a new id and returns it.
+func DevAddShippingZone(z ShippingZone) ShippingZone {
+	devMu.Lock()
+	defer devMu.Unlock()
+	z.ID = devNextShippingZoneID
+	devNextShippingZoneID++
+	devShippingZones = append(devShippingZones, z)
+	return z
+}
+
+// DevUpdateShippingZone replaces a zone; returns false if not found.
+func DevUpdateShippingZone(z ShippingZone) bool {
+	devMu.Lock()
+	defer devMu.Unlock()
+	for i := range devShippingZones {
+		if devShippingZones[i].ID == z.ID {
+			devShippingZones[i] = z
+			return true
+		}
+	}
+	return false
+}
+
+// DevDeleteShippingZone removes a zone.
+func DevDeleteShippingZone(id int64) bool {
+	devMu.Lock()
+	defer devMu.Unlock()
+	for i := range devShippingZones {
+		if devShippingZones[i].ID == id {
+			devShippingZones = append(devShippingZones[:i], devShippingZones[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// devMaxClickEvents bounds the in-memory click and view logs; the oldest events are dropped.
+const devMaxClickEvents = 10000
+
+var devClickEvents []ClickEvent
+
+// DevAddClickEvent appends a click event.
+func DevAddClickEvent(e ClickEvent) {
+	devMu.Lock()
+	defer devMu.Unlock()
+	devClickEvents = append(devClickEvents, e)
+	if len(devClickEvents) > devMaxClickEvents {
+		devClickEvents = append([]ClickEvent(nil), devClickEvents[len(devClickEvents)-devMaxClickEvents:]...)
+	}
+}
+
+// DevGetClickEvents returns a copy of the recorded click events, oldest first.
+func DevGetClickEvents() []ClickEvent {
+	devMu.Lock()
+	defer devMu.Unlock()
+	return append([]ClickEvent(nil), devClickEvents...)
+}
+
+var (
+	devViewEvents    []ViewEvent
+	devAnalyticsRows []analyticsRow
+)
+
+// DevAddViewEvent appends a product view, bounded like the click log.
+func DevAddViewEvent(e ViewEvent) {
+	devMu.Lock()
+	defer devMu.Unlock()
+	devViewEvents = append(devViewEvents, e)
+	if len(devViewEvents) > devMaxClickEvents {
+		devViewEvents = append([]ViewEvent(nil), devViewEvents[len(devViewEvents)-devMaxClickEvents:]...)
+	}
+}
+
+// DevGetViewEvents returns a copy of the recorded views, oldest first.
+func DevGetViewEvents() []ViewEvent {
+	devMu.Lock()
+	defer devMu.Unlock()
+	return append([]ViewEvent(nil), devViewEvents...)
+}
+
+// DevReplaceAnalyticsRows replaces the rollup rows for fromDay and later.
+func DevReplaceAnalyticsRows(fromDay string, rows []analyticsRow) {
+	devMu.Lock()
+	defer devMu.Unlock()
+	kept := devAnalyticsRows[:0:0]
+	for _, r := range devAnalyticsRows {
+		if r.Day < fromDay {
+			kept = append(kept, r)
+		}
+	}
+	devAnalyticsRows = append(kept, rows...)
+}
+
+// DevGetAnalyticsRows returns a copy of the rollup rows.
+func DevGetAnalyticsRows() []analyticsRow {
+	devMu.Lock()
+	defer devMu.Unlock()
+	return append([]analyticsRow(nil), devAnalyticsRows...)
+}
+
+var devSyncStates = map[int64]ShopeeSyncState{}
+
+// DevGetSyncStates returns a copy of the Shopee sync states keyed by product id.
+func DevGetSyncStates() map[int64]ShopeeSyncState {
+	devMu.Lock()
+	defer devMu.Unlock()
+	out := make(map[int64]ShopeeSyncState, len(devSyncStates))
+	for id, s := range devSyncStates {
+		out[id] = s
+	}
+	return out
+}
+
+// DevSetSyncState stores the Shopee sync state of a product.
+func DevSetSyncState(s ShopeeSyncState) {
+	devMu.Lock()
+	defer devMu.Unlock()
+	devSyncStates[s.ProductID] = s
+}
+
+var (
+	devPriceHistory      []PriceChange
+	devNextPriceChangeID int64 = 1
+)
+
+// DevAddPriceChange appends a price change, assigning its id.
+func DevAddPriceChange(c PriceChange) {
+	devMu.Lock()
+	defer devMu.Unlock()
+	c.ID = devNextPriceChangeID
+	devNextPriceChangeID++
+	devPriceHistory = append(devPriceHistory, c)
+}
+
+// DevGetPriceHistory returns a copy of all price changes, oldest first.
+func DevGetPriceHistory() []PriceChange {
+	devMu.Lock()
+	defer devMu.Unlock()
+	return append([]PriceChange(nil), devPriceHistory...)
+}
+
+var (
+	devProfileBlocks      []ProfileBlock
+	devNextProfileBlockID int64 = 1
+)
+
+// DevGetProfileBlocks returns a copy of the in-memory profile blocks.
+func DevGetProfileBlocks() []ProfileBlock {
+	devMu.Lock()
+	defer devMu.Unlock()
+	return append([]ProfileBlock(nil), devProfileBlocks...)
+}
+
+// DevAddProfileBlock stores b with a new id and returns it.
+func DevAddProfileBlock(b ProfileBlock) ProfileBlock {
+	devMu.Lock()
+	defer devMu.Unlock()
+	b.ID = devNextProfileBlockID
+	devNextProfileBlockID++
+	devProfileBlocks = append(devProfileBlocks, b)
+	return b
+}
+
+// DevUpdateProfileBlock replaces a block; returns false if not found.
+func DevUpdateProfileBlock(b ProfileBlock) bool {
+	devMu.Lock()
+	defer devMu.Unlock()
+	for i := range devProfileBlocks {
+		if devProfileBlocks[i].ID == b.ID {
+			devProfileBlocks[i] = b
+			return true
+		}
+	}
+	return false
+}
+
+// DevDeleteProfileBlock removes a block.
+func DevDeleteProfileBlock(id int64) bool {
+	devMu.Lock()
+	defer devMu.Unlock()
+	for i := range devProfileBlocks {
+		if devProfileBlocks[i].ID == id {
+			devProfileBlocks = append(devProfileBlocks[:i], devProfileBlocks[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// DevReorderProfileBlocks sets positions 1..n following ids.
+func DevReorderProfileBlocks(ids []int64) {
+	devMu.Lock()
+	defer devMu.Unlock()
+	for pos, id := range ids {
+		for i := range devProfileBlocks {
+			if devProfileBlocks[i].ID == id {
+				devProfileBlocks[i].Position = pos + 1
+			}
+		}
+	}
+}
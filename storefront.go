@@ -0,0 +1,257 @@
+package main
+
+import (
+	"database/sql"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Storefront tabs, matching the JS filterTab values.
+const (
+	tabMyChoice = "my"
+	tabShopee   = "shopee"
+)
+
+// storefrontSocials are the profile icons the storefront shows, in order.
+var storefrontSocials = []struct{ Name, Icon string }{
+	{"Instagram", "instagram.svg"},
+	{"Facebook", "facebook.svg"},
+	{"TikTok", "tiktok.svg"},
+}
+
+type storefrontLink struct {
+	Name   string
+	Href   string
+	Icon   string // socials: file under static/img
+	Key    string // tabs: the JS filterTab value
+	Active bool
+}
+
+// storefrontData feeds static/index.html.
+type storefrontData struct {
+	Base        string // "/@username" when the shop is served under a path, else ""
+	Profile     Profile
+	Bio         template.HTML
+	Blocks      []ProfileBlock
+	Socials     []storefrontLink
+	Tabs        []storefrontLink
+	Chips       []storefrontLink // first entry is "Tất cả"
+	Tab         string
+	Category    string // slug of the selected category
+	Query       string
+	Products    []Product
+	Prev, Next  string // storefront hrefs of the neighbouring grid pages, "" at either end
+	URL         string // canonical absolute URL
+	Description string
+	Image       string
+}
+
+var (
+	bioURL    = regexp.MustCompile(`https?://[^\s<]+`)
+	bioHandle = regexp.MustCompile(`@([a-zA-Z0-9_.]+)`)
+)
+
+// linkifyBio mirrors linkifyText in app.js: escape, link URLs and @handles
+// (Instagram), and keep line breaks.
+func linkifyBio(s string) template.HTML {
+	out := template.HTMLEscapeString(s)
+	out = bioURL.ReplaceAllString(out, `<a href="$0" target="_blank" rel="noreferrer">$0</a>`)
+	out = bioHandle.ReplaceAllString(out, `<a href="https://www.instagram.com/$1" target="_blank" rel="noreferrer">@$1</a>`)
+	out = strings.ReplaceAll(out, "\n", "<br>")
+	return template.HTML(strings.ReplaceAll(out, "\r", ""))
+}
+
+// storefrontPageSize is how many products one server-rendered grid page holds.
+// app.js replaces the grid with the whole filtered catalog once it runs.
+const storefrontPageSize = 48
+
+// maxStorefrontPage keeps ?page= from turning into a huge OFFSET.
+const maxStorefrontPage = 1000
+
+// storefrontHref builds a storefront URL keeping the given filters.
+func storefrontHref(tab, category, q string) string {
+	return storefrontPageHref(tab, category, q, 1)
+}
+
+// storefrontPageHref is storefrontHref for grid page page (1 is the first).
+func storefrontPageHref(tab, category, q string, page int) string {
+	v := url.Values{}
+	if tab != "" && tab != tabMyChoice {
+		v.Set("tab", tab)
+	}
+	if category != "" {
+		v.Set("category", category)
+	}
+	if q != "" {
+		v.Set("q", q)
+	}
+	if page > 1 {
+		v.Set("page", strconv.Itoa(page))
+	}
+	if len(v) == 0 {
+		return "/"
+	}
+	return "/?" + v.Encode()
+}
+
+// loadStorefrontTemplate parses static/index.html. The page still works without
+// it (the JS renders everything), so a broken template is logged, not fatal.
+func loadStorefrontTemplate() *template.Template {
+	t, err := template.ParseFiles("./static/index.html")
+	if err != nil {
+		log.Printf("warning: storefront template: %v; serving index.html unrendered", err)
+		return nil
+	}
+	return t
+}
+
+// storefrontHandler renders the storefront at "/" with the profile, socials,
+// category chips and product grid already in the HTML, so crawlers and slow
+// phones see content before app.js takes over. ?tab=shopee, ?category=<slug>
+// and ?q= select what is shown, as the JS filters do; only one ?page= of the
+// matching products is read and rendered.
+func storefrontHandler(db *sql.DB) http.HandlerFunc {
+	tmpl := loadStorefrontTemplate()
+	return func(w http.ResponseWriter, r *http.Request) {
+		if tmpl == nil {
+			http.ServeFile(w, r, "./static/index.html")
+			return
+		}
+		profile, err := fetchProfile(db)
+		if err != nil {
+			log.Println("storefront profile error:", err)
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		cats, err := fetchCategories(db)
+		if err != nil {
+			log.Println("storefront categories error:", err)
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		blocks, err := publicProfileBlocks(db)
+		if err != nil {
+			log.Println("storefront blocks error:", err)
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		qv := r.URL.Query()
+		tab := tabMyChoice
+		if qv.Get("tab") == tabShopee {
+			tab = tabShopee
+		}
+		query := strings.TrimSpace(qv.Get("q"))
+		var category Category
+		if ref := qv.Get("category"); ref != "" {
+			c, ok := findCategory(cats, ref)
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			category = c
+		}
+		page := 1
+		if v := qv.Get("page"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxStorefrontPage {
+				http.NotFound(w, r)
+				return
+			}
+			page = n
+		}
+
+		filter := productFilter{publicOnly: true, source: sourceMyChoice}
+		if tab == tabShopee {
+			filter.source = sourceShopee
+		}
+		if category.ID != 0 {
+			filter.categoryIDs = categoryDescendants(cats, category.ID)
+		}
+		offset := (page - 1) * storefrontPageSize
+		var shown []Product
+		more := false
+		if query != "" {
+			results, total, err := searchCatalog(db, requestShop(r).ID, query, filter, offset, storefrontPageSize)
+			if err != nil {
+				log.Println("storefront search error:", err)
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
+			for _, res := range results {
+				shown = append(shown, res.Product)
+			}
+			more = offset+storefrontPageSize < total
+		} else {
+			// one extra row tells whether there is a next page
+			filter.offset, filter.limit = offset, storefrontPageSize+1
+			shown, err = fetchFilteredProducts(db, filter)
+			if err != nil {
+				log.Println("storefront products error:", err)
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
+			if more = len(shown) > storefrontPageSize; more {
+				shown = shown[:storefrontPageSize]
+			}
+		}
+		if page > 1 && len(shown) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+
+		d := storefrontData{
+			Base:        shopBase(r),
+			Profile:     profile,
+			Bio:         linkifyBio(profile.Bio),
+			Blocks:      blocks,
+			Tab:         tab,
+			Category:    category.Slug,
+			Query:       query,
+			Products:    shown,
+			URL:         siteURL(r) + storefrontPageHref("", category.Slug, "", page),
+			Description: truncateRunes(profile.Bio, 200),
+			Image:       profile.AvatarURL,
+		}
+		if d.Description == "" {
+			d.Description = profile.Highlight
+		}
+		if page > 1 {
+			d.Prev = storefrontPageHref(tab, category.Slug, query, page-1)
+		}
+		if more && page < maxStorefrontPage {
+			d.Next = storefrontPageHref(tab, category.Slug, query, page+1)
+		}
+		for _, s := range storefrontSocials {
+			for _, ps := range profile.Socials {
+				if strings.EqualFold(ps.Name, s.Name) {
+					href := d.Base + ps.TrackedURL
+					if ps.TrackedURL == "" {
+						href = ps.URL
+					}
+					d.Socials = append(d.Socials, storefrontLink{Name: s.Name, Href: href, Icon: s.Icon})
+					break
+				}
+			}
+		}
+		d.Tabs = []storefrontLink{
+			{Name: "My Choice", Key: tabMyChoice, Href: storefrontHref(tabMyChoice, category.Slug, query), Active: tab == tabMyChoice},
+			{Name: "SHOPEE", Key: tabShopee, Href: storefrontHref(tabShopee, category.Slug, query), Active: tab == tabShopee},
+		}
+		d.Chips = append(d.Chips, storefrontLink{Name: "Tất cả", Href: storefrontHref(tab, "", query), Active: category.ID == 0})
+		for _, c := range cats {
+			if c.ParentID == 0 && c.Slug != "" {
+				d.Chips = append(d.Chips, storefrontLink{Name: c.Name, Href: storefrontHref(tab, c.Slug, query), Active: c.ID == category.ID})
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := tmpl.Execute(w, d); err != nil {
+			log.Println("storefront render error:", err)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStorefrontPages(t *testing.T) {
+	var products []Product
+	for i := 1; i <= 100; i++ {
+		products = append(products, Product{ID: int64(i), Title: fmt.Sprintf("Áo %d", i), Status: statusPublished, Source: sourceMyChoice, Position: i})
+	}
+	products = append(products,
+		Product{ID: 101, Title: "Đầm Shopee", Status: statusPublished, Source: sourceShopee, Position: 101},
+		Product{ID: 102, Title: "Áo nháp", Status: statusDraft, Source: sourceMyChoice, Position: 102},
+	)
+	useDevOrders(t, products, nil)
+	h := storefrontHandler(nil)
+
+	tests := []struct {
+		url       string
+		wantCode  int
+		wantCards int
+		wantPrev  string
+		wantNext  string
+	}{
+		{"/", 200, storefrontPageSize, "", "/?page=2"},
+		{"/?page=2", 200, storefrontPageSize, "/", "/?page=3"},
+		{"/?page=3", 200, 100 - 2*storefrontPageSize, "/?page=2", ""},
+		{"/?page=4", 404, 0, "", ""},
+		{"/?page=0", 404, 0, "", ""},
+		{"/?page=x", 404, 0, "", ""},
+		{"/?tab=shopee", 200, 1, "", ""},
+		{"/?q=ao", 200, storefrontPageSize, "", "/?page=2&amp;q=ao"},
+		{"/?q=ao&page=3", 200, 100 - 2*storefrontPageSize, "/?page=2&amp;q=ao", ""},
+		{"/?q=nhap", 200, 0, "", ""}, // drafts stay hidden
+		{"/?q=dam&tab=shopee", 200, 1, "", ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest(http.MethodGet, tt.url, nil))
+		if w.Code != tt.wantCode {
+			t.Errorf("%s = %d, want %d", tt.url, w.Code, tt.wantCode)
+			continue
+		}
+		if tt.wantCode != 200 {
+			continue
+		}
+		body := w.Body.String()
+		if n := strings.Count(body, `class="link-card"`); n != tt.wantCards {
+			t.Errorf("%s: %d cards, want %d", tt.url, n, tt.wantCards)
+		}
+		for rel, want := range map[string]string{"prev": tt.wantPrev, "next": tt.wantNext} {
+			link := `href="` + want + `" rel="` + rel + `"`
+			if want != "" && !strings.Contains(body, link) {
+				t.Errorf("%s: missing %s", tt.url, link)
+			}
+			if want == "" && strings.Contains(body, `rel="`+rel+`"`) {
+				t.Errorf("%s: unexpected %s link", tt.url, rel)
+			}
+		}
+	}
+}